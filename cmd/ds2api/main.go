@@ -26,15 +26,25 @@ func main() {
 		port = "5001"
 	}
 
+	tlsConfig, err := server.TLSConfigFromEnv()
+	if err != nil {
+		config.Logger.Error("invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
 	srv := &http.Server{
-		Addr:    "0.0.0.0:" + port,
-		Handler: app.Router,
+		Addr:      "0.0.0.0:" + port,
+		Handler:   app.Router,
+		TLSConfig: tlsConfig,
 	}
-	localURL := fmt.Sprintf("http://127.0.0.1:%s", port)
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	localURL := fmt.Sprintf("%s://127.0.0.1:%s", scheme, port)
 	lanIP := detectLANIPv4()
 	lanURL := ""
 	if lanIP != "" {
-		lanURL = fmt.Sprintf("http://%s:%s", lanIP, port)
+		lanURL = fmt.Sprintf("%s://%s:%s", scheme, lanIP, port)
 	}
 
 	// Start server in a goroutine so we can listen for shutdown signals.
@@ -45,7 +55,13 @@ func main() {
 			config.Logger.Info("starting ds2api", "bind", srv.Addr, "port", port, "local_url", localURL)
 			config.Logger.Warn("lan ip not detected; check active network interfaces")
 		}
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			config.Logger.Error("server stopped unexpectedly", "error", err)
 			os.Exit(1)
 		}
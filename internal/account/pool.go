@@ -12,11 +12,14 @@ import (
 )
 
 type Pool struct {
-	store                  *config.Store
-	mu                     sync.Mutex
-	queue                  []string
-	inUse                  map[string]int
-	waiters                []chan struct{}
+	store   *config.Store
+	mu      sync.Mutex
+	queue   []string
+	inUse   map[string]int
+	waiters []chan struct{}
+	// waking counts waiters that were signalled but have not retried yet;
+	// AcquireIdle leaves their slot alone.
+	waking                 int
 	maxInflightPerAccount  int
 	recommendedConcurrency int
 	maxQueueSize           int
@@ -95,12 +98,22 @@ func (p *Pool) AcquireWait(ctx context.Context, target string, exclude map[strin
 		ctx = context.Background()
 	}
 	exclude = normalizeExclude(exclude)
+	woken := false
 	for {
 		if ctx.Err() != nil {
+			if woken {
+				p.mu.Lock()
+				p.waking--
+				p.mu.Unlock()
+			}
 			return config.Account{}, false
 		}
 
 		p.mu.Lock()
+		if woken {
+			p.waking--
+			woken = false
+		}
 		if acc, ok := p.acquireLocked(target, exclude); ok {
 			p.mu.Unlock()
 			return acc, true
@@ -116,14 +129,29 @@ func (p *Pool) AcquireWait(ctx context.Context, target string, exclude map[strin
 		select {
 		case <-ctx.Done():
 			p.mu.Lock()
-			p.removeWaiterLocked(waiter)
+			if !p.removeWaiterLocked(waiter) {
+				p.waking--
+			}
 			p.mu.Unlock()
 			return config.Account{}, false
 		case <-waiter:
+			woken = true
 		}
 	}
 }
 
+// AcquireIdle takes a slot for low-priority background work. It never
+// queues and only succeeds while no request is waiting for a slot, so
+// interactive traffic always goes first; callers retry later on false.
+func (p *Pool) AcquireIdle(exclude map[string]bool) (config.Account, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.waiters) > 0 || p.waking > 0 {
+		return config.Account{}, false
+	}
+	return p.acquireLocked("", normalizeExclude(exclude))
+}
+
 func (p *Pool) acquireLocked(target string, exclude map[string]bool) (config.Account, bool) {
 	if target != "" {
 		if exclude[target] || !p.canAcquireIDLocked(target) {
@@ -303,6 +331,7 @@ func (p *Pool) notifyWaiterLocked() {
 	}
 	waiter := p.waiters[0]
 	p.waiters = p.waiters[1:]
+	p.waking++
 	close(waiter)
 }
 
@@ -321,6 +350,7 @@ func (p *Pool) drainWaitersLocked() {
 	for _, waiter := range p.waiters {
 		close(waiter)
 	}
+	p.waking += len(p.waiters)
 	p.waiters = nil
 }
 
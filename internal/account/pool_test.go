@@ -294,3 +294,31 @@ func TestPoolAcquireWaitQueueLimitReturnsFalse(t *testing.T) {
 		t.Fatal("timed out waiting for first queued acquire")
 	}
 }
+
+func TestPoolAcquireIdleYieldsToWaiters(t *testing.T) {
+	pool := newSingleAccountPoolForTest(t, "1")
+	idle, ok := pool.AcquireIdle(nil)
+	if !ok {
+		t.Fatal("expected idle acquire to succeed on a free pool")
+	}
+	if _, ok := pool.AcquireIdle(nil); ok {
+		t.Fatal("expected idle acquire to fail when the pool is full")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got := make(chan bool, 1)
+	go func() {
+		_, ok := pool.AcquireWait(ctx, "", nil)
+		got <- ok
+	}()
+	waitForWaitingCount(t, pool, 1)
+	pool.Release(idle.Identifier())
+	// The slot belongs to the woken waiter even before it retries.
+	if _, ok := pool.AcquireIdle(nil); ok {
+		t.Fatal("expected idle acquire to yield to the waiter")
+	}
+	if !<-got {
+		t.Fatal("expected the waiter to get the released slot")
+	}
+}
@@ -0,0 +1,116 @@
+package claude
+
+import (
+	"time"
+
+	"ds2api/internal/batch"
+)
+
+const (
+	// claudeBatchMaxRequests is the most requests one batch may hold.
+	claudeBatchMaxRequests = 100000
+	// claudeBatchExpiry is how long a batch may run before its unprocessed
+	// requests expire.
+	claudeBatchExpiry = 24 * time.Hour
+	// claudeBatchRetention is how long a batch and its results are kept.
+	claudeBatchRetention = 29 * 24 * time.Hour
+	// claudeBatchSink names the results file of a batch.
+	claudeBatchSink = "results"
+)
+
+type claudeBatchCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+func (c *claudeBatchCounts) add(resultType string) {
+	c.Processing--
+	switch resultType {
+	case "succeeded":
+		c.Succeeded++
+	case "errored":
+		c.Errored++
+	case "canceled":
+		c.Canceled++
+	case "expired":
+		c.Expired++
+	}
+}
+
+// claudeBatch is one message batch; the requests run with params as their
+// body and each result line is appended to the "results" sink.
+type claudeBatch struct {
+	batch.Batch
+	Counts claudeBatchCounts `json:"request_counts"`
+}
+
+func (b *claudeBatch) status() string {
+	switch {
+	case b.State == batch.Ended:
+		return "ended"
+	case b.CancelledAt != nil:
+		return "canceling"
+	default:
+		return "in_progress"
+	}
+}
+
+// object renders the batch as the API returns it, without results_url,
+// which needs the request (see claudeBatchObject).
+func (b *claudeBatch) object() map[string]any {
+	return map[string]any{
+		"id":                  b.ID,
+		"type":                "message_batch",
+		"processing_status":   b.status(),
+		"request_counts":      b.Counts,
+		"created_at":          claudeBatchTime(&b.CreatedAt),
+		"expires_at":          claudeBatchTime(&b.ExpiresAt),
+		"ended_at":            claudeBatchTime(b.EndedAt),
+		"cancel_initiated_at": claudeBatchTime(b.CancelledAt),
+		"archived_at":         nil,
+		"results_url":         nil,
+	}
+}
+
+func claudeBatchTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// newClaudeBatchStore loads the message batches saved in dir ("" keeps them
+// in memory only).
+func newClaudeBatchStore(dir string) *batch.Store[*claudeBatch] {
+	return batch.NewStore(dir, batch.Spec[*claudeBatch]{
+		Name:      "claude_batches",
+		IDPrefix:  "msgbatch_",
+		Sinks:     []string{claudeBatchSink},
+		Window:    claudeBatchExpiry,
+		Retention: claudeBatchRetention,
+		New:       func() *claudeBatch { return &claudeBatch{} },
+		Object:    (*claudeBatch).object,
+		Reset: func(b *claudeBatch, total int) {
+			b.Counts = claudeBatchCounts{Processing: total}
+		},
+		Count: func(b *claudeBatch, res batch.Result) {
+			result, _ := res.Line["result"].(map[string]any)
+			resultType, _ := result["type"].(string)
+			b.Counts.add(resultType)
+		},
+		Skipped: func(customID string, cancelled bool) batch.Result {
+			if cancelled {
+				return claudeBatchResult(customID, map[string]any{"type": "canceled"})
+			}
+			return claudeBatchResult(customID, map[string]any{"type": "expired"})
+		},
+	})
+}
+
+// claudeBatchResult is the results line of one request.
+func claudeBatchResult(customID string, result map[string]any) batch.Result {
+	return batch.Result{Sink: claudeBatchSink, Line: map[string]any{"custom_id": customID, "result": result}}
+}
@@ -0,0 +1,291 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/batch"
+)
+
+const (
+	defaultBatchListLimit = 20
+	maxBatchListLimit     = 1000
+)
+
+var claudeBatchCustomIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// StartBatches loads the message batches saved in dir ("" keeps them in
+// memory only) and starts the worker that runs them until ctx ends.
+func (h *Handler) StartBatches(ctx context.Context, dir string) {
+	st := newClaudeBatchStore(dir)
+	h.batchMu.Lock()
+	h.batches = st
+	h.batchMu.Unlock()
+	go st.Run(ctx, batch.Worker[*claudeBatch]{
+		Auth: h.Auth,
+		Run: func(ctx context.Context, a *auth.RequestAuth, _ *claudeBatch, req batch.Request) batch.Result {
+			return claudeBatchResult(req.CustomID, h.runBatchRequest(ctx, a, req.Body))
+		},
+		Failed: func(req batch.Request, err error) batch.Result {
+			return claudeBatchResult(req.CustomID, claudeBatchErrored(claudeAuthStatus(err), err.Error()))
+		},
+	})
+}
+
+func (h *Handler) getBatchStore() *batch.Store[*claudeBatch] {
+	h.batchMu.Lock()
+	defer h.batchMu.Unlock()
+	return h.batches
+}
+
+// batchCaller resolves the caller of a batch route, answering the request
+// itself on failure.
+func (h *Handler) batchCaller(w http.ResponseWriter, r *http.Request) (*auth.RequestAuth, *batch.Store[*claudeBatch], bool) {
+	a, err := h.Auth.DetermineCaller(r)
+	if err != nil {
+		writeClaudeError(w, claudeAuthStatus(err), err.Error())
+		return nil, nil, false
+	}
+	st := h.getBatchStore()
+	if st == nil {
+		writeClaudeError(w, http.StatusNotFound, "Message batches are not enabled.")
+		return nil, nil, false
+	}
+	return a, st, true
+}
+
+func (h *Handler) CreateMessageBatch(w http.ResponseWriter, r *http.Request) {
+	a, ref, err := h.Auth.DetermineCallerRef(r)
+	if err != nil {
+		writeClaudeError(w, claudeAuthStatus(err), err.Error())
+		return
+	}
+	st := h.getBatchStore()
+	if st == nil {
+		writeClaudeError(w, http.StatusNotFound, "Message batches are not enabled.")
+		return
+	}
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeClaudeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	requests, err := parseClaudeBatchRequests(req["requests"])
+	if err != nil {
+		writeClaudeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, claudeBatchObject(r, st.Create(&claudeBatch{}, a.CallerID, ref, requests, time.Now())))
+}
+
+// parseClaudeBatchRequests checks the envelope of each request. Params are
+// only validated when the request runs; invalid ones become errored results.
+func parseClaudeBatchRequests(raw any) ([]batch.Request, error) {
+	items, ok := raw.([]any)
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("requests: List should have at least 1 item")
+	}
+	if len(items) > claudeBatchMaxRequests {
+		return nil, fmt.Errorf("requests: List should have at most %d items", claudeBatchMaxRequests)
+	}
+	out := make([]batch.Request, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("requests.%d: Input should be an object", i)
+		}
+		customID, _ := m["custom_id"].(string)
+		if !claudeBatchCustomIDPattern.MatchString(customID) {
+			return nil, fmt.Errorf("requests.%d.custom_id: String should match pattern '%s'", i, claudeBatchCustomIDPattern.String())
+		}
+		if seen[customID] {
+			return nil, fmt.Errorf("requests.%d.custom_id: Duplicate custom_id '%s'", i, customID)
+		}
+		seen[customID] = true
+		params, ok := m["params"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("requests.%d.params: Field required", i)
+		}
+		if stream, _ := params["stream"].(bool); stream {
+			return nil, fmt.Errorf("requests.%d.params.stream: Streaming is not supported in message batches", i)
+		}
+		out = append(out, batch.Request{CustomID: customID, Body: params})
+	}
+	return out, nil
+}
+
+func (h *Handler) GetMessageBatch(w http.ResponseWriter, r *http.Request) {
+	a, st, ok := h.batchCaller(w, r)
+	if !ok {
+		return
+	}
+	obj, ok := st.Get(a.CallerID, chi.URLParam(r, "batch_id"))
+	if !ok {
+		writeClaudeError(w, http.StatusNotFound, "Message batch not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, claudeBatchObject(r, obj))
+}
+
+// ListMessageBatches pages through the caller's batches newest first.
+// after_id continues with older batches, before_id with newer ones.
+func (h *Handler) ListMessageBatches(w http.ResponseWriter, r *http.Request) {
+	a, st, ok := h.batchCaller(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	limit := defaultBatchListLimit
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxBatchListLimit {
+			writeClaudeError(w, http.StatusBadRequest, fmt.Sprintf("limit: Input should be an integer between 1 and %d", maxBatchListLimit))
+			return
+		}
+		limit = n
+	}
+	all := st.List(a.CallerID)
+	indexOf := func(id string) int {
+		for i, obj := range all {
+			if obj["id"] == id {
+				return i
+			}
+		}
+		return -1
+	}
+	after, before := strings.TrimSpace(q.Get("after_id")), strings.TrimSpace(q.Get("before_id"))
+	if after != "" && before != "" {
+		writeClaudeError(w, http.StatusBadRequest, "Only one of after_id and before_id may be given.")
+		return
+	}
+	start, end, hasMore := 0, limit, false
+	switch {
+	case after != "":
+		if start = indexOf(after) + 1; start == 0 {
+			writeClaudeError(w, http.StatusBadRequest, fmt.Sprintf("after_id: Message batch '%s' not found", after))
+			return
+		}
+		end = start + limit
+	case before != "":
+		if end = indexOf(before); end < 0 {
+			writeClaudeError(w, http.StatusBadRequest, fmt.Sprintf("before_id: Message batch '%s' not found", before))
+			return
+		}
+		start = max(end-limit, 0)
+		hasMore = start > 0
+	}
+	if end > len(all) {
+		end = len(all)
+	} else if after != "" || before == "" {
+		hasMore = end < len(all)
+	}
+	page := all[start:end]
+	for _, obj := range page {
+		claudeBatchObject(r, obj)
+	}
+	var firstID, lastID any
+	if len(page) > 0 {
+		firstID, lastID = page[0]["id"], page[len(page)-1]["id"]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data":     page,
+		"has_more": hasMore,
+		"first_id": firstID,
+		"last_id":  lastID,
+	})
+}
+
+func (h *Handler) CancelMessageBatch(w http.ResponseWriter, r *http.Request) {
+	a, st, ok := h.batchCaller(w, r)
+	if !ok {
+		return
+	}
+	// A batch that is already canceling or ended is returned unchanged.
+	obj, err := st.Cancel(a.CallerID, chi.URLParam(r, "batch_id"), time.Now())
+	if err == batch.ErrNotFound {
+		writeClaudeError(w, http.StatusNotFound, "Message batch not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, claudeBatchObject(r, obj))
+}
+
+// MessageBatchResults streams the results of an ended batch as JSONL, one
+// line per request in the order they finished.
+func (h *Handler) MessageBatchResults(w http.ResponseWriter, r *http.Request) {
+	a, st, ok := h.batchCaller(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "batch_id")
+	rc, err := st.Results(a.CallerID, id, claudeBatchSink)
+	switch {
+	case err == batch.ErrNotFound:
+		writeClaudeError(w, http.StatusNotFound, "Message batch not found.")
+		return
+	case err == batch.ErrNotEnded:
+		writeClaudeError(w, http.StatusBadRequest, fmt.Sprintf("Message batch '%s' has not ended; its results are not available yet.", id))
+		return
+	case err != nil:
+		writeClaudeError(w, http.StatusInternalServerError, "Failed to read batch results.")
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/x-jsonl")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, rc)
+}
+
+// claudeBatchObject fills in the results_url of an ended batch object from
+// the scheme and host r reached us at.
+func claudeBatchObject(r *http.Request, obj map[string]any) map[string]any {
+	if obj["processing_status"] == "ended" {
+		obj["results_url"] = claudeBatchBase(r) + "/anthropic/v1/messages/batches/" + obj["id"].(string) + "/results"
+	}
+	return obj
+}
+
+// claudeBatchBase is the scheme and host the request reached us at.
+// X-Forwarded-Proto is only present when a trusted proxy sent it: the
+// realIP middleware drops it from every other peer.
+func claudeBatchBase(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.Contains(strings.ToLower(r.Header.Get("X-Forwarded-Proto")), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// runBatchRequest runs one batch request like a non-streaming Messages call
+// and returns its result object.
+func (h *Handler) runBatchRequest(ctx context.Context, a *auth.RequestAuth, params map[string]any) map[string]any {
+	norm, err := normalizeClaudeRequest(h.Store, cloneMap(params))
+	if err != nil {
+		return claudeBatchErrored(http.StatusBadRequest, err.Error())
+	}
+	msg, callErr := h.createMessage(ctx, a, norm)
+	if callErr != nil {
+		return claudeBatchErrored(callErr.status, callErr.message)
+	}
+	return map[string]any{"type": "succeeded", "message": msg}
+}
+
+func claudeBatchErrored(status int, message string) map[string]any {
+	return map[string]any{
+		"type": "errored",
+		"error": map[string]any{
+			"type":  "error",
+			"error": claudeErrorObject(status, message),
+		},
+	}
+}
@@ -0,0 +1,180 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/config"
+)
+
+// batchAuth treats the x-api-key value as both the caller id and the
+// credential reference.
+type batchAuth struct{}
+
+func (batchAuth) Determine(r *http.Request) (*auth.RequestAuth, error) {
+	return &auth.RequestAuth{CallerID: r.Header.Get("x-api-key")}, nil
+}
+func (a batchAuth) DetermineCaller(r *http.Request) (*auth.RequestAuth, error) { return a.Determine(r) }
+func (a batchAuth) DetermineCallerRef(r *http.Request) (*auth.RequestAuth, string, error) {
+	caller, err := a.Determine(r)
+	return caller, r.Header.Get("x-api-key"), err
+}
+func (batchAuth) AcquireIdle(_ context.Context, ref string) (*auth.RequestAuth, bool, error) {
+	return &auth.RequestAuth{CallerID: ref}, true, nil
+}
+func (batchAuth) Release(*auth.RequestAuth) {}
+
+type batchDS struct{}
+
+func (batchDS) CreateSession(context.Context, *auth.RequestAuth, int) (string, error) {
+	return "session", nil
+}
+func (batchDS) GetPow(context.Context, *auth.RequestAuth, int) (string, error) { return "pow", nil }
+func (batchDS) CallCompletion(context.Context, *auth.RequestAuth, map[string]any, string, int) (*http.Response, error) {
+	return makeClaudeSSEHTTPResponse(`data: {"p":"response/content","v":"done"}`, `data: [DONE]`), nil
+}
+
+func batchRequest(t *testing.T, router http.Handler, method, path, caller, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("x-api-key", caller)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMessageBatchLifecycle(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	h := &Handler{Store: config.LoadStore(), Auth: batchAuth{}, DS: batchDS{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.StartBatches(ctx, t.TempDir())
+	router := chi.NewRouter()
+	RegisterRoutes(router, h)
+
+	rec := batchRequest(t, router, http.MethodPost, "/anthropic/v1/messages/batches", "alice", `{"requests":[
+		{"custom_id":"ok","params":{"model":"claude-sonnet-4-5","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}},
+		{"custom_id":"bad","params":{"model":"claude-sonnet-4-5"}}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create failed: %d %s", rec.Code, rec.Body.String())
+	}
+	var batch map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &batch)
+	id, _ := batch["id"].(string)
+	if !strings.HasPrefix(id, "msgbatch_") || batch["processing_status"] != "in_progress" || batch["results_url"] != nil {
+		t.Fatalf("unexpected batch %#v", batch)
+	}
+	if rec := batchRequest(t, router, http.MethodGet, "/anthropic/v1/messages/batches/"+id, "bob", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected another caller to get 404, got %d", rec.Code)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for batch["processing_status"] != "ended" {
+		if time.Now().After(deadline) {
+			t.Fatalf("batch did not end: %#v", batch)
+		}
+		time.Sleep(10 * time.Millisecond)
+		rec = batchRequest(t, router, http.MethodGet, "/anthropic/v1/messages/batches/"+id, "alice", "")
+		_ = json.Unmarshal(rec.Body.Bytes(), &batch)
+	}
+	counts, _ := batch["request_counts"].(map[string]any)
+	if counts["succeeded"] != float64(1) || counts["errored"] != float64(1) || counts["processing"] != float64(0) {
+		t.Fatalf("unexpected counts %#v", counts)
+	}
+	if url, _ := batch["results_url"].(string); !strings.HasSuffix(url, "/anthropic/v1/messages/batches/"+id+"/results") {
+		t.Fatalf("unexpected results_url %q", url)
+	}
+
+	rec = batchRequest(t, router, http.MethodGet, "/anthropic/v1/messages/batches/"+id+"/results", "alice", "")
+	results := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		var item struct {
+			CustomID string         `json:"custom_id"`
+			Result   map[string]any `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(line), &item); err != nil {
+			t.Fatalf("bad results line %q: %v", line, err)
+		}
+		results[item.CustomID] = item.Result
+	}
+	if msg, _ := results["ok"]["message"].(map[string]any); results["ok"]["type"] != "succeeded" || msg["type"] != "message" {
+		t.Fatalf("unexpected ok result %#v", results["ok"])
+	}
+	errObj, _ := results["bad"]["error"].(map[string]any)
+	inner, _ := errObj["error"].(map[string]any)
+	if results["bad"]["type"] != "errored" || inner["type"] != "invalid_request_error" {
+		t.Fatalf("unexpected bad result %#v", results["bad"])
+	}
+
+	rec = batchRequest(t, router, http.MethodGet, "/anthropic/v1/messages/batches?limit=5", "alice", "")
+	var page map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &page)
+	if data, _ := page["data"].([]any); len(data) != 1 || page["first_id"] != id || page["has_more"] != false {
+		t.Fatalf("unexpected list %#v", page)
+	}
+}
+
+func TestCreateMessageBatchValidation(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	h := &Handler{Store: config.LoadStore(), Auth: batchAuth{}, DS: batchDS{}, batches: newClaudeBatchStore("")}
+	router := chi.NewRouter()
+	RegisterRoutes(router, h)
+	params := `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hi"}]}`
+	cases := map[string]string{
+		`{"requests":[]}`: "at least 1 item",
+		`{"requests":[{"custom_id":"a b","params":` + params + `}]}`:                                         "requests.0.custom_id",
+		`{"requests":[{"custom_id":"a","params":` + params + `},{"custom_id":"a","params":` + params + `}]}`: "Duplicate custom_id",
+		`{"requests":[{"custom_id":"a"}]}`:                                                                   "requests.0.params: Field required",
+		`{"requests":[{"custom_id":"a","params":{"stream":true}}]}`:                                          "requests.0.params.stream",
+	}
+	for body, want := range cases {
+		rec := batchRequest(t, router, http.MethodPost, "/anthropic/v1/messages/batches", "alice", body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("body %s: expected 400 with %q, got %d %s", body, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestCancelMessageBatchBeforeItRuns(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	// No worker runs, so cancelling skips every request at once.
+	h := &Handler{Store: config.LoadStore(), Auth: batchAuth{}, DS: batchDS{}, batches: newClaudeBatchStore("")}
+	router := chi.NewRouter()
+	RegisterRoutes(router, h)
+	params := `{"model":"claude-sonnet-4-5","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`
+	rec := batchRequest(t, router, http.MethodPost, "/anthropic/v1/messages/batches", "alice",
+		`{"requests":[{"custom_id":"a","params":`+params+`},{"custom_id":"b","params":`+params+`}]}`)
+	var created map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &created)
+	id, _ := created["id"].(string)
+	if rec := batchRequest(t, router, http.MethodGet, "/anthropic/v1/messages/batches/"+id+"/results", "alice", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected results of a running batch to be refused, got %d", rec.Code)
+	}
+
+	rec = batchRequest(t, router, http.MethodPost, "/anthropic/v1/messages/batches/"+id+"/cancel", "alice", "")
+	var batch map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &batch)
+	counts, _ := batch["request_counts"].(map[string]any)
+	if batch["processing_status"] != "ended" || batch["cancel_initiated_at"] == nil || counts["canceled"] != float64(2) || counts["processing"] != float64(0) {
+		t.Fatalf("unexpected cancelled batch %#v", batch)
+	}
+	if url, _ := batch["results_url"].(string); !strings.HasSuffix(url, "/anthropic/v1/messages/batches/"+id+"/results") {
+		t.Fatalf("unexpected results_url %q", url)
+	}
+	rec = batchRequest(t, router, http.MethodGet, "/anthropic/v1/messages/batches/"+id+"/results", "alice", "")
+	if strings.Count(rec.Body.String(), `"type":"canceled"`) != 2 {
+		t.Fatalf("expected both requests to be canceled, got %s", rec.Body.String())
+	}
+	if rec := batchRequest(t, router, http.MethodPost, "/anthropic/v1/messages/batches/"+id+"/cancel", "alice", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected cancelling an ended batch to return it, got %d", rec.Code)
+	}
+}
@@ -11,6 +11,9 @@ import (
 
 type AuthResolver interface {
 	Determine(req *http.Request) (*auth.RequestAuth, error)
+	DetermineCaller(req *http.Request) (*auth.RequestAuth, error)
+	DetermineCallerRef(req *http.Request) (*auth.RequestAuth, string, error)
+	AcquireIdle(ctx context.Context, ref string) (*auth.RequestAuth, bool, error)
 	Release(a *auth.RequestAuth)
 }
 
@@ -22,6 +25,9 @@ type DeepSeekCaller interface {
 
 type ConfigReader interface {
 	ClaudeMapping() map[string]string
+	ReasoningEffortRule(effort string) (config.ReasoningRule, bool)
+	ThinkingSignatureKey() []byte
+	ThinkingHistoryPolicy() string
 }
 
 var _ AuthResolver = (*auth.Resolver)(nil)
@@ -1,12 +1,21 @@
 package claude
 
-import "testing"
+import (
+	"testing"
+
+	"ds2api/internal/config"
+)
 
 type mockClaudeConfig struct {
 	m map[string]string
 }
 
 func (m mockClaudeConfig) ClaudeMapping() map[string]string { return m.m }
+func (m mockClaudeConfig) ReasoningEffortRule(effort string) (config.ReasoningRule, bool) {
+	return config.LookupReasoningEffort(nil, effort)
+}
+func (m mockClaudeConfig) ThinkingSignatureKey() []byte  { return []byte("test-key") }
+func (m mockClaudeConfig) ThinkingHistoryPolicy() string { return config.ThinkingHistoryDrop }
 
 func TestNormalizeClaudeRequestUsesConfigInterfaceMapping(t *testing.T) {
 	req := map[string]any{
@@ -1,13 +1,20 @@
 package claude
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/config"
 )
 
-func TestWriteClaudeErrorIncludesUnifiedFields(t *testing.T) {
+func TestWriteClaudeErrorUsesAnthropicEnvelope(t *testing.T) {
 	rec := httptest.NewRecorder()
 	writeClaudeError(rec, http.StatusUnauthorized, "bad token")
 	if rec.Code != http.StatusUnauthorized {
@@ -18,18 +25,77 @@ func TestWriteClaudeErrorIncludesUnifiedFields(t *testing.T) {
 	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
 		t.Fatalf("decode body: %v", err)
 	}
+	if body["type"] != "error" {
+		t.Fatalf("unexpected envelope type: %v", body["type"])
+	}
 	errObj, _ := body["error"].(map[string]any)
-	if errObj["message"] != "bad token" {
-		t.Fatalf("unexpected message: %v", errObj["message"])
+	if errObj["message"] != "bad token" || errObj["type"] != "authentication_error" || len(errObj) != 2 {
+		t.Fatalf("unexpected error object: %#v", errObj)
 	}
-	if errObj["type"] != "invalid_request_error" {
-		t.Fatalf("unexpected type: %v", errObj["type"])
+	id := rec.Header().Get("request-id")
+	if !strings.HasPrefix(id, "req_") || body["request_id"] != id {
+		t.Fatalf("expected matching request ids, header=%q body=%v", id, body["request_id"])
 	}
-	if errObj["code"] != "authentication_failed" {
-		t.Fatalf("unexpected code: %v", errObj["code"])
+}
+
+func TestClaudeErrorTypes(t *testing.T) {
+	cases := map[int]string{
+		http.StatusBadRequest:          "invalid_request_error",
+		http.StatusUnauthorized:        "authentication_error",
+		http.StatusForbidden:           "permission_error",
+		http.StatusNotFound:            "not_found_error",
+		http.StatusTooManyRequests:     "rate_limit_error",
+		http.StatusInternalServerError: "api_error",
+		statusOverloaded:               "overloaded_error",
 	}
-	if _, ok := errObj["param"]; !ok {
-		t.Fatal("expected param field")
+	for status, want := range cases {
+		if got := claudeErrorType(status); got != want {
+			t.Fatalf("status %d: expected %s, got %s", status, want, got)
+		}
 	}
 }
 
+// scriptedDS answers every completion with the given upstream lines.
+type scriptedDS struct {
+	batchDS
+	lines []string
+}
+
+func (d scriptedDS) CallCompletion(context.Context, *auth.RequestAuth, map[string]any, string, int) (*http.Response, error) {
+	return makeClaudeSSEHTTPResponse(d.lines...), nil
+}
+
+func TestMessagesReportsHowUpstreamStopped(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	cases := []struct {
+		lines      []string
+		status     int
+		stopReason string
+	}{
+		{[]string{`data: {"p":"response/content","v":"partial"}`, `data: {"code":"content_filter"}`}, http.StatusOK, "refusal"},
+		{[]string{`data: {"p":"response/content","v":"partial"}`, `data: {"error":{"message":"boom"}}`}, http.StatusInternalServerError, ""},
+		{[]string{`data: {"error":{"message":"boom"}}`}, http.StatusInternalServerError, ""},
+	}
+	for _, tc := range cases {
+		h := &Handler{Store: config.LoadStore(), Auth: batchAuth{}, DS: scriptedDS{lines: tc.lines}}
+		router := chi.NewRouter()
+		RegisterRoutes(router, h)
+		rec := batchRequest(t, router, http.MethodPost, "/anthropic/v1/messages", "alice",
+			`{"model":"claude-sonnet-4-5","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`)
+		if rec.Code != tc.status || rec.Header().Get("request-id") == "" {
+			t.Fatalf("%v: expected %d with a request-id, got %d %s", tc.lines, tc.status, rec.Code, rec.Body.String())
+		}
+		var body map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		if tc.stopReason == "" {
+			errObj, _ := body["error"].(map[string]any)
+			if body["type"] != "error" || errObj["type"] != "api_error" {
+				t.Fatalf("%v: unexpected error body %s", tc.lines, rec.Body.String())
+			}
+			continue
+		}
+		if body["stop_reason"] != tc.stopReason {
+			t.Fatalf("%v: expected stop_reason=%s, got %s", tc.lines, tc.stopReason, rec.Body.String())
+		}
+	}
+}
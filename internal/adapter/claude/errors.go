@@ -0,0 +1,89 @@
+package claude
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"ds2api/internal/auth"
+)
+
+// statusOverloaded is the status Anthropic answers with when it has no
+// capacity for a request; SDKs retry it.
+const statusOverloaded = 529
+
+// withRequestID gives every Claude response a request-id header, as the
+// Anthropic API does.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claudeRequestID(w)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// claudeRequestID returns the request-id of a response, setting a new one
+// when the response has none yet.
+func claudeRequestID(w http.ResponseWriter) string {
+	if id := w.Header().Get("request-id"); id != "" {
+		return id
+	}
+	id := "req_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	w.Header().Set("request-id", id)
+	return id
+}
+
+// writeClaudeError writes the Anthropic error envelope, typed by status.
+func writeClaudeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"type":       "error",
+		"error":      claudeErrorObject(status, message),
+		"request_id": claudeRequestID(w),
+	})
+}
+
+func claudeErrorObject(status int, message string) map[string]any {
+	return map[string]any{"type": claudeErrorType(status), "message": message}
+}
+
+// claudeErrorType is the Anthropic error type for an HTTP status.
+func claudeErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case statusOverloaded:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
+
+// claudeAuthStatus is the status for an auth failure. A pool with no free
+// account is overloaded rather than rate limiting the caller.
+func claudeAuthStatus(err error) int {
+	switch err {
+	case auth.ErrNoAccount:
+		return statusOverloaded
+	case auth.ErrClientNotAllowed:
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// claudeUpstreamStatus is the status for an upstream reply that was not
+// 200: upstream throttling or unavailability means we are overloaded.
+func claudeUpstreamStatus(upstream int) int {
+	if upstream == http.StatusTooManyRequests || upstream == http.StatusServiceUnavailable {
+		return statusOverloaded
+	}
+	return http.StatusInternalServerError
+}
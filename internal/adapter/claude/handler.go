@@ -1,19 +1,23 @@
 package claude
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"ds2api/internal/auth"
+	"ds2api/internal/batch"
 	"ds2api/internal/config"
 	"ds2api/internal/deepseek"
 	claudefmt "ds2api/internal/format/claude"
+	"ds2api/internal/prompt"
 	"ds2api/internal/sse"
 	streamengine "ds2api/internal/stream"
 	"ds2api/internal/util"
@@ -26,6 +30,12 @@ type Handler struct {
 	Store ConfigReader
 	Auth  AuthResolver
 	DS    DeepSeekCaller
+
+	cacheMu sync.Mutex
+	cache   *claudePromptCache
+
+	batchMu sync.Mutex
+	batches *batch.Store[*claudeBatch]
 }
 
 var (
@@ -35,9 +45,17 @@ var (
 )
 
 func RegisterRoutes(r chi.Router, h *Handler) {
-	r.Get("/anthropic/v1/models", h.ListModels)
-	r.Post("/anthropic/v1/messages", h.Messages)
-	r.Post("/anthropic/v1/messages/count_tokens", h.CountTokens)
+	r.Group(func(r chi.Router) {
+		r.Use(withRequestID)
+		r.Get("/anthropic/v1/models", h.ListModels)
+		r.Post("/anthropic/v1/messages", h.Messages)
+		r.Post("/anthropic/v1/messages/count_tokens", h.CountTokens)
+		r.Post("/anthropic/v1/messages/batches", h.CreateMessageBatch)
+		r.Get("/anthropic/v1/messages/batches", h.ListMessageBatches)
+		r.Get("/anthropic/v1/messages/batches/{batch_id}", h.GetMessageBatch)
+		r.Post("/anthropic/v1/messages/batches/{batch_id}/cancel", h.CancelMessageBatch)
+		r.Get("/anthropic/v1/messages/batches/{batch_id}/results", h.MessageBatchResults)
+	})
 }
 
 func (h *Handler) ListModels(w http.ResponseWriter, _ *http.Request) {
@@ -50,12 +68,7 @@ func (h *Handler) Messages(w http.ResponseWriter, r *http.Request) {
 	}
 	a, err := h.Auth.Determine(r)
 	if err != nil {
-		status := http.StatusUnauthorized
-		detail := err.Error()
-		if err == auth.ErrNoAccount {
-			status = http.StatusTooManyRequests
-		}
-		writeClaudeError(w, status, detail)
+		writeClaudeError(w, claudeAuthStatus(err), err.Error())
 		return
 	}
 	defer h.Auth.Release(a)
@@ -71,94 +84,131 @@ func (h *Handler) Messages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	stdReq := norm.Standard
-
-	sessionID, err := h.DS.CreateSession(r.Context(), a, 3)
-	if err != nil {
-		writeClaudeError(w, http.StatusUnauthorized, "invalid token.")
+	if !stdReq.Stream {
+		msg, callErr := h.createMessage(r.Context(), a, norm)
+		if callErr != nil {
+			writeClaudeError(w, callErr.status, callErr.message)
+			return
+		}
+		writeJSON(w, http.StatusOK, msg)
 		return
 	}
-	pow, err := h.DS.GetPow(r.Context(), a, 3)
-	if err != nil {
-		writeClaudeError(w, http.StatusUnauthorized, "Failed to get PoW")
+
+	resp, callErr := h.startCompletion(r.Context(), a, stdReq)
+	if callErr != nil {
+		writeClaudeError(w, callErr.status, callErr.message)
 		return
 	}
-	requestPayload := stdReq.CompletionPayload(sessionID)
-	resp, err := h.DS.CallCompletion(r.Context(), a, requestPayload, pow, 3)
-	if err != nil {
-		writeClaudeError(w, http.StatusInternalServerError, "Failed to get Claude response.")
-		return
+	opts := claudeStreamOptions{
+		cache:        h.getPromptCache().use(a.CallerID, norm.CacheBreakpoints, time.Now()),
+		finalizeText: h.toolCallFinalizer(r.Context(), a, stdReq),
 	}
-	if resp.StatusCode != http.StatusOK {
-		defer resp.Body.Close()
-		body, _ := io.ReadAll(resp.Body)
-		writeClaudeError(w, http.StatusInternalServerError, string(body))
-		return
+	if norm.ShowThinking {
+		opts.thinkingKey = h.Store.ThinkingSignatureKey()
 	}
+	h.handleClaudeStreamRealtime(w, r, resp, stdReq, opts)
+}
 
-	if stdReq.Stream {
-		h.handleClaudeStreamRealtime(w, r, resp, stdReq.ResponseModel, norm.NormalizedMessages, stdReq.Thinking, stdReq.Search, stdReq.ToolNames)
-		return
+// claudeStreamOptions is what a stream needs beyond the standard request.
+type claudeStreamOptions struct {
+	// thinkingKey signs the upstream thinking shown to the client; when nil
+	// the thinking is hidden.
+	thinkingKey []byte
+	// cache is the prompt cache usage reported in message_start.
+	cache claudefmt.CacheUsage
+	// finalizeText, when set, may replace the reply text before its tool
+	// calls are parsed.
+	finalizeText func(text string, usage *util.UpstreamUsage) string
+}
+
+// createMessage runs a normalized request to completion and returns the
+// message object a non-streaming request answers with.
+func (h *Handler) createMessage(ctx context.Context, a *auth.RequestAuth, norm claudeNormalizedRequest) (map[string]any, *completionError) {
+	stdReq := norm.Standard
+	resp, callErr := h.startCompletion(ctx, a, stdReq)
+	if callErr != nil {
+		return nil, callErr
+	}
+	finalizeText := h.toolCallFinalizer(ctx, a, stdReq)
+	cacheUsage := h.getPromptCache().use(a.CallerID, norm.CacheBreakpoints, time.Now())
+
+	limiter := util.NewOutputLimiter(stdReq.Limits)
+	result := sse.CollectStreamLimited(resp, stdReq.Thinking, true, limiter)
+	// A reply upstream cut short is an error, even with partial output, so
+	// clients see the failure instead of a truncated message.
+	stopReason := "end_turn"
+	switch {
+	case result.ContentFilter:
+		stopReason = "refusal"
+	case result.ErrorMessage != "":
+		return nil, &completionError{http.StatusInternalServerError, result.ErrorMessage}
+	}
+	text, upstream := result.Text, result.Usage
+	if finalizeText != nil {
+		text = finalizeText(text, &upstream)
+	}
+	var sources *util.SearchSources
+	if stdReq.Search {
+		sources = &result.Search
 	}
-	result := sse.CollectStream(resp, stdReq.Thinking, true)
-	respBody := claudefmt.BuildMessageResponse(
+	shownThinking, signature := "", ""
+	if norm.ShowThinking && result.Thinking != "" {
+		key := h.Store.ThinkingSignatureKey()
+		shownThinking, signature = result.Thinking, claudefmt.SignThinking(key, result.Thinking)
+	}
+	respBody := claudefmt.BuildMessageResponseWithSearch(
 		fmt.Sprintf("msg_%d", time.Now().UnixNano()),
 		stdReq.ResponseModel,
-		norm.NormalizedMessages,
-		result.Thinking,
-		result.Text,
-		stdReq.ToolNames,
+		stdReq.FinalPrompt,
+		shownThinking,
+		signature,
+		text,
+		stdReq.ToolPolicy.Filter(util.ParseToolCalls(text, stdReq.ToolNames), stdReq.ToolNames),
+		sources,
 	)
-	writeJSON(w, http.StatusOK, respBody)
+	usage := claudefmt.BuildUsage(stdReq.FinalPrompt, result.Thinking, text, upstream.Delivered(limiter.Done() || text != result.Text))
+	cacheUsage.Apply(usage)
+	if n := len(sources.Searches()); n > 0 {
+		usage["server_tool_use"] = claudefmt.BuildServerToolUsage(n)
+	}
+	respBody["usage"] = usage
+	if respBody["stop_reason"] == "end_turn" {
+		respBody["stop_reason"], respBody["stop_sequence"] = claudeLimitStop(limiter, stopReason)
+	}
+	return respBody, nil
 }
 
 func (h *Handler) CountTokens(w http.ResponseWriter, r *http.Request) {
-	a, err := h.Auth.Determine(r)
-	if err != nil {
-		writeClaudeError(w, http.StatusUnauthorized, err.Error())
+	if _, err := h.Auth.DetermineCaller(r); err != nil {
+		writeClaudeError(w, claudeAuthStatus(err), err.Error())
 		return
 	}
-	defer h.Auth.Release(a)
 
 	var req map[string]any
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeClaudeError(w, http.StatusBadRequest, "invalid json")
 		return
 	}
-	model, _ := req["model"].(string)
-	messages, _ := req["messages"].([]any)
-	if model == "" || len(messages) == 0 {
-		writeClaudeError(w, http.StatusBadRequest, "Request must include 'model' and 'messages'.")
+	// Count the prompt a real request would send, tool prompt included.
+	norm, err := normalizeClaudeRequest(h.Store, req)
+	if err != nil {
+		writeClaudeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	inputTokens := 0
-	if sys, ok := req["system"].(string); ok {
-		inputTokens += util.EstimateTokens(sys)
-	}
-	for _, item := range messages {
-		msg, ok := item.(map[string]any)
-		if !ok {
-			continue
-		}
-		inputTokens += 2
-		inputTokens += util.EstimateTokens(extractMessageContent(msg["content"]))
-	}
-	if tools, ok := req["tools"].([]any); ok {
-		for _, t := range tools {
-			b, _ := json.Marshal(t)
-			inputTokens += util.EstimateTokens(string(b))
-		}
-	}
+	inputTokens := util.CountTokens(norm.Standard.FinalPrompt)
 	if inputTokens < 1 {
 		inputTokens = 1
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"input_tokens": inputTokens})
 }
 
-func (h *Handler) handleClaudeStreamRealtime(w http.ResponseWriter, r *http.Request, resp *http.Response, model string, messages []any, thinkingEnabled, searchEnabled bool, toolNames []string) {
+// handleClaudeStreamRealtime streams a message. stdReq.Thinking is whether
+// upstream thinks; its thinking is only shown when opts.thinkingKey is set.
+func (h *Handler) handleClaudeStreamRealtime(w http.ResponseWriter, r *http.Request, resp *http.Response, stdReq util.StandardRequest, opts claudeStreamOptions) {
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		writeClaudeError(w, http.StatusInternalServerError, string(body))
+		writeClaudeError(w, claudeUpstreamStatus(resp.StatusCode), string(body))
 		return
 	}
 
@@ -176,25 +226,29 @@ func (h *Handler) handleClaudeStreamRealtime(w http.ResponseWriter, r *http.Requ
 		w,
 		rc,
 		canFlush,
-		model,
-		messages,
-		thinkingEnabled,
-		searchEnabled,
-		toolNames,
+		stdReq.ResponseModel,
+		stdReq.FinalPrompt,
+		opts.thinkingKey,
+		stdReq.Search,
+		stdReq.ToolNames,
 	)
+	streamRuntime.toolPolicy = stdReq.ToolPolicy
+	streamRuntime.finalizeText = opts.finalizeText
+	streamRuntime.limiter = util.NewOutputLimiter(stdReq.Limits)
+	streamRuntime.cache = opts.cache
 	// If downstream is already closed, runtime marks itself non-writable.
 	// We still enter ConsumeSSE so upstream body is canceled via request context
 	// and account slots are released deterministically.
 	_ = streamRuntime.sendMessageStart()
 
 	initialType := "text"
-	if thinkingEnabled {
+	if stdReq.Thinking {
 		initialType = "thinking"
 	}
 	streamengine.ConsumeSSE(streamengine.ConsumeConfig{
 		Context:             r.Context(),
 		Body:                resp.Body,
-		ThinkingEnabled:     thinkingEnabled,
+		ThinkingEnabled:     stdReq.Thinking,
 		InitialType:         initialType,
 		KeepAliveInterval:   claudeStreamPingInterval,
 		IdleTimeout:         claudeStreamIdleTimeout,
@@ -208,31 +262,14 @@ func (h *Handler) handleClaudeStreamRealtime(w http.ResponseWriter, r *http.Requ
 	})
 }
 
-func writeClaudeError(w http.ResponseWriter, status int, message string) {
-	code := "invalid_request"
-	switch status {
-	case http.StatusUnauthorized:
-		code = "authentication_failed"
-	case http.StatusTooManyRequests:
-		code = "rate_limit_exceeded"
-	case http.StatusNotFound:
-		code = "not_found"
-	case http.StatusInternalServerError:
-		code = "internal_error"
-	}
-	writeJSON(w, status, map[string]any{
-		"error": map[string]any{
-			"type":    "invalid_request_error",
-			"message": message,
-			"code":    code,
-			"param":   nil,
-		},
-	})
-}
-
-func normalizeClaudeMessages(messages []any) []any {
+// normalizeClaudeMessages flattens content blocks into text. tool_use and
+// tool_result blocks become the tool call and result entries the OpenAI
+// adapter replays, paired by id. Thinking blocks are checked and kept or
+// dropped as history decides.
+func normalizeClaudeMessages(messages []any, history claudeThinkingHistory) ([]any, error) {
 	out := make([]any, 0, len(messages))
-	for _, m := range messages {
+	toolNames := map[string]string{}
+	for i, m := range messages {
 		msg, ok := m.(map[string]any)
 		if !ok {
 			continue
@@ -241,7 +278,7 @@ func normalizeClaudeMessages(messages []any) []any {
 		switch content := msg["content"].(type) {
 		case []any:
 			parts := make([]string, 0, len(content))
-			for _, block := range content {
+			for j, block := range content {
 				b, ok := block.(map[string]any)
 				if !ok {
 					continue
@@ -252,18 +289,100 @@ func normalizeClaudeMessages(messages []any) []any {
 						parts = append(parts, t)
 					}
 				}
+				if typeStr == "tool_use" {
+					id := claudeToolID(b["id"])
+					name, _ := b["name"].(string)
+					toolNames[id] = name
+					parts = append(parts, prompt.FormatToolCall(id, orUnknown(name), claudeToolInputForPrompt(b["input"])))
+				}
 				if typeStr == "tool_result" {
-					parts = append(parts, fmt.Sprintf("%v", b["content"]))
+					id := claudeToolID(b["tool_use_id"])
+					parts = append(parts, prompt.FormatToolResult(id, orUnknown(toolNames[id]), claudeToolResultForPrompt(b["content"]), util.ToBool(b["is_error"])))
+				}
+				if typeStr == "thinking" || typeStr == "redacted_thinking" {
+					text, err := history.block(i, j, b)
+					if err != nil {
+						return nil, err
+					}
+					if text != "" {
+						parts = append(parts, text)
+					}
 				}
 			}
 			copied["content"] = strings.Join(parts, "\n")
 		}
 		out = append(out, copied)
 	}
-	return out
+	return out, nil
+}
+
+func claudeToolID(v any) string {
+	id, _ := v.(string)
+	return orUnknown(strings.TrimSpace(id))
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
 }
 
-func buildClaudeToolPrompt(tools []any) string {
+func claudeToolInputForPrompt(v any) string {
+	if v == nil {
+		return "{}"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// claudeToolResultForPrompt renders tool_result content: a string as is, and
+// an array of blocks as their text with placeholders for images and
+// documents. Anything else is kept as JSON.
+func claudeToolResultForPrompt(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if strings.TrimSpace(x) == "" {
+			return "null"
+		}
+		return x
+	case []any:
+		parts := make([]string, 0, len(x))
+		for _, item := range x {
+			block, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			switch block["type"] {
+			case "text":
+				if t, _ := block["text"].(string); t != "" {
+					parts = append(parts, t)
+				}
+			case "image":
+				parts = append(parts, "[image]")
+			case "document":
+				parts = append(parts, "[document]")
+			default:
+				b, _ := json.Marshal(block)
+				parts = append(parts, string(b))
+			}
+		}
+		if len(parts) == 0 {
+			return "null"
+		}
+		return strings.Join(parts, "\n")
+	default:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+}
+
+func buildClaudeToolPrompt(tools []any, policy util.ToolPolicy) string {
 	parts := []string{"You are Claude, a helpful AI assistant. You have access to these tools:"}
 	for _, t := range tools {
 		m, ok := t.(map[string]any)
@@ -275,7 +394,12 @@ func buildClaudeToolPrompt(tools []any) string {
 		schema, _ := json.Marshal(m["input_schema"])
 		parts = append(parts, fmt.Sprintf("Tool: %s\nDescription: %s\nParameters: %s", name, desc, schema))
 	}
-	parts = append(parts, "When you need to use tools, you can call multiple tools in one response. Output ONLY JSON like {\"tool_calls\":[{\"name\":\"tool\",\"input\":{}}]}")
+	usage := "When you need to use tools, you can call multiple tools in one response."
+	if policy.Single {
+		usage = "When you need to use a tool, call it on its own."
+	}
+	parts = append(parts, usage+" Output ONLY JSON like {\"tool_calls\":[{\"name\":\"tool\",\"input\":{}}]}")
+	parts = append(parts, policy.PromptRules()...)
 	return strings.Join(parts, "\n\n")
 }
 
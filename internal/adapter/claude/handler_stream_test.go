@@ -1,7 +1,9 @@
 package claude
 
 import (
+	claudefmt "ds2api/internal/format/claude"
 	"ds2api/internal/sse"
+	"ds2api/internal/util"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -81,7 +83,7 @@ func TestHandleClaudeStreamRealtimeTextIncrementsWithEventHeaders(t *testing.T)
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
 
-	h.handleClaudeStreamRealtime(rec, req, resp, "claude-sonnet-4-5", []any{map[string]any{"role": "user", "content": "hi"}}, false, false, nil)
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi"}, claudeStreamOptions{})
 
 	body := rec.Body.String()
 	if !strings.Contains(body, "event: message_start") {
@@ -122,19 +124,44 @@ func TestHandleClaudeStreamRealtimeThinkingDelta(t *testing.T) {
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
 
-	h.handleClaudeStreamRealtime(rec, req, resp, "claude-sonnet-4-5", []any{map[string]any{"role": "user", "content": "hi"}}, true, false, nil)
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi", Thinking: true}, claudeStreamOptions{thinkingKey: []byte("k")})
 
 	frames := parseClaudeFrames(t, rec.Body.String())
-	foundThinkingDelta := false
+	thinking, signature := "", ""
 	for _, f := range findClaudeFrames(frames, "content_block_delta") {
 		delta, _ := f.Payload["delta"].(map[string]any)
-		if delta["type"] == "thinking_delta" {
-			foundThinkingDelta = true
-			break
+		switch delta["type"] {
+		case "thinking_delta":
+			thinking += asString(delta["thinking"])
+		case "signature_delta":
+			signature = asString(delta["signature"])
 		}
 	}
-	if !foundThinkingDelta {
-		t.Fatalf("expected thinking_delta event, body=%s", rec.Body.String())
+	if thinking != "思考" {
+		t.Fatalf("expected thinking_delta events, body=%s", rec.Body.String())
+	}
+	if !claudefmt.VerifyThinking([]byte("k"), thinking, signature) {
+		t.Fatalf("expected a valid signature_delta, got %q", signature)
+	}
+}
+
+func TestHandleClaudeStreamRealtimeHidesUnrequestedThinking(t *testing.T) {
+	h := &Handler{}
+	resp := makeClaudeSSEHTTPResponse(
+		`data: {"p":"response/thinking_content","v":"思"}`,
+		`data: {"p":"response/content","v":"ok"}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-opus-4-6", FinalPrompt: "hi", Thinking: true}, claudeStreamOptions{})
+
+	for _, f := range findClaudeFrames(parseClaudeFrames(t, rec.Body.String()), "content_block_start") {
+		block, _ := f.Payload["content_block"].(map[string]any)
+		if block["type"] != "text" {
+			t.Fatalf("expected only a text block, body=%s", rec.Body.String())
+		}
 	}
 }
 
@@ -148,7 +175,7 @@ func TestHandleClaudeStreamRealtimeToolSafety(t *testing.T) {
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
 
-	h.handleClaudeStreamRealtime(rec, req, resp, "claude-sonnet-4-5", []any{map[string]any{"role": "user", "content": "use tool"}}, false, false, []string{"search"})
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "use tool", ToolNames: []string{"search"}}, claudeStreamOptions{})
 
 	frames := parseClaudeFrames(t, rec.Body.String())
 	for _, f := range findClaudeFrames(frames, "content_block_delta") {
@@ -191,7 +218,7 @@ func TestHandleClaudeStreamRealtimeUpstreamErrorEvent(t *testing.T) {
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
 
-	h.handleClaudeStreamRealtime(rec, req, resp, "claude-sonnet-4-5", []any{map[string]any{"role": "user", "content": "hi"}}, false, false, nil)
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi"}, claudeStreamOptions{})
 
 	frames := parseClaudeFrames(t, rec.Body.String())
 	errFrames := findClaudeFrames(frames, "error")
@@ -201,6 +228,42 @@ func TestHandleClaudeStreamRealtimeUpstreamErrorEvent(t *testing.T) {
 	if errFrames[0].Payload["type"] != "error" {
 		t.Fatalf("expected error payload type, body=%s", rec.Body.String())
 	}
+	if errObj, _ := errFrames[0].Payload["error"].(map[string]any); errObj["type"] != "api_error" {
+		t.Fatalf("expected a typed api_error, body=%s", rec.Body.String())
+	}
+}
+
+func TestHandleClaudeStreamRealtimeStopsOnFilterAndUpstreamError(t *testing.T) {
+	for _, last := range []string{`data: {"code":"content_filter"}`, `data: {"error":{"message":"boom"}}`} {
+		h := &Handler{}
+		resp := makeClaudeSSEHTTPResponse(
+			`data: {"p":"response/content","v":"partial"}`,
+			last,
+		)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+
+		h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi"}, claudeStreamOptions{})
+
+		frames := parseClaudeFrames(t, rec.Body.String())
+		deltas := findClaudeFrames(frames, "message_delta")
+		errFrames := findClaudeFrames(frames, "error")
+		if strings.Contains(last, "content_filter") {
+			if len(deltas) != 1 || len(errFrames) != 0 {
+				t.Fatalf("expected one message_delta and no error, body=%s", rec.Body.String())
+			}
+			if delta, _ := deltas[0].Payload["delta"].(map[string]any); delta["stop_reason"] != "refusal" {
+				t.Fatalf("expected stop_reason=refusal, got %#v", delta)
+			}
+			continue
+		}
+		if len(deltas) != 0 || len(errFrames) != 1 {
+			t.Fatalf("expected an error event after partial output, body=%s", rec.Body.String())
+		}
+		if errObj, _ := errFrames[0].Payload["error"].(map[string]any); errObj["type"] != "api_error" {
+			t.Fatalf("expected a typed api_error, body=%s", rec.Body.String())
+		}
+	}
 }
 
 func TestHandleClaudeStreamRealtimePingEvent(t *testing.T) {
@@ -228,7 +291,7 @@ func TestHandleClaudeStreamRealtimePingEvent(t *testing.T) {
 
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
-	h.handleClaudeStreamRealtime(rec, req, resp, "claude-sonnet-4-5", []any{map[string]any{"role": "user", "content": "hi"}}, false, false, nil)
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi"}, claudeStreamOptions{})
 
 	frames := parseClaudeFrames(t, rec.Body.String())
 	if len(findClaudeFrames(frames, "ping")) == 0 {
@@ -255,3 +318,124 @@ func asString(v any) string {
 	s, _ := v.(string)
 	return s
 }
+
+func TestHandleClaudeStreamRealtimeSingleToolUse(t *testing.T) {
+	h := &Handler{}
+	resp := makeClaudeSSEHTTPResponse(
+		`data: {"p":"response/content","v":"{\"tool_calls\":[{\"name\":\"search\",\"input\":{}},{\"name\":\"fetch\",\"input\":{}}]}"}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "use tools", ToolNames: []string{"search", "fetch"}, ToolPolicy: util.ToolPolicy{Single: true}}, claudeStreamOptions{})
+
+	toolUses := 0
+	for _, f := range findClaudeFrames(parseClaudeFrames(t, rec.Body.String()), "content_block_start") {
+		contentBlock, _ := f.Payload["content_block"].(map[string]any)
+		if contentBlock["type"] == "tool_use" {
+			toolUses++
+		}
+	}
+	if toolUses != 1 {
+		t.Fatalf("expected a single tool_use block, got %d body=%s", toolUses, rec.Body.String())
+	}
+}
+
+func TestHandleClaudeStreamRealtimeToolUseIDs(t *testing.T) {
+	h := &Handler{}
+	resp := makeClaudeSSEHTTPResponse(
+		`data: {"p":"response/content","v":"{\"tool_calls\":[{\"name\":\"search\",\"input\":{}},{\"name\":\"fetch\",\"input\":{}}]}"}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "use tools", ToolNames: []string{"search", "fetch"}}, claudeStreamOptions{})
+
+	ids := map[string]bool{}
+	for _, f := range findClaudeFrames(parseClaudeFrames(t, rec.Body.String()), "content_block_start") {
+		contentBlock, _ := f.Payload["content_block"].(map[string]any)
+		if id := asString(contentBlock["id"]); strings.HasPrefix(id, "toolu_") {
+			ids[id] = true
+		}
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected two distinct toolu_ ids, got %v body=%s", ids, rec.Body.String())
+	}
+}
+
+func TestHandleClaudeStreamRealtimeStopSequence(t *testing.T) {
+	h := &Handler{}
+	resp := makeClaudeSSEHTTPResponse(
+		`data: {"p":"response/content","v":"done. ST"}`,
+		`data: {"p":"response/content","v":"OP and more"}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi", Limits: util.OutputLimits{Stop: []string{"STOP"}}}, claudeStreamOptions{})
+
+	frames := parseClaudeFrames(t, rec.Body.String())
+	text := strings.Builder{}
+	for _, f := range findClaudeFrames(frames, "content_block_delta") {
+		delta, _ := f.Payload["delta"].(map[string]any)
+		text.WriteString(asString(delta["text"]))
+	}
+	if text.String() != "done. " {
+		t.Fatalf("unexpected streamed text %q", text.String())
+	}
+	deltas := findClaudeFrames(frames, "message_delta")
+	if len(deltas) != 1 {
+		t.Fatalf("expected one message_delta, body=%s", rec.Body.String())
+	}
+	delta, _ := deltas[0].Payload["delta"].(map[string]any)
+	if delta["stop_reason"] != "stop_sequence" || delta["stop_sequence"] != "STOP" {
+		t.Fatalf("unexpected stop fields: %#v", delta)
+	}
+}
+
+func TestHandleClaudeStreamRealtimeMaxTokens(t *testing.T) {
+	h := &Handler{}
+	resp := makeClaudeSSEHTTPResponse(
+		`data: {"p":"response/content","v":"0123456789abcdef"}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi", Limits: util.OutputLimits{MaxTokens: 2}}, claudeStreamOptions{})
+
+	deltas := findClaudeFrames(parseClaudeFrames(t, rec.Body.String()), "message_delta")
+	if len(deltas) != 1 {
+		t.Fatalf("expected one message_delta, body=%s", rec.Body.String())
+	}
+	delta, _ := deltas[0].Payload["delta"].(map[string]any)
+	if delta["stop_reason"] != "max_tokens" || delta["stop_sequence"] != nil {
+		t.Fatalf("unexpected stop fields: %#v", delta)
+	}
+}
+
+func TestHandleClaudeStreamRealtimeUsesUpstreamUsage(t *testing.T) {
+	h := &Handler{}
+	resp := makeClaudeSSEHTTPResponse(
+		`data: {"p":"response/thinking_content","v":"plan"}`,
+		`data: {"p":"response/thinking_elapsed_secs","v":1.75}`,
+		`data: {"p":"response/content","v":"Done"}`,
+		`data: {"p":"response","o":"BATCH","v":[{"p":"accumulated_token_usage","v":33},{"p":"status","v":"FINISHED"}]}`,
+	)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+
+	h.handleClaudeStreamRealtime(rec, req, resp, util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi", Thinking: true}, claudeStreamOptions{})
+
+	deltas := findClaudeFrames(parseClaudeFrames(t, rec.Body.String()), "message_delta")
+	if len(deltas) != 1 {
+		t.Fatalf("expected one message_delta, body=%s", rec.Body.String())
+	}
+	usage, _ := deltas[0].Payload["usage"].(map[string]any)
+	if usage["output_tokens"] != float64(33) || usage["reasoning_seconds"] != 1.75 {
+		t.Fatalf("expected upstream usage, got %#v", usage)
+	}
+}
@@ -2,6 +2,8 @@ package claude
 
 import (
 	"testing"
+
+	"ds2api/internal/util"
 )
 
 // ─── normalizeClaudeMessages ─────────────────────────────────────────
@@ -10,7 +12,7 @@ func TestNormalizeClaudeMessagesSimpleString(t *testing.T) {
 	msgs := []any{
 		map[string]any{"role": "user", "content": "Hello"},
 	}
-	got := normalizeClaudeMessages(msgs)
+	got, _ := normalizeClaudeMessages(msgs, claudeThinkingHistory{})
 	if len(got) != 1 {
 		t.Fatalf("expected 1 message, got %d", len(got))
 	}
@@ -30,7 +32,7 @@ func TestNormalizeClaudeMessagesArrayContent(t *testing.T) {
 			},
 		},
 	}
-	got := normalizeClaudeMessages(msgs)
+	got, _ := normalizeClaudeMessages(msgs, claudeThinkingHistory{})
 	m := got[0].(map[string]any)
 	if m["content"] != "line1\nline2" {
 		t.Fatalf("expected joined text, got %q", m["content"])
@@ -39,30 +41,44 @@ func TestNormalizeClaudeMessagesArrayContent(t *testing.T) {
 
 func TestNormalizeClaudeMessagesToolResult(t *testing.T) {
 	msgs := []any{
+		map[string]any{
+			"role": "assistant",
+			"content": []any{
+				map[string]any{"type": "text", "text": "checking"},
+				map[string]any{"type": "tool_use", "id": "toolu_1", "name": "search", "input": map[string]any{"q": "go"}},
+			},
+		},
 		map[string]any{
 			"role": "user",
 			"content": []any{
-				map[string]any{"type": "tool_result", "content": "tool output"},
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "is_error": true, "content": []any{
+					map[string]any{"type": "text", "text": "timeout"},
+					map[string]any{"type": "image", "source": map[string]any{"type": "base64", "data": "AAAA"}},
+				}},
 			},
 		},
 	}
-	got := normalizeClaudeMessages(msgs)
-	m := got[0].(map[string]any)
-	if m["content"] != "tool output" {
-		t.Fatalf("expected 'tool output', got %q", m["content"])
+	got, _ := normalizeClaudeMessages(msgs, claudeThinkingHistory{})
+	call := got[0].(map[string]any)["content"]
+	if call != "checking\nTool call:\n- tool_call_id: toolu_1\n- function.name: search\n- function.arguments: {\"q\":\"go\"}" {
+		t.Fatalf("unexpected tool call: %q", call)
+	}
+	result := got[1].(map[string]any)["content"]
+	if result != "Tool result:\n- tool_call_id: toolu_1\n- name: search\n- is_error: true\n- content: timeout\n[image]" {
+		t.Fatalf("unexpected tool result: %q", result)
 	}
 }
 
 func TestNormalizeClaudeMessagesSkipsNonMap(t *testing.T) {
 	msgs := []any{"not a map", 42}
-	got := normalizeClaudeMessages(msgs)
+	got, _ := normalizeClaudeMessages(msgs, claudeThinkingHistory{})
 	if len(got) != 0 {
 		t.Fatalf("expected 0 messages for non-map items, got %d", len(got))
 	}
 }
 
 func TestNormalizeClaudeMessagesEmpty(t *testing.T) {
-	got := normalizeClaudeMessages(nil)
+	got, _ := normalizeClaudeMessages(nil, claudeThinkingHistory{})
 	if len(got) != 0 {
 		t.Fatalf("expected 0, got %d", len(got))
 	}
@@ -72,7 +88,7 @@ func TestNormalizeClaudeMessagesPreservesRole(t *testing.T) {
 	msgs := []any{
 		map[string]any{"role": "assistant", "content": "response"},
 	}
-	got := normalizeClaudeMessages(msgs)
+	got, _ := normalizeClaudeMessages(msgs, claudeThinkingHistory{})
 	m := got[0].(map[string]any)
 	if m["role"] != "assistant" {
 		t.Fatalf("expected 'assistant', got %q", m["role"])
@@ -90,7 +106,7 @@ func TestNormalizeClaudeMessagesMixedContentBlocks(t *testing.T) {
 			},
 		},
 	}
-	got := normalizeClaudeMessages(msgs)
+	got, _ := normalizeClaudeMessages(msgs, claudeThinkingHistory{})
 	m := got[0].(map[string]any)
 	if m["content"] != "Hello\nWorld" {
 		t.Fatalf("expected only text parts joined, got %q", m["content"])
@@ -112,7 +128,7 @@ func TestBuildClaudeToolPromptSingleTool(t *testing.T) {
 			},
 		},
 	}
-	prompt := buildClaudeToolPrompt(tools)
+	prompt := buildClaudeToolPrompt(tools, util.ToolPolicy{})
 	if prompt == "" {
 		t.Fatal("expected non-empty prompt")
 	}
@@ -133,7 +149,7 @@ func TestBuildClaudeToolPromptMultipleTools(t *testing.T) {
 		map[string]any{"name": "tool1", "description": "desc1"},
 		map[string]any{"name": "tool2", "description": "desc2"},
 	}
-	prompt := buildClaudeToolPrompt(tools)
+	prompt := buildClaudeToolPrompt(tools, util.ToolPolicy{})
 	if !containsStr(prompt, "tool1") || !containsStr(prompt, "tool2") {
 		t.Fatalf("expected both tools in prompt")
 	}
@@ -141,7 +157,7 @@ func TestBuildClaudeToolPromptMultipleTools(t *testing.T) {
 
 func TestBuildClaudeToolPromptSkipsNonMap(t *testing.T) {
 	tools := []any{"not a map"}
-	prompt := buildClaudeToolPrompt(tools)
+	prompt := buildClaudeToolPrompt(tools, util.ToolPolicy{})
 	if prompt == "" {
 		t.Fatal("expected non-empty prompt even with invalid tools")
 	}
@@ -0,0 +1,15 @@
+package claude
+
+import "ds2api/internal/util"
+
+// claudeLimitStop maps a limiter outcome onto the Messages API stop_reason and
+// stop_sequence; fallback is kept when no limit was hit.
+func claudeLimitStop(limiter *util.OutputLimiter, fallback string) (string, any) {
+	switch limiter.Reason() {
+	case util.OutputLimitMaxTokens:
+		return "max_tokens", nil
+	case util.OutputLimitStopSequence:
+		return "stop_sequence", limiter.StopSequence()
+	}
+	return fallback, nil
+}
@@ -0,0 +1,233 @@
+package claude
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	claudefmt "ds2api/internal/format/claude"
+	"ds2api/internal/util"
+)
+
+const (
+	// claudeCacheMaxBreakpoints is how many blocks may carry cache_control.
+	claudeCacheMaxBreakpoints = 4
+	// claudeCacheMinTokens is the shortest prefix that is cached.
+	claudeCacheMinTokens = 1024
+)
+
+// claudeCacheBreakpoint is the prompt prefix ending at one cache_control
+// block: tools, then system, then messages, in request order.
+type claudeCacheBreakpoint struct {
+	key    string
+	tokens int
+	ttl    time.Duration
+}
+
+// claudeCacheBreakpoints lists the cacheable prefixes a request marks.
+// Prefixes shorter than claudeCacheMinTokens still count towards the
+// breakpoint limit but are never cached. The prefix is hashed and counted
+// as each block is added, so a prefix's tokens are the sum over its blocks.
+func claudeCacheBreakpoints(req map[string]any) ([]claudeCacheBreakpoint, error) {
+	model, _ := req["model"].(string)
+	prefix := sha256.New()
+	prefix.Write([]byte(model + "\x00"))
+	tokens := 0
+	add := func(text string) {
+		prefix.Write([]byte(text))
+		tokens += util.CountTokens(text)
+	}
+	var out []claudeCacheBreakpoint
+	marked := 0
+	mark := func(path string, block map[string]any) error {
+		raw, ok := block["cache_control"]
+		if !ok || raw == nil {
+			return nil
+		}
+		ttl, err := parseClaudeCacheControl(path, raw)
+		if err != nil {
+			return err
+		}
+		marked++
+		if marked > claudeCacheMaxBreakpoints {
+			return fmt.Errorf("A maximum of %d blocks with cache_control may be provided.", claudeCacheMaxBreakpoints)
+		}
+		if tokens >= claudeCacheMinTokens {
+			out = append(out, claudeCacheBreakpoint{key: hex.EncodeToString(prefix.Sum(nil)), tokens: tokens, ttl: ttl})
+		}
+		return nil
+	}
+
+	tools, _ := req["tools"].([]any)
+	for i, t := range tools {
+		if tool, ok := t.(map[string]any); ok {
+			add(claudeCacheBlockText(tool))
+			if err := mark(fmt.Sprintf("tools.%d", i), tool); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch system := req["system"].(type) {
+	case string:
+		add(system)
+	case []any:
+		for i, item := range system {
+			if block, ok := item.(map[string]any); ok {
+				add(claudeCacheBlockText(block))
+				if err := mark(fmt.Sprintf("system.%d", i), block); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	messages, _ := req["messages"].([]any)
+	for i, m := range messages {
+		msg, ok := m.(map[string]any)
+		if !ok {
+			continue
+		}
+		role, _ := msg["role"].(string)
+		add("\n" + role + ":\n")
+		switch content := msg["content"].(type) {
+		case string:
+			add(content)
+		case []any:
+			for j, item := range content {
+				if block, ok := item.(map[string]any); ok {
+					add(claudeCacheBlockText(block))
+					if err := mark(fmt.Sprintf("messages.%d.content.%d", i, j), block); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// parseClaudeCacheControl reads {"type":"ephemeral","ttl":"5m"|"1h"}.
+func parseClaudeCacheControl(path string, raw any) (time.Duration, error) {
+	cc, ok := raw.(map[string]any)
+	if !ok || cc["type"] != "ephemeral" {
+		return 0, fmt.Errorf("%s.cache_control.type: Input should be 'ephemeral'", path)
+	}
+	switch cc["ttl"] {
+	case nil, "5m":
+		return 5 * time.Minute, nil
+	case "1h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("%s.cache_control.ttl: Input should be '5m' or '1h'", path)
+	}
+}
+
+// claudeCacheBlockText renders a block for the prefix: text as is, anything
+// else as JSON without its cache_control marker.
+func claudeCacheBlockText(block map[string]any) string {
+	if block["type"] == "text" {
+		text, _ := block["text"].(string)
+		return text
+	}
+	copied := cloneMap(block)
+	delete(copied, "cache_control")
+	b, _ := json.Marshal(copied)
+	return string(b)
+}
+
+// claudePromptCache emulates Anthropic prompt caching per caller. It only
+// remembers which prefixes were seen recently, so the cache usage it
+// reports is an estimate: upstream sessions are not reused and every
+// request still sends the full prompt. It holds at most claudePromptCacheMaxEntries
+// prefixes, dropping the least recently used first, and sweeps expired ones
+// at most every claudePromptCacheSweepInterval.
+type claudePromptCache struct {
+	mu        sync.Mutex
+	items     map[string]*list.Element
+	order     *list.List // of *claudePromptCacheEntry, most recently used first
+	nextSweep time.Time
+}
+
+type claudePromptCacheEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+const (
+	claudePromptCacheMaxEntries    = 10000
+	claudePromptCacheSweepInterval = time.Minute
+)
+
+func newClaudePromptCache() *claudePromptCache {
+	return &claudePromptCache{items: map[string]*list.Element{}, order: list.New()}
+}
+
+// use reports what a request would read from and write to the cache and
+// records its prefixes. The longest live prefix is read; the rest of the
+// prompt up to the last breakpoint is written. A hit refreshes the TTL.
+func (c *claudePromptCache) use(owner string, bps []claudeCacheBreakpoint, now time.Time) claudefmt.CacheUsage {
+	if c == nil || len(bps) == 0 {
+		return claudefmt.CacheUsage{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !now.Before(c.nextSweep) {
+		c.sweepLocked(now)
+		c.nextSweep = now.Add(claudePromptCacheSweepInterval)
+	}
+	usage := claudefmt.CacheUsage{}
+	for i := len(bps) - 1; i >= 0; i-- {
+		if el, ok := c.items[owner+"\x00"+bps[i].key]; ok && el.Value.(*claudePromptCacheEntry).expiresAt.After(now) {
+			usage.ReadInputTokens = bps[i].tokens
+			break
+		}
+	}
+	usage.CreationInputTokens = bps[len(bps)-1].tokens - usage.ReadInputTokens
+	for _, bp := range bps {
+		c.touchLocked(owner+"\x00"+bp.key, now.Add(bp.ttl))
+	}
+	for c.order.Len() > claudePromptCacheMaxEntries {
+		c.removeLocked(c.order.Back())
+	}
+	return usage
+}
+
+// touchLocked marks key most recently used and extends it to expiresAt.
+func (c *claudePromptCache) touchLocked(key string, expiresAt time.Time) {
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*claudePromptCacheEntry)
+		if expiresAt.After(entry.expiresAt) {
+			entry.expiresAt = expiresAt
+		}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&claudePromptCacheEntry{key: key, expiresAt: expiresAt})
+}
+
+func (c *claudePromptCache) sweepLocked(now time.Time) {
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if !el.Value.(*claudePromptCacheEntry).expiresAt.After(now) {
+			c.removeLocked(el)
+		}
+		el = next
+	}
+}
+
+func (c *claudePromptCache) removeLocked(el *list.Element) {
+	delete(c.items, el.Value.(*claudePromptCacheEntry).key)
+	c.order.Remove(el)
+}
+
+func (h *Handler) getPromptCache() *claudePromptCache {
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
+	if h.cache == nil {
+		h.cache = newClaudePromptCache()
+	}
+	return h.cache
+}
@@ -0,0 +1,125 @@
+package claude
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ds2api/internal/config"
+)
+
+func cachedClaudeRequest(question string) map[string]any {
+	return map[string]any{
+		"model": "claude-sonnet-4-5",
+		"system": []any{
+			map[string]any{"type": "text", "text": "You are a coding agent."},
+			map[string]any{"type": "text", "text": strings.Repeat("Follow the repository rules. ", 400), "cache_control": map[string]any{"type": "ephemeral"}},
+		},
+		"messages": []any{
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "text", "text": strings.Repeat("Context line. ", 400), "cache_control": map[string]any{"type": "ephemeral", "ttl": "1h"}},
+				map[string]any{"type": "text", "text": question},
+			}},
+		},
+	}
+}
+
+func TestClaudePromptCacheReadsLongestPrefix(t *testing.T) {
+	bps, err := claudeCacheBreakpoints(cachedClaudeRequest("q1"))
+	if err != nil || len(bps) != 2 || bps[0].tokens >= bps[1].tokens || bps[1].ttl != time.Hour {
+		t.Fatalf("unexpected breakpoints %#v err=%v", bps, err)
+	}
+	cache := newClaudePromptCache()
+	now := time.Now()
+
+	first := cache.use("caller", bps, now)
+	if first.ReadInputTokens != 0 || first.CreationInputTokens != bps[1].tokens {
+		t.Fatalf("expected a cache write, got %#v", first)
+	}
+	again, _ := claudeCacheBreakpoints(cachedClaudeRequest("q2"))
+	if second := cache.use("caller", again, now.Add(time.Minute)); second.ReadInputTokens != bps[1].tokens || second.CreationInputTokens != 0 {
+		t.Fatalf("expected a full cache read, got %#v", second)
+	}
+	if other := cache.use("other", again, now.Add(time.Minute)); other.ReadInputTokens != 0 {
+		t.Fatalf("cache leaked across callers: %#v", other)
+	}
+	// The 5m system prefix has expired; the 1h prefix is still live.
+	if late := cache.use("caller", again, now.Add(30*time.Minute)); late.ReadInputTokens != bps[1].tokens {
+		t.Fatalf("expected the 1h prefix to survive, got %#v", late)
+	}
+}
+
+func TestClaudePromptCacheIsBounded(t *testing.T) {
+	cache := newClaudePromptCache()
+	now := time.Now()
+	bp := func(key string, ttl time.Duration) []claudeCacheBreakpoint {
+		return []claudeCacheBreakpoint{{key: key, tokens: 2048, ttl: ttl}}
+	}
+	cache.use("caller", bp("hot", time.Hour), now)
+	for i := 0; i < claudePromptCacheMaxEntries; i++ {
+		if i%100 == 0 {
+			cache.use("caller", bp("hot", time.Hour), now)
+		}
+		cache.use("caller", bp(strconv.Itoa(i), time.Hour), now)
+	}
+	if n := len(cache.items); n != claudePromptCacheMaxEntries {
+		t.Fatalf("expected the cache capped at %d, got %d", claudePromptCacheMaxEntries, n)
+	}
+	if _, ok := cache.items["caller\x000"]; ok {
+		t.Fatal("expected the least recently used prefix to be evicted")
+	}
+	if got := cache.use("caller", bp("hot", time.Hour), now); got.ReadInputTokens != 2048 {
+		t.Fatalf("expected the recently used prefix kept, got %#v", got)
+	}
+
+	// Expired prefixes miss at once but are only dropped at the next sweep.
+	cache = newClaudePromptCache()
+	cache.use("caller", bp("old", 10*time.Second), now)
+	cache.use("caller", bp("new", time.Hour), now.Add(20*time.Second))
+	if len(cache.items) != 2 {
+		t.Fatalf("expected no sweep before the interval, got %d items", len(cache.items))
+	}
+	cache.use("caller", bp("new", time.Hour), now.Add(claudePromptCacheSweepInterval))
+	if _, ok := cache.items["caller\x00old"]; ok || len(cache.items) != 1 {
+		t.Fatalf("expected the expired prefix swept, got %d items", len(cache.items))
+	}
+}
+
+func TestClaudeCacheBreakpointsValidation(t *testing.T) {
+	block := func(cc any) map[string]any {
+		return map[string]any{"type": "text", "text": "x", "cache_control": cc}
+	}
+	cases := []struct {
+		blocks  []any
+		wantErr string
+	}{
+		{[]any{block(map[string]any{"type": "persistent"})}, "messages.0.content.0.cache_control.type"},
+		{[]any{block(map[string]any{"type": "ephemeral", "ttl": "1d"})}, "messages.0.content.0.cache_control.ttl"},
+		{[]any{block(map[string]any{"type": "ephemeral"}), block(map[string]any{"type": "ephemeral"}), block(map[string]any{"type": "ephemeral"}), block(map[string]any{"type": "ephemeral"}), block(map[string]any{"type": "ephemeral"})}, "A maximum of 4 blocks"},
+	}
+	for _, tc := range cases {
+		req := map[string]any{"model": "claude-sonnet-4-5", "messages": []any{map[string]any{"role": "user", "content": tc.blocks}}}
+		if _, err := claudeCacheBreakpoints(req); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("expected %q, got %v", tc.wantErr, err)
+		}
+	}
+	short := map[string]any{"model": "claude-sonnet-4-5", "messages": []any{map[string]any{"role": "user", "content": []any{block(map[string]any{"type": "ephemeral"})}}}}
+	if bps, err := claudeCacheBreakpoints(short); err != nil || len(bps) != 0 {
+		t.Fatalf("expected short prefixes to be skipped, got %#v err=%v", bps, err)
+	}
+}
+
+func TestNormalizeClaudeRequestSystemBlocks(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	norm, err := normalizeClaudeRequest(config.LoadStore(), cachedClaudeRequest("q"))
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if !strings.Contains(norm.Standard.FinalPrompt, "You are a coding agent.\n\nFollow the repository rules.") {
+		t.Fatalf("system blocks missing from prompt: %q", norm.Standard.FinalPrompt[:200])
+	}
+	if len(norm.CacheBreakpoints) != 2 {
+		t.Fatalf("expected two breakpoints, got %d", len(norm.CacheBreakpoints))
+	}
+}
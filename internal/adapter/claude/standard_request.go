@@ -12,6 +12,11 @@ import (
 type claudeNormalizedRequest struct {
 	Standard           util.StandardRequest
 	NormalizedMessages []any
+	// ShowThinking is set when the client enabled thinking and upstream
+	// thinks; only then is thinking returned.
+	ShowThinking bool
+	// CacheBreakpoints are the cacheable prefixes cache_control marks.
+	CacheBreakpoints []claudeCacheBreakpoint
 }
 
 func normalizeClaudeRequest(store ConfigReader, req map[string]any) (claudeNormalizedRequest, error) {
@@ -23,12 +28,38 @@ func normalizeClaudeRequest(store ConfigReader, req map[string]any) (claudeNorma
 	if _, ok := req["max_tokens"]; !ok {
 		req["max_tokens"] = 8192
 	}
-	normalizedMessages := normalizeClaudeMessages(messagesRaw)
+	cacheBreakpoints, err := claudeCacheBreakpoints(req)
+	if err != nil {
+		return claudeNormalizedRequest{}, err
+	}
+	toolPolicy, err := parseClaudeToolPolicy(req["tool_choice"])
+	if err != nil {
+		return claudeNormalizedRequest{}, err
+	}
+	stop, err := util.ParseStopSequences(req["stop_sequences"], "stop_sequences")
+	if err != nil {
+		return claudeNormalizedRequest{}, err
+	}
+	maxTokens, err := util.ParseMaxTokens(req["max_tokens"], "max_tokens")
+	if err != nil {
+		return claudeNormalizedRequest{}, err
+	}
+	normalizedMessages, err := normalizeClaudeMessages(messagesRaw, claudeThinkingHistoryOf(store))
+	if err != nil {
+		return claudeNormalizedRequest{}, err
+	}
 	payload := cloneMap(req)
 	payload["messages"] = normalizedMessages
-	toolsRequested, _ := req["tools"].([]any)
+	allTools, webSearch := splitClaudeWebSearchTools(req["tools"])
+	toolsRequested := offeredClaudeTools(allTools, toolPolicy)
+	if toolPolicy.RequiresCall() && len(extractClaudeToolNames(toolsRequested)) == 0 {
+		if toolPolicy.Choice == util.ToolChoiceFunction {
+			return claudeNormalizedRequest{}, fmt.Errorf("tool_choice tool '%s' is not defined in 'tools'.", toolPolicy.Function)
+		}
+		return claudeNormalizedRequest{}, fmt.Errorf("tool_choice 'any' requires 'tools'.")
+	}
 	if len(toolsRequested) > 0 && !hasSystemMessage(normalizedMessages) {
-		payload["messages"] = append([]any{map[string]any{"role": "system", "content": buildClaudeToolPrompt(toolsRequested)}}, normalizedMessages...)
+		payload["messages"] = append([]any{map[string]any{"role": "system", "content": buildClaudeToolPrompt(toolsRequested, toolPolicy)}}, normalizedMessages...)
 	}
 
 	dsPayload := convertClaudeToDeepSeek(payload, store)
@@ -38,6 +69,15 @@ func normalizeClaudeRequest(store ConfigReader, req map[string]any) (claudeNorma
 		thinkingEnabled = false
 		searchEnabled = false
 	}
+	thinkingEnabled, searchEnabled, err = applyClaudeThinking(store, req["thinking"], maxTokens, thinkingEnabled, searchEnabled)
+	if err != nil {
+		return claudeNormalizedRequest{}, err
+	}
+	thinkingCfg, _ := req["thinking"].(map[string]any)
+	showThinking := thinkingEnabled && thinkingCfg["type"] == "enabled"
+	if webSearch {
+		searchEnabled = true
+	}
 	finalPrompt := deepseek.MessagesPrepare(toMessageMaps(dsPayload["messages"]))
 	toolNames := extractClaudeToolNames(toolsRequested)
 
@@ -50,10 +90,76 @@ func normalizeClaudeRequest(store ConfigReader, req map[string]any) (claudeNorma
 			Messages:       payload["messages"].([]any),
 			FinalPrompt:    finalPrompt,
 			ToolNames:      toolNames,
+			ToolPolicy:     toolPolicy,
 			Stream:         util.ToBool(req["stream"]),
 			Thinking:       thinkingEnabled,
 			Search:         searchEnabled,
+			Limits:         util.OutputLimits{Stop: stop, MaxTokens: maxTokens},
 		},
 		NormalizedMessages: normalizedMessages,
+		ShowThinking:       showThinking,
+		CacheBreakpoints:   cacheBreakpoints,
 	}, nil
 }
+
+// splitClaudeWebSearchTools separates the web_search server tool (type
+// "web_search_20250305" and later versions) from the client tools; it is
+// served by upstream search rather than the tool prompt.
+func splitClaudeWebSearchTools(toolsRaw any) ([]any, bool) {
+	tools, _ := toolsRaw.([]any)
+	out := make([]any, 0, len(tools))
+	webSearch := false
+	for _, t := range tools {
+		if tool, ok := t.(map[string]any); ok {
+			if typ, _ := tool["type"].(string); strings.HasPrefix(typ, "web_search") {
+				webSearch = true
+				continue
+			}
+		}
+		out = append(out, t)
+	}
+	return out, webSearch
+}
+
+// applyClaudeThinking overrides the thinking and search toggles the model
+// implies with the request's thinking setting: disabled applies the "none"
+// effort rule, enabled the rule its budget_tokens maps to. As on Anthropic,
+// the budget must be at least 1024 tokens and below max_tokens.
+func applyClaudeThinking(store ConfigReader, raw any, maxTokens int, thinking, search bool) (bool, bool, error) {
+	if raw == nil {
+		return thinking, search, nil
+	}
+	cfg, ok := raw.(map[string]any)
+	if !ok {
+		return false, false, fmt.Errorf("thinking must be an object.")
+	}
+	effort := ""
+	switch typ, _ := cfg["type"].(string); typ {
+	case "disabled":
+		effort = "none"
+	case "enabled":
+		budget, ok := cfg["budget_tokens"].(float64)
+		if !ok || budget != float64(int(budget)) {
+			return false, false, fmt.Errorf("thinking.budget_tokens: Field required and must be an integer.")
+		}
+		if budget < 1024 {
+			return false, false, fmt.Errorf("thinking.budget_tokens: Input should be greater than or equal to 1024")
+		}
+		if maxTokens > 0 && int(budget) >= maxTokens {
+			return false, false, fmt.Errorf("`max_tokens` must be greater than `thinking.budget_tokens`.")
+		}
+		effort = config.ClaudeThinkingEffort(int(budget))
+	default:
+		return false, false, fmt.Errorf("thinking.type: Input should be 'enabled' or 'disabled'")
+	}
+	var reader config.ReasoningRuleReader
+	if store != nil {
+		reader = store
+	}
+	rule, ok := config.LookupReasoningEffort(reader, effort)
+	if !ok {
+		return thinking, search, nil
+	}
+	thinking, search = rule.Apply(thinking, search)
+	return thinking, search, nil
+}
@@ -1,9 +1,16 @@
 package claude
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"ds2api/internal/auth"
 	"ds2api/internal/config"
+	"ds2api/internal/util"
 )
 
 func TestNormalizeClaudeRequest(t *testing.T) {
@@ -36,3 +43,171 @@ func TestNormalizeClaudeRequest(t *testing.T) {
 		t.Fatalf("expected non-empty final prompt")
 	}
 }
+
+func TestNormalizeClaudeRequestToolChoice(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	store := config.LoadStore()
+	base := func(choice any) map[string]any {
+		return map[string]any{
+			"model":    "claude-sonnet-4-5",
+			"messages": []any{map[string]any{"role": "user", "content": "hello"}},
+			"tools": []any{
+				map[string]any{"name": "search", "description": "Search"},
+				map[string]any{"name": "fetch", "description": "Fetch"},
+			},
+			"tool_choice": choice,
+		}
+	}
+	norm, err := normalizeClaudeRequest(store, base(map[string]any{"type": "tool", "name": "fetch", "disable_parallel_tool_use": true}))
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	policy := norm.Standard.ToolPolicy
+	if policy.Choice != util.ToolChoiceFunction || policy.Function != "fetch" || !policy.Single {
+		t.Fatalf("unexpected tool policy: %#v", policy)
+	}
+	if len(norm.Standard.ToolNames) != 1 || norm.Standard.ToolNames[0] != "fetch" || strings.Contains(norm.Standard.FinalPrompt, "Tool: search") {
+		t.Fatalf("expected only the forced tool to be offered, names=%v", norm.Standard.ToolNames)
+	}
+
+	norm, err = normalizeClaudeRequest(store, base(map[string]any{"type": "none"}))
+	if err != nil || len(norm.Standard.ToolNames) != 0 {
+		t.Fatalf("expected no tools for tool_choice none, names=%v err=%v", norm.Standard.ToolNames, err)
+	}
+	if _, err := normalizeClaudeRequest(store, base(map[string]any{"type": "tool", "name": "missing"})); err == nil {
+		t.Fatal("expected unknown forced tool to be rejected")
+	}
+}
+
+func TestNormalizeClaudeRequestOutputLimits(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	store := config.LoadStore()
+	req := map[string]any{
+		"model":          "claude-sonnet-4-5",
+		"messages":       []any{map[string]any{"role": "user", "content": "hello"}},
+		"stop_sequences": []any{"\n\nHuman:"},
+	}
+	norm, err := normalizeClaudeRequest(store, req)
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	limits := norm.Standard.Limits
+	if limits.MaxTokens != 8192 || len(limits.Stop) != 1 || limits.Stop[0] != "\n\nHuman:" {
+		t.Fatalf("unexpected limits: %#v", limits)
+	}
+
+	req["max_tokens"] = -1.0
+	if _, err := normalizeClaudeRequest(store, req); err == nil {
+		t.Fatal("expected invalid max_tokens to be rejected")
+	}
+}
+
+type allowAllAuth struct{}
+
+func (allowAllAuth) Determine(*http.Request) (*auth.RequestAuth, error) {
+	return &auth.RequestAuth{}, nil
+}
+func (allowAllAuth) DetermineCaller(*http.Request) (*auth.RequestAuth, error) {
+	return &auth.RequestAuth{}, nil
+}
+func (allowAllAuth) DetermineCallerRef(*http.Request) (*auth.RequestAuth, string, error) {
+	return &auth.RequestAuth{}, "", nil
+}
+func (allowAllAuth) AcquireIdle(context.Context, string) (*auth.RequestAuth, bool, error) {
+	return &auth.RequestAuth{}, true, nil
+}
+func (allowAllAuth) Release(*auth.RequestAuth) {}
+
+// busyPoolAuth has no account to lease; only caller checks succeed.
+type busyPoolAuth struct{ allowAllAuth }
+
+func (busyPoolAuth) Determine(*http.Request) (*auth.RequestAuth, error) {
+	return nil, auth.ErrNoAccount
+}
+
+func TestCountTokensUsesRequestPrompt(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	// Counting is local, so it must not need a pool account.
+	h := &Handler{Store: config.LoadStore(), Auth: busyPoolAuth{}}
+	count := func(body string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.CountTokens(rec, httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages/count_tokens", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d body=%s", rec.Code, rec.Body.String())
+		}
+		var out map[string]int
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return out["input_tokens"]
+	}
+	plain := `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hello"}]}`
+	norm, err := normalizeClaudeRequest(h.Store, map[string]any{
+		"model":    "claude-sonnet-4-5",
+		"messages": []any{map[string]any{"role": "user", "content": "hello"}},
+	})
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if got, want := count(plain), util.CountTokens(norm.Standard.FinalPrompt); got != want {
+		t.Fatalf("count_tokens=%d, want the request prompt count %d", got, want)
+	}
+	withTools := `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hello"}],"tools":[{"name":"search","description":"Search the web","input_schema":{"type":"object"}}]}`
+	if count(withTools) <= count(plain) {
+		t.Fatal("expected the tool prompt to be counted")
+	}
+}
+
+func TestNormalizeClaudeRequestThinkingOverridesModel(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	store := config.LoadStore()
+	cases := []struct {
+		model    string
+		thinking any
+		want     bool
+	}{
+		{"claude-sonnet-4-5", map[string]any{"type": "enabled", "budget_tokens": float64(2048)}, true},
+		{"claude-opus-4-6", map[string]any{"type": "disabled"}, false},
+		{"claude-opus-4-6", nil, true},
+	}
+	for _, tc := range cases {
+		req := map[string]any{
+			"model":    tc.model,
+			"messages": []any{map[string]any{"role": "user", "content": "hi"}},
+		}
+		if tc.thinking != nil {
+			req["thinking"] = tc.thinking
+		}
+		norm, err := normalizeClaudeRequest(store, req)
+		if err != nil {
+			t.Fatalf("%s: normalize failed: %v", tc.model, err)
+		}
+		if norm.Standard.Thinking != tc.want {
+			t.Fatalf("%s %v: thinking=%v want %v", tc.model, tc.thinking, norm.Standard.Thinking, tc.want)
+		}
+		if norm.Standard.ResponseModel != tc.model {
+			t.Fatalf("response model changed to %q", norm.Standard.ResponseModel)
+		}
+	}
+}
+
+func TestNormalizeClaudeRequestRejectsInvalidThinking(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	store := config.LoadStore()
+	for _, thinking := range []any{
+		map[string]any{"type": "enabled", "budget_tokens": float64(512)},
+		map[string]any{"type": "enabled"},
+		map[string]any{"type": "sometimes"},
+		"on",
+	} {
+		req := map[string]any{
+			"model":    "claude-sonnet-4-5",
+			"messages": []any{map[string]any{"role": "user", "content": "hi"}},
+			"thinking": thinking,
+		}
+		if _, err := normalizeClaudeRequest(store, req); err == nil {
+			t.Fatalf("expected error for thinking %#v", thinking)
+		}
+	}
+}
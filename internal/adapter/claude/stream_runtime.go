@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	claudefmt "ds2api/internal/format/claude"
 	"ds2api/internal/sse"
 	streamengine "ds2api/internal/stream"
 	"ds2api/internal/util"
@@ -18,25 +19,49 @@ type claudeStreamRuntime struct {
 	canFlush bool
 	writable bool
 
-	model     string
-	toolNames []string
-	messages  []any
+	model       string
+	toolNames   []string
+	toolPolicy  util.ToolPolicy
+	finalPrompt string
+	// finalizeText, when set, may replace the buffered text before tool
+	// detection (a retry for a required tool call).
+	finalizeText func(text string, usage *util.UpstreamUsage) string
+	// limiter cuts output at the client's stop_sequences and max_tokens.
+	limiter *util.OutputLimiter
+	// upstream is the accounting DeepSeek reported on the stream so far.
+	upstream util.UpstreamUsage
+	// cache is the prompt-caching split of the input tokens.
+	cache claudefmt.CacheUsage
 
-	thinkingEnabled   bool
-	searchEnabled     bool
+	// thinkingKey signs the thinking blocks shown to the client; nil hides
+	// thinking the client did not ask for.
+	thinkingKey       []byte
 	bufferToolContent bool
 
 	messageID string
 	thinking  strings.Builder
 	text      strings.Builder
+	// blockThinking is the text of the open thinking block, which its
+	// signature covers.
+	blockThinking strings.Builder
 
 	nextBlockIndex     int
 	thinkingBlockOpen  bool
 	thinkingBlockIndex int
 	textBlockOpen      bool
 	textBlockIndex     int
-	ended              bool
-	upstreamErr        string
+	// textBlockCited marks a text block that already carries citations;
+	// the text after them starts a new block.
+	textBlockCited bool
+	ended          bool
+	upstreamErr    string
+	// filtered marks a stream upstream's content filter ended.
+	filtered bool
+
+	// sources, set when search is enabled, collects upstream searches and
+	// resolves the citation markers in the text.
+	sources   *util.SearchSources
+	citations util.CitationScanner
 }
 
 func newClaudeStreamRuntime(
@@ -44,26 +69,29 @@ func newClaudeStreamRuntime(
 	rc *http.ResponseController,
 	canFlush bool,
 	model string,
-	messages []any,
-	thinkingEnabled bool,
+	finalPrompt string,
+	thinkingKey []byte,
 	searchEnabled bool,
 	toolNames []string,
 ) *claudeStreamRuntime {
-	return &claudeStreamRuntime{
+	s := &claudeStreamRuntime{
 		w:                  w,
 		rc:                 rc,
 		canFlush:           canFlush,
 		writable:           true,
 		model:              model,
-		messages:           messages,
-		thinkingEnabled:    thinkingEnabled,
-		searchEnabled:      searchEnabled,
+		finalPrompt:        finalPrompt,
+		thinkingKey:        thinkingKey,
 		bufferToolContent:  len(toolNames) > 0,
 		toolNames:          toolNames,
 		messageID:          fmt.Sprintf("msg_%d", time.Now().UnixNano()),
 		thinkingBlockIndex: -1,
 		textBlockIndex:     -1,
 	}
+	if searchEnabled {
+		s.sources = &util.SearchSources{}
+	}
+	return s
 }
 
 func (s *claudeStreamRuntime) send(event string, v any) bool {
@@ -104,7 +132,8 @@ func (s *claudeStreamRuntime) send(event string, v any) bool {
 	return true
 }
 
-func (s *claudeStreamRuntime) sendError(message string) {
+// sendError ends the stream with an error event typed by status.
+func (s *claudeStreamRuntime) sendError(status int, message string) {
 	if !s.writable {
 		return
 	}
@@ -113,13 +142,8 @@ func (s *claudeStreamRuntime) sendError(message string) {
 		msg = "upstream stream error"
 	}
 	s.send("error", map[string]any{
-		"type": "error",
-		"error": map[string]any{
-			"type":    "api_error",
-			"message": msg,
-			"code":    "internal_error",
-			"param":   nil,
-		},
+		"type":  "error",
+		"error": claudeErrorObject(status, msg),
 	})
 }
 
@@ -128,7 +152,8 @@ func (s *claudeStreamRuntime) sendPing() bool {
 }
 
 func (s *claudeStreamRuntime) sendMessageStart() bool {
-	inputTokens := util.EstimateTokens(fmt.Sprintf("%v", s.messages))
+	usage := map[string]any{"input_tokens": util.CountTokens(s.finalPrompt), "output_tokens": 0}
+	s.cache.Apply(usage)
 	return s.send("message_start", map[string]any{
 		"type": "message_start",
 		"message": map[string]any{
@@ -139,15 +164,27 @@ func (s *claudeStreamRuntime) sendMessageStart() bool {
 			"content":       []any{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage":         map[string]any{"input_tokens": inputTokens, "output_tokens": 0},
+			"usage":         usage,
 		},
 	})
 }
 
+// closeThinkingBlock signs the open thinking block with a signature_delta
+// and closes it.
 func (s *claudeStreamRuntime) closeThinkingBlock() {
 	if !s.thinkingBlockOpen {
 		return
 	}
+	if !s.send("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": s.thinkingBlockIndex,
+		"delta": map[string]any{
+			"type":      "signature_delta",
+			"signature": claudefmt.SignThinking(s.thinkingKey, s.blockThinking.String()),
+		},
+	}) {
+		return
+	}
 	if !s.send("content_block_stop", map[string]any{
 		"type":  "content_block_stop",
 		"index": s.thinkingBlockIndex,
@@ -156,6 +193,7 @@ func (s *claudeStreamRuntime) closeThinkingBlock() {
 	}
 	s.thinkingBlockOpen = false
 	s.thinkingBlockIndex = -1
+	s.blockThinking.Reset()
 }
 
 func (s *claudeStreamRuntime) closeTextBlock() {
@@ -170,12 +208,19 @@ func (s *claudeStreamRuntime) closeTextBlock() {
 	}
 	s.textBlockOpen = false
 	s.textBlockIndex = -1
+	s.textBlockCited = false
 }
 
 func (s *claudeStreamRuntime) finalize(stopReason string) {
 	if s.ended || !s.writable {
 		return
 	}
+	if !s.appendText(s.limiter.Flush()) {
+		return
+	}
+	if !s.bufferToolContent && !s.writeSegments(s.citations.Flush()) {
+		return
+	}
 	s.ended = true
 
 	s.closeThinkingBlock()
@@ -185,7 +230,10 @@ func (s *claudeStreamRuntime) finalize(stopReason string) {
 	finalText := s.text.String()
 
 	if s.bufferToolContent {
-		detected := util.ParseToolCalls(finalText, s.toolNames)
+		if s.finalizeText != nil {
+			finalText = s.finalizeText(finalText, &s.upstream)
+		}
+		detected := s.toolPolicy.Filter(util.ParseToolCalls(finalText, s.toolNames), s.toolNames)
 		if len(detected) > 0 {
 			stopReason = "tool_use"
 			for i, tc := range detected {
@@ -195,7 +243,7 @@ func (s *claudeStreamRuntime) finalize(stopReason string) {
 					"index": idx,
 					"content_block": map[string]any{
 						"type":  "tool_use",
-						"id":    fmt.Sprintf("toolu_%d_%d", time.Now().Unix(), idx),
+						"id":    claudefmt.NewToolUseID(),
 						"name":  tc.Name,
 						"input": tc.Input,
 					},
@@ -211,47 +259,29 @@ func (s *claudeStreamRuntime) finalize(stopReason string) {
 			}
 			s.nextBlockIndex += len(detected)
 		} else if finalText != "" {
-			idx := s.nextBlockIndex
-			s.nextBlockIndex++
-			if !s.send("content_block_start", map[string]any{
-				"type":  "content_block_start",
-				"index": idx,
-				"content_block": map[string]any{
-					"type": "text",
-					"text": "",
-				},
-			}) {
-				return
-			}
-			if !s.send("content_block_delta", map[string]any{
-				"type":  "content_block_delta",
-				"index": idx,
-				"delta": map[string]any{
-					"type": "text_delta",
-					"text": finalText,
-				},
-			}) {
-				return
-			}
-			if !s.send("content_block_stop", map[string]any{
-				"type":  "content_block_stop",
-				"index": idx,
-			}) {
+			if !s.streamText(finalText) || !s.streamText("") {
 				return
 			}
+			s.closeTextBlock()
 		}
 	}
 
-	outputTokens := util.EstimateTokens(finalThinking) + util.EstimateTokens(finalText)
+	var stopSequence any
+	if stopReason == "end_turn" {
+		stopReason, stopSequence = claudeLimitStop(s.limiter, stopReason)
+	}
+	usage := claudefmt.BuildUsage(s.finalPrompt, finalThinking, finalText, s.upstream.Delivered(s.limiter.Done() || finalText != s.text.String()))
+	delete(usage, "input_tokens")
+	if n := len(s.sources.Searches()); n > 0 {
+		usage["server_tool_use"] = claudefmt.BuildServerToolUsage(n)
+	}
 	if !s.send("message_delta", map[string]any{
 		"type": "message_delta",
 		"delta": map[string]any{
 			"stop_reason":   stopReason,
-			"stop_sequence": nil,
-		},
-		"usage": map[string]any{
-			"output_tokens": outputTokens,
+			"stop_sequence": stopSequence,
 		},
+		"usage": usage,
 	}) {
 		return
 	}
@@ -265,6 +295,11 @@ func (s *claudeStreamRuntime) onParsed(parsed sse.LineResult) streamengine.Parse
 	if !parsed.Parsed {
 		return streamengine.ParsedDecision{}
 	}
+	s.upstream.Merge(parsed.Usage)
+	if parsed.ContentFilter {
+		s.filtered = true
+		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReason("content_filter")}
+	}
 	if parsed.ErrorMessage != "" {
 		s.upstreamErr = parsed.ErrorMessage
 		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReason("upstream_error")}
@@ -272,22 +307,27 @@ func (s *claudeStreamRuntime) onParsed(parsed sse.LineResult) streamengine.Parse
 	if parsed.Stop {
 		return streamengine.ParsedDecision{Stop: true}
 	}
+	if !s.search(parsed.Search) {
+		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+	}
 
 	contentSeen := false
 	for _, p := range parsed.Parts {
 		if p.Text == "" {
 			continue
 		}
-		if p.Type != "thinking" && s.searchEnabled && sse.IsCitation(p.Text) {
-			continue
-		}
 		contentSeen = true
 
 		if p.Type == "thinking" {
-			if !s.thinkingEnabled {
+			if s.thinkingKey == nil {
 				continue
 			}
-			s.thinking.WriteString(p.Text)
+			text := s.limiter.Thinking(p.Text)
+			if text == "" {
+				continue
+			}
+			s.thinking.WriteString(text)
+			s.blockThinking.WriteString(text)
 			s.closeTextBlock()
 			if !s.thinkingBlockOpen {
 				s.thinkingBlockIndex = s.nextBlockIndex
@@ -309,7 +349,7 @@ func (s *claudeStreamRuntime) onParsed(parsed sse.LineResult) streamengine.Parse
 				"index": s.thinkingBlockIndex,
 				"delta": map[string]any{
 					"type":     "thinking_delta",
-					"thinking": p.Text,
+					"thinking": text,
 				},
 			}) {
 				return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
@@ -317,49 +357,180 @@ func (s *claudeStreamRuntime) onParsed(parsed sse.LineResult) streamengine.Parse
 			continue
 		}
 
-		s.text.WriteString(p.Text)
-		if s.bufferToolContent {
-			continue
-		}
-		s.closeThinkingBlock()
-		if !s.textBlockOpen {
-			s.textBlockIndex = s.nextBlockIndex
-			s.nextBlockIndex++
-			if !s.send("content_block_start", map[string]any{
-				"type":  "content_block_start",
-				"index": s.textBlockIndex,
-				"content_block": map[string]any{
-					"type": "text",
-					"text": "",
-				},
-			}) {
-				return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
-			}
-			s.textBlockOpen = true
-		}
-		if !s.send("content_block_delta", map[string]any{
-			"type":  "content_block_delta",
-			"index": s.textBlockIndex,
-			"delta": map[string]any{
-				"type": "text_delta",
-				"text": p.Text,
-			},
-		}) {
+		if !s.appendText(s.limiter.Text(p.Text)) {
 			return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
 		}
 	}
 
+	if s.limiter.Done() {
+		// Ending the scan closes the upstream body, aborting generation.
+		return streamengine.ParsedDecision{ContentSeen: contentSeen, Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+	}
 	return streamengine.ParsedDecision{ContentSeen: contentSeen}
 }
 
-func (s *claudeStreamRuntime) onFinalize(reason streamengine.StopReason, scannerErr error) {
-	if string(reason) == "upstream_error" {
-		s.sendError(s.upstreamErr)
-		return
+// appendText records admitted output text and streams it as a text block
+// delta, unless tool detection buffers it until finalize. It reports false
+// once the client can no longer be written to.
+func (s *claudeStreamRuntime) appendText(text string) bool {
+	if text == "" {
+		return true
 	}
-	if scannerErr != nil {
-		s.sendError(scannerErr.Error())
-		return
+	s.text.WriteString(text)
+	if s.bufferToolContent {
+		return true
+	}
+	return s.streamText(text)
+}
+
+// streamText streams text into text blocks. With search enabled, citation
+// markers become citations_delta events on the block they close; an empty
+// text flushes a marker held back at the end of the previous chunk.
+func (s *claudeStreamRuntime) streamText(text string) bool {
+	if s.sources == nil {
+		return s.writeTextDelta(text)
+	}
+	if text == "" {
+		return s.writeSegments(s.citations.Flush())
+	}
+	return s.writeSegments(s.citations.Feed(text))
+}
+
+func (s *claudeStreamRuntime) writeSegments(segments []util.CitationSegment) bool {
+	for _, seg := range segments {
+		ok := true
+		if seg.Cite > 0 {
+			ok = s.writeCitation(seg.Cite)
+		} else {
+			ok = s.writeTextDelta(seg.Text)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *claudeStreamRuntime) writeTextDelta(text string) bool {
+	if text == "" {
+		return true
+	}
+	if s.textBlockCited {
+		s.closeTextBlock()
+	}
+	if !s.openTextBlock() {
+		return false
+	}
+	return s.send("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": s.textBlockIndex,
+		"delta": map[string]any{
+			"type": "text_delta",
+			"text": text,
+		},
+	})
+}
+
+// writeCitation attaches citation n to the open text block. Markers no
+// search result answers are dropped.
+func (s *claudeStreamRuntime) writeCitation(n int) bool {
+	r, ok := s.sources.Lookup(n)
+	if !ok {
+		return true
+	}
+	if !s.openTextBlock() {
+		return false
+	}
+	s.textBlockCited = true
+	return s.send("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": s.textBlockIndex,
+		"delta": map[string]any{
+			"type":     "citations_delta",
+			"citation": claudefmt.BuildWebSearchCitation(n, r),
+		},
+	})
+}
+
+func (s *claudeStreamRuntime) openTextBlock() bool {
+	if s.textBlockOpen {
+		return true
+	}
+	s.closeThinkingBlock()
+	s.textBlockIndex = s.nextBlockIndex
+	s.nextBlockIndex++
+	if !s.send("content_block_start", map[string]any{
+		"type":  "content_block_start",
+		"index": s.textBlockIndex,
+		"content_block": map[string]any{
+			"type": "text",
+			"text": "",
+		},
+	}) {
+		return false
+	}
+	s.textBlockOpen = true
+	return true
+}
+
+// search records upstream search activity; every search whose results
+// arrive is streamed as a server_tool_use and web_search_tool_result pair.
+func (s *claudeStreamRuntime) search(u util.SearchUpdate) bool {
+	if s.sources == nil || u.IsZero() {
+		return true
+	}
+	before := len(s.sources.Searches())
+	s.sources.Merge(u)
+	for _, ws := range s.sources.Searches()[before:] {
+		s.closeThinkingBlock()
+		s.closeTextBlock()
+		id := claudefmt.NewServerToolUseID(s.nextBlockIndex)
+		use := claudefmt.BuildServerToolUseBlock(id, ws.Query)
+		input, _ := json.Marshal(use["input"])
+		use["input"] = map[string]any{}
+		if !s.sendWholeBlock(use, map[string]any{"type": "input_json_delta", "partial_json": string(input)}) ||
+			!s.sendWholeBlock(claudefmt.BuildWebSearchToolResultBlock(id, ws.Results), nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// sendWholeBlock streams a complete content block, with one optional delta.
+func (s *claudeStreamRuntime) sendWholeBlock(block map[string]any, delta map[string]any) bool {
+	idx := s.nextBlockIndex
+	s.nextBlockIndex++
+	if !s.send("content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         idx,
+		"content_block": block,
+	}) {
+		return false
+	}
+	if delta != nil && !s.send("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": idx,
+		"delta": delta,
+	}) {
+		return false
+	}
+	return s.send("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": idx,
+	})
+}
+
+func (s *claudeStreamRuntime) onFinalize(reason streamengine.StopReason, scannerErr error) {
+	switch {
+	case s.filtered:
+		s.finalize("refusal")
+	case string(reason) == "upstream_error":
+		s.sendError(http.StatusInternalServerError, s.upstreamErr)
+	case scannerErr != nil:
+		s.sendError(http.StatusInternalServerError, scannerErr.Error())
+	case reason == streamengine.StopReasonIdleTimeout || reason == streamengine.StopReasonNoContentTimeout:
+		s.sendError(statusOverloaded, "upstream stopped responding")
+	default:
+		s.finalize("end_turn")
 	}
-	s.finalize("end_turn")
 }
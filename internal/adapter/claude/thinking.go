@@ -0,0 +1,60 @@
+package claude
+
+import (
+	"fmt"
+	"strings"
+
+	"ds2api/internal/config"
+	claudefmt "ds2api/internal/format/claude"
+)
+
+// claudeThinkingHistory is how thinking blocks sent back in the message
+// history are treated; see config.ReasoningConfig.
+type claudeThinkingHistory struct {
+	policy string
+	key    []byte
+}
+
+func claudeThinkingHistoryOf(store ConfigReader) claudeThinkingHistory {
+	if store == nil {
+		return claudeThinkingHistory{policy: config.ThinkingHistoryDrop}
+	}
+	return claudeThinkingHistory{policy: store.ThinkingHistoryPolicy(), key: store.ThinkingSignatureKey()}
+}
+
+// block checks a thinking or redacted_thinking block of message msgIdx and
+// returns the text it contributes to the prompt. Only the replay policy keeps
+// thinking text, so only it rejects blocks without the signature this server
+// gave them; drop discards them even when the signature no longer verifies,
+// e.g. after the key changed. Redacted thinking is opaque and never reaches
+// the prompt.
+func (t claudeThinkingHistory) block(msgIdx, blockIdx int, b map[string]any) (string, error) {
+	if t.policy == config.ThinkingHistoryIgnore {
+		return "", nil
+	}
+	path := fmt.Sprintf("messages.%d.content.%d", msgIdx, blockIdx)
+	if b["type"] == "redacted_thinking" {
+		if data, _ := b["data"].(string); strings.TrimSpace(data) == "" {
+			return "", fmt.Errorf("%s.redacted_thinking.data: Field required", path)
+		}
+		return "", nil
+	}
+	thinking, ok := b["thinking"].(string)
+	if !ok {
+		return "", fmt.Errorf("%s.thinking.thinking: Field required", path)
+	}
+	signature, ok := b["signature"].(string)
+	if !ok {
+		return "", fmt.Errorf("%s.thinking.signature: Field required", path)
+	}
+	if t.policy != config.ThinkingHistoryReplay {
+		return "", nil
+	}
+	if !claudefmt.VerifyThinking(t.key, thinking, signature) {
+		return "", fmt.Errorf("%s: Invalid `signature` in `thinking` block", path)
+	}
+	if thinking == "" {
+		return "", nil
+	}
+	return "<think>\n" + thinking + "\n</think>", nil
+}
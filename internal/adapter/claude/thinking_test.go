@@ -0,0 +1,88 @@
+package claude
+
+import (
+	"strings"
+	"testing"
+
+	"ds2api/internal/config"
+	claudefmt "ds2api/internal/format/claude"
+)
+
+func thinkingHistoryRequest(block map[string]any) map[string]any {
+	return map[string]any{
+		"model": "claude-sonnet-4-5",
+		"messages": []any{
+			map[string]any{"role": "user", "content": "hi"},
+			map[string]any{"role": "assistant", "content": []any{
+				block,
+				map[string]any{"type": "text", "text": "hello"},
+			}},
+			map[string]any{"role": "user", "content": "again"},
+		},
+	}
+}
+
+func TestNormalizeClaudeRequestThinkingHistory(t *testing.T) {
+	signed := map[string]any{"type": "thinking", "thinking": "plan", "signature": claudefmt.SignThinking([]byte("secret"), "plan")}
+	forged := map[string]any{"type": "thinking", "thinking": "edited", "signature": signed["signature"]}
+	redacted := map[string]any{"type": "redacted_thinking", "data": "opaque"}
+	cases := []struct {
+		policy  string
+		block   map[string]any
+		wantErr string
+		replay  bool
+	}{
+		{"", signed, "", false},
+		{"", forged, "", false},
+		{"", map[string]any{"type": "thinking", "thinking": "plan"}, "messages.1.content.0.thinking.signature: Field required", false},
+		{"", redacted, "", false},
+		{"replay", signed, "", true},
+		{"replay", forged, "messages.1.content.0: Invalid `signature` in `thinking` block", false},
+		{"ignore", forged, "", false},
+	}
+	for _, tc := range cases {
+		t.Setenv("DS2API_CONFIG_JSON", `{"reasoning":{"signature_key":"secret","thinking_history":"`+tc.policy+`"}}`)
+		norm, err := normalizeClaudeRequest(config.LoadStore(), thinkingHistoryRequest(tc.block))
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("policy %q block %v: expected %q, got %v", tc.policy, tc.block, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("policy %q block %v: %v", tc.policy, tc.block, err)
+		}
+		if strings.Contains(norm.Standard.FinalPrompt, "plan") != tc.replay {
+			t.Fatalf("policy %q: replayed=%v prompt=%q", tc.policy, !tc.replay, norm.Standard.FinalPrompt)
+		}
+	}
+}
+
+func TestNormalizeClaudeRequestShowThinking(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	store := config.LoadStore()
+	base := func() map[string]any {
+		return map[string]any{
+			"model":      "claude-opus-4-6",
+			"max_tokens": float64(4096),
+			"messages":   []any{map[string]any{"role": "user", "content": "hi"}},
+		}
+	}
+	norm, err := normalizeClaudeRequest(store, base())
+	if err != nil || !norm.Standard.Thinking || norm.ShowThinking {
+		t.Fatalf("expected hidden upstream thinking, got thinking=%v show=%v err=%v", norm.Standard.Thinking, norm.ShowThinking, err)
+	}
+
+	req := base()
+	req["thinking"] = map[string]any{"type": "enabled", "budget_tokens": float64(2048)}
+	norm, err = normalizeClaudeRequest(store, req)
+	if err != nil || !norm.ShowThinking {
+		t.Fatalf("expected shown thinking, got show=%v err=%v", norm.ShowThinking, err)
+	}
+
+	req = base()
+	req["thinking"] = map[string]any{"type": "enabled", "budget_tokens": float64(4096)}
+	if _, err := normalizeClaudeRequest(store, req); err == nil || !strings.Contains(err.Error(), "max_tokens") {
+		t.Fatalf("expected budget_tokens >= max_tokens to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/deepseek"
+	"ds2api/internal/sse"
+	"ds2api/internal/util"
+)
+
+// parseClaudeToolPolicy reads the Anthropic `tool_choice` object: auto, any,
+// tool (with name) or none, each optionally with disable_parallel_tool_use.
+func parseClaudeToolPolicy(raw any) (util.ToolPolicy, error) {
+	if raw == nil {
+		return util.ToolPolicy{}, nil
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return util.ToolPolicy{}, fmt.Errorf("'tool_choice' must be an object.")
+	}
+	policy := util.ToolPolicy{Single: util.ToBool(m["disable_parallel_tool_use"])}
+	typ, _ := m["type"].(string)
+	switch strings.TrimSpace(typ) {
+	case "", "auto":
+	case "any":
+		policy.Choice = util.ToolChoiceRequired
+	case "none":
+		policy.Choice = util.ToolChoiceNone
+	case "tool":
+		name, _ := m["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			return util.ToolPolicy{}, fmt.Errorf("'tool_choice.name' is required when type is tool.")
+		}
+		policy.Choice = util.ToolChoiceFunction
+		policy.Function = strings.TrimSpace(name)
+	default:
+		return util.ToolPolicy{}, fmt.Errorf("Unsupported tool_choice type '%s'.", typ)
+	}
+	return policy, nil
+}
+
+// offeredClaudeTools keeps the tools the policy lets the model see.
+func offeredClaudeTools(tools []any, policy util.ToolPolicy) []any {
+	out := make([]any, 0, len(tools))
+	for _, t := range tools {
+		m, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := m["name"].(string)
+		if policy.Offers(name) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+type completionError struct {
+	status  int
+	message string
+}
+
+// startCompletion opens an upstream session and starts the completion. On
+// success the caller owns resp.Body.
+func (h *Handler) startCompletion(ctx context.Context, a *auth.RequestAuth, stdReq util.StandardRequest) (*http.Response, *completionError) {
+	sessionID, err := h.DS.CreateSession(ctx, a, 3)
+	if err != nil {
+		return nil, &completionError{http.StatusUnauthorized, "invalid token."}
+	}
+	pow, err := h.DS.GetPow(ctx, a, 3)
+	if err != nil {
+		return nil, &completionError{http.StatusUnauthorized, "Failed to get PoW"}
+	}
+	resp, err := h.DS.CallCompletion(ctx, a, stdReq.CompletionPayload(sessionID), pow, 3)
+	if err != nil {
+		return nil, &completionError{http.StatusInternalServerError, "Failed to get Claude response."}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &completionError{claudeUpstreamStatus(resp.StatusCode), string(body)}
+	}
+	return resp, nil
+}
+
+// toolCallFinalizer returns a hook that retries once when tool_choice demanded
+// a call and the reply had none, or nil when no call is required. The retry
+// is held to the request's output limits and counted into usage.
+func (h *Handler) toolCallFinalizer(ctx context.Context, a *auth.RequestAuth, stdReq util.StandardRequest) func(text string, usage *util.UpstreamUsage) string {
+	if !stdReq.ToolPolicy.RequiresCall() {
+		return nil
+	}
+	return func(text string, usage *util.UpstreamUsage) string {
+		if len(stdReq.ToolPolicy.Filter(util.ParseToolCalls(text, stdReq.ToolNames), stdReq.ToolNames)) > 0 {
+			return text
+		}
+		retryReq := stdReq
+		retryReq.FinalPrompt = stdReq.FinalPrompt + deepseek.MessagesPrepare([]map[string]any{
+			{"role": "assistant", "content": text},
+			{"role": "user", "content": stdReq.ToolPolicy.RetryPrompt()},
+		})
+		resp, callErr := h.startCompletion(ctx, a, retryReq)
+		if callErr != nil {
+			return text
+		}
+		result := sse.CollectStreamLimited(resp, stdReq.Thinking, true, util.NewOutputLimiter(stdReq.Limits))
+		if len(stdReq.ToolPolicy.Filter(util.ParseToolCalls(result.Text, stdReq.ToolNames), stdReq.ToolNames)) == 0 {
+			usage.AddRetry(retryReq.FinalPrompt, result.Thinking, result.Text)
+			return text
+		}
+		usage.AddRetry(retryReq.FinalPrompt, result.Thinking, text)
+		return result.Text
+	}
+}
@@ -0,0 +1,67 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/config"
+	"ds2api/internal/util"
+)
+
+// repliesDS answers the n-th completion with replies[n] and records prompts.
+type repliesDS struct {
+	batchDS
+	mu      sync.Mutex
+	replies []string
+	prompts []string
+}
+
+func (d *repliesDS) CallCompletion(_ context.Context, _ *auth.RequestAuth, payload map[string]any, _ string, _ int) (*http.Response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prompt, _ := payload["prompt"].(string)
+	d.prompts = append(d.prompts, prompt)
+	b, _ := json.Marshal(map[string]any{"p": "response/content", "v": d.replies[len(d.prompts)-1]})
+	return makeClaudeSSEHTTPResponse("data: "+string(b), "data: [DONE]"), nil
+}
+
+func TestToolChoiceRetryIsLimitedAndCounted(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	first, retry := "It is sunny.", `{"tool_calls":[{"name":"search","input":{"q":"Paris"}}]}`
+	send := func(ds *repliesDS, extra string) map[string]any {
+		h := &Handler{Store: config.LoadStore(), Auth: batchAuth{}, DS: ds}
+		router := chi.NewRouter()
+		RegisterRoutes(router, h)
+		rec := batchRequest(t, router, http.MethodPost, "/anthropic/v1/messages", "alice",
+			`{"model":"claude-sonnet-4-5","max_tokens":64,`+extra+`"tool_choice":{"type":"any"},"tools":[{"name":"search","input_schema":{"type":"object"}}],"messages":[{"role":"user","content":"weather?"}]}`)
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+		}
+		return body
+	}
+
+	ds := &repliesDS{replies: []string{first, retry}}
+	body := send(ds, "")
+	if body["stop_reason"] != "tool_use" {
+		t.Fatalf("expected the retry's tool call, got %#v", body)
+	}
+	usage := body["usage"].(map[string]any)
+	if got, want := int(usage["input_tokens"].(float64)), util.CountTokens(ds.prompts[0])+util.CountTokens(ds.prompts[1]); got != want {
+		t.Fatalf("expected the retry prompt counted, input_tokens=%d want %d", got, want)
+	}
+	if got, want := int(usage["output_tokens"].(float64)), util.CountTokens(first)+util.CountTokens(retry); got != want {
+		t.Fatalf("expected both replies counted, output_tokens=%d want %d", got, want)
+	}
+
+	body = send(&repliesDS{replies: []string{first, retry}}, `"stop_sequences":["Paris"],`)
+	if body["stop_reason"] == "tool_use" {
+		t.Fatalf("expected the retry cut at the stop sequence, got %#v", body)
+	}
+}
@@ -0,0 +1,98 @@
+package claude
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ds2api/internal/config"
+	claudefmt "ds2api/internal/format/claude"
+	"ds2api/internal/sse"
+	"ds2api/internal/util"
+)
+
+func claudeWebSearchSSE() *http.Response {
+	return makeClaudeSSEHTTPResponse(
+		`data: {"p":"response/fragments","o":"APPEND","v":[{"type":"SEARCH","content":"","queries":[{"query":"go release"}],"results":[]}]}`,
+		`data: {"p":"response/fragments/-1/results","o":"SET","v":[{"url":"https://go.dev/blog","title":"Go Blog","snippet":"Go 1.22 is released"}]}`,
+		`data: {"p":"response/content","v":"Go 1.22 is out"}`,
+		`data: {"v":"[citation:1]"}`,
+		`data: {"p":"response/content","v":"."}`,
+		`data: [DONE]`,
+	)
+}
+
+func TestNormalizeClaudeRequestWebSearchTool(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	store := config.LoadStore()
+	req := map[string]any{
+		"model":    "claude-sonnet-4-5",
+		"messages": []any{map[string]any{"role": "user", "content": "news"}},
+		"tools":    []any{map[string]any{"type": "web_search_20250305", "name": "web_search", "max_uses": 3}},
+	}
+	norm, err := normalizeClaudeRequest(store, req)
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if !norm.Standard.Search || len(norm.Standard.ToolNames) != 0 {
+		t.Fatalf("expected search on and no client tools: search=%v tools=%#v", norm.Standard.Search, norm.Standard.ToolNames)
+	}
+}
+
+func TestHandleClaudeStreamWebSearchBlocksAndCitations(t *testing.T) {
+	h := &Handler{}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/anthropic/v1/messages", nil)
+
+	h.handleClaudeStreamRealtime(rec, req, claudeWebSearchSSE(), util.StandardRequest{ResponseModel: "claude-sonnet-4-5", FinalPrompt: "hi", Search: true}, claudeStreamOptions{})
+
+	frames := parseClaudeFrames(t, rec.Body.String())
+	var blockTypes []string
+	for _, f := range findClaudeFrames(frames, "content_block_start") {
+		block, _ := f.Payload["content_block"].(map[string]any)
+		blockTypes = append(blockTypes, asString(block["type"]))
+	}
+	if strings.Join(blockTypes, ",") != "server_tool_use,web_search_tool_result,text,text" {
+		t.Fatalf("unexpected block order: %v body=%s", blockTypes, rec.Body.String())
+	}
+	var text strings.Builder
+	cited := false
+	for _, f := range findClaudeFrames(frames, "content_block_delta") {
+		delta, _ := f.Payload["delta"].(map[string]any)
+		switch delta["type"] {
+		case "text_delta":
+			text.WriteString(asString(delta["text"]))
+		case "citations_delta":
+			citation, _ := delta["citation"].(map[string]any)
+			cited = citation["type"] == "web_search_result_location" && citation["url"] == "https://go.dev/blog"
+		}
+	}
+	if text.String() != "Go 1.22 is out." || !cited {
+		t.Fatalf("expected cited text without markers, got text=%q cited=%v body=%s", text.String(), cited, rec.Body.String())
+	}
+	deltas := findClaudeFrames(frames, "message_delta")
+	if len(deltas) != 1 {
+		t.Fatalf("expected one message_delta, body=%s", rec.Body.String())
+	}
+	usage, _ := deltas[0].Payload["usage"].(map[string]any)
+	serverToolUse, _ := usage["server_tool_use"].(map[string]any)
+	if serverToolUse["web_search_requests"] != float64(1) {
+		t.Fatalf("expected one web search request, got %#v", usage)
+	}
+}
+
+func TestBuildMessageResponseWithSearchCitations(t *testing.T) {
+	result := sse.CollectStream(claudeWebSearchSSE(), false, true)
+	resp := claudefmt.BuildMessageResponseWithSearch("msg_1", "claude-sonnet-4-5", "hi", "", "", result.Text, nil, &result.Search)
+	content, _ := resp["content"].([]map[string]any)
+	if len(content) != 4 {
+		t.Fatalf("expected search pair and two text blocks, got %#v", content)
+	}
+	if content[0]["type"] != "server_tool_use" || content[1]["type"] != "web_search_tool_result" {
+		t.Fatalf("unexpected search blocks: %#v", content[:2])
+	}
+	if content[2]["text"] != "Go 1.22 is out" || content[2]["citations"] == nil || content[3]["text"] != "." {
+		t.Fatalf("unexpected text blocks: %#v", content[2:])
+	}
+}
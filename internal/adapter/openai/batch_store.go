@@ -0,0 +1,199 @@
+package openai
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ds2api/internal/batch"
+	"ds2api/internal/config"
+)
+
+const (
+	// batchMaxRequests is the most requests one input file may hold.
+	batchMaxRequests = 50000
+	// batchCompletionWindow is the only completion window accepted.
+	batchCompletionWindow = 24 * time.Hour
+	// batchRetention is how long a finished batch is kept; its output and
+	// error files stay until they are deleted.
+	batchRetention = 30 * 24 * time.Hour
+)
+
+// The sinks of a batch: result lines of requests that succeeded go to the
+// output file, the others to the error file.
+const (
+	batchOutputSink = "output"
+	batchErrorsSink = "errors"
+)
+
+type batchCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// batchLineError is one entry of the errors of a batch that failed
+// validation.
+type batchLineError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   any    `json:"param"`
+	Line    any    `json:"line"`
+}
+
+// openAIBatch is one batch. It validates its input file before it runs, and
+// its result lines become the output and error files when it finishes.
+type openAIBatch struct {
+	batch.Batch
+	Endpoint         string            `json:"endpoint"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	Errors           []batchLineError  `json:"errors,omitempty"`
+	OutputFileID     string            `json:"output_file_id,omitempty"`
+	ErrorFileID      string            `json:"error_file_id,omitempty"`
+	Counts           batchCounts       `json:"request_counts"`
+}
+
+func (b *openAIBatch) status() string {
+	switch b.State {
+	case batch.Validating:
+		return "validating"
+	case batch.Running:
+		return "in_progress"
+	case batch.Stopping, batch.Finalizing:
+		switch {
+		case b.CancelledAt != nil:
+			return "cancelling"
+		case b.State == batch.Stopping:
+			return "in_progress"
+		}
+		return "finalizing"
+	}
+	switch {
+	case len(b.Errors) > 0:
+		return "failed"
+	case b.CancelledAt != nil:
+		return "cancelled"
+	case b.ExpiredAt != nil:
+		return "expired"
+	}
+	return "completed"
+}
+
+func (b *openAIBatch) object() map[string]any {
+	var errs any
+	if len(b.Errors) > 0 {
+		errs = map[string]any{"object": "list", "data": b.Errors}
+	}
+	status := b.status()
+	endedAt := func(want string) any {
+		if status != want {
+			return nil
+		}
+		return unixTime(b.EndedAt)
+	}
+	return map[string]any{
+		"id":                b.ID,
+		"object":            "batch",
+		"endpoint":          b.Endpoint,
+		"errors":            errs,
+		"input_file_id":     b.InputFileID,
+		"completion_window": b.CompletionWindow,
+		"status":            status,
+		"output_file_id":    nullableString(b.OutputFileID),
+		"error_file_id":     nullableString(b.ErrorFileID),
+		"created_at":        b.CreatedAt.Unix(),
+		"in_progress_at":    unixTime(b.StartedAt),
+		"expires_at":        b.ExpiresAt.Unix(),
+		"finalizing_at":     unixTime(b.FinishedAt),
+		"completed_at":      endedAt("completed"),
+		"failed_at":         endedAt("failed"),
+		"expired_at":        unixTime(b.ExpiredAt),
+		"cancelling_at":     unixTime(b.CancelledAt),
+		"cancelled_at":      endedAt("cancelled"),
+		"request_counts":    b.Counts,
+		"metadata":          b.Metadata,
+	}
+}
+
+func nullableString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func unixTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
+
+// newBatchStore loads the batches saved in dir ("" keeps them in memory
+// only); their input, output and error files live in files.
+func newBatchStore(dir string, files *fileStore) *batch.Store[*openAIBatch] {
+	return batch.NewStore(dir, batch.Spec[*openAIBatch]{
+		Name:      "openai_batches",
+		IDPrefix:  "batch_",
+		Sinks:     []string{batchOutputSink, batchErrorsSink},
+		Window:    batchCompletionWindow,
+		Retention: batchRetention,
+		New:       func() *openAIBatch { return &openAIBatch{} },
+		Object:    (*openAIBatch).object,
+		Reset: func(b *openAIBatch, total int) {
+			b.Counts = batchCounts{Total: total}
+		},
+		Count: func(b *openAIBatch, res batch.Result) {
+			if res.Sink == batchOutputSink {
+				b.Counts.Completed++
+			} else {
+				b.Counts.Failed++
+			}
+		},
+		Skipped: batchSkippedLine,
+		Validate: func(b *openAIBatch) ([]batch.Request, func(*openAIBatch)) {
+			requests, errs := validateBatchInput(files, b.Owner, b.InputFileID, b.Endpoint)
+			if len(errs) > 0 {
+				return nil, func(b *openAIBatch) { b.Errors = errs }
+			}
+			return requests, nil
+		},
+		Finalize: func(b *openAIBatch, open func(sink string) (io.ReadCloser, error)) func(*openAIBatch) {
+			var fileIDs [2]string
+			for i, sink := range []string{batchOutputSink, batchErrorsSink} {
+				src, err := open(sink)
+				if err != nil {
+					continue
+				}
+				file, err := files.put(b.Owner, b.ID+"_"+sink+".jsonl", "batch_output", src)
+				_ = src.Close()
+				if err != nil {
+					config.Logger.Warn("[openai_batches] finalize failed", "batch", b.ID, "error", err)
+					continue
+				}
+				fileIDs[i] = file.ID
+			}
+			return func(b *openAIBatch) {
+				b.OutputFileID, b.ErrorFileID = fileIDs[0], fileIDs[1]
+			}
+		},
+	})
+}
+
+// batchSkippedLine is the error line of a request that never ran.
+func batchSkippedLine(customID string, cancelled bool) batch.Result {
+	code, message := "batch_expired", "This request could not be executed before the completion window expired."
+	if cancelled {
+		code, message = "batch_cancelled", "This request was not executed because the batch was cancelled."
+	}
+	return batch.Result{Sink: batchErrorsSink, Line: map[string]any{
+		"id":        "batch_req_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		"custom_id": customID,
+		"response":  nil,
+		"error":     map[string]any{"code": code, "message": message},
+	}}
+}
@@ -0,0 +1,315 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/batch"
+)
+
+const (
+	defaultBatchListLimit = 20
+	maxBatchListLimit     = 100
+	// maxBatchLineErrors caps the validation errors reported for one input
+	// file.
+	maxBatchLineErrors = 100
+)
+
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/responses":        true,
+	"/v1/embeddings":       true,
+}
+
+// StartBatches loads the files and batches saved in filesDir and batchesDir
+// ("" keeps them in memory only) and starts the batch worker, which runs
+// until ctx ends.
+func (h *Handler) StartBatches(ctx context.Context, filesDir, batchesDir string) {
+	files := newFileStore(filesDir)
+	batches := newBatchStore(batchesDir, files)
+	h.batchMu.Lock()
+	h.files, h.batches = files, batches
+	h.batchMu.Unlock()
+	go batches.Run(ctx, batch.Worker[*openAIBatch]{
+		Auth: h.Auth,
+		Run:  h.runBatchLine,
+		Failed: func(req batch.Request, err error) batch.Result {
+			status := openAIAuthStatus(err)
+			return batchResultLine(req.CustomID, status, openAIErrorBody(status, err.Error()))
+		},
+	})
+}
+
+func (h *Handler) getBatchStores() (*fileStore, *batch.Store[*openAIBatch]) {
+	h.batchMu.Lock()
+	defer h.batchMu.Unlock()
+	return h.files, h.batches
+}
+
+func (h *Handler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	a, ref, err := h.Auth.DetermineCallerRef(r)
+	if err != nil {
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
+		return
+	}
+	files, batches := h.getBatchStores()
+	if files == nil {
+		writeOpenAIError(w, http.StatusNotFound, "Files and batches are not enabled.")
+		return
+	}
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	inputFileID, _ := req["input_file_id"].(string)
+	if f, ok := files.get(a.CallerID, inputFileID); !ok || f.Purpose != "batch" {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'input_file_id': '%s'. No batch file with that id exists.", inputFileID))
+		return
+	}
+	endpoint, _ := req["endpoint"].(string)
+	if !batchEndpoints[endpoint] {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for 'endpoint': '%s'. Supported values are '/v1/chat/completions', '/v1/responses' and '/v1/embeddings'.", endpoint))
+		return
+	}
+	if window, _ := req["completion_window"].(string); window != "24h" {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for 'completion_window': '%s'. Supported values are: '24h'.", window))
+		return
+	}
+	metadata, err := parseBatchMetadata(req["metadata"])
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	b := &openAIBatch{Endpoint: endpoint, InputFileID: inputFileID, CompletionWindow: "24h", Metadata: metadata}
+	writeJSON(w, http.StatusOK, batches.Create(b, a.CallerID, ref, nil, time.Now()))
+}
+
+func parseBatchMetadata(raw any) (map[string]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("'metadata' must be an object.")
+	}
+	if len(m) > 16 {
+		return nil, fmt.Errorf("'metadata' can have at most 16 keys.")
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		s, ok := v.(string)
+		if !ok || len(k) > 64 || len(s) > 512 {
+			return nil, fmt.Errorf("Invalid 'metadata.%s': keys are strings of at most 64 characters and values strings of at most 512.", k)
+		}
+		out[k] = s
+	}
+	return out, nil
+}
+
+func (h *Handler) GetBatch(w http.ResponseWriter, r *http.Request) {
+	owner, _, batches, ok := h.fileCaller(w, r)
+	if !ok {
+		return
+	}
+	obj, ok := batches.Get(owner, chi.URLParam(r, "batch_id"))
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "Batch not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func (h *Handler) ListBatches(w http.ResponseWriter, r *http.Request) {
+	owner, _, batches, ok := h.fileCaller(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	limit := defaultBatchListLimit
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxBatchListLimit {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("'limit' must be an integer between 1 and %d.", maxBatchListLimit))
+			return
+		}
+		limit = n
+	}
+	list := batches.List(owner)
+	data := make([]any, 0, len(list))
+	for _, obj := range list {
+		data = append(data, obj)
+	}
+	writeListPage(w, data, strings.TrimSpace(q.Get("after")), limit, "Batch")
+}
+
+func (h *Handler) CancelBatch(w http.ResponseWriter, r *http.Request) {
+	owner, _, batches, ok := h.fileCaller(w, r)
+	if !ok {
+		return
+	}
+	obj, err := batches.Cancel(owner, chi.URLParam(r, "batch_id"), time.Now())
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, obj)
+	case batch.ErrNotCancellable:
+		writeOpenAIError(w, http.StatusConflict, "Cannot cancel a batch that has already finished.")
+	default:
+		writeOpenAIError(w, http.StatusNotFound, "Batch not found.")
+	}
+}
+
+// validateBatchInput reads an input file and checks every line against the
+// batch endpoint.
+func validateBatchInput(files *fileStore, owner, inputFileID, endpoint string) ([]batch.Request, []batchLineError) {
+	rc, err := files.open(owner, inputFileID)
+	if err != nil {
+		return nil, []batchLineError{{Code: "invalid_file", Message: fmt.Sprintf("Input file '%s' no longer exists.", inputFileID), Param: "input_file_id"}}
+	}
+	defer rc.Close()
+	var (
+		requests []batch.Request
+		errs     []batchLineError
+	)
+	seen := map[string]bool{}
+	fail := func(line int, code, param, message string) {
+		if len(errs) < maxBatchLineErrors {
+			errs = append(errs, batchLineError{Code: code, Message: message, Param: param, Line: line})
+		}
+	}
+	sc := bufio.NewScanner(rc)
+	sc.Buffer(make([]byte, 0, 64*1024), maxFileBytes)
+	for n := 1; sc.Scan(); n++ {
+		raw := bytes.TrimSpace(sc.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var line struct {
+			CustomID any    `json:"custom_id"`
+			Method   string `json:"method"`
+			URL      string `json:"url"`
+			Body     any    `json:"body"`
+		}
+		if err := json.Unmarshal(raw, &line); err != nil {
+			fail(n, "invalid_json_line", "", "This line is not parseable as valid JSON.")
+			continue
+		}
+		customID, _ := line.CustomID.(string)
+		body, isObject := line.Body.(map[string]any)
+		switch {
+		case customID == "":
+			fail(n, "missing_required_parameter", "custom_id", "The line is missing the required 'custom_id' string.")
+		case seen[customID]:
+			fail(n, "duplicate_custom_id", "custom_id", fmt.Sprintf("The custom_id '%s' appears more than once in the input file.", customID))
+		case line.Method != http.MethodPost:
+			fail(n, "invalid_method", "method", "The method must be 'POST'.")
+		case line.URL != endpoint:
+			fail(n, "mismatched_endpoint", "url", fmt.Sprintf("The url '%s' does not match the batch endpoint '%s'.", line.URL, endpoint))
+		case !isObject:
+			fail(n, "invalid_body", "body", "The body must be a JSON object.")
+		default:
+			seen[customID] = true
+			requests = append(requests, batch.Request{CustomID: customID, Body: body})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, []batchLineError{{Code: "invalid_file", Message: "Input file could not be read: " + err.Error(), Param: "input_file_id"}}
+	}
+	switch {
+	case len(errs) > 0:
+	case len(requests) == 0:
+		errs = append(errs, batchLineError{Code: "empty_file", Message: "The input file has no requests.", Param: "input_file_id"})
+	case len(requests) > batchMaxRequests:
+		errs = append(errs, batchLineError{Code: "too_many_requests", Message: fmt.Sprintf("The input file has more than %d requests.", batchMaxRequests), Param: "input_file_id"})
+	}
+	return requests, errs
+}
+
+// runBatchLine runs one request of b through the handler of its endpoint on
+// the leased auth a and returns its result line, which goes to the output
+// file when the request succeeded.
+func (h *Handler) runBatchLine(ctx context.Context, a *auth.RequestAuth, b *openAIBatch, request batch.Request) batch.Result {
+	rec := &batchRecorder{header: http.Header{}}
+	body := request.Body
+	if stream, _ := body["stream"].(bool); stream {
+		writeOpenAIError(rec, http.StatusBadRequest, "Streaming is not supported in batches.")
+	} else if background, _ := body["background"].(bool); background {
+		writeOpenAIError(rec, http.StatusBadRequest, "Background responses are not supported in batches.")
+	} else {
+		raw, _ := json.Marshal(body)
+		req, err := http.NewRequestWithContext(context.WithValue(ctx, batchLeaseKey{}, a), http.MethodPost, b.Endpoint, bytes.NewReader(raw))
+		if err != nil {
+			writeOpenAIError(rec, http.StatusInternalServerError, err.Error())
+		} else {
+			req.Header.Set("Content-Type", "application/json")
+			switch b.Endpoint {
+			case "/v1/chat/completions":
+				h.ChatCompletions(rec, req)
+			case "/v1/responses":
+				h.Responses(rec, req)
+			case "/v1/embeddings":
+				h.Embeddings(rec, req)
+			}
+		}
+	}
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	var out any
+	if err := json.Unmarshal(rec.body.Bytes(), &out); err != nil {
+		out = rec.body.String()
+	}
+	return batchResultLine(request.CustomID, status, out)
+}
+
+// batchResultLine is the output or error file line of a request that ran.
+func batchResultLine(customID string, status int, body any) batch.Result {
+	sink := batchErrorsSink
+	if status >= 200 && status < 300 {
+		sink = batchOutputSink
+	}
+	return batch.Result{Sink: sink, Line: map[string]any{
+		"id":        "batch_req_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		"custom_id": customID,
+		"response": map[string]any{
+			"status_code": status,
+			"request_id":  "req_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+			"body":        body,
+		},
+		"error": nil,
+	}}
+}
+
+// batchRecorder captures the response a handler writes for a batch line.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *batchRecorder) Header() http.Header { return r.header }
+
+func (r *batchRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *batchRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(p)
+}
@@ -0,0 +1,224 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ds2api/internal/batch"
+)
+
+func batchRoute(t *testing.T, router http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func uploadBatchFile(t *testing.T, router http.Handler, token, content string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("purpose", "batch")
+	fw, _ := mw.CreateFormFile("file", "input.jsonl")
+	_, _ = fw.Write([]byte(content))
+	_ = mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/v1/files", &buf)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var file map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &file)
+	if rec.Code != http.StatusOK || file["object"] != "file" || file["purpose"] != "batch" || file["bytes"] != float64(len(content)) {
+		t.Fatalf("upload failed: %d %s", rec.Code, rec.Body.String())
+	}
+	return file["id"].(string)
+}
+
+func waitForBatch(t *testing.T, router http.Handler, token, id string, done func(map[string]any) bool) map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		var batch map[string]any
+		rec := batchRoute(t, router, http.MethodGet, "/v1/batches/"+id, token, "")
+		_ = json.Unmarshal(rec.Body.Bytes(), &batch)
+		if done(batch) {
+			return batch
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batch did not finish: %s", rec.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBatchLifecycle(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &promptDS{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batchDir := t.TempDir()
+	h.StartBatches(ctx, t.TempDir(), batchDir)
+	router := chi.NewRouter()
+	RegisterRoutes(router, h)
+
+	input := `{"custom_id":"ok","method":"POST","url":"/v1/chat/completions","body":{"model":"deepseek-chat","messages":[{"role":"user","content":"hi"}]}}
+{"custom_id":"streamed","method":"POST","url":"/v1/chat/completions","body":{"model":"deepseek-chat","stream":true,"messages":[{"role":"user","content":"hi"}]}}
+`
+	fileID := uploadBatchFile(t, router, "alice-token", input)
+	if rec := batchRoute(t, router, http.MethodGet, "/v1/files/"+fileID, "bob-token", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected another caller to get 404, got %d", rec.Code)
+	}
+
+	rec := batchRoute(t, router, http.MethodPost, "/v1/batches", "alice-token",
+		`{"input_file_id":"`+fileID+`","endpoint":"/v1/chat/completions","completion_window":"24h","metadata":{"job":"nightly"}}`)
+	var batch map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &batch)
+	id, _ := batch["id"].(string)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(id, "batch_") || batch["status"] != "validating" {
+		t.Fatalf("create failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	batch = waitForBatch(t, router, "alice-token", id, func(b map[string]any) bool { return b["status"] == "completed" })
+	counts, _ := batch["request_counts"].(map[string]any)
+	if counts["total"] != float64(2) || counts["completed"] != float64(1) || counts["failed"] != float64(1) {
+		t.Fatalf("unexpected counts %#v", counts)
+	}
+	if batch["completed_at"] == nil || batch["in_progress_at"] == nil || batch["finalizing_at"] == nil {
+		t.Fatalf("expected lifecycle timestamps, got %#v", batch)
+	}
+	if raw, err := os.ReadFile(filepath.Join(batchDir, id+".json")); err != nil || bytes.Contains(raw, []byte("alice-token")) {
+		t.Fatalf("expected the batch saved without the caller token, got %s err=%v", raw, err)
+	}
+
+	readLine := func(fileID string) map[string]any {
+		rec := batchRoute(t, router, http.MethodGet, "/v1/files/"+fileID+"/content", "alice-token", "")
+		var line map[string]any
+		if err := json.Unmarshal(bytes.TrimSpace(rec.Body.Bytes()), &line); err != nil {
+			t.Fatalf("bad line %q: %v", rec.Body.String(), err)
+		}
+		return line
+	}
+	out := readLine(batch["output_file_id"].(string))
+	resp, _ := out["response"].(map[string]any)
+	body, _ := resp["body"].(map[string]any)
+	if out["custom_id"] != "ok" || out["error"] != nil || resp["status_code"] != float64(200) || body["object"] != "chat.completion" {
+		t.Fatalf("unexpected output line %#v", out)
+	}
+	if reqID, _ := resp["request_id"].(string); !strings.HasPrefix(reqID, "req_") || !strings.HasPrefix(out["id"].(string), "batch_req_") {
+		t.Fatalf("unexpected line ids %#v", out)
+	}
+	errLine := readLine(batch["error_file_id"].(string))
+	if resp, _ := errLine["response"].(map[string]any); errLine["custom_id"] != "streamed" || resp["status_code"] != float64(400) {
+		t.Fatalf("unexpected error line %#v", errLine)
+	}
+
+	rec = batchRoute(t, router, http.MethodGet, "/v1/files?purpose=batch_output", "alice-token", "")
+	var page map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &page)
+	if data, _ := page["data"].([]any); len(data) != 2 || page["object"] != "list" {
+		t.Fatalf("unexpected file list %s", rec.Body.String())
+	}
+	if rec := batchRoute(t, router, http.MethodPost, "/v1/batches/"+id+"/cancel", "alice-token", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 cancelling a completed batch, got %d", rec.Code)
+	}
+	if rec := batchRoute(t, router, http.MethodDelete, "/v1/files/"+fileID, "alice-token", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"deleted":true`) {
+		t.Fatalf("delete failed: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBatchValidation(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &promptDS{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.StartBatches(ctx, "", "")
+	router := chi.NewRouter()
+	RegisterRoutes(router, h)
+
+	fileID := uploadBatchFile(t, router, "alice-token", `{"custom_id":"a","method":"POST","url":"/v1/embeddings","body":{}}
+{"custom_id":"a","method":"POST","url":"/v1/embeddings","body":{}}
+{"custom_id":"b","method":"POST","url":"/v1/responses","body":{}}
+`)
+	cases := map[string]string{
+		`{"input_file_id":"file-missing","endpoint":"/v1/embeddings","completion_window":"24h"}`:    "input_file_id",
+		`{"input_file_id":"` + fileID + `","endpoint":"/v1/completions","completion_window":"24h"}`: "endpoint",
+		`{"input_file_id":"` + fileID + `","endpoint":"/v1/embeddings","completion_window":"1h"}`:   "completion_window",
+	}
+	for body, want := range cases {
+		rec := batchRoute(t, router, http.MethodPost, "/v1/batches", "alice-token", body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("body %s: expected 400 mentioning %q, got %d %s", body, want, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := batchRoute(t, router, http.MethodPost, "/v1/batches", "alice-token",
+		`{"input_file_id":"`+fileID+`","endpoint":"/v1/embeddings","completion_window":"24h"}`)
+	var batch map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &batch)
+	batch = waitForBatch(t, router, "alice-token", batch["id"].(string), func(b map[string]any) bool { return b["status"] == "failed" })
+	errs, _ := batch["errors"].(map[string]any)
+	data, _ := errs["data"].([]any)
+	if len(data) != 2 {
+		t.Fatalf("expected two line errors, got %#v", batch["errors"])
+	}
+	first, _ := data[0].(map[string]any)
+	second, _ := data[1].(map[string]any)
+	if first["code"] != "duplicate_custom_id" || first["line"] != float64(2) || second["code"] != "mismatched_endpoint" || second["line"] != float64(3) {
+		t.Fatalf("unexpected line errors %#v", data)
+	}
+}
+
+func TestCancelledBatchWritesItsErrorFile(t *testing.T) {
+	files := newFileStore(t.TempDir())
+	dir := t.TempDir()
+	st := newBatchStore(dir, files)
+	requests := []batch.Request{{CustomID: "a"}, {CustomID: "b"}}
+	id := st.Create(&openAIBatch{Endpoint: "/v1/embeddings", CompletionWindow: "24h"}, "alice", "key", requests, time.Now())["id"].(string)
+	obj, err := st.Cancel("alice", id, time.Now())
+	if counts := obj["request_counts"].(batchCounts); err != nil || obj["status"] != "cancelling" || counts.Failed != 2 {
+		t.Fatalf("unexpected cancelling batch %#v err=%v", obj, err)
+	}
+
+	// No request is left to run; the worker only writes the files.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go st.Run(ctx, batch.Worker[*openAIBatch]{})
+	deadline := time.Now().Add(2 * time.Second)
+	for obj["status"] != "cancelled" {
+		if time.Now().After(deadline) {
+			t.Fatalf("batch was not finalized: %#v", obj)
+		}
+		time.Sleep(10 * time.Millisecond)
+		obj, _ = st.Get("alice", id)
+	}
+	if obj["output_file_id"] != nil || obj["cancelled_at"] == nil || obj["error_file_id"] == nil {
+		t.Fatalf("unexpected cancelled batch %#v", obj)
+	}
+	rc, err := files.open("alice", obj["error_file_id"].(string))
+	if err != nil {
+		t.Fatalf("open error file: %v", err)
+	}
+	defer rc.Close()
+	var lines bytes.Buffer
+	_, _ = lines.ReadFrom(rc)
+	if strings.Count(lines.String(), `"code":"batch_cancelled"`) != 2 {
+		t.Fatalf("expected a and b to be cancelled, got %s", lines.String())
+	}
+	if obj, ok := newBatchStore(dir, files).Get("alice", id); !ok || obj["status"] != "cancelled" {
+		t.Fatalf("expected the cancelled batch to survive a restart, got %#v", obj)
+	}
+}
@@ -0,0 +1,281 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/config"
+	"ds2api/internal/deepseek"
+	openaifmt "ds2api/internal/format/openai"
+	"ds2api/internal/sse"
+	streamengine "ds2api/internal/stream"
+	"ds2api/internal/util"
+)
+
+// choiceLanes are the upstream identities serving an n>1 request. Lane 0 is
+// the request's primary auth; extra lanes are leased for the duration of the
+// request. Choices are spread round-robin over lanes and each lane runs its
+// share sequentially, so parallelism never exceeds the slots actually held.
+type choiceLanes struct {
+	auths []*auth.RequestAuth
+	owned []*auth.RequestAuth
+}
+
+func (h *Handler) acquireChoiceLanes(ctx context.Context, primary *auth.RequestAuth, n int) *choiceLanes {
+	lanes := &choiceLanes{auths: []*auth.RequestAuth{primary}}
+	for len(lanes.auths) < n {
+		extra, ok := h.Auth.AcquireExtra(ctx, primary)
+		if !ok {
+			break
+		}
+		lanes.auths = append(lanes.auths, extra)
+		lanes.owned = append(lanes.owned, extra)
+	}
+	return lanes
+}
+
+func (h *Handler) releaseChoiceLanes(lanes *choiceLanes) {
+	for _, a := range lanes.owned {
+		h.Auth.Release(a)
+	}
+}
+
+// run calls fn for every choice index in [0, n), each on its lane.
+func (lanes *choiceLanes) run(n int, fn func(a *auth.RequestAuth, index int)) {
+	var wg sync.WaitGroup
+	for lane := range lanes.auths {
+		a := lanes.auths[lane]
+		wg.Add(1)
+		go func(lane int) {
+			defer wg.Done()
+			for idx := lane; idx < n; idx += len(lanes.auths) {
+				fn(a, idx)
+			}
+		}(lane)
+	}
+	wg.Wait()
+}
+
+func newChatCompletionID() string {
+	return "chatcmpl-" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+type choiceCallError struct {
+	status  int
+	message string
+}
+
+// startChoice opens a fresh upstream session and starts a completion; it
+// serves every chat and Responses request. On success the caller owns
+// resp.Body.
+func (h *Handler) startChoice(ctx context.Context, a *auth.RequestAuth, stdReq util.StandardRequest) (*http.Response, *choiceCallError) {
+	sessionID, err := h.DS.CreateSession(ctx, a, 3)
+	if err != nil {
+		return nil, upstreamCallError(err, invalidTokenError(a))
+	}
+	pow, err := h.DS.GetPow(ctx, a, 3)
+	if err != nil {
+		return nil, upstreamCallError(err, &choiceCallError{http.StatusUnauthorized, "Failed to get PoW (invalid token or unknown error)."})
+	}
+	resp, err := h.DS.CallCompletion(ctx, a, stdReq.CompletionPayload(sessionID), pow, 3)
+	if err != nil {
+		return nil, upstreamCallError(err, &choiceCallError{http.StatusInternalServerError, "Failed to get completion."})
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &choiceCallError{resp.StatusCode, string(body)}
+	}
+	return resp, nil
+}
+
+// invalidTokenError is the error for an upstream call DeepSeek refused
+// because of the account token.
+func invalidTokenError(a *auth.RequestAuth) *choiceCallError {
+	if a.UseConfigToken {
+		return &choiceCallError{http.StatusUnauthorized, "Account token is invalid. Please re-login the account in admin."}
+	}
+	return &choiceCallError{http.StatusUnauthorized, "Invalid token. If this should be a DS2API key, add it to config.keys first."}
+}
+
+// upstreamCallError maps a session, PoW or completion call that gave up to
+// the error the client sees. A rejected token, or a failure of unknown
+// shape, gets fallback; upstream throttling is a 429 and any other upstream
+// failure a 502.
+func upstreamCallError(err error, fallback *choiceCallError) *choiceCallError {
+	var callErr *deepseek.CallError
+	if !errors.As(err, &callErr) || callErr.TokenInvalid {
+		return fallback
+	}
+	if callErr.Status == http.StatusTooManyRequests {
+		return &choiceCallError{http.StatusTooManyRequests, "DeepSeek is rate limiting this account; try again later."}
+	}
+	return &choiceCallError{http.StatusBadGateway, "DeepSeek " + callErr.Op + " failed."}
+}
+
+// handleMultiChoice serves n>1 requests.
+func (h *Handler) handleMultiChoice(w http.ResponseWriter, r *http.Request, a *auth.RequestAuth, stdReq util.StandardRequest) {
+	lanes := h.acquireChoiceLanes(r.Context(), a, stdReq.N)
+	defer h.releaseChoiceLanes(lanes)
+	if stdReq.Stream {
+		h.handleMultiChoiceStream(w, r, lanes, stdReq)
+		return
+	}
+
+	completionID := newChatCompletionID()
+	outputs := make([]openaifmt.ChatChoiceOutput, stdReq.N)
+	var firstErr *choiceCallError
+	var errMu sync.Mutex
+	fail := func(idx int, callErr *choiceCallError) {
+		outputs[idx].Error = callErr.message
+		errMu.Lock()
+		if firstErr == nil {
+			firstErr = callErr
+		}
+		errMu.Unlock()
+	}
+	lanes.run(stdReq.N, func(la *auth.RequestAuth, idx int) {
+		resp, callErr := h.startChoice(r.Context(), la, stdReq)
+		if callErr != nil {
+			fail(idx, callErr)
+			return
+		}
+		limiter := util.NewOutputLimiter(stdReq.Limits)
+		result := sse.CollectStreamLimited(resp, stdReq.Thinking, true, limiter)
+		outputs[idx].Thinking = result.Thinking
+		outputs[idx].FinishReason = chatFinishReason(limiter)
+		text, usage := result.Text, result.Usage
+		finalizeText := h.outputFinalizer(r.Context(), la, stdReq)
+		if finalizeText != nil {
+			var err error
+			if text, err = finalizeText(text, &usage); err != nil {
+				fail(idx, &choiceCallError{http.StatusBadGateway, err.Error()})
+				return
+			}
+		}
+		outputs[idx].Text = text
+		outputs[idx].Usage = usage.Delivered(limiter.Done() || text != result.Text)
+		if stdReq.SearchSources {
+			outputs[idx].Sources = &result.Search
+		}
+		outputs[idx].ToolCalls = detectToolCalls(text, stdReq.ToolNames, stdReq.ToolPolicy, finalizeText != nil)
+	})
+
+	succeeded := 0
+	for _, out := range outputs {
+		if out.Error == "" {
+			succeeded++
+		}
+	}
+	if succeeded == 0 && firstErr != nil {
+		writeOpenAIError(w, firstErr.status, firstErr.message)
+		return
+	}
+	writeJSON(w, http.StatusOK, openaifmt.BuildChatCompletionChoices(completionID, stdReq.ResponseModel, stdReq.FinalPrompt, outputs))
+}
+
+// handleMultiChoiceStream interleaves the deltas of every choice on one SSE
+// stream, each tagged with its choice index. Choice 0 is started before any
+// byte is written so a failing upstream still yields a plain JSON error; a
+// later choice that fails is closed with finish_reason "error". Usage for
+// all choices is sent in a final chunk with an empty choices list.
+func (h *Handler) handleMultiChoiceStream(w http.ResponseWriter, r *http.Request, lanes *choiceLanes, stdReq util.StandardRequest) {
+	firstResp, callErr := h.startChoice(r.Context(), lanes.auths[0], stdReq)
+	if callErr != nil {
+		writeOpenAIError(w, callErr.status, callErr.message)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache, no-transform")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	canFlush := rc.Flush() == nil
+	if !canFlush {
+		config.Logger.Warn("[stream] response writer does not support flush; streaming may be buffered")
+	}
+
+	completionID := newChatCompletionID()
+	created := time.Now().Unix()
+	bufferToolContent := len(stdReq.ToolNames) > 0 && h.toolcallFeatureMatchEnabled()
+	emitEarlyToolDeltas := h.toolcallEarlyEmitHighConfidence()
+	var writeMu sync.Mutex
+	outputs := make([]openaifmt.ChatChoiceOutput, stdReq.N)
+
+	newRuntime := func(idx int) *chatStreamRuntime {
+		rt := newChatStreamRuntime(w, rc, canFlush, completionID, created, stdReq.ResponseModel, stdReq.FinalPrompt,
+			stdReq.Thinking, stdReq.Search, stdReq.ToolNames, bufferToolContent, emitEarlyToolDeltas)
+		rt.writeMu = &writeMu
+		rt.choiceIndex = idx
+		rt.toolPolicy = stdReq.ToolPolicy
+		rt.limiter = util.NewOutputLimiter(stdReq.Limits)
+		if stdReq.SearchSources {
+			rt.sources = &util.SearchSources{}
+		}
+		return rt
+	}
+	laneFor := func(idx int) *auth.RequestAuth {
+		return lanes.auths[idx%len(lanes.auths)]
+	}
+	consume := func(idx int, resp *http.Response) {
+		defer resp.Body.Close()
+		rt := newRuntime(idx)
+		rt.finalizeText = h.outputFinalizer(r.Context(), laneFor(idx), stdReq)
+		initialType := "text"
+		if stdReq.Thinking {
+			initialType = "thinking"
+		}
+		streamengine.ConsumeSSE(streamengine.ConsumeConfig{
+			Context:             r.Context(),
+			Body:                resp.Body,
+			ThinkingEnabled:     stdReq.Thinking,
+			InitialType:         initialType,
+			KeepAliveInterval:   time.Duration(deepseek.KeepAliveTimeout) * time.Second,
+			IdleTimeout:         time.Duration(deepseek.StreamIdleTimeout) * time.Second,
+			MaxKeepAliveNoInput: deepseek.MaxKeepaliveCount,
+		}, streamengine.ConsumeHooks{
+			OnKeepAlive: rt.sendKeepAlive,
+			OnParsed:    rt.onParsed,
+			OnFinalize: func(reason streamengine.StopReason, _ error) {
+				finishReason := "stop"
+				if string(reason) == "content_filter" {
+					finishReason = "content_filter"
+				}
+				rt.finishChoice(finishReason, false)
+			},
+		})
+		outputs[idx].Thinking = rt.thinking.String()
+		outputs[idx].Text = rt.text.String()
+		outputs[idx].Usage = rt.upstream.Delivered(rt.limiter.Done())
+	}
+
+	lanes.run(stdReq.N, func(la *auth.RequestAuth, idx int) {
+		if idx == 0 {
+			consume(0, firstResp)
+			return
+		}
+		resp, callErr := h.startChoice(r.Context(), la, stdReq)
+		if callErr != nil {
+			outputs[idx].Error = callErr.message
+			config.Logger.Warn("[stream] choice failed", "index", idx, "status", callErr.status)
+			newRuntime(idx).sendChoiceError(callErr.message)
+			return
+		}
+		consume(idx, resp)
+	})
+
+	final := newRuntime(0)
+	if !final.sendChunk(openaifmt.BuildChatStreamChunk(completionID, created, stdReq.ResponseModel,
+		[]map[string]any{}, openaifmt.BuildChatChoicesUsage(stdReq.FinalPrompt, outputs))) {
+		return
+	}
+	final.sendDone()
+}
@@ -0,0 +1,212 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/deepseek"
+)
+
+// choiceDS answers each completion with a distinct text; calls listed in fail
+// get an upstream 500 instead.
+type choiceDS struct {
+	mu    sync.Mutex
+	calls int
+	fail  map[int]bool
+}
+
+func (d *choiceDS) CreateSession(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "session", nil
+}
+
+func (d *choiceDS) GetPow(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "pow", nil
+}
+
+func (d *choiceDS) CallCompletion(_ context.Context, _ *auth.RequestAuth, _ map[string]any, _ string, _ int) (*http.Response, error) {
+	d.mu.Lock()
+	call := d.calls
+	d.calls++
+	d.mu.Unlock()
+	if d.fail[call] {
+		resp := makeSSEHTTPResponse("upstream down")
+		resp.StatusCode = http.StatusInternalServerError
+		return resp, nil
+	}
+	return makeSSEHTTPResponse(
+		fmt.Sprintf(`data: {"p":"response/content","v":"answer %d"}`, call),
+		`data: [DONE]`,
+	), nil
+}
+
+func postChatChoices(t *testing.T, h *Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer direct-token")
+	rec := httptest.NewRecorder()
+	h.ChatCompletions(rec, req)
+	return rec
+}
+
+func TestChatCompletionsMultipleChoicesNonStream(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &choiceDS{}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	rec := postChatChoices(t, h, `{"model":"deepseek-chat","n":3,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+	if ds.calls != 3 {
+		t.Fatalf("expected 3 upstream calls, got %d", ds.calls)
+	}
+	out := decodeJSONBody(t, rec.Body.String())
+	choices, _ := out["choices"].([]any)
+	if len(choices) != 3 {
+		t.Fatalf("expected 3 choices, got %#v", out["choices"])
+	}
+	seen := map[string]bool{}
+	for i, item := range choices {
+		choice, _ := item.(map[string]any)
+		if int(choice["index"].(float64)) != i {
+			t.Fatalf("choice %d has index %v", i, choice["index"])
+		}
+		msg, _ := choice["message"].(map[string]any)
+		seen[msg["content"].(string)] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected distinct contents per choice, got %#v", seen)
+	}
+	usage, _ := out["usage"].(map[string]any)
+	single := decodeJSONBody(t, postChatChoices(t, h, `{"model":"deepseek-chat","messages":[{"role":"user","content":"hi"}]}`).Body.String())
+	singleUsage, _ := single["usage"].(map[string]any)
+	if usage["prompt_tokens"] != singleUsage["prompt_tokens"] {
+		t.Fatalf("prompt tokens should be counted once: %v vs %v", usage["prompt_tokens"], singleUsage["prompt_tokens"])
+	}
+	if usage["completion_tokens"].(float64) != 3*singleUsage["completion_tokens"].(float64) {
+		t.Fatalf("completion tokens should sum over choices: %v vs %v", usage["completion_tokens"], singleUsage["completion_tokens"])
+	}
+}
+
+func TestChatCompletionsMultipleChoicesPartialFailure(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &choiceDS{fail: map[int]bool{1: true}}}
+
+	rec := postChatChoices(t, h, `{"model":"deepseek-chat","n":2,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+	out := decodeJSONBody(t, rec.Body.String())
+	choices, _ := out["choices"].([]any)
+	if len(choices) != 2 {
+		t.Fatalf("expected 2 choices, got %#v", out["choices"])
+	}
+	reasons := map[any]int{}
+	for _, item := range choices {
+		choice, _ := item.(map[string]any)
+		reasons[choice["finish_reason"]]++
+	}
+	if reasons["stop"] != 1 || reasons["error"] != 1 {
+		t.Fatalf("expected one stop and one error choice, got %#v", reasons)
+	}
+
+	h.DS = &choiceDS{fail: map[int]bool{0: true, 1: true}}
+	rec = postChatChoices(t, h, `{"model":"deepseek-chat","n":2,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected upstream error status when every choice fails, got %d", rec.Code)
+	}
+}
+
+// sessionFailDS fails every CreateSession with err.
+type sessionFailDS struct {
+	choiceDS
+	err error
+}
+
+func (d *sessionFailDS) CreateSession(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "", d.err
+}
+
+func TestChatCompletionsKeepsSessionFailureStatus(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{&deepseek.CallError{Op: "create session", Status: http.StatusUnauthorized, TokenInvalid: true}, http.StatusUnauthorized},
+		{&deepseek.CallError{Op: "create session", Status: http.StatusTooManyRequests}, http.StatusTooManyRequests},
+		{&deepseek.CallError{Op: "create session", Status: http.StatusServiceUnavailable}, http.StatusBadGateway},
+		{&deepseek.CallError{Op: "create session"}, http.StatusBadGateway},
+	}
+	for _, tc := range cases {
+		for _, body := range []string{
+			`{"model":"deepseek-chat","messages":[{"role":"user","content":"hi"}]}`,
+			`{"model":"deepseek-chat","n":2,"messages":[{"role":"user","content":"hi"}]}`,
+		} {
+			h := &Handler{Store: store, Auth: resolver, DS: &sessionFailDS{err: tc.err}}
+			if rec := postChatChoices(t, h, body); rec.Code != tc.want {
+				t.Fatalf("session error %#v: expected %d, got %d body=%s", tc.err, tc.want, rec.Code, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestChatCompletionsMultipleChoicesStream(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &choiceDS{}}
+
+	rec := postChatChoices(t, h, `{"model":"deepseek-chat","n":2,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+	frames, done := parseSSEDataFrames(t, rec.Body.String())
+	if !done {
+		t.Fatal("expected [DONE]")
+	}
+	finished := map[int]string{}
+	for _, frame := range frames {
+		choices, _ := frame["choices"].([]any)
+		for _, item := range choices {
+			choice, _ := item.(map[string]any)
+			if reason, ok := choice["finish_reason"].(string); ok {
+				finished[int(choice["index"].(float64))] = reason
+			}
+		}
+	}
+	if finished[0] != "stop" || finished[1] != "stop" || len(finished) != 2 {
+		t.Fatalf("expected both choices to finish, got %#v", finished)
+	}
+	last := frames[len(frames)-1]
+	if choices, _ := last["choices"].([]any); len(choices) != 0 {
+		t.Fatalf("expected trailing usage chunk without choices, got %#v", last)
+	}
+	if _, ok := last["usage"].(map[string]any); !ok {
+		t.Fatalf("expected aggregated usage in trailing chunk, got %#v", last)
+	}
+}
+
+func TestNormalizeOpenAIChatRequestChoiceCount(t *testing.T) {
+	cfg := mockOpenAIConfig{aliases: map[string]string{}, maxChoices: 2}
+	base := func(n any) map[string]any {
+		return map[string]any{
+			"model":    "deepseek-chat",
+			"n":        n,
+			"messages": []any{map[string]any{"role": "user", "content": "hi"}},
+		}
+	}
+	out, err := normalizeOpenAIChatRequest(cfg, base(float64(2)))
+	if err != nil || out.N != 2 {
+		t.Fatalf("expected n=2 accepted, got n=%d err=%v", out.N, err)
+	}
+	for _, bad := range []any{float64(3), float64(0), 1.5, "2"} {
+		if _, err := normalizeOpenAIChatRequest(cfg, base(bad)); err == nil {
+			t.Fatalf("expected n=%v to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/util"
+)
+
+func TestWantSearchSourcesRequestFlagOverridesKeyDefault(t *testing.T) {
+	keyOn := &auth.RequestAuth{SearchSources: true}
+	cases := []struct {
+		req  map[string]any
+		a    *auth.RequestAuth
+		want bool
+	}{
+		{map[string]any{}, nil, false},
+		{map[string]any{}, keyOn, true},
+		{map[string]any{"search_sources": false}, keyOn, false},
+		{map[string]any{"search_sources": true}, &auth.RequestAuth{}, true},
+	}
+	for i, tc := range cases {
+		if got := wantSearchSources(tc.req, tc.a); got != tc.want {
+			t.Fatalf("case %d: want %v, got %v", i, tc.want, got)
+		}
+	}
+}
+
+func TestHandleStreamSearchSourcesDeltas(t *testing.T) {
+	h := &Handler{}
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	rec := httptest.NewRecorder()
+
+	h.handleStream(rec, req, webSearchSSE(), "cid-search", util.StandardRequest{ResponseModel: "deepseek-chat-search", FinalPrompt: "prompt", Search: true, SearchSources: true}, nil)
+
+	frames, done := parseSSEDataFrames(t, rec.Body.String())
+	if !done {
+		t.Fatalf("expected [DONE], body=%s", rec.Body.String())
+	}
+	var queries, results []any
+	var content strings.Builder
+	for _, frame := range frames {
+		choices, _ := frame["choices"].([]any)
+		for _, c := range choices {
+			delta, _ := c.(map[string]any)["delta"].(map[string]any)
+			if q, ok := delta["search_queries"].([]any); ok {
+				queries = append(queries, q...)
+			}
+			if r, ok := delta["search_results"].([]any); ok {
+				results = append(results, r...)
+			}
+			content.WriteString(asString(delta["content"]))
+		}
+	}
+	if len(queries) != 1 || queries[0] != "go release" {
+		t.Fatalf("unexpected search_queries: %#v", queries)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected one search result, got %#v", results)
+	}
+	result, _ := results[0].(map[string]any)
+	if result["index"] != float64(1) || result["url"] != "https://go.dev/blog" {
+		t.Fatalf("unexpected search result: %#v", result)
+	}
+	if content.String() != "Go 1.22 is out[citation:1]." {
+		t.Fatalf("expected citation markers kept with sources, got %q", content.String())
+	}
+}
+
+func TestHandleStreamWithoutSearchSourcesOmitsExtensions(t *testing.T) {
+	h := &Handler{}
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	rec := httptest.NewRecorder()
+
+	h.handleStream(rec, req, webSearchSSE(), "cid-search", util.StandardRequest{ResponseModel: "deepseek-chat-search", FinalPrompt: "prompt", Search: true}, nil)
+
+	body := rec.Body.String()
+	if strings.Contains(body, "search_results") || strings.Contains(body, "search_queries") || strings.Contains(body, "[citation:") {
+		t.Fatalf("expected strict chunks, body=%s", body)
+	}
+}
+
+func TestHandleNonStreamSearchSources(t *testing.T) {
+	h := &Handler{}
+	rec := httptest.NewRecorder()
+
+	h.handleNonStream(rec, webSearchSSE(), "cid-search", util.StandardRequest{ResponseModel: "deepseek-chat-search", FinalPrompt: "prompt", SearchSources: true}, nil)
+
+	out := decodeJSONBody(t, rec.Body.String())
+	sources, _ := out["sources"].([]any)
+	if len(sources) != 1 {
+		t.Fatalf("expected one source, got %#v", out["sources"])
+	}
+	if src, _ := sources[0].(map[string]any); src["url"] != "https://go.dev/blog" || src["title"] != "Go Blog" {
+		t.Fatalf("unexpected source: %#v", src)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"sync"
 
 	openaifmt "ds2api/internal/format/openai"
 	"ds2api/internal/sse"
@@ -16,6 +17,22 @@ type chatStreamRuntime struct {
 	rc       *http.ResponseController
 	canFlush bool
 	writable bool
+	// writeMu is shared by the runtimes of an n>1 stream so their chunks
+	// never interleave mid-frame; nil for single-choice streams.
+	writeMu     *sync.Mutex
+	choiceIndex int
+	// finalizeText, when set, holds content deltas back and rewrites the
+	// final text (structured output enforcement) before it is emitted.
+	finalizeText textFinalizer
+	toolPolicy   util.ToolPolicy
+	// limiter cuts output at the client's stop sequences and token limit.
+	limiter *util.OutputLimiter
+	// upstream is the accounting DeepSeek reported on the stream so far.
+	upstream util.UpstreamUsage
+	// sources, when set, collects the searches so their progress and
+	// results go out as delta extensions; citation markers are then kept
+	// in the content for clients to resolve against the results.
+	sources *util.SearchSources
 
 	completionID string
 	created      int64
@@ -69,7 +86,16 @@ func newChatStreamRuntime(
 	}
 }
 
+func (s *chatStreamRuntime) lockWrite() func() {
+	if s.writeMu == nil {
+		return func() {}
+	}
+	s.writeMu.Lock()
+	return s.writeMu.Unlock
+}
+
 func (s *chatStreamRuntime) sendKeepAlive() bool {
+	defer s.lockWrite()()
 	if !s.writable {
 		return false
 	}
@@ -88,10 +114,11 @@ func (s *chatStreamRuntime) sendKeepAlive() bool {
 }
 
 func (s *chatStreamRuntime) sendChunk(v any) bool {
+	b, _ := json.Marshal(v)
+	defer s.lockWrite()()
 	if !s.writable {
 		return false
 	}
-	b, _ := json.Marshal(v)
 	if _, err := s.w.Write([]byte("data: ")); err != nil {
 		s.writable = false
 		return false
@@ -114,6 +141,7 @@ func (s *chatStreamRuntime) sendChunk(v any) bool {
 }
 
 func (s *chatStreamRuntime) sendDone() bool {
+	defer s.lockWrite()()
 	if !s.writable {
 		return false
 	}
@@ -131,13 +159,53 @@ func (s *chatStreamRuntime) sendDone() bool {
 }
 
 func (s *chatStreamRuntime) finalize(finishReason string) {
-	if !s.writable {
+	if !s.finishChoice(finishReason, true) {
 		return
 	}
-	finalThinking := s.thinking.String()
+	s.sendDone()
+}
+
+// finishChoice flushes buffered tool output and emits this choice's finish
+// chunk, carrying usage when withUsage is set.
+func (s *chatStreamRuntime) finishChoice(finishReason string, withUsage bool) bool {
+	if !s.writable {
+		return false
+	}
+	if held := s.limiter.Flush(); held != "" {
+		if choices := s.appendText(held); len(choices) > 0 {
+			if !s.sendChunk(openaifmt.BuildChatStreamChunk(s.completionID, s.created, s.model, choices, nil)) {
+				return false
+			}
+		}
+	}
+	if s.limiter.Reason() == util.OutputLimitMaxTokens && finishReason == "stop" {
+		finishReason = "length"
+	}
 	finalText := s.text.String()
-	detected := util.ParseToolCalls(finalText, s.toolNames)
-	if len(detected) > 0 && !s.toolCallsEmitted {
+	if s.finalizeText != nil {
+		content, err := s.finalizeText(finalText, &s.upstream)
+		if err != nil {
+			return s.sendChoiceError(err.Error())
+		}
+		finalText = content
+	}
+	detected := detectToolCalls(finalText, s.toolNames, s.toolPolicy, s.finalizeText != nil)
+	if s.finalizeText != nil && len(detected) == 0 {
+		delta := map[string]any{"content": finalText}
+		if !s.firstChunkSent {
+			delta["role"] = "assistant"
+			s.firstChunkSent = true
+		}
+		if !s.sendChunk(openaifmt.BuildChatStreamChunk(
+			s.completionID,
+			s.created,
+			s.model,
+			[]map[string]any{openaifmt.BuildChatStreamDeltaChoice(s.choiceIndex, delta)},
+			nil,
+		)) {
+			return false
+		}
+	} else if len(detected) > 0 && !s.toolCallsEmitted {
 		finishReason = "tool_calls"
 		delta := map[string]any{
 			"tool_calls": util.FormatOpenAIStreamToolCalls(detected),
@@ -150,10 +218,10 @@ func (s *chatStreamRuntime) finalize(finishReason string) {
 			s.completionID,
 			s.created,
 			s.model,
-			[]map[string]any{openaifmt.BuildChatStreamDeltaChoice(0, delta)},
+			[]map[string]any{openaifmt.BuildChatStreamDeltaChoice(s.choiceIndex, delta)},
 			nil,
 		)) {
-			return
+			return false
 		}
 	} else if s.bufferToolContent {
 		for _, evt := range flushToolSieve(&s.toolSieve, s.toolNames) {
@@ -171,10 +239,10 @@ func (s *chatStreamRuntime) finalize(finishReason string) {
 				s.completionID,
 				s.created,
 				s.model,
-				[]map[string]any{openaifmt.BuildChatStreamDeltaChoice(0, delta)},
+				[]map[string]any{openaifmt.BuildChatStreamDeltaChoice(s.choiceIndex, delta)},
 				nil,
 			)) {
-				return
+				return false
 			}
 		}
 	}
@@ -182,16 +250,40 @@ func (s *chatStreamRuntime) finalize(finishReason string) {
 	if len(detected) > 0 || s.toolCallsEmitted {
 		finishReason = "tool_calls"
 	}
-	if !s.sendChunk(openaifmt.BuildChatStreamChunk(
+	var usage map[string]any
+	if withUsage {
+		usage = openaifmt.BuildChatUsageWithUpstream(s.finalPrompt, s.thinking.String(), finalText, s.upstream.Delivered(s.limiter.Done() || finalText != s.text.String()))
+	}
+	return s.sendChunk(openaifmt.BuildChatStreamChunk(
 		s.completionID,
 		s.created,
 		s.model,
-		[]map[string]any{openaifmt.BuildChatStreamFinishChoice(0, finishReason)},
-		openaifmt.BuildChatUsage(s.finalPrompt, finalThinking, finalText),
-	)) {
-		return
+		[]map[string]any{openaifmt.BuildChatStreamFinishChoice(s.choiceIndex, finishReason)},
+		usage,
+	))
+}
+
+// admitToolCalls applies the tool policy to calls found mid-stream; with
+// parallel tool calls disabled nothing is admitted after the first call.
+func (s *chatStreamRuntime) admitToolCalls(calls []util.ParsedToolCall) []util.ParsedToolCall {
+	if s.toolPolicy.Single && s.toolCallsEmitted {
+		return nil
 	}
-	s.sendDone()
+	return s.toolPolicy.Filter(calls, s.toolNames)
+}
+
+// earlyToolDeltas reports whether tool call arguments may stream before the
+// call is complete. Deltas go out before the policy can judge the call, so
+// only the default policy, which admits every parsed call, allows them.
+func (s *chatStreamRuntime) earlyToolDeltas() bool {
+	return s.emitEarlyToolDeltas && s.toolPolicy.IsDefault()
+}
+
+// sendChoiceError closes this choice with finish_reason "error".
+func (s *chatStreamRuntime) sendChoiceError(message string) bool {
+	choice := openaifmt.BuildChatStreamFinishChoice(s.choiceIndex, "error")
+	choice["error"] = map[string]any{"message": message}
+	return s.sendChunk(openaifmt.BuildChatStreamChunk(s.completionID, s.created, s.model, []map[string]any{choice}, nil))
 }
 
 func (s *chatStreamRuntime) onParsed(parsed sse.LineResult) streamengine.ParsedDecision {
@@ -201,6 +293,7 @@ func (s *chatStreamRuntime) onParsed(parsed sse.LineResult) streamengine.ParsedD
 	if !parsed.Parsed {
 		return streamengine.ParsedDecision{}
 	}
+	s.upstream.Merge(parsed.Usage)
 	if parsed.ContentFilter || parsed.ErrorMessage != "" {
 		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReason("content_filter")}
 	}
@@ -208,76 +301,43 @@ func (s *chatStreamRuntime) onParsed(parsed sse.LineResult) streamengine.ParsedD
 		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
 	}
 
-	newChoices := make([]map[string]any, 0, len(parsed.Parts))
+	newChoices := make([]map[string]any, 0, len(parsed.Parts)+1)
+	if delta := s.searchDelta(parsed.Search); len(delta) > 0 {
+		if !s.firstChunkSent {
+			delta["role"] = "assistant"
+			s.firstChunkSent = true
+		}
+		newChoices = append(newChoices, openaifmt.BuildChatStreamDeltaChoice(s.choiceIndex, delta))
+	}
 	contentSeen := false
 	for _, p := range parsed.Parts {
-		if s.searchEnabled && sse.IsCitation(p.Text) {
+		if s.searchEnabled && s.sources == nil && sse.IsCitation(p.Text) {
 			continue
 		}
 		if p.Text == "" {
 			continue
 		}
 		contentSeen = true
+		var textChoices []map[string]any
 		delta := map[string]any{}
-		if !s.firstChunkSent {
-			delta["role"] = "assistant"
-			s.firstChunkSent = true
-		}
 		if p.Type == "thinking" {
 			if s.thinkingEnabled {
-				s.thinking.WriteString(p.Text)
-				delta["reasoning_content"] = p.Text
-			}
-		} else {
-			s.text.WriteString(p.Text)
-			if !s.bufferToolContent {
-				delta["content"] = p.Text
-			} else {
-				events := processToolSieveChunk(&s.toolSieve, p.Text, s.toolNames)
-				for _, evt := range events {
-					if len(evt.ToolCallDeltas) > 0 {
-						if !s.emitEarlyToolDeltas {
-							continue
-						}
-						s.toolCallsEmitted = true
-						tcDelta := map[string]any{
-							"tool_calls": formatIncrementalStreamToolCallDeltas(evt.ToolCallDeltas, s.streamToolCallIDs),
-						}
-						if !s.firstChunkSent {
-							tcDelta["role"] = "assistant"
-							s.firstChunkSent = true
-						}
-						newChoices = append(newChoices, openaifmt.BuildChatStreamDeltaChoice(0, tcDelta))
-						continue
-					}
-					if len(evt.ToolCalls) > 0 {
-						s.toolCallsEmitted = true
-						tcDelta := map[string]any{
-							"tool_calls": util.FormatOpenAIStreamToolCalls(evt.ToolCalls),
-						}
-						if !s.firstChunkSent {
-							tcDelta["role"] = "assistant"
-							s.firstChunkSent = true
-						}
-						newChoices = append(newChoices, openaifmt.BuildChatStreamDeltaChoice(0, tcDelta))
-						continue
-					}
-					if evt.Content != "" {
-						contentDelta := map[string]any{
-							"content": evt.Content,
-						}
-						if !s.firstChunkSent {
-							contentDelta["role"] = "assistant"
-							s.firstChunkSent = true
-						}
-						newChoices = append(newChoices, openaifmt.BuildChatStreamDeltaChoice(0, contentDelta))
-					}
+				if text := s.limiter.Thinking(p.Text); text != "" {
+					s.thinking.WriteString(text)
+					delta["reasoning_content"] = text
 				}
 			}
+		} else {
+			textChoices = s.appendText(s.limiter.Text(p.Text))
+		}
+		if !s.firstChunkSent {
+			delta["role"] = "assistant"
+			s.firstChunkSent = true
 		}
 		if len(delta) > 0 {
-			newChoices = append(newChoices, openaifmt.BuildChatStreamDeltaChoice(0, delta))
+			newChoices = append(newChoices, openaifmt.BuildChatStreamDeltaChoice(s.choiceIndex, delta))
 		}
+		newChoices = append(newChoices, textChoices...)
 	}
 
 	if len(newChoices) > 0 {
@@ -285,5 +345,84 @@ func (s *chatStreamRuntime) onParsed(parsed sse.LineResult) streamengine.ParsedD
 			return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
 		}
 	}
+	if s.limiter.Done() {
+		// Ending the scan closes the upstream body, aborting generation.
+		return streamengine.ParsedDecision{ContentSeen: contentSeen, Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+	}
 	return streamengine.ParsedDecision{ContentSeen: contentSeen}
 }
+
+// searchDelta folds a line's search activity in and returns the delta
+// extensions announcing it: search_queries as a search starts and
+// search_results once its results arrive. It is empty unless sources are
+// requested.
+func (s *chatStreamRuntime) searchDelta(u util.SearchUpdate) map[string]any {
+	if s.sources == nil || u.IsZero() {
+		return nil
+	}
+	offset := len(s.sources.Results())
+	s.sources.Merge(u)
+	delta := map[string]any{}
+	queries := make([]any, 0, len(u.Queries))
+	for _, q := range u.Queries {
+		if q = strings.TrimSpace(q); q != "" {
+			queries = append(queries, q)
+		}
+	}
+	if len(queries) > 0 {
+		delta["search_queries"] = queries
+	}
+	if results := s.sources.Results(); len(results) > offset {
+		delta["search_results"] = openaifmt.BuildChatSearchResults(results[offset:], offset)
+	}
+	return delta
+}
+
+// appendText records admitted output text and returns the delta choices it
+// produces: plain content, or tool call deltas when the tool sieve is active.
+// Nothing is returned while a finalizer holds the text back.
+func (s *chatStreamRuntime) appendText(text string) []map[string]any {
+	if text == "" {
+		return nil
+	}
+	s.text.WriteString(text)
+	if s.finalizeText != nil {
+		return nil
+	}
+	withRole := func(delta map[string]any) map[string]any {
+		if !s.firstChunkSent {
+			delta["role"] = "assistant"
+			s.firstChunkSent = true
+		}
+		return openaifmt.BuildChatStreamDeltaChoice(s.choiceIndex, delta)
+	}
+	if !s.bufferToolContent {
+		return []map[string]any{withRole(map[string]any{"content": text})}
+	}
+	choices := []map[string]any{}
+	s.toolSieve.holdDeltas = !s.earlyToolDeltas()
+	for _, evt := range processToolSieveChunk(&s.toolSieve, text, s.toolNames) {
+		if len(evt.ToolCallDeltas) > 0 {
+			s.toolCallsEmitted = true
+			choices = append(choices, withRole(map[string]any{
+				"tool_calls": formatIncrementalStreamToolCallDeltas(evt.ToolCallDeltas, s.streamToolCallIDs),
+			}))
+			continue
+		}
+		if len(evt.ToolCalls) > 0 {
+			calls := s.admitToolCalls(evt.ToolCalls)
+			if len(calls) == 0 {
+				continue
+			}
+			s.toolCallsEmitted = true
+			choices = append(choices, withRole(map[string]any{
+				"tool_calls": util.FormatOpenAIStreamToolCalls(calls),
+			}))
+			continue
+		}
+		if evt.Content != "" {
+			choices = append(choices, withRole(map[string]any{"content": evt.Content}))
+		}
+	}
+	return choices
+}
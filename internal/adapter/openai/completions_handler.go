@@ -0,0 +1,401 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/config"
+	"ds2api/internal/deepseek"
+	openaifmt "ds2api/internal/format/openai"
+	"ds2api/internal/sse"
+	streamengine "ds2api/internal/stream"
+	"ds2api/internal/tokenizer"
+	"ds2api/internal/util"
+)
+
+// DeepSeek fill-in-the-middle markers, used to place a suffix after the
+// text the model should insert.
+const (
+	fimBegin = "<｜fim▁begin｜>"
+	fimHole  = "<｜fim▁hole｜>"
+	fimEnd   = "<｜fim▁end｜>"
+)
+
+// Completions serves the legacy text completion API. Prompts are sent
+// upstream verbatim, without the chat template; each prompt yields n
+// choices, indexed prompt by prompt.
+func (h *Handler) Completions(w http.ResponseWriter, r *http.Request) {
+	a, err := h.Auth.Determine(r)
+	if err != nil {
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
+		return
+	}
+	defer h.Auth.Release(a)
+	r = r.WithContext(auth.WithAuth(r.Context(), a))
+
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	creq, err := normalizeOpenAICompletionRequest(h.Store, req)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	total := len(creq.Prompts) * creq.Std.N
+	lanes := h.acquireChoiceLanes(r.Context(), a, total)
+	defer h.releaseChoiceLanes(lanes)
+	if creq.Std.Stream {
+		h.handleCompletionsStream(w, r, lanes, creq)
+		return
+	}
+
+	outputs := make([]openaifmt.TextChoiceOutput, total)
+	var firstErr *choiceCallError
+	var errMu sync.Mutex
+	lanes.run(total, func(la *auth.RequestAuth, idx int) {
+		if creq.Echo {
+			outputs[idx].Echo = creq.promptOf(idx)
+		}
+		resp, callErr := h.startChoice(r.Context(), la, creq.choiceRequest(idx))
+		if callErr != nil {
+			outputs[idx].Error = callErr.message
+			errMu.Lock()
+			if firstErr == nil {
+				firstErr = callErr
+			}
+			errMu.Unlock()
+			return
+		}
+		limiter := util.NewOutputLimiter(creq.Std.Limits)
+		result := sse.CollectStreamLimited(resp, creq.Std.Thinking, true, limiter)
+		outputs[idx].Text = result.Text
+		outputs[idx].FinishReason = chatFinishReason(limiter)
+		outputs[idx].Usage = result.Usage.Delivered(limiter.Done())
+	})
+
+	failed := 0
+	for _, out := range outputs {
+		if out.Error != "" {
+			failed++
+		}
+	}
+	if failed == total && firstErr != nil {
+		writeOpenAIError(w, firstErr.status, firstErr.message)
+		return
+	}
+	writeJSON(w, http.StatusOK, openaifmt.BuildTextCompletion(newTextCompletionID(), creq.Std.ResponseModel, creq.FinalPrompts, outputs))
+}
+
+// handleCompletionsStream interleaves the text deltas of every choice on one
+// SSE stream. As for chat, choice 0 is started before any byte is written,
+// later failures close their choice with finish_reason "error", and usage is
+// sent in a final chunk with an empty choices list.
+func (h *Handler) handleCompletionsStream(w http.ResponseWriter, r *http.Request, lanes *choiceLanes, creq completionRequest) {
+	firstResp, callErr := h.startChoice(r.Context(), lanes.auths[0], creq.choiceRequest(0))
+	if callErr != nil {
+		writeOpenAIError(w, callErr.status, callErr.message)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache, no-transform")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	canFlush := rc.Flush() == nil
+	if !canFlush {
+		config.Logger.Warn("[stream] response writer does not support flush; streaming may be buffered")
+	}
+
+	total := len(creq.Prompts) * creq.Std.N
+	completionID := newTextCompletionID()
+	created := time.Now().Unix()
+	var writeMu sync.Mutex
+	outputs := make([]openaifmt.TextChoiceOutput, total)
+	newRuntime := func(idx int) *textCompletionStreamRuntime {
+		return &textCompletionStreamRuntime{
+			w:             w,
+			rc:            rc,
+			canFlush:      canFlush,
+			writable:      true,
+			writeMu:       &writeMu,
+			completionID:  completionID,
+			created:       created,
+			model:         creq.Std.ResponseModel,
+			index:         idx,
+			searchEnabled: creq.Std.Search,
+			limiter:       util.NewOutputLimiter(creq.Std.Limits),
+		}
+	}
+	consume := func(idx int, resp *http.Response) {
+		defer resp.Body.Close()
+		rt := newRuntime(idx)
+		if creq.Echo {
+			outputs[idx].Echo = creq.promptOf(idx)
+			if !rt.sendText(outputs[idx].Echo) {
+				return
+			}
+		}
+		initialType := "text"
+		if creq.Std.Thinking {
+			initialType = "thinking"
+		}
+		streamengine.ConsumeSSE(streamengine.ConsumeConfig{
+			Context:             r.Context(),
+			Body:                resp.Body,
+			ThinkingEnabled:     creq.Std.Thinking,
+			InitialType:         initialType,
+			KeepAliveInterval:   time.Duration(deepseek.KeepAliveTimeout) * time.Second,
+			IdleTimeout:         time.Duration(deepseek.StreamIdleTimeout) * time.Second,
+			MaxKeepAliveNoInput: deepseek.MaxKeepaliveCount,
+		}, streamengine.ConsumeHooks{
+			OnKeepAlive: rt.sendKeepAlive,
+			OnParsed:    rt.onParsed,
+			OnFinalize: func(reason streamengine.StopReason, _ error) {
+				finishReason := "stop"
+				if string(reason) == "content_filter" {
+					finishReason = "content_filter"
+				}
+				rt.finish(finishReason)
+			},
+		})
+		outputs[idx].Text = rt.text.String()
+		outputs[idx].Usage = rt.upstream.Delivered(rt.limiter.Done())
+	}
+
+	lanes.run(total, func(la *auth.RequestAuth, idx int) {
+		if idx == 0 {
+			consume(0, firstResp)
+			return
+		}
+		resp, callErr := h.startChoice(r.Context(), la, creq.choiceRequest(idx))
+		if callErr != nil {
+			outputs[idx].Error = callErr.message
+			config.Logger.Warn("[stream] completion choice failed", "index", idx, "status", callErr.status)
+			newRuntime(idx).sendChoiceError(callErr.message)
+			return
+		}
+		consume(idx, resp)
+	})
+
+	final := newRuntime(0)
+	if !final.sendChunk(openaifmt.BuildTextCompletionChunk(completionID, created, creq.Std.ResponseModel,
+		[]map[string]any{}, openaifmt.BuildTextCompletionUsage(creq.FinalPrompts, outputs))) {
+		return
+	}
+	final.sendDone()
+}
+
+func newTextCompletionID() string {
+	return "cmpl-" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+// parseCompletionPrompts accepts a string, an array of strings, an array of
+// token ids, or an array of token id arrays. Token ids need the tokenizer
+// vocabulary to be turned back into text.
+func parseCompletionPrompts(raw any) ([]string, error) {
+	switch v := raw.(type) {
+	case string:
+		if v == "" {
+			return nil, fmt.Errorf("'prompt' must not be empty.")
+		}
+		return []string{v}, nil
+	case []any:
+		if len(v) == 0 {
+			return nil, fmt.Errorf("'prompt' must not be empty.")
+		}
+		if _, isToken := v[0].(float64); isToken {
+			text, err := decodePromptTokens(v)
+			if err != nil {
+				return nil, err
+			}
+			return []string{text}, nil
+		}
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			switch p := item.(type) {
+			case string:
+				if p == "" {
+					return nil, fmt.Errorf("'prompt' must not contain empty strings.")
+				}
+				out = append(out, p)
+			case []any:
+				text, err := decodePromptTokens(p)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, text)
+			default:
+				return nil, fmt.Errorf("'prompt' must be a string, an array of strings or an array of token ids.")
+			}
+		}
+		return out, nil
+	case nil:
+		return nil, fmt.Errorf("Request must include 'model' and 'prompt'.")
+	default:
+		return nil, fmt.Errorf("'prompt' must be a string, an array of strings or an array of token ids.")
+	}
+}
+
+func decodePromptTokens(raw []any) (string, error) {
+	tok := tokenizer.Default()
+	if tok == nil {
+		return "", fmt.Errorf("Token id prompts need the tokenizer vocabulary, which is not loaded.")
+	}
+	ids := make([]int, 0, len(raw))
+	for _, item := range raw {
+		f, ok := item.(float64)
+		if !ok || f != float64(int(f)) || f < 0 {
+			return "", fmt.Errorf("'prompt' token arrays must contain only non-negative integers.")
+		}
+		ids = append(ids, int(f))
+	}
+	text := tok.Decode(ids)
+	if text == "" {
+		return "", fmt.Errorf("'prompt' must not be empty.")
+	}
+	return text, nil
+}
+
+// buildRawCompletionPrompt sends the prompt as is; a suffix turns it into a
+// fill-in-the-middle request.
+func buildRawCompletionPrompt(prompt, suffix string) string {
+	if suffix == "" {
+		return prompt
+	}
+	return fimBegin + prompt + fimHole + suffix + fimEnd
+}
+
+// textCompletionStreamRuntime streams one choice of a legacy completion.
+// Reasoning has no place in the text completion shape and is not sent.
+type textCompletionStreamRuntime struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	canFlush bool
+	writable bool
+	writeMu  *sync.Mutex
+
+	completionID  string
+	created       int64
+	model         string
+	index         int
+	searchEnabled bool
+
+	limiter  *util.OutputLimiter
+	upstream util.UpstreamUsage
+	text     strings.Builder
+}
+
+func (s *textCompletionStreamRuntime) write(frame []byte) bool {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if !s.writable {
+		return false
+	}
+	if _, err := s.w.Write(frame); err != nil {
+		s.writable = false
+		return false
+	}
+	if s.canFlush {
+		if err := s.rc.Flush(); err != nil {
+			s.writable = false
+			return false
+		}
+	}
+	return true
+}
+
+func (s *textCompletionStreamRuntime) sendChunk(v any) bool {
+	b, _ := json.Marshal(v)
+	return s.write(append(append([]byte("data: "), b...), '\n', '\n'))
+}
+
+func (s *textCompletionStreamRuntime) sendKeepAlive() bool {
+	if !s.canFlush {
+		return s.writable
+	}
+	return s.write([]byte(": keep-alive\n\n"))
+}
+
+func (s *textCompletionStreamRuntime) sendDone() bool {
+	return s.write([]byte("data: [DONE]\n\n"))
+}
+
+func (s *textCompletionStreamRuntime) sendText(text string) bool {
+	if text == "" {
+		return true
+	}
+	return s.sendChunk(openaifmt.BuildTextCompletionChunk(s.completionID, s.created, s.model,
+		[]map[string]any{openaifmt.BuildTextCompletionChoice(s.index, text, nil)}, nil))
+}
+
+func (s *textCompletionStreamRuntime) sendChoiceError(message string) bool {
+	choice := openaifmt.BuildTextCompletionChoice(s.index, "", "error")
+	choice["error"] = map[string]any{"message": message}
+	return s.sendChunk(openaifmt.BuildTextCompletionChunk(s.completionID, s.created, s.model, []map[string]any{choice}, nil))
+}
+
+func (s *textCompletionStreamRuntime) appendText(text string) bool {
+	if text == "" {
+		return true
+	}
+	s.text.WriteString(text)
+	return s.sendText(text)
+}
+
+func (s *textCompletionStreamRuntime) onParsed(parsed sse.LineResult) streamengine.ParsedDecision {
+	if !s.writable {
+		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+	}
+	if !parsed.Parsed {
+		return streamengine.ParsedDecision{}
+	}
+	s.upstream.Merge(parsed.Usage)
+	if parsed.ContentFilter || parsed.ErrorMessage != "" {
+		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReason("content_filter")}
+	}
+	if parsed.Stop {
+		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+	}
+	contentSeen := false
+	for _, p := range parsed.Parts {
+		if p.Text == "" || (s.searchEnabled && sse.IsCitation(p.Text)) {
+			continue
+		}
+		contentSeen = true
+		if p.Type == "thinking" {
+			// Reasoning still spends the token budget, as in chat.
+			s.limiter.Thinking(p.Text)
+			continue
+		}
+		if !s.appendText(s.limiter.Text(p.Text)) {
+			return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+		}
+	}
+	if s.limiter.Done() {
+		// Ending the scan closes the upstream body, aborting generation.
+		return streamengine.ParsedDecision{ContentSeen: contentSeen, Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+	}
+	return streamengine.ParsedDecision{ContentSeen: contentSeen}
+}
+
+// finish releases text held back as a possible stop sequence and closes the
+// choice.
+func (s *textCompletionStreamRuntime) finish(finishReason string) bool {
+	if !s.appendText(s.limiter.Flush()) {
+		return false
+	}
+	if s.limiter.Reason() == util.OutputLimitMaxTokens && finishReason == "stop" {
+		finishReason = "length"
+	}
+	return s.sendChunk(openaifmt.BuildTextCompletionChunk(s.completionID, s.created, s.model,
+		[]map[string]any{openaifmt.BuildTextCompletionChoice(s.index, "", finishReason)}, nil))
+}
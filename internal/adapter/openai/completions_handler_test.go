@@ -0,0 +1,171 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"ds2api/internal/auth"
+)
+
+// promptDS records the prompts it is sent and answers each with a fixed
+// continuation.
+type promptDS struct {
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (d *promptDS) CreateSession(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "session", nil
+}
+
+func (d *promptDS) GetPow(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "pow", nil
+}
+
+func (d *promptDS) CallCompletion(_ context.Context, _ *auth.RequestAuth, payload map[string]any, _ string, _ int) (*http.Response, error) {
+	d.mu.Lock()
+	d.prompts = append(d.prompts, payload["prompt"].(string))
+	d.mu.Unlock()
+	return makeSSEHTTPResponse(
+		`data: {"p":"response/thinking_content","v":"hidden"}`,
+		`data: {"p":"response/content","v":" world. STOP here"}`,
+		`data: [DONE]`,
+	), nil
+}
+
+func postCompletions(t *testing.T, h *Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer direct-token")
+	rec := httptest.NewRecorder()
+	h.Completions(rec, req)
+	return rec
+}
+
+func TestCompletionsSendsRawPromptAndEchoes(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &promptDS{}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	rec := postCompletions(t, h, `{"model":"deepseek-reasoner","prompt":"Hello","echo":true,"stop":" STOP"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", rec.Code, rec.Body.String())
+	}
+	if len(ds.prompts) != 1 || ds.prompts[0] != "Hello" {
+		t.Fatalf("expected the raw prompt upstream, got %#v", ds.prompts)
+	}
+	out := decodeJSONBody(t, rec.Body.String())
+	if out["object"] != "text_completion" || !strings.HasPrefix(out["id"].(string), "cmpl-") {
+		t.Fatalf("unexpected object: %#v", out)
+	}
+	choice := out["choices"].([]any)[0].(map[string]any)
+	if choice["text"] != "Hello world." || choice["finish_reason"] != "stop" {
+		t.Fatalf("unexpected choice: %#v", choice)
+	}
+	if _, ok := choice["logprobs"]; !ok {
+		t.Fatalf("expected logprobs key: %#v", choice)
+	}
+}
+
+func TestCompletionsMultiplePromptsAndSuffix(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &promptDS{}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	rec := postCompletions(t, h, `{"model":"deepseek-chat","prompt":["a","b"],"n":2,"suffix":"END"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", rec.Code, rec.Body.String())
+	}
+	choices := decodeJSONBody(t, rec.Body.String())["choices"].([]any)
+	if len(choices) != 4 || len(ds.prompts) != 4 {
+		t.Fatalf("expected 4 choices from 4 calls, got %d choices %d calls", len(choices), len(ds.prompts))
+	}
+	want := map[string]int{fimBegin + "a" + fimHole + "END" + fimEnd: 2, fimBegin + "b" + fimHole + "END" + fimEnd: 2}
+	for _, p := range ds.prompts {
+		want[p]--
+	}
+	for p, left := range want {
+		if left != 0 {
+			t.Fatalf("prompt %q sent an unexpected number of times: %#v", p, ds.prompts)
+		}
+	}
+}
+
+func TestCompletionsStreamEchoAndUsage(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &promptDS{}}
+
+	rec := postCompletions(t, h, `{"model":"deepseek-chat","prompt":"Hi","echo":true,"stream":true}`)
+	frames, done := parseSSEDataFrames(t, rec.Body.String())
+	if !done {
+		t.Fatalf("expected [DONE], body=%s", rec.Body.String())
+	}
+	var text strings.Builder
+	var finish any
+	var usage map[string]any
+	for _, f := range frames {
+		if f["object"] != "text_completion" {
+			t.Fatalf("unexpected chunk object: %#v", f)
+		}
+		for _, c := range f["choices"].([]any) {
+			choice := c.(map[string]any)
+			text.WriteString(choice["text"].(string))
+			if choice["finish_reason"] != nil {
+				finish = choice["finish_reason"]
+			}
+		}
+		if u, ok := f["usage"].(map[string]any); ok {
+			usage = u
+		}
+	}
+	if text.String() != "Hi world. STOP here" || finish != "stop" {
+		t.Fatalf("unexpected stream text=%q finish=%v", text.String(), finish)
+	}
+	if usage == nil || usage["completion_tokens"].(float64) <= 0 {
+		t.Fatalf("expected usage chunk, got %#v", usage)
+	}
+}
+
+func TestCompletionsCapsPromptsTimesN(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &promptDS{}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+	for _, body := range []string{
+		`{"model":"deepseek-chat","prompt":["a","b","c","d","e"]}`,
+		`{"model":"deepseek-chat","prompt":["a","b","c"],"n":2}`,
+	} {
+		rec := postCompletions(t, h, body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "at most 4 are allowed") {
+			t.Fatalf("expected 400 for %s, got %d %s", body, rec.Code, rec.Body.String())
+		}
+	}
+	if len(ds.prompts) != 0 {
+		t.Fatalf("expected no upstream calls, got %d", len(ds.prompts))
+	}
+}
+
+func TestCompletionsRejectsBadPrompt(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &promptDS{}}
+	for _, body := range []string{
+		`{"model":"deepseek-chat"}`,
+		`{"model":"deepseek-chat","prompt":""}`,
+		`{"model":"deepseek-chat","prompt":[1,2,3]}`,
+		`{"model":"deepseek-chat","prompt":{"x":1}}`,
+	} {
+		rec := postCompletions(t, h, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, rec.Code)
+		}
+		var out map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		if out["error"] == nil {
+			t.Fatalf("expected error body for %s: %s", body, rec.Body.String())
+		}
+	}
+}
@@ -12,6 +12,9 @@ import (
 type AuthResolver interface {
 	Determine(req *http.Request) (*auth.RequestAuth, error)
 	DetermineCaller(req *http.Request) (*auth.RequestAuth, error)
+	DetermineCallerRef(req *http.Request) (*auth.RequestAuth, string, error)
+	AcquireIdle(ctx context.Context, ref string) (*auth.RequestAuth, bool, error)
+	AcquireExtra(ctx context.Context, primary *auth.RequestAuth) (*auth.RequestAuth, bool)
 	Release(a *auth.RequestAuth)
 }
 
@@ -24,10 +27,13 @@ type DeepSeekCaller interface {
 type ConfigReader interface {
 	ModelAliases() map[string]string
 	CompatWideInputStrictOutput() bool
+	CompatResponseFormatRepair() bool
 	ToolcallMode() string
 	ToolcallEarlyEmitConfidence() string
 	ResponsesStoreTTLSeconds() int
 	EmbeddingsProvider() string
+	RuntimeMaxChoices() int
+	ReasoningEffortRule(effort string) (config.ReasoningRule, bool)
 }
 
 var _ AuthResolver = (*auth.Resolver)(nil)
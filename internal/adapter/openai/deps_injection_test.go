@@ -1,6 +1,10 @@
 package openai
 
-import "testing"
+import (
+	"testing"
+
+	"ds2api/internal/config"
+)
 
 type mockOpenAIConfig struct {
 	aliases      map[string]string
@@ -9,16 +13,23 @@ type mockOpenAIConfig struct {
 	earlyEmit    string
 	responsesTTL int
 	embedProv    string
+	maxChoices   int
+	noRepair     bool
 }
 
 func (m mockOpenAIConfig) ModelAliases() map[string]string { return m.aliases }
 func (m mockOpenAIConfig) CompatWideInputStrictOutput() bool {
 	return m.wideInput
 }
+func (m mockOpenAIConfig) CompatResponseFormatRepair() bool    { return !m.noRepair }
 func (m mockOpenAIConfig) ToolcallMode() string                { return m.toolMode }
 func (m mockOpenAIConfig) ToolcallEarlyEmitConfidence() string { return m.earlyEmit }
 func (m mockOpenAIConfig) ResponsesStoreTTLSeconds() int       { return m.responsesTTL }
 func (m mockOpenAIConfig) EmbeddingsProvider() string          { return m.embedProv }
+func (m mockOpenAIConfig) RuntimeMaxChoices() int              { return m.maxChoices }
+func (m mockOpenAIConfig) ReasoningEffortRule(effort string) (config.ReasoningRule, bool) {
+	return config.LookupReasoningEffort(nil, effort)
+}
 
 func TestNormalizeOpenAIChatRequestWithConfigInterface(t *testing.T) {
 	cfg := mockOpenAIConfig{
@@ -52,7 +63,7 @@ func TestNormalizeOpenAIResponsesRequestWideInputPolicyFromInterface(t *testing.
 	_, err := normalizeOpenAIResponsesRequest(mockOpenAIConfig{
 		aliases:   map[string]string{},
 		wideInput: false,
-	}, req)
+	}, req, nil)
 	if err == nil {
 		t.Fatal("expected error when wide input is disabled and only input is provided")
 	}
@@ -60,7 +71,7 @@ func TestNormalizeOpenAIResponsesRequestWideInputPolicyFromInterface(t *testing.
 	out, err := normalizeOpenAIResponsesRequest(mockOpenAIConfig{
 		aliases:   map[string]string{},
 		wideInput: true,
-	}, req)
+	}, req, nil)
 	if err != nil {
 		t.Fatalf("unexpected error when wide input is enabled: %v", err)
 	}
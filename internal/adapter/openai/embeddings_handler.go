@@ -8,23 +8,17 @@ import (
 	"net/http"
 	"strings"
 
-	"ds2api/internal/auth"
 	"ds2api/internal/config"
 	"ds2api/internal/util"
 )
 
 func (h *Handler) Embeddings(w http.ResponseWriter, r *http.Request) {
-	a, err := h.Auth.Determine(r)
+	_, release, err := h.determine(r)
 	if err != nil {
-		status := http.StatusUnauthorized
-		detail := err.Error()
-		if err == auth.ErrNoAccount {
-			status = http.StatusTooManyRequests
-		}
-		writeOpenAIError(w, status, detail)
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
 		return
 	}
-	defer h.Auth.Release(a)
+	defer release()
 
 	var req map[string]any
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -67,7 +61,7 @@ func (h *Handler) Embeddings(w http.ResponseWriter, r *http.Request) {
 	data := make([]map[string]any, 0, len(inputs))
 	totalTokens := 0
 	for i, input := range inputs {
-		totalTokens += util.EstimateTokens(input)
+		totalTokens += util.CountTokens(input)
 		data = append(data, map[string]any{
 			"object":    "embedding",
 			"index":     i,
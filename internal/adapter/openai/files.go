@@ -0,0 +1,387 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"ds2api/internal/batch"
+	"ds2api/internal/config"
+)
+
+const (
+	// maxFileBytes is the largest file that may be uploaded.
+	maxFileBytes = 200 << 20
+	// maxFilesListLimit is the largest page GET /v1/files returns.
+	maxFilesListLimit = 10000
+)
+
+var errFileNotFound = errors.New("file not found")
+
+// storedFile is an uploaded or generated file. With a directory its content
+// is saved as <id> and its metadata as <id>.json; otherwise the content is
+// kept in data.
+type storedFile struct {
+	ID        string `json:"id"`
+	Owner     string `json:"owner"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+
+	data []byte
+}
+
+func (f storedFile) object() map[string]any {
+	return map[string]any{
+		"id":             f.ID,
+		"object":         "file",
+		"bytes":          f.Bytes,
+		"created_at":     f.CreatedAt,
+		"filename":       f.Filename,
+		"purpose":        f.Purpose,
+		"status":         "processed",
+		"status_details": nil,
+	}
+}
+
+// fileStore holds the files of /v1/files, owned by caller.
+type fileStore struct {
+	mu    sync.Mutex
+	dir   string
+	files map[string]storedFile
+}
+
+func newFileStore(dir string) *fileStore {
+	st := &fileStore{dir: strings.TrimSpace(dir), files: map[string]storedFile{}}
+	if st.dir == "" {
+		return st
+	}
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			config.Logger.Warn("[openai_files] load failed", "dir", st.dir, "error", err)
+		}
+		return st
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(st.dir, e.Name()))
+		var f storedFile
+		if err == nil {
+			err = json.Unmarshal(raw, &f)
+		}
+		if err != nil || f.ID+".json" != e.Name() {
+			config.Logger.Warn("[openai_files] load failed", "file", e.Name(), "error", err)
+			continue
+		}
+		st.files[f.ID] = f
+	}
+	return st
+}
+
+// put stores the content read from src as a new file.
+func (st *fileStore) put(owner, filename, purpose string, src io.Reader) (storedFile, error) {
+	f := storedFile{
+		ID:        "file-" + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Owner:     owner,
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+	}
+	if st.dir == "" {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return storedFile{}, err
+		}
+		f.data, f.Bytes = data, int64(len(data))
+	} else {
+		n, err := st.writeContent(f.ID, src)
+		if err != nil {
+			return storedFile{}, err
+		}
+		f.Bytes = n
+		meta, _ := json.Marshal(f)
+		if err := writeFileAtomic(filepath.Join(st.dir, f.ID+".json"), meta); err != nil {
+			_ = os.Remove(filepath.Join(st.dir, f.ID))
+			return storedFile{}, err
+		}
+	}
+	st.mu.Lock()
+	st.files[f.ID] = f
+	st.mu.Unlock()
+	return f, nil
+}
+
+func (st *fileStore) writeContent(id string, src io.Reader) (int64, error) {
+	if err := os.MkdirAll(st.dir, 0o700); err != nil {
+		return 0, err
+	}
+	path := filepath.Join(st.dir, id)
+	out, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, src)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		_ = os.Remove(path + ".tmp")
+		return 0, err
+	}
+	return n, nil
+}
+
+func writeFileAtomic(path string, raw []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (st *fileStore) get(owner, id string) (storedFile, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	f, ok := st.files[id]
+	if !ok || f.Owner != owner {
+		return storedFile{}, false
+	}
+	return f, true
+}
+
+// open returns the content of a file.
+func (st *fileStore) open(owner, id string) (io.ReadCloser, error) {
+	f, ok := st.get(owner, id)
+	if !ok {
+		return nil, errFileNotFound
+	}
+	if st.dir == "" {
+		return io.NopCloser(bytes.NewReader(f.data)), nil
+	}
+	return os.Open(filepath.Join(st.dir, id))
+}
+
+// list returns owner's files with the given purpose ("" for any), newest
+// first.
+func (st *fileStore) list(owner, purpose string) []storedFile {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make([]storedFile, 0)
+	for _, f := range st.files {
+		if f.Owner == owner && (purpose == "" || f.Purpose == purpose) {
+			out = append(out, f)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt == out[j].CreatedAt {
+			return out[i].ID > out[j].ID
+		}
+		return out[i].CreatedAt > out[j].CreatedAt
+	})
+	return out
+}
+
+func (st *fileStore) remove(owner, id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	f, ok := st.files[id]
+	if !ok || f.Owner != owner {
+		return false
+	}
+	delete(st.files, id)
+	if st.dir != "" {
+		_ = os.Remove(filepath.Join(st.dir, id+".json"))
+		_ = os.Remove(filepath.Join(st.dir, id))
+	}
+	return true
+}
+
+// fileCaller resolves the caller of a file or batch route, answering the
+// request itself on failure.
+func (h *Handler) fileCaller(w http.ResponseWriter, r *http.Request) (string, *fileStore, *batch.Store[*openAIBatch], bool) {
+	a, err := h.Auth.DetermineCaller(r)
+	if err != nil {
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
+		return "", nil, nil, false
+	}
+	files, batches := h.getBatchStores()
+	if files == nil {
+		writeOpenAIError(w, http.StatusNotFound, "Files and batches are not enabled.")
+		return "", nil, nil, false
+	}
+	return a.CallerID, files, batches, true
+}
+
+func (h *Handler) UploadFile(w http.ResponseWriter, r *http.Request) {
+	owner, files, _, ok := h.fileCaller(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileBytes+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Request must be multipart/form-data with 'file' and 'purpose', at most 200 MB.")
+		return
+	}
+	defer func() { _ = r.MultipartForm.RemoveAll() }()
+	if purpose := r.FormValue("purpose"); purpose != "batch" {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for 'purpose': '%s'. Only 'batch' is supported.", purpose))
+		return
+	}
+	src, header, err := r.FormFile("file")
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "Request must include 'file'.")
+		return
+	}
+	defer src.Close()
+	if header.Size > maxFileBytes {
+		writeOpenAIError(w, http.StatusRequestEntityTooLarge, "File is larger than 200 MB.")
+		return
+	}
+	f, err := files.put(owner, filepath.Base(header.Filename), "batch", src)
+	if err != nil {
+		config.Logger.Warn("[openai_files] save failed", "error", err)
+		writeOpenAIError(w, http.StatusInternalServerError, "Failed to store file.")
+		return
+	}
+	writeJSON(w, http.StatusOK, f.object())
+}
+
+func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	owner, files, _, ok := h.fileCaller(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	limit := maxFilesListLimit
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxFilesListLimit {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("'limit' must be an integer between 1 and %d.", maxFilesListLimit))
+			return
+		}
+		limit = n
+	}
+	order := strings.TrimSpace(q.Get("order"))
+	if order == "" {
+		order = "desc"
+	}
+	if order != "asc" && order != "desc" {
+		writeOpenAIError(w, http.StatusBadRequest, "'order' must be 'asc' or 'desc'.")
+		return
+	}
+	list := files.list(owner, strings.TrimSpace(q.Get("purpose")))
+	if order == "asc" {
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+	data := make([]any, 0, len(list))
+	for _, f := range list {
+		data = append(data, f.object())
+	}
+	writeListPage(w, data, strings.TrimSpace(q.Get("after")), limit, "File")
+}
+
+// writeListPage writes the page of data after the item with id after as an
+// OpenAI list object.
+func writeListPage(w http.ResponseWriter, data []any, after string, limit int, kind string) {
+	id := func(item any) string {
+		m, _ := item.(map[string]any)
+		s, _ := m["id"].(string)
+		return s
+	}
+	start := 0
+	if after != "" {
+		start = -1
+		for i, item := range data {
+			if id(item) == after {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("%s '%s' not found.", kind, after))
+			return
+		}
+	}
+	end := start + limit
+	if end > len(data) {
+		end = len(data)
+	}
+	page := data[start:end]
+	var firstID, lastID any
+	if len(page) > 0 {
+		firstID, lastID = id(page[0]), id(page[len(page)-1])
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"object":   "list",
+		"data":     page,
+		"first_id": firstID,
+		"last_id":  lastID,
+		"has_more": end < len(data),
+	})
+}
+
+func (h *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
+	owner, files, _, ok := h.fileCaller(w, r)
+	if !ok {
+		return
+	}
+	f, ok := files.get(owner, chi.URLParam(r, "file_id"))
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "File not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, f.object())
+}
+
+func (h *Handler) GetFileContent(w http.ResponseWriter, r *http.Request) {
+	owner, files, _, ok := h.fileCaller(w, r)
+	if !ok {
+		return
+	}
+	rc, err := files.open(owner, chi.URLParam(r, "file_id"))
+	if err != nil {
+		writeOpenAIError(w, http.StatusNotFound, "File not found.")
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, rc)
+}
+
+func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	owner, files, _, ok := h.fileCaller(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "file_id")
+	if !files.remove(owner, id) {
+		writeOpenAIError(w, http.StatusNotFound, "File not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "object": "file", "deleted": true})
+}
@@ -1,7 +1,6 @@
 package openai
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +13,7 @@ import (
 	"github.com/google/uuid"
 
 	"ds2api/internal/auth"
+	"ds2api/internal/batch"
 	"ds2api/internal/config"
 	"ds2api/internal/deepseek"
 	openaifmt "ds2api/internal/format/openai"
@@ -37,6 +37,26 @@ type Handler struct {
 	leaseStats   streamLeaseStats
 	responsesMu  sync.Mutex
 	responses    *responseStore
+	batchMu      sync.Mutex
+	files        *fileStore
+	batches      *batch.Store[*openAIBatch]
+}
+
+// batchLeaseKey carries the auth a batch worker leased for one batch line.
+type batchLeaseKey struct{}
+
+// determine resolves the auth of a request and how to release it. A batch
+// line arrives with the lease its worker holds; the handler borrows it and
+// leaves releasing it to the worker.
+func (h *Handler) determine(r *http.Request) (*auth.RequestAuth, func(), error) {
+	if a, ok := r.Context().Value(batchLeaseKey{}).(*auth.RequestAuth); ok {
+		return a, func() {}, nil
+	}
+	a, err := h.Auth.Determine(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, func() { h.Auth.Release(a) }, nil
 }
 
 type streamLease struct {
@@ -48,9 +68,23 @@ func RegisterRoutes(r chi.Router, h *Handler) {
 	r.Get("/v1/models", h.ListModels)
 	r.Get("/v1/models/{model_id}", h.GetModel)
 	r.Post("/v1/chat/completions", h.ChatCompletions)
+	r.Post("/v1/completions", h.Completions)
 	r.Post("/v1/responses", h.Responses)
 	r.Get("/v1/responses/{response_id}", h.GetResponseByID)
+	r.Delete("/v1/responses/{response_id}", h.DeleteResponse)
+	r.Post("/v1/responses/{response_id}/cancel", h.CancelResponse)
+	r.Get("/v1/responses/{response_id}/input_items", h.ListResponseInputItems)
 	r.Post("/v1/embeddings", h.Embeddings)
+	r.Post("/v1/files", h.UploadFile)
+	r.Get("/v1/files", h.ListFiles)
+	r.Get("/v1/files/{file_id}", h.GetFile)
+	r.Delete("/v1/files/{file_id}", h.DeleteFile)
+	r.Get("/v1/files/{file_id}/content", h.GetFileContent)
+	r.Post("/v1/batches", h.CreateBatch)
+	r.Get("/v1/batches", h.ListBatches)
+	r.Get("/v1/batches/{batch_id}", h.GetBatch)
+	r.Post("/v1/batches/{batch_id}/cancel", h.CancelBatch)
+	r.Post("/v1/tokenize", h.Tokenize)
 }
 
 func (h *Handler) ListModels(w http.ResponseWriter, _ *http.Request) {
@@ -77,17 +111,12 @@ func (h *Handler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a, err := h.Auth.Determine(r)
+	a, release, err := h.determine(r)
 	if err != nil {
-		status := http.StatusUnauthorized
-		detail := err.Error()
-		if err == auth.ErrNoAccount {
-			status = http.StatusTooManyRequests
-		}
-		writeOpenAIError(w, status, detail)
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
 		return
 	}
-	defer h.Auth.Release(a)
+	defer release()
 	r = r.WithContext(auth.WithAuth(r.Context(), a))
 
 	var req map[string]any
@@ -100,51 +129,60 @@ func (h *Handler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		writeOpenAIError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	sessionID, err := h.DS.CreateSession(r.Context(), a, 3)
-	if err != nil {
-		if a.UseConfigToken {
-			writeOpenAIError(w, http.StatusUnauthorized, "Account token is invalid. Please re-login the account in admin.")
-		} else {
-			writeOpenAIError(w, http.StatusUnauthorized, "Invalid token. If this should be a DS2API key, add it to config.keys first.")
-		}
-		return
-	}
-	pow, err := h.DS.GetPow(r.Context(), a, 3)
-	if err != nil {
-		writeOpenAIError(w, http.StatusUnauthorized, "Failed to get PoW (invalid token or unknown error).")
+	stdReq.SearchSources = stdReq.Search && wantSearchSources(req, a)
+	if stdReq.N > 1 {
+		h.handleMultiChoice(w, r, a, stdReq)
 		return
 	}
-	payload := stdReq.CompletionPayload(sessionID)
-	resp, err := h.DS.CallCompletion(r.Context(), a, payload, pow, 3)
-	if err != nil {
-		writeOpenAIError(w, http.StatusInternalServerError, "Failed to get completion.")
+
+	resp, callErr := h.startChoice(r.Context(), a, stdReq)
+	if callErr != nil {
+		writeOpenAIError(w, callErr.status, callErr.message)
 		return
 	}
+	completionID := newChatCompletionID()
+	finalizeText := h.outputFinalizer(r.Context(), a, stdReq)
 	if stdReq.Stream {
-		h.handleStream(w, r, resp, sessionID, stdReq.ResponseModel, stdReq.FinalPrompt, stdReq.Thinking, stdReq.Search, stdReq.ToolNames)
+		h.handleStream(w, r, resp, completionID, stdReq, finalizeText)
 		return
 	}
-	h.handleNonStream(w, r.Context(), resp, sessionID, stdReq.ResponseModel, stdReq.FinalPrompt, stdReq.Thinking, stdReq.ToolNames)
+	h.handleNonStream(w, resp, completionID, stdReq, finalizeText)
 }
 
-func (h *Handler) handleNonStream(w http.ResponseWriter, ctx context.Context, resp *http.Response, completionID, model, finalPrompt string, thinkingEnabled bool, toolNames []string) {
+// handleNonStream answers a single-choice request. finalizeText, when set,
+// rewrites the whole reply (response_format, required tool calls) before
+// its tool calls are read.
+func (h *Handler) handleNonStream(w http.ResponseWriter, resp *http.Response, completionID string, stdReq util.StandardRequest, finalizeText textFinalizer) {
 	if resp.StatusCode != http.StatusOK {
 		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
 		writeOpenAIError(w, resp.StatusCode, string(body))
 		return
 	}
-	_ = ctx
-	result := sse.CollectStream(resp, thinkingEnabled, true)
+	limiter := util.NewOutputLimiter(stdReq.Limits)
+	result := sse.CollectStreamLimited(resp, stdReq.Thinking, true, limiter)
 
 	finalThinking := result.Thinking
-	finalText := result.Text
-	respBody := openaifmt.BuildChatCompletion(completionID, model, finalPrompt, finalThinking, finalText, toolNames)
+	finalText, usage := result.Text, result.Usage
+	if finalizeText != nil {
+		var err error
+		if finalText, err = finalizeText(finalText, &usage); err != nil {
+			writeOpenAIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+	}
+	detected := detectToolCalls(finalText, stdReq.ToolNames, stdReq.ToolPolicy, finalizeText != nil)
+	respBody := openaifmt.BuildChatCompletionWithToolCalls(completionID, stdReq.ResponseModel, stdReq.FinalPrompt, finalThinking, finalText, detected, chatFinishReason(limiter))
+	respBody["usage"] = openaifmt.BuildChatUsageWithUpstream(stdReq.FinalPrompt, finalThinking, finalText, usage.Delivered(limiter.Done() || finalText != result.Text))
+	if stdReq.SearchSources {
+		respBody["sources"] = openaifmt.BuildChatSources(&result.Search)
+	}
 	writeJSON(w, http.StatusOK, respBody)
 }
 
-func (h *Handler) handleStream(w http.ResponseWriter, r *http.Request, resp *http.Response, completionID, model, finalPrompt string, thinkingEnabled, searchEnabled bool, toolNames []string) {
+// handleStream streams a single-choice request; finalizeText is as for
+// handleNonStream and holds the content back until the reply is complete.
+func (h *Handler) handleStream(w http.ResponseWriter, r *http.Request, resp *http.Response, completionID string, stdReq util.StandardRequest, finalizeText textFinalizer) {
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
@@ -162,10 +200,10 @@ func (h *Handler) handleStream(w http.ResponseWriter, r *http.Request, resp *htt
 	}
 
 	created := time.Now().Unix()
-	bufferToolContent := len(toolNames) > 0 && h.toolcallFeatureMatchEnabled()
+	bufferToolContent := len(stdReq.ToolNames) > 0 && h.toolcallFeatureMatchEnabled()
 	emitEarlyToolDeltas := h.toolcallEarlyEmitHighConfidence()
 	initialType := "text"
-	if thinkingEnabled {
+	if stdReq.Thinking {
 		initialType = "thinking"
 	}
 
@@ -175,19 +213,25 @@ func (h *Handler) handleStream(w http.ResponseWriter, r *http.Request, resp *htt
 		canFlush,
 		completionID,
 		created,
-		model,
-		finalPrompt,
-		thinkingEnabled,
-		searchEnabled,
-		toolNames,
+		stdReq.ResponseModel,
+		stdReq.FinalPrompt,
+		stdReq.Thinking,
+		stdReq.Search,
+		stdReq.ToolNames,
 		bufferToolContent,
 		emitEarlyToolDeltas,
 	)
+	streamRuntime.toolPolicy = stdReq.ToolPolicy
+	streamRuntime.finalizeText = finalizeText
+	streamRuntime.limiter = util.NewOutputLimiter(stdReq.Limits)
+	if stdReq.SearchSources {
+		streamRuntime.sources = &util.SearchSources{}
+	}
 
 	streamengine.ConsumeSSE(streamengine.ConsumeConfig{
 		Context:             r.Context(),
 		Body:                resp.Body,
-		ThinkingEnabled:     thinkingEnabled,
+		ThinkingEnabled:     stdReq.Thinking,
 		InitialType:         initialType,
 		KeepAliveInterval:   time.Duration(deepseek.KeepAliveTimeout) * time.Second,
 		IdleTimeout:         time.Duration(deepseek.StreamIdleTimeout) * time.Second,
@@ -207,7 +251,7 @@ func (h *Handler) handleStream(w http.ResponseWriter, r *http.Request, resp *htt
 	})
 }
 
-func injectToolPrompt(messages []map[string]any, tools []any) ([]map[string]any, []string) {
+func injectToolPrompt(messages []map[string]any, tools []any, policy util.ToolPolicy) ([]map[string]any, []string) {
 	toolSchemas := make([]string, 0, len(tools))
 	names := make([]string, 0, len(tools))
 	for _, t := range tools {
@@ -225,6 +269,9 @@ func injectToolPrompt(messages []map[string]any, tools []any) ([]map[string]any,
 		if name == "" {
 			name = "unknown"
 		}
+		if !policy.Offers(name) {
+			continue
+		}
 		names = append(names, name)
 		if desc == "" {
 			desc = "No description available"
@@ -236,6 +283,9 @@ func injectToolPrompt(messages []map[string]any, tools []any) ([]map[string]any,
 		return messages, names
 	}
 	toolPrompt := "You have access to these tools:\n\n" + strings.Join(toolSchemas, "\n\n") + "\n\nWhen you need to use tools, output ONLY this JSON format (no other text):\n{\"tool_calls\": [{\"name\": \"tool_name\", \"input\": {\"param\": \"value\"}}]}\n\nIMPORTANT:\n1) If calling tools, output ONLY the JSON. The response must start with { and end with }.\n2) After receiving a tool result, you MUST use it to produce the final answer.\n3) Only call another tool when the previous result is missing required data or returned an error."
+	for i, rule := range policy.PromptRules() {
+		toolPrompt += fmt.Sprintf("\n%d) %s", i+4, rule)
+	}
 
 	for i := range messages {
 		if messages[i]["role"] == "system" {
@@ -283,14 +333,30 @@ func formatIncrementalStreamToolCallDeltas(deltas []toolCallDelta, ids map[int]s
 }
 
 func writeOpenAIError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, map[string]any{
+	writeJSON(w, status, openAIErrorBody(status, message))
+}
+
+// openAIAuthStatus is the status for an auth failure: a busy pool is 429,
+// a caller outside its key policy 403, anything else 401.
+func openAIAuthStatus(err error) int {
+	switch err {
+	case auth.ErrNoAccount:
+		return http.StatusTooManyRequests
+	case auth.ErrClientNotAllowed:
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+func openAIErrorBody(status int, message string) map[string]any {
+	return map[string]any{
 		"error": map[string]any{
 			"message": message,
 			"type":    openAIErrorType(status),
 			"code":    openAIErrorCode(status),
 			"param":   nil,
 		},
-	})
+	}
 }
 
 func openAIErrorType(status int) string {
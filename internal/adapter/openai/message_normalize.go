@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+
+	"ds2api/internal/prompt"
 )
 
 func normalizeOpenAIMessagesForPrompt(raw []any) []map[string]any {
@@ -86,7 +88,7 @@ func formatAssistantToolCallsForPrompt(msg map[string]any) string {
 			if args == "" {
 				args = "{}"
 			}
-			entries = append(entries, fmt.Sprintf("Tool call:\n- tool_call_id: %s\n- function.name: %s\n- function.arguments: %s", id, name, args))
+			entries = append(entries, prompt.FormatToolCall(id, name, args))
 		}
 	}
 
@@ -99,7 +101,7 @@ func formatAssistantToolCallsForPrompt(msg map[string]any) string {
 		if args == "" {
 			args = "{}"
 		}
-		entries = append(entries, fmt.Sprintf("Tool call:\n- tool_call_id: call_legacy\n- function.name: %s\n- function.arguments: %s", name, args))
+		entries = append(entries, prompt.FormatToolCall("call_legacy", name, args))
 	}
 
 	return strings.Join(entries, "\n\n")
@@ -133,7 +135,7 @@ func formatToolResultForPrompt(msg map[string]any) string {
 		content = "null"
 	}
 
-	return fmt.Sprintf("Tool result:\n- tool_call_id: %s\n- name: %s\n- content: %s", toolCallID, name, content)
+	return prompt.FormatToolResult(toolCallID, name, content, false)
 }
 
 func isEmptyPromptContent(s string) bool {
@@ -0,0 +1,42 @@
+package openai
+
+import "ds2api/internal/util"
+
+// parseOpenAIOutputLimits reads `stop` and the first present token limit
+// field. The web API ignores both, so they are enforced on the output.
+func parseOpenAIOutputLimits(req map[string]any, tokenFields ...string) (util.OutputLimits, error) {
+	stop, err := util.ParseStopSequences(req["stop"], "stop")
+	if err != nil {
+		return util.OutputLimits{}, err
+	}
+	limits := util.OutputLimits{Stop: stop}
+	for _, field := range tokenFields {
+		if req[field] == nil {
+			continue
+		}
+		if limits.MaxTokens, err = util.ParseMaxTokens(req[field], field); err != nil {
+			return util.OutputLimits{}, err
+		}
+		break
+	}
+	return limits, nil
+}
+
+// chatFinishReason is the finish_reason of a choice that made no tool call:
+// "length" when the token limit cut it, otherwise "stop".
+func chatFinishReason(limiter *util.OutputLimiter) string {
+	if limiter.Reason() == util.OutputLimitMaxTokens {
+		return "length"
+	}
+	return "stop"
+}
+
+// markResponseIncomplete flags a Responses object whose output hit
+// max_output_tokens, as the Responses API reports truncation.
+func markResponseIncomplete(obj map[string]any, limiter *util.OutputLimiter) {
+	if limiter.Reason() != util.OutputLimitMaxTokens {
+		return
+	}
+	obj["status"] = "incomplete"
+	obj["incomplete_details"] = map[string]any{"reason": "max_output_tokens"}
+}
@@ -0,0 +1,109 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ds2api/internal/util"
+)
+
+func TestNormalizeOpenAIChatRequestOutputLimits(t *testing.T) {
+	cfg := mockOpenAIConfig{aliases: map[string]string{}}
+	req := map[string]any{
+		"model":                 "deepseek-chat",
+		"messages":              []any{map[string]any{"role": "user", "content": "hi"}},
+		"stop":                  "###",
+		"max_tokens":            100.0,
+		"max_completion_tokens": 20.0,
+	}
+	out, err := normalizeOpenAIChatRequest(cfg, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.Limits.MaxTokens != 20 || len(out.Limits.Stop) != 1 || out.Limits.Stop[0] != "###" {
+		t.Fatalf("unexpected limits: %#v", out.Limits)
+	}
+	for _, k := range []string{"stop", "max_tokens", "max_completion_tokens"} {
+		if _, ok := out.PassThrough[k]; ok {
+			t.Fatalf("expected %s to be enforced locally, not passed upstream", k)
+		}
+	}
+
+	req["stop"] = []any{"a", 1.0}
+	if _, err := normalizeOpenAIChatRequest(cfg, req); err == nil {
+		t.Fatal("expected non-string stop entry to be rejected")
+	}
+}
+
+func TestHandleStreamMaxTokensAbortsWithLength(t *testing.T) {
+	h := &Handler{}
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = io.WriteString(pw, "data: {\"p\":\"response/content\",\"v\":\"abcdefgh\"}\n")
+		_, _ = io.WriteString(pw, "data: {\"p\":\"response/content\",\"v\":\"ijklmnop\"}\n")
+		// The upstream never finishes; only the token limit ends the stream.
+	}()
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: pr}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	h.handleStream(rec, req, resp, "cid-limit", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt", Limits: util.OutputLimits{MaxTokens: 3}}, nil)
+	_ = pw.Close()
+
+	frames, done := parseSSEDataFrames(t, rec.Body.String())
+	if !done {
+		t.Fatalf("expected [DONE], body=%s", rec.Body.String())
+	}
+	content := strings.Builder{}
+	for _, frame := range frames {
+		for _, item := range frame["choices"].([]any) {
+			delta, _ := item.(map[string]any)["delta"].(map[string]any)
+			c, _ := delta["content"].(string)
+			content.WriteString(c)
+		}
+	}
+	if content.String() != "abcdefghijklmno" {
+		t.Fatalf("unexpected truncated content %q", content.String())
+	}
+	if streamFinishReason(frames) != "length" {
+		t.Fatalf("expected finish_reason length, body=%s", rec.Body.String())
+	}
+}
+
+func TestHandleNonStreamCutsAtStopSequence(t *testing.T) {
+	h := &Handler{}
+	resp := makeSSEHTTPResponse(
+		`data: {"p":"response/content","v":"Answer: 4\nObserv"}`,
+		`data: {"p":"response/content","v":"ation: done"}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	h.handleNonStream(rec, resp, "cid-stop", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt", Limits: util.OutputLimits{Stop: []string{"\nObservation"}}}, nil)
+
+	choice := decodeJSONBody(t, rec.Body.String())["choices"].([]any)[0].(map[string]any)
+	if choice["finish_reason"] != "stop" || choice["message"].(map[string]any)["content"] != "Answer: 4" {
+		t.Fatalf("unexpected choice: %#v", choice)
+	}
+}
+
+func TestHandleResponsesNonStreamMaxOutputTokensIncomplete(t *testing.T) {
+	h := &Handler{}
+	resp := makeSSEHTTPResponse(
+		`data: {"p":"response/content","v":"a long long answer"}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	h.handleResponsesNonStream(rec, resp, responsesTurn{owner: "owner-a"}, "resp_limit", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt", Limits: util.OutputLimits{MaxTokens: 2}}, nil)
+
+	obj := decodeJSONBody(t, rec.Body.String())
+	details, _ := obj["incomplete_details"].(map[string]any)
+	if obj["status"] != "incomplete" || details["reason"] != "max_output_tokens" {
+		t.Fatalf("expected incomplete response, got %#v", obj)
+	}
+	if obj["output_text"] != "a long long" {
+		t.Fatalf("unexpected output_text %q", obj["output_text"])
+	}
+}
@@ -2,13 +2,17 @@ package openai
 
 import (
 	"ds2api/internal/deepseek"
+	"ds2api/internal/util"
 )
 
-func buildOpenAIFinalPrompt(messagesRaw []any, toolsRaw any) (string, []string) {
+func buildOpenAIFinalPrompt(messagesRaw []any, toolsRaw any, format *util.ResponseFormat, policy util.ToolPolicy) (string, []string) {
 	messages := normalizeOpenAIMessagesForPrompt(messagesRaw)
 	toolNames := []string{}
-	if tools, ok := toolsRaw.([]any); ok && len(tools) > 0 {
-		messages, toolNames = injectToolPrompt(messages, tools)
+	if tools, ok := toolsRaw.([]any); ok && len(tools) > 0 && !policy.Disabled() {
+		messages, toolNames = injectToolPrompt(messages, tools, policy)
+	}
+	if format != nil {
+		messages = injectResponseFormatPrompt(messages, format)
 	}
 	return deepseek.MessagesPrepare(messages), toolNames
 }
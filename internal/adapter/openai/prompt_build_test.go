@@ -3,6 +3,8 @@ package openai
 import (
 	"strings"
 	"testing"
+
+	"ds2api/internal/util"
 )
 
 func TestBuildOpenAIFinalPrompt_HandlerPathIncludesToolRoundtripSemantics(t *testing.T) {
@@ -40,7 +42,7 @@ func TestBuildOpenAIFinalPrompt_HandlerPathIncludesToolRoundtripSemantics(t *tes
 		},
 	}
 
-	finalPrompt, toolNames := buildOpenAIFinalPrompt(messages, tools)
+	finalPrompt, toolNames := buildOpenAIFinalPrompt(messages, tools, nil, util.ToolPolicy{})
 	if len(toolNames) != 1 || toolNames[0] != "get_weather" {
 		t.Fatalf("unexpected tool names: %#v", toolNames)
 	}
@@ -70,7 +72,7 @@ func TestBuildOpenAIFinalPrompt_VercelPreparePathKeepsFinalAnswerInstruction(t *
 		},
 	}
 
-	finalPrompt, _ := buildOpenAIFinalPrompt(messages, tools)
+	finalPrompt, _ := buildOpenAIFinalPrompt(messages, tools, nil, util.ToolPolicy{})
 	if !strings.Contains(finalPrompt, "After receiving a tool result, you MUST use it to produce the final answer.") {
 		t.Fatalf("vercel prepare finalPrompt missing final-answer instruction: %q", finalPrompt)
 	}
@@ -0,0 +1,42 @@
+package openai
+
+import (
+	"fmt"
+	"strings"
+
+	"ds2api/internal/config"
+)
+
+// applyReasoningEffort overrides the thinking and search toggles the model
+// implies with the rule of the effort the request names in field (OpenAI
+// reasoning_effort or Responses reasoning.effort). An absent effort leaves
+// them unchanged.
+func applyReasoningEffort(store ConfigReader, effort any, field string, thinking, search bool) (bool, bool, error) {
+	if effort == nil {
+		return thinking, search, nil
+	}
+	name, ok := effort.(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		return false, false, fmt.Errorf("'%s' must be a non-empty string.", field)
+	}
+	var reader config.ReasoningRuleReader
+	if store != nil {
+		reader = store
+	}
+	rule, ok := config.LookupReasoningEffort(reader, name)
+	if !ok {
+		return false, false, fmt.Errorf("'%s' value '%s' is not supported.", field, name)
+	}
+	thinking, search = rule.Apply(thinking, search)
+	return thinking, search, nil
+}
+
+// responsesReasoningEffort returns the effort of a Responses reasoning
+// object, or nil when none was sent.
+func responsesReasoningEffort(raw any) any {
+	reasoning, ok := raw.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return reasoning["effort"]
+}
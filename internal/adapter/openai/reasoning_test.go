@@ -0,0 +1,73 @@
+package openai
+
+import (
+	"testing"
+
+	"ds2api/internal/config"
+)
+
+func TestNormalizeChatReasoningEffortOverridesModelThinking(t *testing.T) {
+	cases := []struct {
+		model    string
+		effort   string
+		thinking bool
+	}{
+		{"deepseek-chat", "high", true},
+		{"deepseek-reasoner", "none", false},
+		{"o3", "minimal", false},
+		{"gpt-4o", "LOW", true},
+	}
+	for _, tc := range cases {
+		req := map[string]any{
+			"model":            tc.model,
+			"messages":         []any{map[string]any{"role": "user", "content": "hi"}},
+			"reasoning_effort": tc.effort,
+		}
+		out, err := normalizeOpenAIChatRequest(mockOpenAIConfig{}, req)
+		if err != nil {
+			t.Fatalf("%s/%s: normalize failed: %v", tc.model, tc.effort, err)
+		}
+		if out.Thinking != tc.thinking {
+			t.Fatalf("%s/%s: thinking=%v want %v", tc.model, tc.effort, out.Thinking, tc.thinking)
+		}
+		if out.ResponseModel != tc.model {
+			t.Fatalf("%s/%s: response model changed to %q", tc.model, tc.effort, out.ResponseModel)
+		}
+	}
+}
+
+func TestNormalizeChatReasoningEffortRejectsUnknown(t *testing.T) {
+	for _, effort := range []any{"extreme", 3, ""} {
+		req := map[string]any{
+			"model":            "deepseek-chat",
+			"messages":         []any{map[string]any{"role": "user", "content": "hi"}},
+			"reasoning_effort": effort,
+		}
+		if _, err := normalizeOpenAIChatRequest(mockOpenAIConfig{}, req); err == nil {
+			t.Fatalf("expected error for reasoning_effort %#v", effort)
+		}
+	}
+}
+
+func TestNormalizeResponsesReasoningEffortUsesConfiguredRules(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{"reasoning":{"efforts":{"high":{"thinking":true,"search":true},"turbo":{"thinking":false}}}}`)
+	store := config.LoadStore()
+
+	req := map[string]any{"model": "deepseek-chat", "input": "hi", "reasoning": map[string]any{"effort": "high", "summary": "auto"}}
+	out, err := normalizeOpenAIResponsesRequest(store, req, nil)
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if !out.Thinking || !out.Search {
+		t.Fatalf("expected configured high rule to enable thinking and search, got thinking=%v search=%v", out.Thinking, out.Search)
+	}
+
+	req = map[string]any{"model": "deepseek-reasoner-search", "input": "hi", "reasoning": map[string]any{"effort": "turbo"}}
+	out, err = normalizeOpenAIResponsesRequest(store, req, nil)
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if out.Thinking || !out.Search {
+		t.Fatalf("expected custom effort to only turn thinking off, got thinking=%v search=%v", out.Thinking, out.Search)
+	}
+}
@@ -0,0 +1,72 @@
+package openai
+
+import "sync"
+
+// responseEvent is one stream event of a background response.
+type responseEvent struct {
+	seq     int
+	name    string
+	payload map[string]any
+}
+
+// responseEventLog records the stream events of a background response so
+// clients can attach to it, or resume, with starting_after. Sequence numbers
+// start at 0.
+type responseEventLog struct {
+	mu     sync.Mutex
+	events []responseEvent
+	done   bool
+	// wake is closed and replaced whenever the log changes.
+	wake chan struct{}
+}
+
+func newResponseEventLog() *responseEventLog {
+	return &responseEventLog{wake: make(chan struct{})}
+}
+
+// append records an event, stamping its payload with the sequence number.
+func (l *responseEventLog) append(name string, payload map[string]any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return
+	}
+	seq := len(l.events)
+	stamped := cloneAnyMap(payload)
+	stamped["sequence_number"] = seq
+	l.events = append(l.events, responseEvent{seq: seq, name: name, payload: stamped})
+	l.wakeLocked()
+}
+
+// finish marks the log complete; later appends are dropped.
+func (l *responseEventLog) finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return
+	}
+	l.done = true
+	l.wakeLocked()
+}
+
+func (l *responseEventLog) wakeLocked() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// since returns the events after sequence number after, whether the log is
+// complete, and a channel closed on the next change.
+func (l *responseEventLog) since(after int) ([]responseEvent, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := after + 1
+	if start < 0 {
+		start = 0
+	}
+	if start > len(l.events) {
+		start = len(l.events)
+	}
+	out := make([]responseEvent, len(l.events)-start)
+	copy(out, l.events[start:])
+	return out, l.done, l.wake
+}
@@ -0,0 +1,162 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/deepseek"
+	"ds2api/internal/sse"
+	"ds2api/internal/util"
+)
+
+// parseChatResponseFormat reads the Chat Completions `response_format` field.
+func parseChatResponseFormat(raw any) (*util.ResponseFormat, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("'response_format' must be an object.")
+	}
+	typ, _ := m["type"].(string)
+	switch strings.TrimSpace(typ) {
+	case "", "text":
+		return nil, nil
+	case util.ResponseFormatJSONObject:
+		return &util.ResponseFormat{Type: util.ResponseFormatJSONObject}, nil
+	case util.ResponseFormatJSONSchema:
+		spec, _ := m["json_schema"].(map[string]any)
+		if spec == nil {
+			return nil, fmt.Errorf("'response_format.json_schema' is required when type is json_schema.")
+		}
+		return jsonSchemaFormat(spec, "response_format.json_schema")
+	default:
+		return nil, fmt.Errorf("Unsupported response_format type '%s'.", typ)
+	}
+}
+
+// parseResponsesTextFormat reads the Responses API `text.format` field, which
+// carries the schema fields inline instead of under `json_schema`.
+func parseResponsesTextFormat(rawText any) (*util.ResponseFormat, error) {
+	text, _ := rawText.(map[string]any)
+	if text == nil || text["format"] == nil {
+		return nil, nil
+	}
+	m, ok := text["format"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("'text.format' must be an object.")
+	}
+	typ, _ := m["type"].(string)
+	switch strings.TrimSpace(typ) {
+	case "", "text":
+		return nil, nil
+	case util.ResponseFormatJSONObject:
+		return &util.ResponseFormat{Type: util.ResponseFormatJSONObject}, nil
+	case util.ResponseFormatJSONSchema:
+		return jsonSchemaFormat(m, "text.format")
+	default:
+		return nil, fmt.Errorf("Unsupported text.format type '%s'.", typ)
+	}
+}
+
+func jsonSchemaFormat(spec map[string]any, field string) (*util.ResponseFormat, error) {
+	schema, ok := spec["schema"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("'%s.schema' must be a JSON Schema object.", field)
+	}
+	name, _ := spec["name"].(string)
+	return &util.ResponseFormat{
+		Type:   util.ResponseFormatJSONSchema,
+		Name:   strings.TrimSpace(name),
+		Schema: schema,
+		Strict: util.ToBool(spec["strict"]),
+	}, nil
+}
+
+func injectResponseFormatPrompt(messages []map[string]any, format *util.ResponseFormat) []map[string]any {
+	instruction := format.Instruction()
+	if instruction == "" {
+		return messages
+	}
+	for i := range messages {
+		if messages[i]["role"] == "system" {
+			old, _ := messages[i]["content"].(string)
+			messages[i]["content"] = strings.TrimSpace(old + "\n\n" + instruction)
+			return messages
+		}
+	}
+	return append([]map[string]any{{"role": "system", "content": instruction}}, messages...)
+}
+
+// textFinalizer turns the final model text into the reply; the cost of any
+// follow-up call it makes is added to usage.
+type textFinalizer func(text string, usage *util.UpstreamUsage) (string, error)
+
+// outputFinalizer returns the hook that turns final model text into the
+// reply the request asked for, or nil when the text can be used as is. A
+// missing required tool call is retried first; conforming structured output
+// is enforced on anything that is not a tool call.
+func (h *Handler) outputFinalizer(ctx context.Context, a *auth.RequestAuth, stdReq util.StandardRequest) textFinalizer {
+	needsCall := stdReq.ToolPolicy.RequiresCall()
+	if stdReq.ResponseFormat == nil && !needsCall {
+		return nil
+	}
+	return func(text string, usage *util.UpstreamUsage) (string, error) {
+		if needsCall && len(detectToolCalls(text, stdReq.ToolNames, stdReq.ToolPolicy, false)) == 0 {
+			text = h.retryForToolCall(ctx, a, stdReq, text, usage)
+		}
+		if stdReq.ResponseFormat == nil || formatOutputIsToolCall(text, stdReq.ToolNames) {
+			return text, nil
+		}
+		return h.enforceResponseFormat(ctx, a, stdReq, text, usage)
+	}
+}
+
+// formatOutputIsToolCall reports whether output of a response_format request
+// is a tool call rather than the structured answer. Without declared tools
+// any JSON is the answer itself, even one shaped like a tool call.
+func formatOutputIsToolCall(text string, toolNames []string) bool {
+	return len(toolNames) > 0 && len(util.ParseToolCalls(text, toolNames)) > 0
+}
+
+// enforceResponseFormat validates text against the requested format. Invalid
+// output is sent back to the model once for correction when repair is on.
+// Strict json_schema requests fail if the result still does not validate;
+// otherwise the original text is returned as a best effort. The repair call
+// is held to the request's output limits and counted into usage.
+func (h *Handler) enforceResponseFormat(ctx context.Context, a *auth.RequestAuth, stdReq util.StandardRequest, text string, usage *util.UpstreamUsage) (string, error) {
+	format := stdReq.ResponseFormat
+	out, err := format.Check(text)
+	if err == nil {
+		return out, nil
+	}
+	if h.responseFormatRepairEnabled() {
+		repairReq := stdReq
+		repairReq.FinalPrompt = stdReq.FinalPrompt + deepseek.MessagesPrepare([]map[string]any{
+			{"role": "assistant", "content": text},
+			{"role": "user", "content": fmt.Sprintf("Your previous reply was rejected: %v. Reply again with only the corrected JSON.", err)},
+		})
+		if resp, callErr := h.startChoice(ctx, a, repairReq); callErr == nil {
+			result := sse.CollectStreamLimited(resp, stdReq.Thinking, true, util.NewOutputLimiter(stdReq.Limits))
+			out, err = format.Check(result.Text)
+			if err == nil {
+				usage.AddRetry(repairReq.FinalPrompt, result.Thinking, text)
+				return out, nil
+			}
+			usage.AddRetry(repairReq.FinalPrompt, result.Thinking, result.Text)
+		}
+	}
+	if format.Strict {
+		return "", fmt.Errorf("model output does not match response_format: %v", err)
+	}
+	return text, nil
+}
+
+func (h *Handler) responseFormatRepairEnabled() bool {
+	if h == nil || h.Store == nil {
+		return true
+	}
+	return h.Store.CompatResponseFormatRepair()
+}
@@ -0,0 +1,139 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"ds2api/internal/auth"
+)
+
+// scriptedDS replies to the i-th completion with replies[i] and records the
+// prompts it was sent.
+type scriptedDS struct {
+	mu      sync.Mutex
+	replies []string
+	prompts []string
+}
+
+func (d *scriptedDS) CreateSession(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "session", nil
+}
+
+func (d *scriptedDS) GetPow(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "pow", nil
+}
+
+func (d *scriptedDS) CallCompletion(_ context.Context, _ *auth.RequestAuth, payload map[string]any, _ string, _ int) (*http.Response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prompt, _ := payload["prompt"].(string)
+	d.prompts = append(d.prompts, prompt)
+	reply := d.replies[len(d.prompts)-1]
+	b, _ := json.Marshal(map[string]any{"p": "response/content", "v": reply})
+	return makeSSEHTTPResponse("data: "+string(b), "data: [DONE]"), nil
+}
+
+const personSchemaRequest = `{
+	"model":"deepseek-chat",
+	"messages":[{"role":"user","content":"describe a person"}],
+	"response_format":{"type":"json_schema","json_schema":{"name":"person","strict":true,
+		"schema":{"type":"object","properties":{"name":{"type":"string"}},"required":["name"],"additionalProperties":false}}}
+}`
+
+func TestChatResponseFormatExtractsJSONAndInjectsInstruction(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &scriptedDS{replies: []string{"Sure!\n```json\n{\"name\":\"Ada\"}\n```"}}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	rec := postChatChoices(t, h, personSchemaRequest)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(ds.prompts[0], `JSON Schema named "person"`) {
+		t.Fatalf("expected schema instruction in prompt, got %q", ds.prompts[0])
+	}
+	out := decodeJSONBody(t, rec.Body.String())
+	choice := out["choices"].([]any)[0].(map[string]any)
+	msg := choice["message"].(map[string]any)
+	if msg["content"] != `{"name":"Ada"}` {
+		t.Fatalf("expected extracted JSON content, got %#v", msg["content"])
+	}
+}
+
+func TestChatResponseFormatRepairsOnceThenFailsStrict(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &scriptedDS{replies: []string{`{"nom":"Ada"}`, `{"name":"Ada"}`}}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	rec := postChatChoices(t, h, personSchemaRequest)
+	if rec.Code != http.StatusOK || len(ds.prompts) != 2 {
+		t.Fatalf("expected a repaired reply after one retry, status=%d calls=%d", rec.Code, len(ds.prompts))
+	}
+	if !strings.Contains(ds.prompts[1], "missing required property") {
+		t.Fatalf("expected validation error in repair prompt, got %q", ds.prompts[1])
+	}
+
+	h.DS = &scriptedDS{replies: []string{`not json`, `still not json`}}
+	rec = postChatChoices(t, h, personSchemaRequest)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected strict failure status 502, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestChatResponseFormatStreamHoldsContentUntilValidated(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &scriptedDS{replies: []string{"```json\n{\"name\":\"Ada\"}\n```"}}}
+
+	body := strings.Replace(personSchemaRequest, `"model":"deepseek-chat",`, `"model":"deepseek-chat","stream":true,`, 1)
+	rec := postChatChoices(t, h, body)
+	frames, done := parseSSEDataFrames(t, rec.Body.String())
+	if !done {
+		t.Fatalf("expected [DONE], body=%s", rec.Body.String())
+	}
+	var content strings.Builder
+	for _, frame := range frames {
+		for _, item := range frame["choices"].([]any) {
+			delta, _ := item.(map[string]any)["delta"].(map[string]any)
+			if c, ok := delta["content"].(string); ok {
+				content.WriteString(c)
+			}
+		}
+	}
+	if content.String() != `{"name":"Ada"}` {
+		t.Fatalf("expected only validated JSON to be streamed, got %q", content.String())
+	}
+}
+
+func TestResponsesTextFormatParsing(t *testing.T) {
+	cfg := mockOpenAIConfig{aliases: map[string]string{}, wideInput: true}
+	req := map[string]any{
+		"model": "deepseek-chat",
+		"input": "hi",
+		"text": map[string]any{"format": map[string]any{
+			"type":   "json_schema",
+			"name":   "answer",
+			"strict": true,
+			"schema": map[string]any{"type": "object"},
+		}},
+	}
+	out, err := normalizeOpenAIResponsesRequest(cfg, req, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.ResponseFormat == nil || out.ResponseFormat.Name != "answer" || !out.ResponseFormat.Strict {
+		t.Fatalf("unexpected response format: %#v", out.ResponseFormat)
+	}
+
+	req["text"] = map[string]any{"format": map[string]any{"type": "json_schema", "name": "answer"}}
+	if _, err := normalizeOpenAIResponsesRequest(cfg, req, nil); err == nil {
+		t.Fatal("expected missing schema to be rejected")
+	}
+	req["text"] = map[string]any{"format": map[string]any{"type": "xml"}}
+	if _, err := normalizeOpenAIResponsesRequest(cfg, req, nil); err == nil {
+		t.Fatal("expected unsupported format type to be rejected")
+	}
+}
@@ -1,16 +1,46 @@
 package openai
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"sync"
 	"time"
 
 	"ds2api/internal/auth"
 )
 
+var (
+	errResponseCancelled = errors.New("response cancelled")
+	errResponseDeleted   = errors.New("response deleted")
+	errResponseExpired   = errors.New("response ran too long")
+)
+
+// maxResponseRunTime is how long a response may stay queued or in_progress
+// before the sweep fails it.
+const maxResponseRunTime = time.Hour
+
 type storedResponse struct {
-	Owner     string
-	Value     map[string]any
+	Owner string
+	Value map[string]any
+	// History is the conversation the response continued from and Input the
+	// messages of its own turn; together with Value's output they let a later
+	// request chain on via previous_response_id.
+	History []any
+	Input   []any
+	// ExpiresAt counts from when the response was stored or last reached a
+	// terminal status; unfinished responses expire maxResponseRunTime after
+	// StartedAt instead.
 	ExpiresAt time.Time
+	// StartedAt is when the response was first stored.
+	StartedAt time.Time
+	// cancel aborts the upstream call of an in_progress response with
+	// errResponseCancelled, errResponseDeleted or errResponseExpired; nil
+	// once it has finished.
+	cancel context.CancelCauseFunc
+	// events is the event log of a background response; kept once it
+	// finishes so clients can still replay it.
+	events *responseEventLog
 }
 
 type responseStore struct {
@@ -41,46 +71,251 @@ func responseStoreOwner(a *auth.RequestAuth) string {
 }
 
 func (s *responseStore) put(owner, id string, value map[string]any) {
-	if s == nil || owner == "" || id == "" || value == nil {
+	s.putTurn(owner, id, value, nil, nil)
+}
+
+// putTurn stores a response together with the conversation that produced it.
+func (s *responseStore) putTurn(owner, id string, value map[string]any, history, input []any) {
+	s.save(owner, id, storedResponse{Value: value, History: history, Input: input})
+}
+
+// start records an unfinished response; cancel aborts its upstream call and
+// events, for background responses, is where its stream events go. The
+// entry is replaced by putTurn once the response finishes.
+func (s *responseStore) start(owner, id string, value map[string]any, history, input []any, cancel context.CancelCauseFunc, events *responseEventLog) {
+	s.save(owner, id, storedResponse{Value: value, History: history, Input: input, cancel: cancel, events: events})
+}
+
+// setStatus updates the status of an unfinished response.
+func (s *responseStore) setStatus(owner, id, status string) {
+	if s == nil {
 		return
 	}
-	now := time.Now()
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.sweepLocked(now)
-	s.items[responseStoreKey(owner, id)] = storedResponse{
-		Owner:     owner,
-		Value:     cloneAnyMap(value),
-		ExpiresAt: now.Add(s.ttl),
+	item, ok := s.lookupLocked(owner, id)
+	if !ok || item.cancel == nil {
+		return
 	}
+	item.Value = cloneAnyMap(item.Value)
+	item.Value["status"] = status
+	s.items[responseStoreKey(owner, id)] = item
 }
 
-func (s *responseStore) get(owner, id string) (map[string]any, bool) {
+// eventLog returns the event log of a response; nil when the response was
+// not run in the background.
+func (s *responseStore) eventLog(owner, id string) (*responseEventLog, bool) {
 	if s == nil || owner == "" || id == "" {
 		return nil, false
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.lookupLocked(owner, id)
+	if !ok {
+		return nil, false
+	}
+	return item.events, true
+}
+
+func (s *responseStore) save(owner, id string, item storedResponse) {
+	if s == nil || owner == "" || id == "" || item.Value == nil {
+		return
+	}
 	now := time.Now()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.sweepLocked(now)
+	item.Owner = owner
+	item.Value = cloneAnyMap(item.Value)
+	item.ExpiresAt = now.Add(s.ttl)
+	item.StartedAt = now
+	key := responseStoreKey(owner, id)
+	if prev, ok := s.items[key]; ok {
+		item.StartedAt = prev.StartedAt
+		if item.events == nil {
+			item.events = prev.events
+		}
+	}
+	s.items[key] = item
+}
+
+// dropInProgress removes a response that never finished, e.g. because its
+// upstream call failed before any output was produced.
+func (s *responseStore) dropInProgress(owner, id string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.lookupLocked(owner, id); ok && item.cancel != nil {
+		delete(s.items, responseStoreKey(owner, id))
+	}
+}
+
+// lookupLocked returns the live entry for owner and id.
+func (s *responseStore) lookupLocked(owner, id string) (storedResponse, bool) {
+	s.sweepLocked(time.Now())
 	item, ok := s.items[responseStoreKey(owner, id)]
-	if !ok {
+	if !ok || item.Owner != owner {
+		return storedResponse{}, false
+	}
+	return item, true
+}
+
+func (s *responseStore) get(owner, id string) (map[string]any, bool) {
+	if s == nil || owner == "" || id == "" {
 		return nil, false
 	}
-	if item.Owner != owner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.lookupLocked(owner, id)
+	if !ok {
 		return nil, false
 	}
 	return cloneAnyMap(item.Value), true
 }
 
+// cancel aborts an in_progress response and marks it cancelled. cancelled
+// is false when the response had already finished; found is false when it
+// is unknown to owner.
+func (s *responseStore) cancel(owner, id string) (value map[string]any, found, cancelled bool) {
+	if s == nil || owner == "" || id == "" {
+		return nil, false, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.lookupLocked(owner, id)
+	if !ok {
+		return nil, false, false
+	}
+	if item.cancel != nil {
+		item.cancel(errResponseCancelled)
+		item.cancel = nil
+		item.Value = cloneAnyMap(item.Value)
+		item.Value["status"] = "cancelled"
+		item.ExpiresAt = time.Now().Add(s.ttl)
+		s.items[responseStoreKey(owner, id)] = item
+	}
+	return cloneAnyMap(item.Value), true, item.Value["status"] == "cancelled"
+}
+
+// delete removes a response, aborting it first when still in progress.
+func (s *responseStore) delete(owner, id string) bool {
+	if s == nil || owner == "" || id == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.lookupLocked(owner, id)
+	if !ok {
+		return false
+	}
+	if item.cancel != nil {
+		item.cancel(errResponseDeleted)
+	}
+	delete(s.items, responseStoreKey(owner, id))
+	return true
+}
+
+// inputItems returns the input items of a response's own turn.
+func (s *responseStore) inputItems(owner, id string) ([]any, bool) {
+	if s == nil || owner == "" || id == "" {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.lookupLocked(owner, id)
+	if !ok {
+		return nil, false
+	}
+	return item.Input, true
+}
+
+// conversation returns the full message list of a stored response: the
+// history it continued from, its input and its output as assistant messages.
+func (s *responseStore) conversation(owner, id string) ([]any, bool) {
+	if s == nil || owner == "" || id == "" {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.lookupLocked(owner, id)
+	if !ok {
+		return nil, false
+	}
+	out := make([]any, 0, len(item.History)+len(item.Input)+1)
+	out = append(out, item.History...)
+	out = append(out, normalizeResponsesInputItems(item.Input)...)
+	out = append(out, responseOutputMessages(item.Value)...)
+	return out, true
+}
+
+// sweepLocked drops expired responses. A response still running is kept,
+// so GET, cancel and stream resume keep finding it, until it has run for
+// maxResponseRunTime: then its upstream call is aborted and it is failed
+// and expires like any finished response.
 func (s *responseStore) sweepLocked(now time.Time) {
 	for k, v := range s.items {
+		if v.cancel != nil || v.Value["status"] == "queued" || v.Value["status"] == "in_progress" {
+			if now.Sub(v.StartedAt) > maxResponseRunTime {
+				s.items[k] = s.expireLocked(v, now)
+			}
+			continue
+		}
 		if now.After(v.ExpiresAt) {
 			delete(s.items, k)
 		}
 	}
 }
 
+// expireLocked fails a response that ran too long and ends its event log.
+func (s *responseStore) expireLocked(item storedResponse, now time.Time) storedResponse {
+	if item.cancel != nil {
+		item.cancel(errResponseExpired)
+		item.cancel = nil
+	}
+	item.Value = cloneAnyMap(item.Value)
+	item.Value["status"] = "failed"
+	item.Value["error"] = map[string]any{"code": "server_error", "message": "The response did not finish in time."}
+	item.ExpiresAt = now.Add(s.ttl)
+	if item.events != nil {
+		item.events.append("response.failed", map[string]any{"type": "response.failed", "response": item.Value})
+		item.events.finish()
+	}
+	return item
+}
+
+// responseOutputMessages turns the output items of a response object back
+// into assistant chat messages; reasoning items are dropped.
+func responseOutputMessages(obj map[string]any) []any {
+	items, _ := obj["output"].([]any)
+	out := make([]any, 0, len(items))
+	for _, raw := range items {
+		item, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch item["type"] {
+		case "message":
+			parts, _ := item["content"].([]any)
+			texts := make([]string, 0, len(parts))
+			for _, p := range parts {
+				if part, ok := p.(map[string]any); ok && part["type"] == "output_text" {
+					if text, _ := part["text"].(string); text != "" {
+						texts = append(texts, text)
+					}
+				}
+			}
+			if len(texts) > 0 {
+				out = append(out, map[string]any{"role": "assistant", "content": strings.Join(texts, "")})
+			}
+		case "function_call":
+			out = appendResponsesFunctionCall(out, item)
+		}
+	}
+	return out
+}
+
 func cloneAnyMap(in map[string]any) map[string]any {
 	if in == nil {
 		return nil
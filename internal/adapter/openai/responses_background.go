@@ -0,0 +1,156 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/config"
+	"ds2api/internal/deepseek"
+	openaifmt "ds2api/internal/format/openai"
+	"ds2api/internal/util"
+)
+
+// startBackgroundResponse queues a background response and hands the
+// account lease to a worker that runs it independently of this request.
+// The client gets the queued object, or with stream=true is attached to
+// the response's event stream.
+func (h *Handler) startBackgroundResponse(w http.ResponseWriter, r *http.Request, a *auth.RequestAuth, stdReq util.StandardRequest, turn responsesTurn) {
+	responseID := newResponseID()
+	ctx, cancel := context.WithCancelCause(auth.WithAuth(context.Background(), a))
+	turn.cause = func() error { return context.Cause(ctx) }
+	events := newResponseEventLog()
+	queued := turn.stamp(openaifmt.BuildResponsePendingObject(responseID, stdReq.ResponseModel, "queued"))
+	h.getResponseStore().start(turn.owner, responseID, queued, turn.history, turn.input, cancel, events)
+	events.append("response.created", map[string]any{"type": "response.created", "response": queued})
+
+	go h.runBackgroundResponse(ctx, cancel, a, stdReq, turn, responseID, events)
+
+	if stdReq.Stream {
+		streamResponseEvents(w, r, events, -1)
+		return
+	}
+	writeJSON(w, http.StatusOK, queued)
+}
+
+// runBackgroundResponse runs a queued response to completion, recording
+// its events in the log and persisting the result. It owns the lease on a.
+func (h *Handler) runBackgroundResponse(ctx context.Context, cancel context.CancelCauseFunc, a *auth.RequestAuth, stdReq util.StandardRequest, turn responsesTurn, responseID string, events *responseEventLog) {
+	defer h.Auth.Release(a)
+	defer cancel(nil)
+	defer events.finish()
+	st := h.getResponseStore()
+	defer st.dropInProgress(turn.owner, responseID)
+	defer func() {
+		// Nothing waits on a background response, so a panic must not leave
+		// it in_progress: it fails like an upstream error.
+		if p := recover(); p != nil {
+			config.Logger.Error("[responses] background response panicked", "response", responseID, "panic", p)
+			obj := turn.stamp(openaifmt.BuildResponsePendingObject(responseID, stdReq.ResponseModel, "failed"))
+			obj["error"] = map[string]any{"code": "server_error", "message": "The response failed unexpectedly."}
+			turn.persist(st, responseID, obj)
+			events.append("response.failed", map[string]any{"type": "response.failed", "response": obj})
+		}
+	}()
+
+	st.setStatus(turn.owner, responseID, "in_progress")
+	inProgress := turn.stamp(openaifmt.BuildResponsePendingObject(responseID, stdReq.ResponseModel, "in_progress"))
+	events.append("response.in_progress", map[string]any{"type": "response.in_progress", "response": inProgress})
+
+	resp, callErr := h.startChoice(ctx, a, stdReq)
+	if callErr != nil {
+		obj := turn.stamp(openaifmt.BuildResponsePendingObject(responseID, stdReq.ResponseModel, "failed"))
+		if turn.cancelled() {
+			turn.persist(st, responseID, obj)
+			events.append("response.cancelled", map[string]any{"type": "response.cancelled", "response": obj})
+			return
+		}
+		obj["error"] = map[string]any{"code": "upstream_error", "message": callErr.message}
+		turn.persist(st, responseID, obj)
+		events.append("response.failed", map[string]any{"type": "response.failed", "response": obj})
+		return
+	}
+	defer resp.Body.Close()
+
+	streamRuntime := h.newResponsesRuntime(nil, nil, false, turn, responseID, stdReq, h.outputFinalizer(ctx, a, stdReq))
+	streamRuntime.events = events
+	consumeResponsesStream(ctx, resp.Body, streamRuntime, turn)
+}
+
+// streamStoredResponse serves GET /v1/responses/{id}?stream=true: the
+// events of a background response after starting_after, then live ones
+// until it finishes.
+func (h *Handler) streamStoredResponse(w http.ResponseWriter, r *http.Request, owner, id string) {
+	after := -1
+	if raw := strings.TrimSpace(r.URL.Query().Get("starting_after")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeOpenAIError(w, http.StatusBadRequest, "'starting_after' must be a non-negative integer.")
+			return
+		}
+		after = n
+	}
+	events, ok := h.getResponseStore().eventLog(owner, id)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "Response not found.")
+		return
+	}
+	if events == nil {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Response '%s' was not created with background=true and cannot be streamed.", id))
+		return
+	}
+	streamResponseEvents(w, r, events, after)
+}
+
+// streamResponseEvents writes the events of a log after sequence number
+// after as SSE, following the log until it completes or the client leaves.
+// Leaving does not affect the response itself.
+func streamResponseEvents(w http.ResponseWriter, r *http.Request, events *responseEventLog, after int) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache, no-transform")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	canFlush := rc.Flush() == nil
+
+	keepAlive := time.NewTicker(time.Duration(deepseek.KeepAliveTimeout) * time.Second)
+	defer keepAlive.Stop()
+	for {
+		batch, done, wake := events.since(after)
+		for _, evt := range batch {
+			b, _ := json.Marshal(evt.payload)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.name, b); err != nil {
+				return
+			}
+			after = evt.seq
+		}
+		if done {
+			if _, err := io.WriteString(w, "data: [DONE]\n\n"); err != nil {
+				return
+			}
+		}
+		if canFlush {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+		if done {
+			return
+		}
+		select {
+		case <-wake:
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package openai
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ds2api/internal/auth"
+)
+
+// gatedDS holds the completion call until gate is closed.
+type gatedDS struct {
+	gate chan struct{}
+}
+
+func (d *gatedDS) CreateSession(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "session", nil
+}
+
+func (d *gatedDS) GetPow(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "pow", nil
+}
+
+func (d *gatedDS) CallCompletion(ctx context.Context, _ *auth.RequestAuth, _ map[string]any, _ string, _ int) (*http.Response, error) {
+	select {
+	case <-d.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return makeSSEHTTPResponse(
+		`data: {"p":"response/content","v":"background answer"}`,
+		`data: [DONE]`,
+	), nil
+}
+
+// panicDS panics in the completion call.
+type panicDS struct{ gatedDS }
+
+func (*panicDS) CallCompletion(context.Context, *auth.RequestAuth, map[string]any, string, int) (*http.Response, error) {
+	panic("upstream client bug")
+}
+
+// countingAuth counts lease releases of the wrapped resolver.
+type countingAuth struct {
+	AuthResolver
+	released atomic.Int32
+}
+
+func (c *countingAuth) Release(a *auth.RequestAuth) {
+	c.released.Add(1)
+	c.AuthResolver.Release(a)
+}
+
+func waitResponseStatus(t *testing.T, h *Handler, owner, id, status string) map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if obj, ok := h.getResponseStore().get(owner, id); ok && obj["status"] == status {
+			return obj
+		}
+		time.Sleep(time.Millisecond)
+	}
+	obj, _ := h.getResponseStore().get(owner, id)
+	t.Fatalf("response %s never reached %q: %#v", id, status, obj)
+	return nil
+}
+
+func TestBackgroundResponseReturnsQueuedAndPersistsResult(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &gatedDS{gate: make(chan struct{})}
+	counting := &countingAuth{AuthResolver: resolver}
+	h := &Handler{Store: store, Auth: counting, DS: ds}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	owner := responseStoreOwner(authForToken(t, resolver, "token-a"))
+
+	rec := serveResponses(r, http.MethodPost, "/v1/responses", `{"model":"deepseek-chat","input":"hi","background":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", rec.Code, rec.Body.String())
+	}
+	queued := decodeJSONBody(t, rec.Body.String())
+	if queued["status"] != "queued" || queued["background"] != true {
+		t.Fatalf("expected a queued background response: %#v", queued)
+	}
+	if n := counting.released.Load(); n != 0 {
+		t.Fatalf("expected the worker to hold the lease, got %d releases", n)
+	}
+	id, _ := queued["id"].(string)
+
+	close(ds.gate)
+	done := waitResponseStatus(t, h, owner, id, "completed")
+	if done["output_text"] != "background answer" || done["background"] != true {
+		t.Fatalf("unexpected persisted response: %#v", done)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for counting.released.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := counting.released.Load(); n != 1 {
+		t.Fatalf("expected the worker to release the lease once, got %d", n)
+	}
+
+	replay := serveResponses(r, http.MethodGet, "/v1/responses/"+id+"?stream=true&starting_after=0", "")
+	body := replay.Body.String()
+	if strings.Contains(body, "event: response.created") {
+		t.Fatalf("expected events after sequence 0 only: %s", body)
+	}
+	frames, finished := parseSSEDataFrames(t, body)
+	if !finished || len(frames) == 0 {
+		t.Fatalf("expected replayed events and [DONE]: %s", body)
+	}
+	if frames[0]["sequence_number"] != float64(1) || frames[len(frames)-1]["type"] != "response.completed" {
+		t.Fatalf("unexpected replay: %#v", frames)
+	}
+}
+
+func TestBackgroundResponseStreamAttachesLive(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &gatedDS{gate: make(chan struct{})}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+
+	done := make(chan string)
+	go func() {
+		done <- serveResponses(r, http.MethodPost, "/v1/responses", `{"model":"deepseek-chat","input":"hi","background":true,"stream":true}`).Body.String()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(ds.gate)
+
+	var body string
+	select {
+	case body = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not finish")
+	}
+	frames, finished := parseSSEDataFrames(t, body)
+	if !finished || len(frames) < 3 {
+		t.Fatalf("expected a full event stream: %s", body)
+	}
+	created, _ := frames[0]["response"].(map[string]any)
+	if frames[0]["type"] != "response.created" || created["status"] != "queued" {
+		t.Fatalf("expected a queued response.created first: %#v", frames[0])
+	}
+	if !strings.Contains(body, "background answer") || frames[len(frames)-1]["type"] != "response.completed" {
+		t.Fatalf("unexpected stream: %s", body)
+	}
+}
+
+func TestBackgroundResponseCancel(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := newHangingDS()
+	counting := &countingAuth{AuthResolver: resolver}
+	h := &Handler{Store: store, Auth: counting, DS: ds}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	owner := responseStoreOwner(authForToken(t, resolver, "token-a"))
+
+	rec := serveResponses(r, http.MethodPost, "/v1/responses", `{"model":"deepseek-chat","input":"hi","background":true}`)
+	id, _ := decodeJSONBody(t, rec.Body.String())["id"].(string)
+	<-ds.streaming
+
+	if rec := serveResponses(r, http.MethodPost, "/v1/responses/"+id+"/cancel", ""); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected cancel status %d body=%s", rec.Code, rec.Body.String())
+	}
+	select {
+	case <-ds.aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream call was not aborted")
+	}
+	waitResponseStatus(t, h, owner, id, "cancelled")
+	replay := serveResponses(r, http.MethodGet, "/v1/responses/"+id+"?stream=true", "")
+	if !strings.Contains(replay.Body.String(), "event: response.cancelled") {
+		t.Fatalf("expected response.cancelled in the event log: %s", replay.Body.String())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for counting.released.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := counting.released.Load(); n != 1 {
+		t.Fatalf("expected the lease to be released, got %d", n)
+	}
+}
+
+func TestStreamingForegroundResponseIsRejected(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	owner := responseStoreOwner(authForToken(t, resolver, "token-a"))
+	h.getResponseStore().put(owner, "resp_fg", map[string]any{"id": "resp_fg", "status": "completed"})
+
+	if rec := serveResponses(r, http.MethodGet, "/v1/responses/resp_fg?stream=true", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBackgroundResponsePanicFailsIt(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	counting := &countingAuth{AuthResolver: resolver}
+	h := &Handler{Store: store, Auth: counting, DS: &panicDS{}}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	owner := responseStoreOwner(authForToken(t, resolver, "token-a"))
+
+	rec := serveResponses(r, http.MethodPost, "/v1/responses", `{"model":"deepseek-chat","input":"hi","background":true}`)
+	id, _ := decodeJSONBody(t, rec.Body.String())["id"].(string)
+	failed := waitResponseStatus(t, h, owner, id, "failed")
+	if errObj, _ := failed["error"].(map[string]any); errObj["code"] != "server_error" {
+		t.Fatalf("unexpected failed response %#v", failed)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for counting.released.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := counting.released.Load(); n != 1 {
+		t.Fatalf("expected the lease released once, got %d", n)
+	}
+	frames, finished := parseSSEDataFrames(t, serveResponses(r, http.MethodGet, "/v1/responses/"+id+"?stream=true", "").Body.String())
+	if !finished || frames[len(frames)-1]["type"] != "response.failed" {
+		t.Fatalf("expected the event log to end with response.failed: %#v", frames)
+	}
+}
@@ -0,0 +1,162 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func postResponses(t *testing.T, h *Handler, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.Responses(rec, req)
+	return rec
+}
+
+func TestResponsesPreviousResponseIDChainsConversation(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &promptDS{}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	first := postResponses(t, h, "token-a", `{"model":"deepseek-chat","instructions":"Be terse.","input":"first question"}`)
+	if first.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", first.Code, first.Body.String())
+	}
+	firstID, _ := decodeJSONBody(t, first.Body.String())["id"].(string)
+
+	second := postResponses(t, h, "token-a", `{"model":"deepseek-chat","previous_response_id":"`+firstID+`","input":"second question"}`)
+	if second.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", second.Code, second.Body.String())
+	}
+	secondObj := decodeJSONBody(t, second.Body.String())
+	if secondObj["previous_response_id"] != firstID {
+		t.Fatalf("expected previous_response_id on the response: %#v", secondObj)
+	}
+	prompt := ds.prompts[1]
+	for _, want := range []string{"first question", "world. STOP here", "second question"} {
+		if !strings.Contains(prompt, want) {
+			t.Fatalf("expected %q in chained prompt: %q", want, prompt)
+		}
+	}
+	if strings.Index(prompt, "first question") > strings.Index(prompt, "second question") {
+		t.Fatalf("expected history before the new input: %q", prompt)
+	}
+	if strings.Contains(prompt, "Be terse.") {
+		t.Fatalf("expected instructions not to carry over: %q", prompt)
+	}
+
+	secondID, _ := secondObj["id"].(string)
+	third := postResponses(t, h, "token-a", `{"model":"deepseek-chat","previous_response_id":"`+secondID+`","input":"third question"}`)
+	if third.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", third.Code, third.Body.String())
+	}
+	if prompt := ds.prompts[2]; !strings.Contains(prompt, "first question") || !strings.Contains(prompt, "second question") {
+		t.Fatalf("expected the whole chain in the prompt: %q", prompt)
+	}
+}
+
+func TestResponsesPreviousResponseIDIsOwnerIsolated(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &promptDS{}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	first := postResponses(t, h, "token-a", `{"model":"deepseek-chat","input":"secret"}`)
+	firstID, _ := decodeJSONBody(t, first.Body.String())["id"].(string)
+
+	rec := postResponses(t, h, "token-b", `{"model":"deepseek-chat","previous_response_id":"`+firstID+`","input":"next"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for another caller, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if len(ds.prompts) != 1 {
+		t.Fatalf("expected no upstream call, got %d", len(ds.prompts))
+	}
+}
+
+func TestResponsesPreviousResponseIDExpired(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &promptDS{}}
+	h.responses = newResponseStore(time.Millisecond)
+	owner := responseStoreOwner(authForToken(t, resolver, "token-a"))
+	h.responses.put(owner, "resp_old", map[string]any{"id": "resp_old", "object": "response"})
+	time.Sleep(5 * time.Millisecond)
+
+	rec := postResponses(t, h, "token-a", `{"model":"deepseek-chat","previous_response_id":"resp_old","input":"next"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "resp_old") {
+		t.Fatalf("expected the id in the error: %s", rec.Body.String())
+	}
+}
+
+func TestResponseOutputMessagesKeepsToolCalls(t *testing.T) {
+	msgs := responseOutputMessages(map[string]any{
+		"output": []any{
+			map[string]any{"type": "reasoning", "summary": []any{}},
+			map[string]any{"type": "function_call", "call_id": "call_1", "name": "read_file", "arguments": `{"path":"a"}`},
+			map[string]any{"type": "function_call", "call_id": "call_2", "name": "read_file", "arguments": `{"path":"b"}`},
+		},
+	})
+	if len(msgs) != 1 {
+		t.Fatalf("expected one message, got %#v", msgs)
+	}
+	if calls, _ := msgs[0].(map[string]any)["tool_calls"].([]any); len(calls) != 2 {
+		t.Fatalf("expected both calls in one assistant message: %#v", msgs[0])
+	}
+	prompt := normalizeOpenAIMessagesForPrompt(msgs)
+	if len(prompt) != 1 || !strings.Contains(prompt[0]["content"].(string), "read_file") {
+		t.Fatalf("expected tool call in prompt history: %#v", prompt)
+	}
+}
+
+func TestNormalizeResponsesInputFunctionCallOutput(t *testing.T) {
+	msgs := normalizeResponsesInputAsMessages([]any{
+		map[string]any{"role": "user", "content": "read it"},
+		map[string]any{"type": "reasoning", "summary": []any{}},
+		map[string]any{"type": "function_call", "call_id": "call_1", "name": "read_file", "arguments": `{"path":"a"}`},
+		map[string]any{"type": "function_call_output", "call_id": "call_1", "output": "file body"},
+	})
+	if len(msgs) != 3 {
+		t.Fatalf("expected user, assistant and tool messages, got %#v", msgs)
+	}
+	tool, _ := msgs[2].(map[string]any)
+	if tool["role"] != "tool" || tool["tool_call_id"] != "call_1" || tool["name"] != "read_file" || tool["content"] != "file body" {
+		t.Fatalf("unexpected tool message: %#v", tool)
+	}
+	prompt := normalizeOpenAIMessagesForPrompt(msgs)
+	joined := ""
+	for _, m := range prompt {
+		joined += m["content"].(string) + "\n"
+	}
+	if !strings.Contains(joined, "call_1") || !strings.Contains(joined, "file body") {
+		t.Fatalf("expected the call and its output in the prompt: %#v", prompt)
+	}
+}
+
+func TestResponsesInputItemsKeepFunctionCallOutputShape(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &promptDS{}}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+
+	rec := serveResponses(r, http.MethodPost, "/v1/responses", `{"model":"deepseek-chat","input":[{"type":"function_call","call_id":"call_1","name":"read_file","arguments":"{}"},{"type":"function_call_output","call_id":"call_1","output":"ok"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", rec.Code, rec.Body.String())
+	}
+	id, _ := decodeJSONBody(t, rec.Body.String())["id"].(string)
+	list := decodeJSONBody(t, serveResponses(r, http.MethodGet, "/v1/responses/"+id+"/input_items?order=asc", "").Body.String())
+	data, _ := list["data"].([]any)
+	if len(data) != 2 {
+		t.Fatalf("expected two input items, got %#v", list)
+	}
+	output, _ := data[1].(map[string]any)
+	if output["type"] != "function_call_output" || !strings.HasPrefix(output["id"].(string), "fco_") {
+		t.Fatalf("unexpected input item: %#v", output)
+	}
+}
@@ -22,7 +22,11 @@ func TestResponsesMessagesFromRequestWithInstructions(t *testing.T) {
 		"input":        "ping",
 		"instructions": "system text",
 	}
-	msgs := responsesMessagesFromRequest(req)
+	out, err := normalizeOpenAIResponsesRequest(mockOpenAIConfig{wideInput: true}, req, nil)
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	msgs := out.Messages
 	if len(msgs) != 2 {
 		t.Fatalf("expected two messages, got %d", len(msgs))
 	}
@@ -1,10 +1,13 @@
 package openai
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,23 +19,16 @@ import (
 	openaifmt "ds2api/internal/format/openai"
 	"ds2api/internal/sse"
 	streamengine "ds2api/internal/stream"
+	"ds2api/internal/util"
 )
 
 func (h *Handler) GetResponseByID(w http.ResponseWriter, r *http.Request) {
-	a, err := h.Auth.DetermineCaller(r)
-	if err != nil {
-		writeOpenAIError(w, http.StatusUnauthorized, err.Error())
+	owner, id, ok := h.responseCaller(w, r)
+	if !ok {
 		return
 	}
-
-	id := strings.TrimSpace(chi.URLParam(r, "response_id"))
-	if id == "" {
-		writeOpenAIError(w, http.StatusBadRequest, "response_id is required.")
-		return
-	}
-	owner := responseStoreOwner(a)
-	if owner == "" {
-		writeOpenAIError(w, http.StatusUnauthorized, "unauthorized")
+	if stream, _ := strconv.ParseBool(r.URL.Query().Get("stream")); stream {
+		h.streamStoredResponse(w, r, owner, id)
 		return
 	}
 	st := h.getResponseStore()
@@ -44,18 +40,41 @@ func (h *Handler) GetResponseByID(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, item)
 }
 
+// responseCaller resolves the store owner and response_id of a request on a
+// stored response, writing the error itself when either is missing.
+func (h *Handler) responseCaller(w http.ResponseWriter, r *http.Request) (owner, id string, ok bool) {
+	a, err := h.Auth.DetermineCaller(r)
+	if err != nil {
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
+		return "", "", false
+	}
+
+	id = strings.TrimSpace(chi.URLParam(r, "response_id"))
+	if id == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "response_id is required.")
+		return "", "", false
+	}
+	owner = responseStoreOwner(a)
+	if owner == "" {
+		writeOpenAIError(w, http.StatusUnauthorized, "unauthorized")
+		return "", "", false
+	}
+	return owner, id, true
+}
+
 func (h *Handler) Responses(w http.ResponseWriter, r *http.Request) {
-	a, err := h.Auth.Determine(r)
+	a, release, err := h.determine(r)
 	if err != nil {
-		status := http.StatusUnauthorized
-		detail := err.Error()
-		if err == auth.ErrNoAccount {
-			status = http.StatusTooManyRequests
-		}
-		writeOpenAIError(w, status, detail)
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
 		return
 	}
-	defer h.Auth.Release(a)
+	// A background response takes the lease over to its worker.
+	leased := true
+	defer func() {
+		if leased {
+			release()
+		}
+	}()
 	r = r.WithContext(auth.WithAuth(r.Context(), a))
 	owner := responseStoreOwner(a)
 	if owner == "" {
@@ -68,55 +87,170 @@ func (h *Handler) Responses(w http.ResponseWriter, r *http.Request) {
 		writeOpenAIError(w, http.StatusBadRequest, "invalid json")
 		return
 	}
-	stdReq, err := normalizeOpenAIResponsesRequest(h.Store, req)
+	turn := responsesTurn{owner: owner, background: util.ToBool(req["background"])}
+	if prev, _ := req["previous_response_id"].(string); strings.TrimSpace(prev) != "" {
+		turn.previousID = strings.TrimSpace(prev)
+		history, ok := h.getResponseStore().conversation(owner, turn.previousID)
+		if !ok {
+			writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("Previous response '%s' not found or expired.", turn.previousID))
+			return
+		}
+		turn.history = history
+	}
+	stdReq, err := normalizeOpenAIResponsesRequest(h.Store, req, turn.history)
 	if err != nil {
 		writeOpenAIError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	sessionID, err := h.DS.CreateSession(r.Context(), a, 3)
-	if err != nil {
-		if a.UseConfigToken {
-			writeOpenAIError(w, http.StatusUnauthorized, "Account token is invalid. Please re-login the account in admin.")
-		} else {
-			writeOpenAIError(w, http.StatusUnauthorized, "Invalid token. If this should be a DS2API key, add it to config.keys first.")
-		}
+	turn.input = responsesInputItems(responsesTurnItems(h.Store, req))
+	if turn.background {
+		leased = false
+		h.startBackgroundResponse(w, r, a, stdReq, turn)
 		return
 	}
-	pow, err := h.DS.GetPow(r.Context(), a, 3)
-	if err != nil {
-		writeOpenAIError(w, http.StatusUnauthorized, "Failed to get PoW (invalid token or unknown error).")
+
+	// The response is tracked as in_progress until it finishes so it can be
+	// fetched, cancelled or deleted meanwhile; cancelling aborts the
+	// upstream call, which also releases the account.
+	responseID := newResponseID()
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	r = r.WithContext(ctx)
+	turn.cause = func() error { return context.Cause(ctx) }
+	st := h.getResponseStore()
+	st.start(owner, responseID, turn.stamp(openaifmt.BuildResponsePendingObject(responseID, stdReq.ResponseModel, "in_progress")), turn.history, turn.input, cancel, nil)
+	defer st.dropInProgress(owner, responseID)
+
+	resp, callErr := h.startChoice(r.Context(), a, stdReq)
+	if callErr != nil {
+		writeOpenAIError(w, callErr.status, callErr.message)
 		return
 	}
-	payload := stdReq.CompletionPayload(sessionID)
-	resp, err := h.DS.CallCompletion(r.Context(), a, payload, pow, 3)
-	if err != nil {
-		writeOpenAIError(w, http.StatusInternalServerError, "Failed to get completion.")
+
+	finalizeText := h.outputFinalizer(r.Context(), a, stdReq)
+	if stdReq.Stream {
+		h.handleResponsesStream(w, r, resp, turn, responseID, stdReq, finalizeText)
 		return
 	}
+	h.handleResponsesNonStream(w, resp, turn, responseID, stdReq, finalizeText)
+}
 
-	responseID := "resp_" + strings.ReplaceAll(uuid.NewString(), "-", "")
-	if stdReq.Stream {
-		h.handleResponsesStream(w, r, resp, owner, responseID, stdReq.ResponseModel, stdReq.FinalPrompt, stdReq.Thinking, stdReq.Search, stdReq.ToolNames)
+func newResponseID() string {
+	return "resp_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+// responsesTurn is the conversation state a response is stored with so a
+// later request can continue from it via previous_response_id.
+type responsesTurn struct {
+	owner      string
+	previousID string
+	history    []any
+	input      []any
+	background bool
+	// cause reports why the response's context ended, if it has.
+	cause func() error
+}
+
+// stamp sets the fields every object of this response carries.
+func (t responsesTurn) stamp(obj map[string]any) map[string]any {
+	if t.previousID != "" {
+		obj["previous_response_id"] = t.previousID
+	}
+	if t.background {
+		obj["background"] = true
+	}
+	return obj
+}
+
+// cancelled reports whether the response was cancelled via the API.
+func (t responsesTurn) cancelled() bool {
+	return t.cause != nil && errors.Is(t.cause(), errResponseCancelled)
+}
+
+// persist stores the finished response unless it was deleted or expired
+// while it ran.
+func (t responsesTurn) persist(st *responseStore, responseID string, obj map[string]any) {
+	if t.cause != nil && (errors.Is(t.cause(), errResponseDeleted) || errors.Is(t.cause(), errResponseExpired)) {
 		return
 	}
-	h.handleResponsesNonStream(w, resp, owner, responseID, stdReq.ResponseModel, stdReq.FinalPrompt, stdReq.Thinking, stdReq.ToolNames)
+	if t.cancelled() {
+		obj["status"] = "cancelled"
+	}
+	st.putTurn(t.owner, responseID, t.stamp(obj), t.history, t.input)
+}
+
+// responsesInputItems gives every input item an id and type so it can be
+// listed through input_items; role-shaped items are messages.
+func responsesInputItems(items []any) []any {
+	out := make([]any, 0, len(items))
+	for _, raw := range items {
+		msg, ok := raw.(map[string]any)
+		if !ok {
+			out = append(out, raw)
+			continue
+		}
+		item := cloneAnyMap(msg)
+		if _, ok := item["type"]; !ok {
+			item["type"] = "message"
+		}
+		if id, _ := item["id"].(string); strings.TrimSpace(id) == "" {
+			item["id"] = openaifmt.NewResponseItemID(responsesInputItemPrefix(asString(item["type"])))
+		}
+		out = append(out, item)
+	}
+	return out
 }
 
-func (h *Handler) handleResponsesNonStream(w http.ResponseWriter, resp *http.Response, owner, responseID, model, finalPrompt string, thinkingEnabled bool, toolNames []string) {
+func responsesInputItemPrefix(itemType string) string {
+	switch itemType {
+	case "message":
+		return "msg"
+	case "function_call":
+		return "fc"
+	case "function_call_output":
+		return "fco"
+	}
+	return "item"
+}
+
+func (h *Handler) handleResponsesNonStream(w http.ResponseWriter, resp *http.Response, turn responsesTurn, responseID string, stdReq util.StandardRequest, finalizeText textFinalizer) {
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		writeOpenAIError(w, resp.StatusCode, strings.TrimSpace(string(body)))
 		return
 	}
-	result := sse.CollectStream(resp, thinkingEnabled, true)
-	responseObj := openaifmt.BuildResponseObject(responseID, model, finalPrompt, result.Thinking, result.Text, toolNames)
-	h.getResponseStore().put(owner, responseID, responseObj)
+	limiter := util.NewOutputLimiter(stdReq.Limits)
+	result := sse.CollectStreamLimited(resp, stdReq.Thinking, true, limiter)
+	var sources *util.SearchSources
+	if stdReq.Search {
+		sources = &result.Search
+	}
+	if turn.cancelled() {
+		output := buildResponsesOutput(result.Thinking, result.Text, nil, sources)
+		responseObj := openaifmt.BuildResponseObjectWithOutput(responseID, stdReq.ResponseModel, stdReq.FinalPrompt, result.Thinking, result.Text, output)
+		turn.persist(h.getResponseStore(), responseID, responseObj)
+		writeJSON(w, http.StatusOK, responseObj)
+		return
+	}
+	text, usage := result.Text, result.Usage
+	if finalizeText != nil {
+		var err error
+		if text, err = finalizeText(text, &usage); err != nil {
+			writeOpenAIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+	}
+	detected := detectToolCalls(text, stdReq.ToolNames, stdReq.ToolPolicy, finalizeText != nil)
+	output := buildResponsesOutput(result.Thinking, text, detected, sources)
+	responseObj := openaifmt.BuildResponseObjectWithOutput(responseID, stdReq.ResponseModel, stdReq.FinalPrompt, result.Thinking, text, output)
+	responseObj["usage"] = openaifmt.BuildResponsesUsage(stdReq.FinalPrompt, result.Thinking, text, usage.Delivered(limiter.Done() || text != result.Text))
+	markResponseIncomplete(responseObj, limiter)
+	turn.persist(h.getResponseStore(), responseID, responseObj)
 	writeJSON(w, http.StatusOK, responseObj)
 }
 
-func (h *Handler) handleResponsesStream(w http.ResponseWriter, r *http.Request, resp *http.Response, owner, responseID, model, finalPrompt string, thinkingEnabled, searchEnabled bool, toolNames []string) {
+func (h *Handler) handleResponsesStream(w http.ResponseWriter, r *http.Request, resp *http.Response, turn responsesTurn, responseID string, stdReq util.StandardRequest, finalizeText textFinalizer) {
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
@@ -130,11 +264,16 @@ func (h *Handler) handleResponsesStream(w http.ResponseWriter, r *http.Request,
 	rc := http.NewResponseController(w)
 	canFlush := rc.Flush() == nil
 
-	initialType := "text"
-	if thinkingEnabled {
-		initialType = "thinking"
-	}
-	bufferToolContent := len(toolNames) > 0 && h.toolcallFeatureMatchEnabled()
+	streamRuntime := h.newResponsesRuntime(w, rc, canFlush, turn, responseID, stdReq, finalizeText)
+	// If downstream is already closed, runtime marks itself non-writable.
+	// We still enter ConsumeSSE so upstream body is canceled via request context
+	// and account slots are released deterministically.
+	_ = streamRuntime.sendCreated()
+	consumeResponsesStream(r.Context(), resp.Body, streamRuntime, turn)
+}
+
+func (h *Handler) newResponsesRuntime(w http.ResponseWriter, rc *http.ResponseController, canFlush bool, turn responsesTurn, responseID string, stdReq util.StandardRequest, finalizeText textFinalizer) *responsesStreamRuntime {
+	bufferToolContent := len(stdReq.ToolNames) > 0 && h.toolcallFeatureMatchEnabled()
 	emitEarlyToolDeltas := h.toolcallEarlyEmitHighConfidence()
 
 	streamRuntime := newResponsesStreamRuntime(
@@ -142,26 +281,34 @@ func (h *Handler) handleResponsesStream(w http.ResponseWriter, r *http.Request,
 		rc,
 		canFlush,
 		responseID,
-		model,
-		finalPrompt,
-		thinkingEnabled,
-		searchEnabled,
-		toolNames,
+		stdReq.ResponseModel,
+		stdReq.FinalPrompt,
+		stdReq.Thinking,
+		stdReq.Search,
+		stdReq.ToolNames,
 		bufferToolContent,
 		emitEarlyToolDeltas,
 		func(obj map[string]any) {
-			h.getResponseStore().put(owner, responseID, obj)
+			turn.persist(h.getResponseStore(), responseID, obj)
 		},
 	)
-	// If downstream is already closed, runtime marks itself non-writable.
-	// We still enter ConsumeSSE so upstream body is canceled via request context
-	// and account slots are released deterministically.
-	_ = streamRuntime.sendCreated()
+	streamRuntime.finalizeText = finalizeText
+	streamRuntime.toolPolicy = stdReq.ToolPolicy
+	streamRuntime.limiter = util.NewOutputLimiter(stdReq.Limits)
+	return streamRuntime
+}
 
+// consumeResponsesStream feeds an upstream completion through a Responses
+// stream runtime until it ends or ctx is done.
+func consumeResponsesStream(ctx context.Context, body io.Reader, streamRuntime *responsesStreamRuntime, turn responsesTurn) {
+	initialType := "text"
+	if streamRuntime.thinkingEnabled {
+		initialType = "thinking"
+	}
 	streamengine.ConsumeSSE(streamengine.ConsumeConfig{
-		Context:             r.Context(),
-		Body:                resp.Body,
-		ThinkingEnabled:     thinkingEnabled,
+		Context:             ctx,
+		Body:                body,
+		ThinkingEnabled:     streamRuntime.thinkingEnabled,
 		InitialType:         initialType,
 		KeepAliveInterval:   time.Duration(deepseek.KeepAliveTimeout) * time.Second,
 		IdleTimeout:         time.Duration(deepseek.StreamIdleTimeout) * time.Second,
@@ -174,17 +321,63 @@ func (h *Handler) handleResponsesStream(w http.ResponseWriter, r *http.Request,
 		OnFinalize: func(_ streamengine.StopReason, _ error) {
 			streamRuntime.finalize()
 		},
+		OnContextDone: func() {
+			if turn.cancelled() {
+				streamRuntime.sendCancelled()
+			}
+		},
 	})
 }
 
-func responsesMessagesFromRequest(req map[string]any) []any {
+// responsesWideInput reports whether Responses requests may use `input` and
+// `instructions` rather than only chat-style `messages`.
+func responsesWideInput(store ConfigReader) bool {
+	// Keep width-control as an explicit policy hook even if current default is true.
+	if store == nil {
+		return true
+	}
+	return store.CompatWideInputStrictOutput()
+}
+
+// responsesTurnInput returns the messages a request adds to the conversation,
+// without its instructions.
+func responsesTurnInput(store ConfigReader, req map[string]any) []any {
+	if !responsesWideInput(store) {
+		msgs, _ := req["messages"].([]any)
+		return msgs
+	}
+	return responsesInputFromRequest(req)
+}
+
+// responsesTurnItems returns the input items of a request as given, so
+// function calls and their outputs keep their item shape in input_items;
+// other inputs are recorded as the messages they normalize to.
+func responsesTurnItems(store ConfigReader, req map[string]any) []any {
+	if responsesWideInput(store) {
+		if msgs, ok := req["messages"].([]any); !ok || len(msgs) == 0 {
+			if items, ok := req["input"].([]any); ok && responsesAllItems(items) {
+				return items
+			}
+		}
+	}
+	return responsesTurnInput(store, req)
+}
+
+func responsesAllItems(items []any) bool {
+	for _, raw := range items {
+		if _, ok := raw.(map[string]any); !ok {
+			return false
+		}
+	}
+	return len(items) > 0
+}
+
+func responsesInputFromRequest(req map[string]any) []any {
 	if msgs, ok := req["messages"].([]any); ok && len(msgs) > 0 {
-		return prependInstructionMessage(msgs, req["instructions"])
+		return msgs
 	}
 	if rawInput, ok := req["input"]; ok {
-		if msgs := normalizeResponsesInputAsMessages(rawInput); len(msgs) > 0 {
-			return prependInstructionMessage(msgs, req["instructions"])
-		}
+		return normalizeResponsesInputAsMessages(rawInput)
 	}
 	return nil
 }
@@ -209,33 +402,7 @@ func normalizeResponsesInputAsMessages(input any) []any {
 		}
 		return []any{map[string]any{"role": "user", "content": v}}
 	case []any:
-		if len(v) == 0 {
-			return nil
-		}
-		// If caller already provides role-shaped items, keep as-is.
-		if first, ok := v[0].(map[string]any); ok {
-			if _, hasRole := first["role"]; hasRole {
-				return v
-			}
-		}
-		parts := make([]string, 0, len(v))
-		for _, item := range v {
-			if m, ok := item.(map[string]any); ok {
-				if t, _ := m["type"].(string); strings.EqualFold(strings.TrimSpace(t), "input_text") {
-					if txt, _ := m["text"].(string); strings.TrimSpace(txt) != "" {
-						parts = append(parts, txt)
-						continue
-					}
-				}
-			}
-			if s := strings.TrimSpace(fmt.Sprintf("%v", item)); s != "" {
-				parts = append(parts, s)
-			}
-		}
-		if len(parts) == 0 {
-			return nil
-		}
-		return []any{map[string]any{"role": "user", "content": strings.Join(parts, "\n")}}
+		return normalizeResponsesInputItems(v)
 	case map[string]any:
 		if txt, _ := v["text"].(string); strings.TrimSpace(txt) != "" {
 			return []any{map[string]any{"role": "user", "content": txt}}
@@ -246,3 +413,87 @@ func normalizeResponsesInputAsMessages(input any) []any {
 	}
 	return nil
 }
+
+// normalizeResponsesInputItems turns a list of input items into chat
+// messages. Role-shaped items are kept; function_call items become assistant
+// tool calls and function_call_output items tool results; reasoning and
+// item_reference items carry nothing for the prompt. Loose text between them
+// is joined into user messages.
+func normalizeResponsesInputItems(items []any) []any {
+	out := make([]any, 0, len(items))
+	toolNames := map[string]string{}
+	var loose []string
+	flush := func() {
+		if len(loose) > 0 {
+			out = append(out, map[string]any{"role": "user", "content": strings.Join(loose, "\n")})
+			loose = nil
+		}
+	}
+	for _, raw := range items {
+		m, ok := raw.(map[string]any)
+		if !ok {
+			if s := strings.TrimSpace(fmt.Sprintf("%v", raw)); s != "" {
+				loose = append(loose, s)
+			}
+			continue
+		}
+		if _, hasRole := m["role"]; hasRole {
+			flush()
+			out = append(out, m)
+			continue
+		}
+		switch t := strings.ToLower(strings.TrimSpace(asString(m["type"]))); t {
+		case "function_call":
+			flush()
+			callID := asString(m["call_id"])
+			toolNames[callID] = asString(m["name"])
+			out = appendResponsesFunctionCall(out, m)
+		case "function_call_output":
+			flush()
+			callID := asString(m["call_id"])
+			msg := map[string]any{"role": "tool", "tool_call_id": callID, "content": m["output"]}
+			if name := toolNames[callID]; name != "" {
+				msg["name"] = name
+			}
+			out = append(out, msg)
+		case "reasoning", "item_reference":
+		case "input_text":
+			if txt, _ := m["text"].(string); strings.TrimSpace(txt) != "" {
+				loose = append(loose, txt)
+			}
+		default:
+			if s := strings.TrimSpace(fmt.Sprintf("%v", m)); s != "" {
+				loose = append(loose, s)
+			}
+		}
+	}
+	flush()
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// appendResponsesFunctionCall adds a function_call item to messages as an
+// assistant tool call, merging consecutive calls into one message.
+func appendResponsesFunctionCall(messages []any, item map[string]any) []any {
+	call := map[string]any{
+		"id":   asString(item["call_id"]),
+		"type": "function",
+		"function": map[string]any{
+			"name":      asString(item["name"]),
+			"arguments": item["arguments"],
+		},
+	}
+	if n := len(messages); n > 0 {
+		if last, ok := messages[n-1].(map[string]any); ok && last["role"] == "assistant" && last["content"] == nil {
+			if calls, ok := last["tool_calls"].([]any); ok {
+				merged := cloneAnyMap(last)
+				merged["tool_calls"] = append(append([]any{}, calls...), call)
+				messages[n-1] = merged
+				return messages
+			}
+		}
+	}
+	return append(messages, map[string]any{"role": "assistant", "content": nil, "tool_calls": []any{call}})
+}
@@ -0,0 +1,93 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultInputItemsLimit = 20
+	maxInputItemsLimit     = 100
+)
+
+func (h *Handler) DeleteResponse(w http.ResponseWriter, r *http.Request) {
+	owner, id, ok := h.responseCaller(w, r)
+	if !ok {
+		return
+	}
+	if !h.getResponseStore().delete(owner, id) {
+		writeOpenAIError(w, http.StatusNotFound, "Response not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":      id,
+		"object":  "response",
+		"deleted": true,
+	})
+}
+
+// CancelResponse aborts an in_progress response. Its upstream call is
+// closed, which ends generation and releases the account it held; the
+// request that created it receives the partial output with status
+// "cancelled". Cancelling a cancelled response is a no-op.
+func (h *Handler) CancelResponse(w http.ResponseWriter, r *http.Request) {
+	owner, id, ok := h.responseCaller(w, r)
+	if !ok {
+		return
+	}
+	item, found, cancelled := h.getResponseStore().cancel(owner, id)
+	if !found {
+		writeOpenAIError(w, http.StatusNotFound, "Response not found.")
+		return
+	}
+	if !cancelled {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Cannot cancel a response with status '%v'.", item["status"]))
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// ListResponseInputItems pages through the input items of a response's own
+// turn, newest first unless order=asc. `after` is the id of the last item of
+// the previous page.
+func (h *Handler) ListResponseInputItems(w http.ResponseWriter, r *http.Request) {
+	owner, id, ok := h.responseCaller(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	limit := defaultInputItemsLimit
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxInputItemsLimit {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("'limit' must be an integer between 1 and %d.", maxInputItemsLimit))
+			return
+		}
+		limit = n
+	}
+	order := strings.TrimSpace(q.Get("order"))
+	if order == "" {
+		order = "desc"
+	}
+	if order != "asc" && order != "desc" {
+		writeOpenAIError(w, http.StatusBadRequest, "'order' must be 'asc' or 'desc'.")
+		return
+	}
+
+	items, ok := h.getResponseStore().inputItems(owner, id)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "Response not found.")
+		return
+	}
+	ordered := make([]any, 0, len(items))
+	if order == "asc" {
+		ordered = append(ordered, items...)
+	} else {
+		for i := len(items) - 1; i >= 0; i-- {
+			ordered = append(ordered, items[i])
+		}
+	}
+	writeListPage(w, ordered, strings.TrimSpace(q.Get("after")), limit, "Input item")
+}
@@ -0,0 +1,260 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ds2api/internal/auth"
+)
+
+// hangingDS streams one content chunk and then holds the upstream open until
+// the request context ends.
+type hangingDS struct {
+	streaming chan struct{}
+	aborted   chan struct{}
+}
+
+func newHangingDS() *hangingDS {
+	return &hangingDS{streaming: make(chan struct{}), aborted: make(chan struct{})}
+}
+
+func (d *hangingDS) CreateSession(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "session", nil
+}
+
+func (d *hangingDS) GetPow(_ context.Context, _ *auth.RequestAuth, _ int) (string, error) {
+	return "pow", nil
+}
+
+func (d *hangingDS) CallCompletion(ctx context.Context, _ *auth.RequestAuth, _ map[string]any, _ string, _ int) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = io.WriteString(pw, "data: {\"p\":\"response/content\",\"v\":\"partial\"}\n\n")
+		close(d.streaming)
+		<-ctx.Done()
+		close(d.aborted)
+		_ = pw.CloseWithError(ctx.Err())
+	}()
+	return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: pr}, nil
+}
+
+func serveResponses(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer token-a")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func waitInProgressResponse(t *testing.T, h *Handler, owner string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		st := h.getResponseStore()
+		st.mu.Lock()
+		for _, item := range st.items {
+			if item.Owner == owner && item.Value["status"] == "in_progress" {
+				id, _ := item.Value["id"].(string)
+				st.mu.Unlock()
+				return id
+			}
+		}
+		st.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no in_progress response")
+	return ""
+}
+
+func TestCancelResponseAbortsInFlightStream(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := newHangingDS()
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	owner := responseStoreOwner(authForToken(t, resolver, "token-a"))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serveResponses(r, http.MethodPost, "/v1/responses", `{"model":"deepseek-chat","input":"hi","stream":true}`)
+	}()
+	<-ds.streaming
+	id := waitInProgressResponse(t, h, owner)
+
+	if got := serveResponses(r, http.MethodGet, "/v1/responses/"+id, ""); !strings.Contains(got.Body.String(), `"in_progress"`) {
+		t.Fatalf("expected in_progress while streaming: %s", got.Body.String())
+	}
+	cancelRec := serveResponses(r, http.MethodPost, "/v1/responses/"+id+"/cancel", "")
+	if cancelRec.Code != http.StatusOK {
+		t.Fatalf("unexpected cancel status %d body=%s", cancelRec.Code, cancelRec.Body.String())
+	}
+	if out := decodeJSONBody(t, cancelRec.Body.String()); out["status"] != "cancelled" {
+		t.Fatalf("expected cancelled status: %#v", out)
+	}
+
+	select {
+	case <-ds.aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream call was not aborted")
+	}
+	streamRec := <-done
+	if !strings.Contains(streamRec.Body.String(), "event: response.cancelled") {
+		t.Fatalf("expected response.cancelled event: %s", streamRec.Body.String())
+	}
+	stored, ok := h.getResponseStore().get(owner, id)
+	if !ok || stored["status"] != "cancelled" {
+		t.Fatalf("expected stored cancelled response: %#v", stored)
+	}
+
+	again := serveResponses(r, http.MethodPost, "/v1/responses/"+id+"/cancel", "")
+	if again.Code != http.StatusOK {
+		t.Fatalf("expected cancel to be idempotent, got %d body=%s", again.Code, again.Body.String())
+	}
+}
+
+func TestCancelResponseRejectsFinishedResponse(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	owner := responseStoreOwner(authForToken(t, resolver, "token-a"))
+	h.getResponseStore().put(owner, "resp_done", map[string]any{"id": "resp_done", "status": "completed"})
+
+	if rec := serveResponses(r, http.MethodPost, "/v1/responses/resp_done/cancel", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := serveResponses(r, http.MethodPost, "/v1/responses/resp_missing/cancel", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDeleteResponse(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	owner := responseStoreOwner(authForToken(t, resolver, "token-a"))
+	h.getResponseStore().put(owner, "resp_del", map[string]any{"id": "resp_del", "status": "completed"})
+
+	rec := serveResponses(r, http.MethodDelete, "/v1/responses/resp_del", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", rec.Code, rec.Body.String())
+	}
+	if out := decodeJSONBody(t, rec.Body.String()); out["deleted"] != true || out["id"] != "resp_del" {
+		t.Fatalf("unexpected body: %#v", out)
+	}
+	if rec := serveResponses(r, http.MethodGet, "/v1/responses/resp_del", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", rec.Code)
+	}
+	if rec := serveResponses(r, http.MethodDelete, "/v1/responses/resp_del", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 on second delete, got %d", rec.Code)
+	}
+}
+
+func TestListResponseInputItemsPaginates(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver, DS: &promptDS{}}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+
+	msgs := make([]string, 0, 5)
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"role":"user","content":"m%d"}`, i))
+	}
+	created := serveResponses(r, http.MethodPost, "/v1/responses", `{"model":"deepseek-chat","instructions":"sys","input":[`+strings.Join(msgs, ",")+`]}`)
+	if created.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", created.Code, created.Body.String())
+	}
+	id, _ := decodeJSONBody(t, created.Body.String())["id"].(string)
+
+	first := decodeJSONBody(t, serveResponses(r, http.MethodGet, "/v1/responses/"+id+"/input_items?limit=2&order=asc", "").Body.String())
+	data, _ := first["data"].([]any)
+	if first["object"] != "list" || len(data) != 2 || first["has_more"] != true {
+		t.Fatalf("unexpected first page: %#v", first)
+	}
+	if data[0].(map[string]any)["content"] != "m0" || data[0].(map[string]any)["type"] != "message" {
+		t.Fatalf("expected input items without instructions: %#v", data)
+	}
+	lastID, _ := first["last_id"].(string)
+	if !strings.HasPrefix(lastID, "msg_") {
+		t.Fatalf("expected item ids: %#v", first)
+	}
+
+	rest := decodeJSONBody(t, serveResponses(r, http.MethodGet, "/v1/responses/"+id+"/input_items?order=asc&after="+lastID, "").Body.String())
+	data, _ = rest["data"].([]any)
+	if len(data) != 3 || rest["has_more"] != false || data[0].(map[string]any)["content"] != "m2" {
+		t.Fatalf("unexpected second page: %#v", rest)
+	}
+
+	desc := decodeJSONBody(t, serveResponses(r, http.MethodGet, "/v1/responses/"+id+"/input_items", "").Body.String())
+	data, _ = desc["data"].([]any)
+	if len(data) != 5 || data[0].(map[string]any)["content"] != "m4" {
+		t.Fatalf("expected newest first by default: %#v", desc)
+	}
+
+	if rec := serveResponses(r, http.MethodGet, "/v1/responses/"+id+"/input_items?limit=0", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad limit, got %d", rec.Code)
+	}
+}
+
+func TestResponseStoreKeepsRunningResponsesPastTTL(t *testing.T) {
+	st := newResponseStore(20 * time.Millisecond)
+	_, cancel := context.WithCancelCause(context.Background())
+	st.start("alice", "resp_long", map[string]any{"id": "resp_long", "status": "queued"}, nil, nil, cancel, newResponseEventLog())
+	st.start("alice", "resp_cancel", map[string]any{"id": "resp_cancel", "status": "queued"}, nil, nil, cancel, nil)
+	st.put("alice", "resp_done", map[string]any{"id": "resp_done", "status": "completed"})
+
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := st.get("alice", "resp_done"); ok {
+		t.Fatal("expected a finished response to expire")
+	}
+	if _, ok := st.get("alice", "resp_long"); !ok {
+		t.Fatal("expected a running response to outlive the TTL")
+	}
+	if _, found, cancelled := st.cancel("alice", "resp_cancel"); !found || !cancelled {
+		t.Fatalf("expected a running response to stay cancellable, found=%v cancelled=%v", found, cancelled)
+	}
+
+	st.putTurn("alice", "resp_long", map[string]any{"id": "resp_long", "status": "completed"}, nil, nil)
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := st.get("alice", "resp_long"); !ok {
+		t.Fatal("expected the TTL to restart when the response finished")
+	}
+	if _, ok := st.get("alice", "resp_cancel"); !ok {
+		t.Fatal("expected the TTL to restart when the response was cancelled")
+	}
+}
+
+func TestResponseStoreFailsResponsesThatRunTooLong(t *testing.T) {
+	st := newResponseStore(time.Minute)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	events := newResponseEventLog()
+	st.start("alice", "resp_stuck", map[string]any{"id": "resp_stuck", "status": "in_progress"}, nil, nil, cancel, events)
+
+	st.mu.Lock()
+	st.sweepLocked(time.Now().Add(maxResponseRunTime + time.Minute))
+	st.mu.Unlock()
+	obj, ok := st.get("alice", "resp_stuck")
+	if errObj, _ := obj["error"].(map[string]any); !ok || obj["status"] != "failed" || errObj["code"] != "server_error" {
+		t.Fatalf("expected the stuck response to fail, got %#v", obj)
+	}
+	if !errors.Is(context.Cause(ctx), errResponseExpired) {
+		t.Fatalf("expected the upstream call to be aborted, got %v", context.Cause(ctx))
+	}
+	logged, done, _ := events.since(-1)
+	if !done || len(logged) != 1 || logged[0].name != "response.failed" {
+		t.Fatalf("expected the event log to end with response.failed, got %#v done=%v", logged, done)
+	}
+	if _, _, cancelled := st.cancel("alice", "resp_stuck"); cancelled {
+		t.Fatal("expected the failed response not to be cancellable")
+	}
+}
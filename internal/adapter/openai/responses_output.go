@@ -0,0 +1,329 @@
+package openai
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	openaifmt "ds2api/internal/format/openai"
+	"ds2api/internal/util"
+)
+
+// responsesOutput assembles the output items of a streamed response and
+// emits their events in the official order: output_item.added, the item's
+// part and delta events, then the done events ending in output_item.done.
+// An open item is closed as soon as a different kind of output starts.
+type responsesOutput struct {
+	send  func(event string, payload map[string]any) bool
+	items []any
+
+	reasoning *responsesOpenItem
+	message   *responsesOpenItem
+	// calls are the function calls being streamed incrementally, in the
+	// order they were opened; callsByIndex maps the tool sieve's index.
+	calls        []*responsesOpenItem
+	callsByIndex map[int]*responsesOpenItem
+
+	// sources, set when search is enabled, resolves the [citation:N]
+	// markers in the text into url_citation annotations.
+	sources   *util.SearchSources
+	citations util.CitationScanner
+}
+
+type responsesOpenItem struct {
+	outputIndex int
+	id          string
+	callID      string
+	name        string
+	text        strings.Builder
+	annotations []any
+}
+
+func newResponsesOutput(send func(event string, payload map[string]any) bool) *responsesOutput {
+	return &responsesOutput{send: send, callsByIndex: map[int]*responsesOpenItem{}}
+}
+
+func (o *responsesOutput) emit(payload map[string]any) bool {
+	event, _ := payload["type"].(string)
+	return o.send(event, payload)
+}
+
+// open reserves the next output index for item and announces it.
+func (o *responsesOutput) open(id string, item map[string]any) (*responsesOpenItem, bool) {
+	it := &responsesOpenItem{outputIndex: len(o.items), id: id}
+	o.items = append(o.items, item)
+	return it, o.emit(openaifmt.BuildResponsesOutputItemPayload("response.output_item.added", it.outputIndex, item))
+}
+
+// done stores the final form of an item and announces it.
+func (o *responsesOutput) done(it *responsesOpenItem, item map[string]any) bool {
+	o.items[it.outputIndex] = item
+	return o.emit(openaifmt.BuildResponsesOutputItemPayload("response.output_item.done", it.outputIndex, item))
+}
+
+func (o *responsesOutput) reasoningDelta(delta string) bool {
+	if delta == "" {
+		return true
+	}
+	if o.reasoning == nil {
+		if !o.closeMessage() || !o.closeCalls() {
+			return false
+		}
+		id := openaifmt.NewResponseItemID("rs")
+		it, ok := o.open(id, openaifmt.BuildResponseReasoningItem(id, ""))
+		o.reasoning = it
+		if !ok || !o.emit(openaifmt.BuildResponsesSummaryPartPayload("response.reasoning_summary_part.added", id, it.outputIndex, 0, openaifmt.BuildResponseSummaryTextPart(""))) {
+			return false
+		}
+	}
+	o.reasoning.text.WriteString(delta)
+	return o.emit(openaifmt.BuildResponsesSummaryTextDeltaPayload(o.reasoning.id, o.reasoning.outputIndex, 0, delta))
+}
+
+func (o *responsesOutput) closeReasoning() bool {
+	it := o.reasoning
+	if it == nil {
+		return true
+	}
+	o.reasoning = nil
+	text := it.text.String()
+	return o.emit(openaifmt.BuildResponsesSummaryTextDonePayload(it.id, it.outputIndex, 0, text)) &&
+		o.emit(openaifmt.BuildResponsesSummaryPartPayload("response.reasoning_summary_part.done", it.id, it.outputIndex, 0, openaifmt.BuildResponseSummaryTextPart(text))) &&
+		o.done(it, openaifmt.BuildResponseReasoningItem(it.id, text))
+}
+
+func (o *responsesOutput) textDelta(delta string) bool {
+	if o.sources == nil {
+		return o.writeText(delta)
+	}
+	return o.writeSegments(o.citations.Feed(delta))
+}
+
+func (o *responsesOutput) writeSegments(segments []util.CitationSegment) bool {
+	for _, seg := range segments {
+		ok := true
+		if seg.Cite > 0 {
+			ok = o.cite(seg.Cite)
+		} else {
+			ok = o.writeText(seg.Text)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *responsesOutput) writeText(delta string) bool {
+	if delta == "" {
+		return true
+	}
+	if !o.openMessage() {
+		return false
+	}
+	o.message.text.WriteString(delta)
+	return o.emit(openaifmt.BuildResponsesTextDeltaPayload(o.message.id, o.message.outputIndex, 0, delta))
+}
+
+func (o *responsesOutput) openMessage() bool {
+	if o.message != nil {
+		return true
+	}
+	if !o.closeReasoning() || !o.closeCalls() {
+		return false
+	}
+	id := openaifmt.NewResponseItemID("msg")
+	it, ok := o.open(id, openaifmt.BuildResponseMessageItem(id, "", "in_progress", nil))
+	o.message = it
+	return ok && o.emit(openaifmt.BuildResponsesContentPartPayload("response.content_part.added", id, it.outputIndex, 0, openaifmt.BuildResponseOutputTextPart("", nil)))
+}
+
+// cite replaces citation marker n with a markdown link to the cited page,
+// annotated as a url_citation. Markers no search result answers are dropped.
+func (o *responsesOutput) cite(n int) bool {
+	r, ok := o.sources.Lookup(n)
+	if !ok {
+		return true
+	}
+	if !o.openMessage() {
+		return false
+	}
+	ref := fmt.Sprintf("[%d](%s)", n, r.URL)
+	start := utf8.RuneCountInString(o.message.text.String())
+	if !o.writeText(ref) {
+		return false
+	}
+	it := o.message
+	annotation := openaifmt.BuildResponseURLCitation(start, start+utf8.RuneCountInString(ref), r.URL, r.Title)
+	it.annotations = append(it.annotations, annotation)
+	return o.emit(openaifmt.BuildResponsesAnnotationAddedPayload(it.id, it.outputIndex, 0, len(it.annotations)-1, annotation))
+}
+
+func (o *responsesOutput) closeMessage() bool {
+	if !o.writeSegments(o.citations.Flush()) {
+		return false
+	}
+	it := o.message
+	if it == nil {
+		return true
+	}
+	o.message = nil
+	text := it.text.String()
+	return o.emit(openaifmt.BuildResponsesTextDonePayload(it.id, it.outputIndex, 0, text)) &&
+		o.emit(openaifmt.BuildResponsesContentPartPayload("response.content_part.done", it.id, it.outputIndex, 0, openaifmt.BuildResponseOutputTextPart(text, it.annotations))) &&
+		o.done(it, openaifmt.BuildResponseMessageItem(it.id, text, "completed", it.annotations))
+}
+
+// search records upstream search activity; every search whose results
+// arrive becomes a completed web_search_call item.
+func (o *responsesOutput) search(u util.SearchUpdate) bool {
+	if o.sources == nil || u.IsZero() {
+		return true
+	}
+	before := len(o.sources.Searches())
+	o.sources.Merge(u)
+	for _, ws := range o.sources.Searches()[before:] {
+		if !o.webSearchCall(ws) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *responsesOutput) webSearchCall(ws util.WebSearch) bool {
+	if !o.closeAll() {
+		return false
+	}
+	id := openaifmt.NewResponseItemID("ws")
+	it, ok := o.open(id, openaifmt.BuildResponseWebSearchCallItem(id, "in_progress", ws.Query, nil))
+	return ok &&
+		o.emit(openaifmt.BuildResponsesWebSearchCallPayload("response.web_search_call.in_progress", id, it.outputIndex)) &&
+		o.emit(openaifmt.BuildResponsesWebSearchCallPayload("response.web_search_call.searching", id, it.outputIndex)) &&
+		o.emit(openaifmt.BuildResponsesWebSearchCallPayload("response.web_search_call.completed", id, it.outputIndex)) &&
+		o.done(it, openaifmt.BuildResponseWebSearchCallItem(id, "completed", ws.Query, ws.Results))
+}
+
+// callDelta streams part of a function call as the tool sieve recognizes it.
+func (o *responsesOutput) callDelta(d toolCallDelta) bool {
+	if d.Name == "" && d.Arguments == "" {
+		return true
+	}
+	it := o.callsByIndex[d.Index]
+	if it == nil {
+		if !o.closeReasoning() || !o.closeMessage() {
+			return false
+		}
+		var ok bool
+		if it, ok = o.openCall(d.Name); !ok {
+			return false
+		}
+		o.callsByIndex[d.Index] = it
+		o.calls = append(o.calls, it)
+	}
+	if it.name == "" {
+		it.name = d.Name
+	}
+	if d.Arguments == "" {
+		return true
+	}
+	it.text.WriteString(d.Arguments)
+	return o.emit(openaifmt.BuildResponsesArgumentsDeltaPayload(it.id, it.outputIndex, d.Arguments))
+}
+
+func (o *responsesOutput) openCall(name string) (*responsesOpenItem, bool) {
+	id := openaifmt.NewResponseItemID("fc")
+	callID := openaifmt.NewResponseItemID("call")
+	it, ok := o.open(id, openaifmt.BuildResponseFunctionCallItem(id, callID, name, "", "in_progress"))
+	it.callID = callID
+	it.name = name
+	return it, ok
+}
+
+// addCalls completes function calls. Calls already streamed through
+// callDelta are completed in order; the rest are emitted whole.
+func (o *responsesOutput) addCalls(calls []util.ParsedToolCall) bool {
+	if !o.closeReasoning() || !o.closeMessage() {
+		return false
+	}
+	for i, call := range calls {
+		var it *responsesOpenItem
+		if i < len(o.calls) {
+			it = o.calls[i]
+			if it.name == "" {
+				it.name = call.Name
+			}
+		} else {
+			var ok bool
+			if it, ok = o.openCall(call.Name); !ok {
+				return false
+			}
+		}
+		if it.text.Len() == 0 {
+			args := openaifmt.ToolCallArguments(call.Input)
+			it.text.WriteString(args)
+			if !o.emit(openaifmt.BuildResponsesArgumentsDeltaPayload(it.id, it.outputIndex, args)) {
+				return false
+			}
+		}
+		if !o.closeCall(it) {
+			return false
+		}
+	}
+	if len(calls) < len(o.calls) {
+		o.calls = o.calls[len(calls):]
+		return o.closeCalls()
+	}
+	o.calls = nil
+	o.callsByIndex = map[int]*responsesOpenItem{}
+	return true
+}
+
+// closeCalls completes the incrementally streamed calls with the arguments
+// seen so far.
+func (o *responsesOutput) closeCalls() bool {
+	calls := o.calls
+	o.calls = nil
+	o.callsByIndex = map[int]*responsesOpenItem{}
+	for _, it := range calls {
+		if !o.closeCall(it) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *responsesOutput) closeCall(it *responsesOpenItem) bool {
+	args := it.text.String()
+	return o.emit(openaifmt.BuildResponsesArgumentsDonePayload(it.id, it.outputIndex, args)) &&
+		o.done(it, openaifmt.BuildResponseFunctionCallItem(it.id, it.callID, it.name, args, "completed"))
+}
+
+// closeAll completes every open item.
+func (o *responsesOutput) closeAll() bool {
+	return o.closeReasoning() && o.closeMessage() && o.closeCalls()
+}
+
+// buildResponsesOutput assembles the output items of a collected
+// completion: its searches, reasoning, then function calls or a message.
+// sources is nil unless search was enabled.
+func buildResponsesOutput(thinking, text string, detected []util.ParsedToolCall, sources *util.SearchSources) []any {
+	o := newResponsesOutput(func(string, map[string]any) bool { return true })
+	if sources != nil {
+		o.sources = sources
+		for _, ws := range sources.Searches() {
+			o.webSearchCall(ws)
+		}
+	}
+	o.reasoningDelta(thinking)
+	if len(detected) > 0 {
+		o.addCalls(detected)
+	} else {
+		o.textDelta(text)
+		o.closeMessage()
+		if len(o.items) == 0 || o.items[len(o.items)-1].(map[string]any)["type"] != "message" {
+			o.items = append(o.items, openaifmt.BuildResponseMessageItem(openaifmt.NewResponseItemID("msg"), "", "completed", nil))
+		}
+	}
+	o.closeAll()
+	return o.items
+}
@@ -16,6 +16,9 @@ type responsesStreamRuntime struct {
 	rc       *http.ResponseController
 	canFlush bool
 	writable bool
+	// events, when set, receives the events instead of w: the response is
+	// running in the background and clients attach through the log.
+	events *responseEventLog
 
 	responseID  string
 	model       string
@@ -23,18 +26,28 @@ type responsesStreamRuntime struct {
 	toolNames   []string
 
 	thinkingEnabled bool
-	searchEnabled   bool
 
 	bufferToolContent   bool
 	emitEarlyToolDeltas bool
 	toolCallsEmitted    bool
 
-	sieve             toolStreamSieveState
-	thinking          strings.Builder
-	text              strings.Builder
-	streamToolCallIDs map[int]string
+	sieve    toolStreamSieveState
+	thinking strings.Builder
+	text     strings.Builder
+	// out assembles the output items and emits their events.
+	out *responsesOutput
+	// seq numbers the events written to w.
+	seq int
 
 	persistResponse func(obj map[string]any)
+	// finalizeText, when set, holds text deltas back and rewrites the final
+	// text (structured output enforcement) before it is emitted.
+	finalizeText textFinalizer
+	toolPolicy   util.ToolPolicy
+	// limiter cuts output at the client's stop sequences and token limit.
+	limiter *util.OutputLimiter
+	// upstream is the accounting DeepSeek reported on the stream so far.
+	upstream util.UpstreamUsage
 }
 
 func newResponsesStreamRuntime(
@@ -51,7 +64,7 @@ func newResponsesStreamRuntime(
 	emitEarlyToolDeltas bool,
 	persistResponse func(obj map[string]any),
 ) *responsesStreamRuntime {
-	return &responsesStreamRuntime{
+	s := &responsesStreamRuntime{
 		w:                   w,
 		rc:                  rc,
 		canFlush:            canFlush,
@@ -60,19 +73,28 @@ func newResponsesStreamRuntime(
 		model:               model,
 		finalPrompt:         finalPrompt,
 		thinkingEnabled:     thinkingEnabled,
-		searchEnabled:       searchEnabled,
 		toolNames:           toolNames,
 		bufferToolContent:   bufferToolContent,
 		emitEarlyToolDeltas: emitEarlyToolDeltas,
-		streamToolCallIDs:   map[int]string{},
 		persistResponse:     persistResponse,
 	}
+	s.out = newResponsesOutput(s.sendEvent)
+	if searchEnabled {
+		s.out.sources = &util.SearchSources{}
+	}
+	return s
 }
 
 func (s *responsesStreamRuntime) sendEvent(event string, payload map[string]any) bool {
 	if !s.writable {
 		return false
 	}
+	if s.events != nil {
+		s.events.append(event, payload)
+		return true
+	}
+	payload["sequence_number"] = s.seq
+	s.seq++
 	b, _ := json.Marshal(payload)
 	if _, err := s.w.Write([]byte("event: " + event + "\n")); err != nil {
 		s.writable = false
@@ -103,7 +125,7 @@ func (s *responsesStreamRuntime) sendKeepAlive() bool {
 	if !s.writable {
 		return false
 	}
-	if !s.canFlush {
+	if s.events != nil || !s.canFlush {
 		return true
 	}
 	if _, err := s.w.Write([]byte(": keep-alive\n\n")); err != nil {
@@ -118,13 +140,19 @@ func (s *responsesStreamRuntime) sendKeepAlive() bool {
 }
 
 func (s *responsesStreamRuntime) sendCreated() bool {
-	return s.sendEvent("response.created", openaifmt.BuildResponsesCreatedPayload(s.responseID, s.model))
+	obj := openaifmt.BuildResponsePendingObject(s.responseID, s.model, "in_progress")
+	return s.sendEvent("response.created", openaifmt.BuildResponsesLifecyclePayload("response.created", obj)) &&
+		s.sendEvent("response.in_progress", openaifmt.BuildResponsesLifecyclePayload("response.in_progress", obj))
 }
 
 func (s *responsesStreamRuntime) sendDone() bool {
 	if !s.writable {
 		return false
 	}
+	if s.events != nil {
+		s.events.finish()
+		return true
+	}
 	if _, err := s.w.Write([]byte("data: [DONE]\n\n")); err != nil {
 		s.writable = false
 		return false
@@ -142,28 +170,67 @@ func (s *responsesStreamRuntime) finalize() {
 	if !s.writable {
 		return
 	}
+	if !s.appendText(s.limiter.Flush()) {
+		return
+	}
 	finalThinking := s.thinking.String()
 	finalText := s.text.String()
-	if s.bufferToolContent {
+	held := s.finalizeText != nil
+	if held {
+		content, err := s.finalizeText(finalText, &s.upstream)
+		if err != nil {
+			if !s.out.closeAll() {
+				return
+			}
+			obj := openaifmt.BuildResponseObjectWithOutput(s.responseID, s.model, s.finalPrompt, finalThinking, "", s.out.items)
+			obj["status"] = "failed"
+			obj["error"] = map[string]any{"code": "invalid_structured_output", "message": err.Error()}
+			if s.persistResponse != nil {
+				s.persistResponse(obj)
+			}
+			if !s.sendEvent("response.failed", openaifmt.BuildResponsesLifecyclePayload("response.failed", obj)) {
+				return
+			}
+			s.sendDone()
+			return
+		}
+		finalText = content
+	}
+	detected := detectToolCalls(finalText, s.toolNames, s.toolPolicy, held)
+	switch {
+	case held && len(detected) > 0:
+		s.toolCallsEmitted = true
+		if !s.out.addCalls(detected) {
+			return
+		}
+	case held:
+		if !s.out.textDelta(finalText) {
+			return
+		}
+	case s.bufferToolContent:
 		for _, evt := range flushToolSieve(&s.sieve, s.toolNames) {
-			if evt.Content != "" {
-				if !s.sendEvent("response.output_text.delta", openaifmt.BuildResponsesTextDeltaPayload(s.responseID, evt.Content)) {
-					return
-				}
+			if !s.out.textDelta(evt.Content) {
+				return
 			}
-			if len(evt.ToolCalls) > 0 {
-				s.toolCallsEmitted = true
-				if !s.sendEvent("response.output_tool_call.done", openaifmt.BuildResponsesToolCallDonePayload(s.responseID, util.FormatOpenAIStreamToolCalls(evt.ToolCalls))) {
-					return
-				}
+			if len(evt.ToolCalls) > 0 && !s.addToolCalls(evt.ToolCalls) {
+				return
 			}
 		}
+	case len(detected) > 0 && !s.toolCallsEmitted:
+		// Without the tool sieve the raw text was streamed as it came;
+		// the calls found in it still become function_call items.
+		s.toolCallsEmitted = true
+		if !s.out.addCalls(detected) {
+			return
+		}
 	}
-
-	obj := openaifmt.BuildResponseObject(s.responseID, s.model, s.finalPrompt, finalThinking, finalText, s.toolNames)
-	if s.toolCallsEmitted {
-		obj["status"] = "completed"
+	if !s.out.closeAll() {
+		return
 	}
+
+	obj := openaifmt.BuildResponseObjectWithOutput(s.responseID, s.model, s.finalPrompt, finalThinking, finalText, s.out.items)
+	obj["usage"] = openaifmt.BuildResponsesUsage(s.finalPrompt, finalThinking, finalText, s.upstream.Delivered(s.limiter.Done() || finalText != s.text.String()))
+	markResponseIncomplete(obj, s.limiter)
 	if s.persistResponse != nil {
 		s.persistResponse(obj)
 	}
@@ -173,6 +240,39 @@ func (s *responsesStreamRuntime) finalize() {
 	s.sendDone()
 }
 
+// sendCancelled closes the stream of a response cancelled via the API with
+// whatever output it had produced.
+func (s *responsesStreamRuntime) sendCancelled() {
+	if !s.writable || !s.out.closeAll() {
+		return
+	}
+	obj := openaifmt.BuildResponseObjectWithOutput(s.responseID, s.model, s.finalPrompt, s.thinking.String(), s.text.String(), s.out.items)
+	obj["status"] = "cancelled"
+	if s.persistResponse != nil {
+		s.persistResponse(obj)
+	}
+	if !s.sendEvent("response.cancelled", openaifmt.BuildResponsesLifecyclePayload("response.cancelled", obj)) {
+		return
+	}
+	s.sendDone()
+}
+
+// admitToolCalls applies the tool policy to calls found mid-stream; with
+// parallel tool calls disabled nothing is admitted after the first call.
+func (s *responsesStreamRuntime) admitToolCalls(calls []util.ParsedToolCall) []util.ParsedToolCall {
+	if s.toolPolicy.Single && s.toolCallsEmitted {
+		return nil
+	}
+	return s.toolPolicy.Filter(calls, s.toolNames)
+}
+
+// earlyToolDeltas reports whether tool call arguments may stream before the
+// call is complete. Deltas go out before the policy can judge the call, so
+// only the default policy, which admits every parsed call, allows them.
+func (s *responsesStreamRuntime) earlyToolDeltas() bool {
+	return s.emitEarlyToolDeltas && s.toolPolicy.IsDefault()
+}
+
 func (s *responsesStreamRuntime) onParsed(parsed sse.LineResult) streamengine.ParsedDecision {
 	if !s.writable {
 		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
@@ -180,60 +280,86 @@ func (s *responsesStreamRuntime) onParsed(parsed sse.LineResult) streamengine.Pa
 	if !parsed.Parsed {
 		return streamengine.ParsedDecision{}
 	}
+	s.upstream.Merge(parsed.Usage)
 	if parsed.ContentFilter || parsed.ErrorMessage != "" || parsed.Stop {
 		return streamengine.ParsedDecision{Stop: true}
 	}
+	if !s.out.search(parsed.Search) {
+		return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+	}
 
 	contentSeen := false
 	for _, p := range parsed.Parts {
 		if p.Text == "" {
 			continue
 		}
-		if p.Type != "thinking" && s.searchEnabled && sse.IsCitation(p.Text) {
-			continue
-		}
 		contentSeen = true
 		if p.Type == "thinking" {
 			if !s.thinkingEnabled {
 				continue
 			}
-			s.thinking.WriteString(p.Text)
-			if !s.sendEvent("response.reasoning.delta", openaifmt.BuildResponsesReasoningDeltaPayload(s.responseID, p.Text)) {
+			text := s.limiter.Thinking(p.Text)
+			if text == "" {
+				continue
+			}
+			s.thinking.WriteString(text)
+			if !s.out.reasoningDelta(text) {
 				return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
 			}
 			continue
 		}
+		if !s.appendText(s.limiter.Text(p.Text)) {
+			return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+		}
+	}
 
-		s.text.WriteString(p.Text)
-		if !s.bufferToolContent {
-			if !s.sendEvent("response.output_text.delta", openaifmt.BuildResponsesTextDeltaPayload(s.responseID, p.Text)) {
-				return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
-			}
-			continue
+	if s.limiter.Done() {
+		// Ending the scan closes the upstream body, aborting generation.
+		return streamengine.ParsedDecision{ContentSeen: contentSeen, Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+	}
+	return streamengine.ParsedDecision{ContentSeen: contentSeen}
+}
+
+// appendText records admitted output text and emits its text and tool call
+// events, unless a finalizer holds the text back. It reports false once the
+// client can no longer be written to.
+func (s *responsesStreamRuntime) appendText(text string) bool {
+	if text == "" {
+		return true
+	}
+	s.text.WriteString(text)
+	if s.finalizeText != nil {
+		return true
+	}
+	if !s.bufferToolContent {
+		return s.out.textDelta(text)
+	}
+	s.sieve.holdDeltas = !s.earlyToolDeltas()
+	for _, evt := range processToolSieveChunk(&s.sieve, text, s.toolNames) {
+		if !s.out.textDelta(evt.Content) {
+			return false
 		}
-		for _, evt := range processToolSieveChunk(&s.sieve, p.Text, s.toolNames) {
-			if evt.Content != "" {
-				if !s.sendEvent("response.output_text.delta", openaifmt.BuildResponsesTextDeltaPayload(s.responseID, evt.Content)) {
-					return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
-				}
-			}
-			if len(evt.ToolCallDeltas) > 0 {
-				if !s.emitEarlyToolDeltas {
-					continue
-				}
-				s.toolCallsEmitted = true
-				if !s.sendEvent("response.output_tool_call.delta", openaifmt.BuildResponsesToolCallDeltaPayload(s.responseID, formatIncrementalStreamToolCallDeltas(evt.ToolCallDeltas, s.streamToolCallIDs))) {
-					return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
-				}
-			}
-			if len(evt.ToolCalls) > 0 {
-				s.toolCallsEmitted = true
-				if !s.sendEvent("response.output_tool_call.done", openaifmt.BuildResponsesToolCallDonePayload(s.responseID, util.FormatOpenAIStreamToolCalls(evt.ToolCalls))) {
-					return streamengine.ParsedDecision{Stop: true, StopReason: streamengine.StopReasonHandlerRequested}
+		if len(evt.ToolCallDeltas) > 0 {
+			s.toolCallsEmitted = true
+			for _, d := range evt.ToolCallDeltas {
+				if !s.out.callDelta(d) {
+					return false
 				}
 			}
 		}
+		if len(evt.ToolCalls) > 0 && !s.addToolCalls(evt.ToolCalls) {
+			return false
+		}
 	}
+	return true
+}
 
-	return streamengine.ParsedDecision{ContentSeen: contentSeen}
+// addToolCalls completes the calls the tool policy admits, along with any
+// streamed early.
+func (s *responsesStreamRuntime) addToolCalls(calls []util.ParsedToolCall) bool {
+	admitted := s.admitToolCalls(calls)
+	if len(admitted) > 0 {
+		s.toolCallsEmitted = true
+	}
+	return s.out.addCalls(admitted)
 }
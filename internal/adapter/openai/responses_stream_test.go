@@ -8,6 +8,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"ds2api/internal/util"
 )
 
 func TestHandleResponsesStreamToolCallsHideRawOutputTextInCompleted(t *testing.T) {
@@ -30,7 +32,7 @@ func TestHandleResponsesStreamToolCallsHideRawOutputTextInCompleted(t *testing.T
 		Body:       io.NopCloser(strings.NewReader(streamBody)),
 	}
 
-	h.handleResponsesStream(rec, req, resp, "owner-a", "resp_test", "deepseek-chat", "prompt", false, false, []string{"read_file"})
+	h.handleResponsesStream(rec, req, resp, responsesTurn{owner: "owner-a"}, "resp_test", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt", ToolNames: []string{"read_file"}}, nil)
 
 	completed, ok := extractSSEEventPayload(rec.Body.String(), "response.completed")
 	if !ok {
@@ -46,16 +48,14 @@ func TestHandleResponsesStreamToolCallsHideRawOutputTextInCompleted(t *testing.T
 		t.Fatalf("expected structured output entries, got %#v", responseObj["output"])
 	}
 	first, _ := output[0].(map[string]any)
-	if first["type"] != "tool_calls" {
-		t.Fatalf("expected first output type tool_calls, got %#v", first["type"])
+	if first["type"] != "function_call" {
+		t.Fatalf("expected first output type function_call, got %#v", first["type"])
 	}
-	toolCalls, _ := first["tool_calls"].([]any)
-	if len(toolCalls) == 0 {
-		t.Fatalf("expected at least one tool_call in output, got %#v", first["tool_calls"])
+	if first["name"] != "read_file" || first["arguments"] != `{"path":"README.MD"}` {
+		t.Fatalf("unexpected function_call item: %#v", first)
 	}
-	call0, _ := toolCalls[0].(map[string]any)
-	if call0["name"] != "read_file" {
-		t.Fatalf("unexpected tool call name: %#v", call0["name"])
+	if callID, _ := first["call_id"].(string); !strings.HasPrefix(callID, "call_") {
+		t.Fatalf("expected a call_id, got %#v", first["call_id"])
 	}
 	if strings.Contains(outputText, `"tool_calls"`) {
 		t.Fatalf("raw tool_calls JSON leaked in output_text: %q", outputText)
@@ -82,7 +82,7 @@ func TestHandleResponsesStreamIncompleteTailNotDuplicatedInCompletedOutputText(t
 		Body:       io.NopCloser(strings.NewReader(streamBody)),
 	}
 
-	h.handleResponsesStream(rec, req, resp, "owner-a", "resp_test", "deepseek-chat", "prompt", false, false, []string{"read_file"})
+	h.handleResponsesStream(rec, req, resp, responsesTurn{owner: "owner-a"}, "resp_test", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt", ToolNames: []string{"read_file"}}, nil)
 
 	completed, ok := extractSSEEventPayload(rec.Body.String(), "response.completed")
 	if !ok {
@@ -95,6 +95,67 @@ func TestHandleResponsesStreamIncompleteTailNotDuplicatedInCompletedOutputText(t
 	}
 }
 
+func TestHandleResponsesStreamEmitsItemEventSequence(t *testing.T) {
+	h := &Handler{}
+	req := httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+	rec := httptest.NewRecorder()
+	resp := makeSSEHTTPResponse(
+		`data: {"p":"response/thinking_content","v":"think"}`,
+		`data: {"p":"response/content","v":"hel"}`,
+		`data: {"p":"response/content","v":"lo"}`,
+		`data: [DONE]`,
+	)
+
+	h.handleResponsesStream(rec, req, resp, responsesTurn{owner: "owner-a"}, "resp_test", util.StandardRequest{ResponseModel: "deepseek-reasoner", FinalPrompt: "prompt", Thinking: true}, nil)
+
+	frames, done := parseSSEDataFrames(t, rec.Body.String())
+	if !done {
+		t.Fatalf("expected [DONE], body=%s", rec.Body.String())
+	}
+	want := []string{
+		"response.created",
+		"response.in_progress",
+		"response.output_item.added",
+		"response.reasoning_summary_part.added",
+		"response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.done",
+		"response.reasoning_summary_part.done",
+		"response.output_item.done",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_text.done",
+		"response.content_part.done",
+		"response.output_item.done",
+		"response.completed",
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("expected %d events, got %d: %s", len(want), len(frames), rec.Body.String())
+	}
+	for i, frame := range frames {
+		if frame["type"] != want[i] || frame["sequence_number"] != float64(i) {
+			t.Fatalf("event %d: expected %s with sequence %d, got %#v", i, want[i], i, frame)
+		}
+	}
+	if frames[10]["output_index"] != float64(1) || frames[10]["content_index"] != float64(0) || frames[10]["delta"] != "hel" {
+		t.Fatalf("unexpected text delta: %#v", frames[10])
+	}
+	responseObj, _ := frames[len(frames)-1]["response"].(map[string]any)
+	output, _ := responseObj["output"].([]any)
+	if len(output) != 2 || responseObj["output_text"] != "hello" {
+		t.Fatalf("unexpected completed response: %#v", responseObj)
+	}
+	reasoning, _ := output[0].(map[string]any)
+	summary, _ := reasoning["summary"].([]any)
+	if reasoning["type"] != "reasoning" || len(summary) != 1 {
+		t.Fatalf("expected a reasoning item with a summary: %#v", reasoning)
+	}
+	if added, _ := frames[2]["item"].(map[string]any); added["id"] != reasoning["id"] {
+		t.Fatalf("expected added and completed items to share an id: %#v vs %#v", added, reasoning)
+	}
+}
+
 func extractSSEEventPayload(body, targetEvent string) (map[string]any, bool) {
 	scanner := bufio.NewScanner(strings.NewReader(body))
 	matched := false
@@ -0,0 +1,111 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ds2api/internal/util"
+)
+
+func webSearchSSE() *http.Response {
+	return makeSSEHTTPResponse(
+		`data: {"p":"response/fragments","o":"APPEND","v":[{"type":"SEARCH","content":"","queries":[{"query":"go release"}],"results":[]}]}`,
+		`data: {"p":"response/fragments/-1/results","o":"SET","v":[{"url":"https://go.dev/blog","title":"Go Blog","snippet":"Go 1.22 is released"}]}`,
+		`data: {"p":"response/content","v":"Go 1.22 is out"}`,
+		`data: {"v":"[citation:1]"}`,
+		`data: {"p":"response/content","v":"."}`,
+		`data: [DONE]`,
+	)
+}
+
+func TestResponsesWebSearchToolEnablesSearch(t *testing.T) {
+	req := map[string]any{
+		"model": "deepseek-chat",
+		"input": "news",
+		"tools": []any{map[string]any{"type": "web_search"}},
+	}
+	out, err := normalizeOpenAIResponsesRequest(mockOpenAIConfig{wideInput: true}, req, nil)
+	if err != nil {
+		t.Fatalf("normalize failed: %v", err)
+	}
+	if !out.Search || len(out.ToolNames) != 0 {
+		t.Fatalf("expected search on and no function tools: search=%v tools=%#v", out.Search, out.ToolNames)
+	}
+	if strings.Contains(out.FinalPrompt, "You have access to these tools") {
+		t.Fatalf("web_search must not be injected as a tool prompt: %q", out.FinalPrompt)
+	}
+}
+
+func TestHandleResponsesStreamWebSearchCitations(t *testing.T) {
+	h := &Handler{}
+	req := httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+	rec := httptest.NewRecorder()
+
+	h.handleResponsesStream(rec, req, webSearchSSE(), responsesTurn{owner: "owner-a"}, "resp_test", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt", Search: true}, nil)
+
+	body := rec.Body.String()
+	for _, event := range []string{"response.web_search_call.searching", "response.web_search_call.completed", "response.output_text.annotation.added"} {
+		if !strings.Contains(body, "event: "+event+"\n") {
+			t.Fatalf("expected %s, body=%s", event, body)
+		}
+	}
+	completed, ok := extractSSEEventPayload(body, "response.completed")
+	if !ok {
+		t.Fatalf("expected response.completed, body=%s", body)
+	}
+	responseObj, _ := completed["response"].(map[string]any)
+	if responseObj["output_text"] != "Go 1.22 is out[1](https://go.dev/blog)." {
+		t.Fatalf("unexpected output_text: %#v", responseObj["output_text"])
+	}
+	output, _ := responseObj["output"].([]any)
+	if len(output) != 2 {
+		t.Fatalf("expected a web_search_call and a message, got %#v", output)
+	}
+	call, _ := output[0].(map[string]any)
+	action, _ := call["action"].(map[string]any)
+	if call["type"] != "web_search_call" || action["query"] != "go release" {
+		t.Fatalf("unexpected web_search_call: %#v", call)
+	}
+	msg, _ := output[1].(map[string]any)
+	content, _ := msg["content"].([]any)
+	part, _ := content[0].(map[string]any)
+	annotations, _ := part["annotations"].([]any)
+	if len(annotations) != 1 {
+		t.Fatalf("expected one annotation, got %#v", part)
+	}
+	ann, _ := annotations[0].(map[string]any)
+	if ann["type"] != "url_citation" || ann["url"] != "https://go.dev/blog" || ann["title"] != "Go Blog" ||
+		ann["start_index"] != float64(14) || ann["end_index"] != float64(38) {
+		t.Fatalf("unexpected annotation: %#v", ann)
+	}
+}
+
+func TestHandleResponsesNonStreamWebSearchCitations(t *testing.T) {
+	h := &Handler{}
+	rec := httptest.NewRecorder()
+
+	h.handleResponsesNonStream(rec, webSearchSSE(), responsesTurn{owner: "owner-a"}, "resp_test", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt", Search: true}, nil)
+
+	out := decodeJSONBody(t, rec.Body.String())
+	if out["output_text"] != "Go 1.22 is out[1](https://go.dev/blog)." {
+		t.Fatalf("unexpected output_text: %#v", out["output_text"])
+	}
+	output, _ := out["output"].([]any)
+	if len(output) != 2 || output[0].(map[string]any)["type"] != "web_search_call" {
+		t.Fatalf("unexpected output: %#v", output)
+	}
+}
+
+func TestHandleResponsesNonStreamKeepsMarkersWithoutSearch(t *testing.T) {
+	h := &Handler{}
+	rec := httptest.NewRecorder()
+
+	h.handleResponsesNonStream(rec, webSearchSSE(), responsesTurn{owner: "owner-a"}, "resp_test", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt"}, nil)
+
+	out := decodeJSONBody(t, rec.Body.String())
+	if out["output_text"] != "Go 1.22 is out[citation:1]." {
+		t.Fatalf("unexpected output_text: %#v", out["output_text"])
+	}
+}
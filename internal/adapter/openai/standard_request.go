@@ -19,11 +19,34 @@ func normalizeOpenAIChatRequest(store ConfigReader, req map[string]any) (util.St
 		return util.StandardRequest{}, fmt.Errorf("Model '%s' is not available.", model)
 	}
 	thinkingEnabled, searchEnabled, _ := config.GetModelConfig(resolvedModel)
+	thinkingEnabled, searchEnabled, err := applyReasoningEffort(store, req["reasoning_effort"], "reasoning_effort", thinkingEnabled, searchEnabled)
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
 	responseModel := strings.TrimSpace(model)
 	if responseModel == "" {
 		responseModel = resolvedModel
 	}
-	finalPrompt, toolNames := buildOpenAIFinalPrompt(messagesRaw, req["tools"])
+	n, err := parseChoiceCount(store, req)
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
+	format, err := parseChatResponseFormat(req["response_format"])
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
+	toolPolicy, err := parseOpenAIToolPolicy(req)
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
+	finalPrompt, toolNames := buildOpenAIFinalPrompt(messagesRaw, req["tools"], format, toolPolicy)
+	if err := checkToolPolicy(toolPolicy, toolNames); err != nil {
+		return util.StandardRequest{}, err
+	}
+	limits, err := parseOpenAIOutputLimits(req, "max_completion_tokens", "max_tokens")
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
 	passThrough := collectOpenAIChatPassThrough(req)
 
 	return util.StandardRequest{
@@ -34,14 +57,47 @@ func normalizeOpenAIChatRequest(store ConfigReader, req map[string]any) (util.St
 		Messages:       messagesRaw,
 		FinalPrompt:    finalPrompt,
 		ToolNames:      toolNames,
+		ToolPolicy:     toolPolicy,
 		Stream:         util.ToBool(req["stream"]),
 		Thinking:       thinkingEnabled,
 		Search:         searchEnabled,
+		N:              n,
+		ResponseFormat: format,
+		Limits:         limits,
 		PassThrough:    passThrough,
 	}, nil
 }
 
-func normalizeOpenAIResponsesRequest(store ConfigReader, req map[string]any) (util.StandardRequest, error) {
+// parseChoiceCount validates the OpenAI `n` parameter against the configured
+// runtime.max_choices ceiling.
+func parseChoiceCount(store ConfigReader, req map[string]any) (int, error) {
+	raw, ok := req["n"]
+	if !ok || raw == nil {
+		return 1, nil
+	}
+	f, ok := raw.(float64)
+	if !ok || f != float64(int(f)) || f < 1 {
+		return 0, fmt.Errorf("'n' must be a positive integer.")
+	}
+	if maxChoices := maxChoiceCount(store); int(f) > maxChoices {
+		return 0, fmt.Errorf("'n' must be at most %d.", maxChoices)
+	}
+	return int(f), nil
+}
+
+// maxChoiceCount is the most choices, and so upstream completions, one
+// request may ask for.
+func maxChoiceCount(store ConfigReader) int {
+	if store == nil {
+		return 4
+	}
+	return store.RuntimeMaxChoices()
+}
+
+// normalizeOpenAIResponsesRequest builds the upstream request for a Responses
+// call; history is the conversation resolved from previous_response_id and
+// goes between the instructions and this turn's input.
+func normalizeOpenAIResponsesRequest(store ConfigReader, req map[string]any, history []any) (util.StandardRequest, error) {
 	model, _ := req["model"].(string)
 	model = strings.TrimSpace(model)
 	if model == "" {
@@ -52,22 +108,44 @@ func normalizeOpenAIResponsesRequest(store ConfigReader, req map[string]any) (ut
 		return util.StandardRequest{}, fmt.Errorf("Model '%s' is not available.", model)
 	}
 	thinkingEnabled, searchEnabled, _ := config.GetModelConfig(resolvedModel)
+	thinkingEnabled, searchEnabled, err := applyReasoningEffort(store, responsesReasoningEffort(req["reasoning"]), "reasoning.effort", thinkingEnabled, searchEnabled)
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
 
-	// Keep width-control as an explicit policy hook even if current default is true.
-	allowWideInput := true
-	if store != nil {
-		allowWideInput = store.CompatWideInputStrictOutput()
-	}
-	var messagesRaw []any
-	if allowWideInput {
-		messagesRaw = responsesMessagesFromRequest(req)
-	} else if msgs, ok := req["messages"].([]any); ok && len(msgs) > 0 {
-		messagesRaw = msgs
-	}
-	if len(messagesRaw) == 0 {
+	input := responsesTurnInput(store, req)
+	if len(input) == 0 {
 		return util.StandardRequest{}, fmt.Errorf("Request must include 'input' or 'messages'.")
 	}
-	finalPrompt, toolNames := buildOpenAIFinalPrompt(messagesRaw, req["tools"])
+	messagesRaw := input
+	if len(history) > 0 {
+		messagesRaw = make([]any, 0, len(history)+len(input))
+		messagesRaw = append(messagesRaw, history...)
+		messagesRaw = append(messagesRaw, input...)
+	}
+	if responsesWideInput(store) {
+		messagesRaw = prependInstructionMessage(messagesRaw, req["instructions"])
+	}
+	format, err := parseResponsesTextFormat(req["text"])
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
+	toolPolicy, err := parseOpenAIToolPolicy(req)
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
+	tools, webSearch := splitWebSearchTools(req["tools"])
+	if webSearch {
+		searchEnabled = true
+	}
+	finalPrompt, toolNames := buildOpenAIFinalPrompt(messagesRaw, tools, format, toolPolicy)
+	if err := checkToolPolicy(toolPolicy, toolNames); err != nil {
+		return util.StandardRequest{}, err
+	}
+	limits, err := parseOpenAIOutputLimits(req, "max_output_tokens")
+	if err != nil {
+		return util.StandardRequest{}, err
+	}
 	passThrough := collectOpenAIChatPassThrough(req)
 
 	return util.StandardRequest{
@@ -78,23 +156,99 @@ func normalizeOpenAIResponsesRequest(store ConfigReader, req map[string]any) (ut
 		Messages:       messagesRaw,
 		FinalPrompt:    finalPrompt,
 		ToolNames:      toolNames,
+		ToolPolicy:     toolPolicy,
 		Stream:         util.ToBool(req["stream"]),
 		Thinking:       thinkingEnabled,
 		Search:         searchEnabled,
+		ResponseFormat: format,
+		Limits:         limits,
 		PassThrough:    passThrough,
 	}, nil
 }
 
+// completionRequest is a legacy completions request. Every prompt is sent in
+// raw-prompt mode and yields N choices; choice i answers prompt i / N.
+type completionRequest struct {
+	Std util.StandardRequest
+	// Prompts are the prompts as given, repeated ahead of the output when
+	// Echo is set; FinalPrompts are what is sent upstream.
+	Prompts      []string
+	FinalPrompts []string
+	Echo         bool
+}
+
+// choiceRequest is the upstream request of choice i.
+func (c completionRequest) choiceRequest(i int) util.StandardRequest {
+	std := c.Std
+	std.FinalPrompt = c.FinalPrompts[i/c.Std.N]
+	return std
+}
+
+func (c completionRequest) promptOf(i int) string {
+	return c.Prompts[i/c.Std.N]
+}
+
+func normalizeOpenAICompletionRequest(store ConfigReader, req map[string]any) (completionRequest, error) {
+	model, _ := req["model"].(string)
+	model = strings.TrimSpace(model)
+	if model == "" {
+		return completionRequest{}, fmt.Errorf("Request must include 'model' and 'prompt'.")
+	}
+	prompts, err := parseCompletionPrompts(req["prompt"])
+	if err != nil {
+		return completionRequest{}, err
+	}
+	resolvedModel, ok := config.ResolveModel(store, model)
+	if !ok {
+		return completionRequest{}, fmt.Errorf("Model '%s' is not available.", model)
+	}
+	thinkingEnabled, searchEnabled, _ := config.GetModelConfig(resolvedModel)
+	n, err := parseChoiceCount(store, req)
+	if err != nil {
+		return completionRequest{}, err
+	}
+	// Every prompt gets n choices of its own, so the cap applies to both.
+	if maxChoices := maxChoiceCount(store); len(prompts)*n > maxChoices {
+		return completionRequest{}, fmt.Errorf("'prompt' and 'n' ask for %d choices; at most %d are allowed.", len(prompts)*n, maxChoices)
+	}
+	suffix, _ := req["suffix"].(string)
+	finalPrompts := make([]string, len(prompts))
+	for i, p := range prompts {
+		finalPrompts[i] = buildRawCompletionPrompt(p, suffix)
+	}
+	limits, err := parseOpenAIOutputLimits(req, "max_tokens")
+	if err != nil {
+		return completionRequest{}, err
+	}
+
+	return completionRequest{
+		Std: util.StandardRequest{
+			Surface:        "openai_completions",
+			RequestedModel: model,
+			ResolvedModel:  resolvedModel,
+			ResponseModel:  model,
+			FinalPrompt:    finalPrompts[0],
+			RawPrompt:      true,
+			Stream:         util.ToBool(req["stream"]),
+			Thinking:       thinkingEnabled,
+			Search:         searchEnabled,
+			N:              n,
+			Limits:         limits,
+			PassThrough:    collectOpenAIChatPassThrough(req),
+		},
+		Prompts:      prompts,
+		FinalPrompts: finalPrompts,
+		Echo:         util.ToBool(req["echo"]),
+	}, nil
+}
+
 func collectOpenAIChatPassThrough(req map[string]any) map[string]any {
 	out := map[string]any{}
 	for _, k := range []string{
 		"temperature",
 		"top_p",
-		"max_tokens",
-		"max_completion_tokens",
 		"presence_penalty",
 		"frequency_penalty",
-		"stop",
 	} {
 		if v, ok := req[k]; ok {
 			out[k] = v
@@ -47,7 +47,7 @@ func TestNormalizeOpenAIResponsesRequestInput(t *testing.T) {
 		"input":        "ping",
 		"instructions": "system",
 	}
-	n, err := normalizeOpenAIResponsesRequest(store, req)
+	n, err := normalizeOpenAIResponsesRequest(store, req, nil)
 	if err != nil {
 		t.Fatalf("normalize failed: %v", err)
 	}
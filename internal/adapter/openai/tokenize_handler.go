@@ -0,0 +1,68 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ds2api/internal/config"
+	"ds2api/internal/tokenizer"
+	"ds2api/internal/util"
+)
+
+// Tokenize counts the tokens of `input`, or of the prompt that `messages`
+// (with optional `tools`) would produce for a chat completion.
+func (h *Handler) Tokenize(w http.ResponseWriter, r *http.Request) {
+	// Tokenizing is local, so the caller is checked without leasing an account.
+	if _, err := h.Auth.DetermineCaller(r); err != nil {
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
+		return
+	}
+
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	model, _ := req["model"].(string)
+	model = strings.TrimSpace(model)
+	if model == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "Request must include 'model'.")
+		return
+	}
+	if _, ok := config.ResolveModel(h.Store, model); !ok {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("Model '%s' is not available.", model))
+		return
+	}
+
+	var text string
+	if _, ok := req["messages"]; ok {
+		stdReq, err := normalizeOpenAIChatRequest(h.Store, req)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		text = stdReq.FinalPrompt
+	} else if input, ok := req["input"].(string); ok {
+		text = input
+	} else {
+		writeOpenAIError(w, http.StatusBadRequest, "Request must include string 'input' or 'messages'.")
+		return
+	}
+
+	out := map[string]any{
+		"object":    "tokenize",
+		"model":     model,
+		"tokenizer": "estimate",
+		"count":     util.CountTokens(text),
+		"tokens":    nil,
+	}
+	if tok := tokenizer.Default(); tok != nil {
+		ids := tok.Encode(text)
+		out["tokenizer"] = tokenizer.Name
+		out["count"] = len(ids)
+		out["tokens"] = ids
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,90 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"ds2api/internal/tokenizer"
+	"ds2api/internal/util"
+)
+
+func postTokenize(t *testing.T, h *Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	req := httptest.NewRequest(http.MethodPost, "/v1/tokenize", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer direct-token")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+// installLetterTokenizer installs a vocabulary of single lowercase letters
+// and the byte-level space symbol, so every such character is one token.
+func installLetterTokenizer(t *testing.T) {
+	t.Helper()
+	vocab := []string{`"Ġ":0`}
+	for i, c := range "abcdefghijklmnopqrstuvwxyz" {
+		vocab = append(vocab, `"`+string(c)+`":`+string(rune('1'+i%9))+string(rune('0'+i/9)))
+	}
+	tok, err := tokenizer.Parse(strings.NewReader(`{"model":{"type":"BPE","vocab":{` + strings.Join(vocab, ",") + `},"merges":[]}}`))
+	if err != nil {
+		t.Fatalf("parse tokenizer: %v", err)
+	}
+	tokenizer.SetDefault(tok)
+	t.Cleanup(func() { tokenizer.SetDefault(nil) })
+}
+
+func TestTokenizeFallsBackToEstimate(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	rec := postTokenize(t, &Handler{Store: store, Auth: resolver}, `{"model":"deepseek-chat","input":"hello world"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d body=%s", rec.Code, rec.Body.String())
+	}
+	body := decodeJSONBody(t, rec.Body.String())
+	if body["tokenizer"] != "estimate" || body["count"] != float64(util.EstimateTokens("hello world")) || body["tokens"] != nil {
+		t.Fatalf("unexpected estimate body: %#v", body)
+	}
+}
+
+func TestTokenizeWithVocabularyAndMessages(t *testing.T) {
+	installLetterTokenizer(t)
+	store, resolver := newDirectTokenResolver(t)
+	h := &Handler{Store: store, Auth: resolver}
+
+	body := decodeJSONBody(t, postTokenize(t, h, `{"model":"deepseek-chat","input":"hi there"}`).Body.String())
+	tokens, _ := body["tokens"].([]any)
+	if body["tokenizer"] != tokenizer.Name || body["count"] != float64(8) || len(tokens) != 8 {
+		t.Fatalf("unexpected tokenize body: %#v", body)
+	}
+
+	chat := `{"model":"deepseek-chat","messages":[{"role":"user","content":"hi"}]}`
+	body = decodeJSONBody(t, postTokenize(t, h, chat).Body.String())
+	if body["count"] != float64(2) {
+		t.Fatalf("expected the chat prompt to be counted, got %#v", body)
+	}
+
+	if rec := postTokenize(t, h, `{"model":"deepseek-chat","input":["a"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for non-string input, got %d", rec.Code)
+	}
+}
+
+func TestTokenizeDoesNotLeaseAnAccount(t *testing.T) {
+	store, resolver := newManagedKeyResolver(t)
+	busy := authForToken(t, resolver, "managed-key")
+	defer resolver.Release(busy)
+
+	r := chi.NewRouter()
+	RegisterRoutes(r, &Handler{Store: store, Auth: resolver})
+	req := httptest.NewRequest(http.MethodPost, "/v1/tokenize", strings.NewReader(`{"model":"deepseek-chat","input":"hi"}`))
+	req.Header.Set("Authorization", "Bearer managed-key")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected tokenize to ignore the busy pool, got %d body=%s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,102 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"ds2api/internal/auth"
+	"ds2api/internal/deepseek"
+	"ds2api/internal/sse"
+	"ds2api/internal/util"
+)
+
+// parseOpenAIToolPolicy reads `tool_choice` and `parallel_tool_calls`. Both the
+// Chat Completions shape {"type":"function","function":{"name":...}} and the
+// Responses shape {"type":"function","name":...} are accepted.
+func parseOpenAIToolPolicy(req map[string]any) (util.ToolPolicy, error) {
+	policy := util.ToolPolicy{}
+	if v, ok := req["parallel_tool_calls"].(bool); ok && !v {
+		policy.Single = true
+	}
+	switch choice := req["tool_choice"].(type) {
+	case nil:
+	case string:
+		switch strings.TrimSpace(choice) {
+		case "", util.ToolChoiceAuto:
+		case util.ToolChoiceNone, util.ToolChoiceRequired:
+			policy.Choice = strings.TrimSpace(choice)
+		default:
+			return util.ToolPolicy{}, fmt.Errorf("Unsupported tool_choice '%s'.", choice)
+		}
+	case map[string]any:
+		typ, _ := choice["type"].(string)
+		switch strings.TrimSpace(typ) {
+		case util.ToolChoiceAuto:
+		case util.ToolChoiceNone, util.ToolChoiceRequired:
+			policy.Choice = strings.TrimSpace(typ)
+		case util.ToolChoiceFunction:
+			name, _ := choice["name"].(string)
+			if fn, ok := choice["function"].(map[string]any); ok && strings.TrimSpace(name) == "" {
+				name, _ = fn["name"].(string)
+			}
+			if strings.TrimSpace(name) == "" {
+				return util.ToolPolicy{}, fmt.Errorf("'tool_choice' of type function must name a function.")
+			}
+			policy.Choice = util.ToolChoiceFunction
+			policy.Function = strings.TrimSpace(name)
+		default:
+			return util.ToolPolicy{}, fmt.Errorf("Unsupported tool_choice type '%s'.", typ)
+		}
+	default:
+		return util.ToolPolicy{}, fmt.Errorf("'tool_choice' must be a string or an object.")
+	}
+	return policy, nil
+}
+
+// checkToolPolicy rejects a tool_choice that the offered tools cannot satisfy.
+func checkToolPolicy(policy util.ToolPolicy, toolNames []string) error {
+	if !policy.RequiresCall() {
+		return nil
+	}
+	if len(toolNames) == 0 {
+		if policy.Choice == util.ToolChoiceFunction {
+			return fmt.Errorf("tool_choice function '%s' is not defined in 'tools'.", policy.Function)
+		}
+		return fmt.Errorf("tool_choice '%s' requires 'tools'.", policy.Choice)
+	}
+	return nil
+}
+
+// detectToolCalls returns the tool calls in final model text that the tool
+// policy admits. Output held back for a finalizer only counts as a call when
+// it names a declared tool, so JSON answers are not mistaken for calls.
+func detectToolCalls(text string, toolNames []string, policy util.ToolPolicy, held bool) []util.ParsedToolCall {
+	if held && !formatOutputIsToolCall(text, toolNames) {
+		return nil
+	}
+	return policy.Filter(util.ParseToolCalls(text, toolNames), toolNames)
+}
+
+// retryForToolCall asks the model once more when tool_choice demanded a call
+// and the reply had none. The original text is kept if the retry fails or
+// still does not call a tool. The retry is held to the request's stop
+// sequences and token limit, and counted into usage.
+func (h *Handler) retryForToolCall(ctx context.Context, a *auth.RequestAuth, stdReq util.StandardRequest, text string, usage *util.UpstreamUsage) string {
+	retryReq := stdReq
+	retryReq.FinalPrompt = stdReq.FinalPrompt + deepseek.MessagesPrepare([]map[string]any{
+		{"role": "assistant", "content": text},
+		{"role": "user", "content": stdReq.ToolPolicy.RetryPrompt()},
+	})
+	resp, callErr := h.startChoice(ctx, a, retryReq)
+	if callErr != nil {
+		return text
+	}
+	result := sse.CollectStreamLimited(resp, stdReq.Thinking, true, util.NewOutputLimiter(stdReq.Limits))
+	if len(detectToolCalls(result.Text, stdReq.ToolNames, stdReq.ToolPolicy, false)) == 0 {
+		usage.AddRetry(retryReq.FinalPrompt, result.Thinking, result.Text)
+		return text
+	}
+	usage.AddRetry(retryReq.FinalPrompt, result.Thinking, text)
+	return result.Text
+}
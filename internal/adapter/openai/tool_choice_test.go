@@ -0,0 +1,189 @@
+package openai
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"ds2api/internal/util"
+)
+
+const weatherTools = `"tools":[
+	{"type":"function","function":{"name":"get_weather","parameters":{"type":"object"}}},
+	{"type":"function","function":{"name":"get_time","parameters":{"type":"object"}}}
+]`
+
+func TestParseOpenAIToolPolicy(t *testing.T) {
+	cases := []struct {
+		req  map[string]any
+		want util.ToolPolicy
+	}{
+		{map[string]any{}, util.ToolPolicy{}},
+		{map[string]any{"tool_choice": "auto"}, util.ToolPolicy{}},
+		{map[string]any{"tool_choice": "none"}, util.ToolPolicy{Choice: util.ToolChoiceNone}},
+		{map[string]any{"tool_choice": "required", "parallel_tool_calls": false}, util.ToolPolicy{Choice: util.ToolChoiceRequired, Single: true}},
+		{map[string]any{"tool_choice": map[string]any{"type": "function", "function": map[string]any{"name": "get_time"}}}, util.ToolPolicy{Choice: util.ToolChoiceFunction, Function: "get_time"}},
+		{map[string]any{"tool_choice": map[string]any{"type": "function", "name": "get_time"}}, util.ToolPolicy{Choice: util.ToolChoiceFunction, Function: "get_time"}},
+	}
+	for _, tc := range cases {
+		got, err := parseOpenAIToolPolicy(tc.req)
+		if err != nil || got != tc.want {
+			t.Fatalf("parseOpenAIToolPolicy(%v) = %#v, %v; want %#v", tc.req, got, err, tc.want)
+		}
+	}
+	for _, bad := range []any{"always", map[string]any{"type": "function"}, 3.0} {
+		if _, err := parseOpenAIToolPolicy(map[string]any{"tool_choice": bad}); err == nil {
+			t.Fatalf("expected tool_choice %v to be rejected", bad)
+		}
+	}
+}
+
+func TestNormalizeOpenAIChatRequestToolChoicePrompt(t *testing.T) {
+	cfg := mockOpenAIConfig{aliases: map[string]string{}}
+	tools := []any{
+		map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}},
+		map[string]any{"type": "function", "function": map[string]any{"name": "get_time"}},
+	}
+	base := func(choice any) map[string]any {
+		return map[string]any{
+			"model":       "deepseek-chat",
+			"messages":    []any{map[string]any{"role": "user", "content": "hi"}},
+			"tools":       tools,
+			"tool_choice": choice,
+		}
+	}
+	out, err := normalizeOpenAIChatRequest(cfg, base(map[string]any{"type": "function", "function": map[string]any{"name": "get_time"}}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out.ToolNames) != 1 || out.ToolNames[0] != "get_time" || strings.Contains(out.FinalPrompt, "get_weather") {
+		t.Fatalf("expected only the forced tool to be offered, names=%v", out.ToolNames)
+	}
+	if !strings.Contains(out.FinalPrompt, `You MUST call the tool "get_time"`) {
+		t.Fatalf("expected forced tool instruction in prompt")
+	}
+
+	out, err = normalizeOpenAIChatRequest(cfg, base("none"))
+	if err != nil || len(out.ToolNames) != 0 || strings.Contains(out.FinalPrompt, "You have access to these tools") {
+		t.Fatalf("expected no tools offered for tool_choice none, names=%v err=%v", out.ToolNames, err)
+	}
+
+	if _, err := normalizeOpenAIChatRequest(cfg, base(map[string]any{"type": "function", "function": map[string]any{"name": "missing"}})); err == nil {
+		t.Fatal("expected unknown forced function to be rejected")
+	}
+}
+
+func TestChatToolChoiceRequiredRetriesOnce(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	ds := &scriptedDS{replies: []string{
+		"I think it is sunny.",
+		`{"tool_calls":[{"name":"get_weather","input":{"city":"Paris"}}]}`,
+	}}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	rec := postChatChoices(t, h, `{"model":"deepseek-chat","tool_choice":"required",`+weatherTools+`,"messages":[{"role":"user","content":"weather?"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+	if len(ds.prompts) != 2 || !strings.Contains(ds.prompts[1], "did not call a tool") {
+		t.Fatalf("expected one retry with a tool call reminder, prompts=%d", len(ds.prompts))
+	}
+	choice := decodeJSONBody(t, rec.Body.String())["choices"].([]any)[0].(map[string]any)
+	if choice["finish_reason"] != "tool_calls" {
+		t.Fatalf("expected tool_calls finish reason, got %#v", choice)
+	}
+}
+
+func TestChatToolChoiceRetryIsLimitedAndCounted(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	first, retry := "I think it is sunny.", `{"tool_calls":[{"name":"get_weather","input":{"city":"Paris"}}]}`
+	ds := &scriptedDS{replies: []string{first, retry}}
+	h := &Handler{Store: store, Auth: resolver, DS: ds}
+
+	rec := postChatChoices(t, h, `{"model":"deepseek-chat","tool_choice":"required",`+weatherTools+`,"messages":[{"role":"user","content":"weather?"}]}`)
+	usage := decodeJSONBody(t, rec.Body.String())["usage"].(map[string]any)
+	if got, want := int(usage["prompt_tokens"].(float64)), util.CountTokens(ds.prompts[0])+util.CountTokens(ds.prompts[1]); got != want {
+		t.Fatalf("expected the retry prompt counted, prompt_tokens=%d want %d", got, want)
+	}
+	if got, want := int(usage["completion_tokens"].(float64)), util.CountTokens(first)+util.CountTokens(retry); got != want {
+		t.Fatalf("expected both replies counted, completion_tokens=%d want %d", got, want)
+	}
+
+	h.DS = &scriptedDS{replies: []string{first, retry}}
+	rec = postChatChoices(t, h, `{"model":"deepseek-chat","tool_choice":"required","stop":["Paris"],`+weatherTools+`,"messages":[{"role":"user","content":"weather?"}]}`)
+	choice := decodeJSONBody(t, rec.Body.String())["choices"].([]any)[0].(map[string]any)
+	if choice["message"].(map[string]any)["content"] != first {
+		t.Fatalf("expected the retry cut at the stop sequence and the first reply kept, got %#v", choice)
+	}
+}
+
+func TestChatParallelToolCallsFalseKeepsFirstCall(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	twoCalls := `{"tool_calls":[{"name":"get_weather","input":{}},{"name":"get_time","input":{}}]}`
+	body := `{"model":"deepseek-chat","parallel_tool_calls":false,` + weatherTools + `,"messages":[{"role":"user","content":"both"}]}`
+
+	h := &Handler{Store: store, Auth: resolver, DS: &scriptedDS{replies: []string{twoCalls}}}
+	choice := decodeJSONBody(t, postChatChoices(t, h, body).Body.String())["choices"].([]any)[0].(map[string]any)
+	calls, _ := choice["message"].(map[string]any)["tool_calls"].([]any)
+	if len(calls) != 1 {
+		t.Fatalf("expected a single tool call, got %#v", choice["message"])
+	}
+
+	h.DS = &scriptedDS{replies: []string{twoCalls}}
+	rec := postChatChoices(t, h, strings.Replace(body, `"model":"deepseek-chat",`, `"model":"deepseek-chat","stream":true,`, 1))
+	frames, _ := parseSSEDataFrames(t, rec.Body.String())
+	streamed := 0
+	for _, frame := range frames {
+		for _, item := range frame["choices"].([]any) {
+			delta, _ := item.(map[string]any)["delta"].(map[string]any)
+			if tcs, ok := delta["tool_calls"].([]any); ok {
+				for _, tc := range tcs {
+					if fn, _ := tc.(map[string]any)["function"].(map[string]any); fn["name"] != nil {
+						streamed++
+					}
+				}
+			}
+		}
+	}
+	if streamed != 1 {
+		t.Fatalf("expected one streamed tool call, got %d body=%s", streamed, rec.Body.String())
+	}
+}
+
+func TestChatToolChoiceNoneKeepsToolJSONAsContent(t *testing.T) {
+	store, resolver := newDirectTokenResolver(t)
+	reply := `{"tool_calls":[{"name":"get_weather","input":{}}]}`
+	h := &Handler{Store: store, Auth: resolver, DS: &scriptedDS{replies: []string{reply}}}
+
+	rec := postChatChoices(t, h, `{"model":"deepseek-chat","tool_choice":"none",`+weatherTools+`,"messages":[{"role":"user","content":"hi"}]}`)
+	choice := decodeJSONBody(t, rec.Body.String())["choices"].([]any)[0].(map[string]any)
+	if choice["finish_reason"] != "stop" || choice["message"].(map[string]any)["content"] != reply {
+		t.Fatalf("expected plain content with tool_choice none, got %#v", choice)
+	}
+}
+
+func TestChatStreamToolPolicyHoldsEarlyToolDeltas(t *testing.T) {
+	reply := `{"tool_calls":[{"name":"get_weather","input":{"city":"Paris"}},{"name":"get_time","input":{"zone":"CET"}}]}`
+	streamedNames := func(policy util.ToolPolicy) []string {
+		rt := newChatStreamRuntime(nil, nil, false, "chatcmpl-test", 0, "deepseek-chat", "prompt", false, false, []string{"get_weather", "get_time"}, true, true)
+		rt.toolPolicy = policy
+		var names []string
+		for i := 0; i < len(reply); i += 8 {
+			for _, choice := range rt.appendText(reply[i:min(i+8, len(reply))]) {
+				calls, _ := choice["delta"].(map[string]any)["tool_calls"].([]map[string]any)
+				for _, call := range calls {
+					if fn, _ := call["function"].(map[string]any); fn["name"] != nil {
+						names = append(names, fn["name"].(string))
+					}
+				}
+			}
+		}
+		return names
+	}
+	if got := streamedNames(util.ToolPolicy{}); len(got) != 2 {
+		t.Fatalf("expected both calls to stream under the default policy, got %v", got)
+	}
+	if got := streamedNames(util.ToolPolicy{Single: true}); len(got) != 1 || got[0] != "get_weather" {
+		t.Fatalf("expected only the first call with parallel tool calls disabled, got %v", got)
+	}
+}
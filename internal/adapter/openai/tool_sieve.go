@@ -17,6 +17,9 @@ type toolStreamSieveState struct {
 	toolArgsSent   int
 	toolArgsString bool
 	toolArgsDone   bool
+	// holdDeltas turns incremental deltas off, so every call arrives whole
+	// in ToolCalls where the caller can still reject it.
+	holdDeltas bool
 }
 
 type toolStreamEvent struct {
@@ -58,8 +61,10 @@ func processToolSieveChunk(state *toolStreamSieveState, chunk string, toolNames
 				state.capture.WriteString(state.pending.String())
 				state.pending.Reset()
 			}
-			if deltas := buildIncrementalToolDeltas(state); len(deltas) > 0 {
-				events = append(events, toolStreamEvent{ToolCallDeltas: deltas})
+			if !state.holdDeltas {
+				if deltas := buildIncrementalToolDeltas(state); len(deltas) > 0 {
+					events = append(events, toolStreamEvent{ToolCallDeltas: deltas})
+				}
 			}
 			prefix, calls, suffix, ready := consumeToolCapture(state, toolNames)
 			if !ready {
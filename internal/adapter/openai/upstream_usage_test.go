@@ -0,0 +1,62 @@
+package openai
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"ds2api/internal/util"
+)
+
+func TestHandleNonStreamPrefersUpstreamUsage(t *testing.T) {
+	h := &Handler{}
+	resp := makeSSEHTTPResponse(
+		`data: {"p":"response/thinking_content","v":"hmm"}`,
+		`data: {"p":"response/thinking_elapsed_secs","v":2.5}`,
+		`data: {"p":"response/content","v":"Hello"}`,
+		`data: {"p":"response","o":"BATCH","v":[{"p":"accumulated_token_usage","v":57},{"p":"quasi_status","v":"FINISHED"}]}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	h.handleNonStream(rec, resp, "cid-usage", util.StandardRequest{ResponseModel: "deepseek-reasoner", FinalPrompt: "prompt", Thinking: true}, nil)
+
+	usage := decodeJSONBody(t, rec.Body.String())["usage"].(map[string]any)
+	details, _ := usage["completion_tokens_details"].(map[string]any)
+	if usage["completion_tokens"] != float64(57) || details["reasoning_seconds"] != 2.5 {
+		t.Fatalf("expected upstream usage, got %#v", usage)
+	}
+	if usage["total_tokens"] != float64(57+util.CountTokens("prompt")) {
+		t.Fatalf("unexpected total: %#v", usage)
+	}
+}
+
+func TestHandleNonStreamEstimatesUsageWhenUpstreamSilent(t *testing.T) {
+	h := &Handler{}
+	resp := makeSSEHTTPResponse(`data: {"p":"response/content","v":"Hello"}`, `data: [DONE]`)
+	rec := httptest.NewRecorder()
+	h.handleNonStream(rec, resp, "cid-usage", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt"}, nil)
+
+	usage := decodeJSONBody(t, rec.Body.String())["usage"].(map[string]any)
+	details, _ := usage["completion_tokens_details"].(map[string]any)
+	if usage["completion_tokens"] != float64(util.CountTokens("Hello")) {
+		t.Fatalf("expected estimated completion tokens, got %#v", usage)
+	}
+	if _, ok := details["reasoning_seconds"]; ok {
+		t.Fatalf("expected no reasoning_seconds without upstream timing: %#v", details)
+	}
+}
+
+func TestHandleResponsesNonStreamUpstreamUsageIgnoredWhenCut(t *testing.T) {
+	h := &Handler{}
+	resp := makeSSEHTTPResponse(
+		`data: {"p":"response/accumulated_token_usage","v":90}`,
+		`data: {"p":"response/content","v":"a long long answer"}`,
+		`data: [DONE]`,
+	)
+	rec := httptest.NewRecorder()
+	h.handleResponsesNonStream(rec, resp, responsesTurn{owner: "owner-a"}, "resp_usage", util.StandardRequest{ResponseModel: "deepseek-chat", FinalPrompt: "prompt", Limits: util.OutputLimits{MaxTokens: 2}}, nil)
+
+	usage := decodeJSONBody(t, rec.Body.String())["usage"].(map[string]any)
+	if usage["output_tokens"] != float64(util.CountTokens("a long long")) {
+		t.Fatalf("expected the delivered text to be counted locally, got %#v", usage)
+	}
+}
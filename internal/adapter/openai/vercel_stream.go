@@ -42,11 +42,7 @@ func (h *Handler) handleVercelStreamPrepare(w http.ResponseWriter, r *http.Reque
 
 	a, err := h.Auth.Determine(r)
 	if err != nil {
-		status := http.StatusUnauthorized
-		if err == auth.ErrNoAccount {
-			status = http.StatusTooManyRequests
-		}
-		writeOpenAIError(w, status, err.Error())
+		writeOpenAIError(w, openAIAuthStatus(err), err.Error())
 		return
 	}
 	leased := false
@@ -75,19 +71,29 @@ func (h *Handler) handleVercelStreamPrepare(w http.ResponseWriter, r *http.Reque
 		writeOpenAIError(w, http.StatusBadRequest, "stream must be true")
 		return
 	}
+	if stdReq.N > 1 {
+		writeOpenAIError(w, http.StatusBadRequest, "'n' greater than 1 is not supported on the Vercel streaming path.")
+		return
+	}
+	if stdReq.ResponseFormat != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "'response_format' is not supported on the Vercel streaming path.")
+		return
+	}
+	if !stdReq.ToolPolicy.IsDefault() {
+		writeOpenAIError(w, http.StatusBadRequest, "'tool_choice' and 'parallel_tool_calls' are not supported on the Vercel streaming path.")
+		return
+	}
 
 	sessionID, err := h.DS.CreateSession(r.Context(), a, 3)
 	if err != nil {
-		if a.UseConfigToken {
-			writeOpenAIError(w, http.StatusUnauthorized, "Account token is invalid. Please re-login the account in admin.")
-		} else {
-			writeOpenAIError(w, http.StatusUnauthorized, "Invalid token. If this should be a DS2API key, add it to config.keys first.")
-		}
+		callErr := upstreamCallError(err, invalidTokenError(a))
+		writeOpenAIError(w, callErr.status, callErr.message)
 		return
 	}
 	powHeader, err := h.DS.GetPow(r.Context(), a, 3)
 	if err != nil {
-		writeOpenAIError(w, http.StatusUnauthorized, "Failed to get PoW (invalid token or unknown error).")
+		callErr := upstreamCallError(err, &choiceCallError{http.StatusUnauthorized, "Failed to get PoW (invalid token or unknown error)."})
+		writeOpenAIError(w, callErr.status, callErr.message)
 		return
 	}
 	if strings.TrimSpace(a.DeepSeekToken) == "" {
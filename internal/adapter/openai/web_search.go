@@ -0,0 +1,43 @@
+package openai
+
+import (
+	"strings"
+
+	"ds2api/internal/auth"
+)
+
+// splitWebSearchTools separates the built-in web_search tool (also written
+// web_search_preview or with a dated suffix) from the function tools. The
+// built-in tool is served by upstream search rather than a tool prompt.
+func splitWebSearchTools(toolsRaw any) (any, bool) {
+	tools, ok := toolsRaw.([]any)
+	if !ok {
+		return toolsRaw, false
+	}
+	out := make([]any, 0, len(tools))
+	webSearch := false
+	for _, t := range tools {
+		if tool, ok := t.(map[string]any); ok && isWebSearchToolType(tool["type"]) {
+			webSearch = true
+			continue
+		}
+		out = append(out, t)
+	}
+	return out, webSearch
+}
+
+func isWebSearchToolType(v any) bool {
+	typ, _ := v.(string)
+	return strings.HasPrefix(strings.TrimSpace(typ), "web_search")
+}
+
+// wantSearchSources resolves whether a chat completion carries the search
+// progress and source extensions: the request's search_sources flag when
+// sent, else the caller key's default. Both are off unless configured, so
+// strict clients never see the extra fields.
+func wantSearchSources(req map[string]any, a *auth.RequestAuth) bool {
+	if v, ok := req["search_sources"].(bool); ok {
+		return v
+	}
+	return a != nil && a.SearchSources
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"ds2api/internal/config"
+)
+
+// auditConfigDiff lists the leaf-level differences between two configs with
+// secrets (API keys, passwords, tokens, password hashes) replaced by short
+// fingerprints. Keys are diffed as a set and accounts/users by identity so a
+// deletion does not show up as every later entry shifting by one.
+func auditConfigDiff(before, after config.Config) []AuditChange {
+	b := flattenAuditConfig(before)
+	a := flattenAuditConfig(after)
+	paths := make([]string, 0, len(b)+len(a))
+	for p := range b {
+		paths = append(paths, p)
+	}
+	for p := range a {
+		if _, ok := b[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	changes := make([]AuditChange, 0)
+	for _, p := range paths {
+		bv, bok := b[p]
+		av, aok := a[p]
+		if bok && aok && reflect.DeepEqual(bv, av) {
+			continue
+		}
+		changes = append(changes, AuditChange{Path: p, Before: bv, After: av})
+	}
+	return changes
+}
+
+func flattenAuditConfig(c config.Config) map[string]any {
+	out := map[string]any{}
+	for _, k := range c.Keys {
+		out["keys["+redactAuditSecret(k)+"]"] = true
+	}
+	for _, acc := range c.Accounts {
+		prefix := "accounts[" + acc.Identifier() + "]"
+		putAuditLeaf(out, prefix+".email", acc.Email)
+		putAuditLeaf(out, prefix+".mobile", acc.Mobile)
+		putAuditLeaf(out, prefix+".password", redactAuditSecret(acc.Password))
+		putAuditLeaf(out, prefix+".token", redactAuditSecret(acc.Token))
+	}
+	for _, u := range c.Admin.Users {
+		prefix := "admin.users[" + u.Name + "]"
+		putAuditLeaf(out, prefix+".role", u.Role)
+		putAuditLeaf(out, prefix+".password_hash", redactAuditSecret(u.PasswordHash))
+		putAuditLeaf(out, prefix+".totp_secret", redactAuditSecret(u.TOTPSecret))
+	}
+
+	for _, p := range c.KeyPolicies {
+		prefix := "key_policies[" + redactAuditSecret(p.Key) + "]"
+		putAuditList(out, prefix+".allowed_cidrs", p.AllowedCIDRs)
+		putAuditList(out, prefix+".client_cert_subjects", p.ClientCertSubjects)
+		putAuditList(out, prefix+".client_cert_fingerprints", p.ClientCertFingerprints)
+		if p.SearchSources {
+			putAuditLeaf(out, prefix+".search_sources", true)
+		}
+	}
+
+	rest := c.Clone()
+	rest.Keys = nil
+	rest.KeyPolicies = nil
+	rest.Accounts = nil
+	rest.Admin.Users = nil
+	rest.Admin.PasswordHash = redactAuditSecret(rest.Admin.PasswordHash)
+	rest.Admin.TOTPSecret = redactAuditSecret(rest.Admin.TOTPSecret)
+	rest.Reasoning.SignatureKey = redactAuditSecret(rest.Reasoning.SignatureKey)
+	raw, err := json.Marshal(rest)
+	if err != nil {
+		return out
+	}
+	var m map[string]any
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return out
+	}
+	flattenAuditValue(out, "", m)
+	return out
+}
+
+func flattenAuditValue(out map[string]any, prefix string, v any) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		putAuditLeaf(out, prefix, v)
+		return
+	}
+	for k, child := range m {
+		p := k
+		if prefix != "" {
+			p = prefix + "." + k
+		}
+		flattenAuditValue(out, p, child)
+	}
+}
+
+func putAuditList(out map[string]any, path string, v []string) {
+	if len(v) == 0 {
+		return
+	}
+	items := make([]any, len(v))
+	for i, s := range v {
+		items[i] = s
+	}
+	out[path] = items
+}
+
+func putAuditLeaf(out map[string]any, path string, v any) {
+	if s, ok := v.(string); ok && strings.TrimSpace(s) == "" {
+		return
+	}
+	if v == nil {
+		return
+	}
+	out[path] = v
+}
+
+func redactAuditSecret(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(v))
+	return "redacted:" + hex.EncodeToString(sum[:4])
+}
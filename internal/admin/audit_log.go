@@ -0,0 +1,224 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ds2api/internal/config"
+)
+
+// AuditEntry is one admin mutation: who did it, on which route, from where,
+// and the redacted config diff it produced.
+type AuditEntry struct {
+	ID      string        `json:"id"`
+	Time    int64         `json:"time"`
+	Subject string        `json:"subject"`
+	Role    string        `json:"role"`
+	IP      string        `json:"ip"`
+	Method  string        `json:"method"`
+	Route   string        `json:"route"`
+	Status  int           `json:"status"`
+	Changes []AuditChange `json:"changes,omitempty"`
+}
+
+type AuditChange struct {
+	Path   string `json:"path"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+type auditFilter struct {
+	Subject string
+	Route   string
+	Method  string
+	Since   int64
+	Until   int64
+}
+
+func (f auditFilter) match(e AuditEntry) bool {
+	if f.Subject != "" && e.Subject != f.Subject {
+		return false
+	}
+	if f.Route != "" && !strings.Contains(e.Route, f.Route) {
+		return false
+	}
+	if f.Method != "" && !strings.EqualFold(e.Method, f.Method) {
+		return false
+	}
+	if f.Since > 0 && e.Time < f.Since {
+		return false
+	}
+	if f.Until > 0 && e.Time > f.Until {
+		return false
+	}
+	return true
+}
+
+// AuditLog keeps audit entries in memory and, when a path is set, appends
+// them to a JSONL file. Entries past the retention limits are dropped and the
+// file is compacted once enough of it is stale.
+type AuditLog struct {
+	mu        sync.Mutex
+	path      string
+	entries   []AuditEntry
+	fileLines int
+}
+
+// NewAuditLog loads any existing entries from path. An empty path keeps the
+// log in memory only (e.g. on Vercel, where the filesystem is ephemeral).
+func NewAuditLog(path string) *AuditLog {
+	l := &AuditLog{path: strings.TrimSpace(path)}
+	if l.path == "" {
+		return l
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			config.Logger.Warn("[audit] load failed", "path", l.path, "error", err)
+		}
+		return l
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		l.fileLines++
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.ID == "" {
+			continue
+		}
+		l.entries = append(l.entries, e)
+	}
+	return l
+}
+
+func (l *AuditLog) Append(e AuditEntry, maxEntries int, maxAge time.Duration) {
+	if l == nil {
+		return
+	}
+	if e.ID == "" {
+		e.ID = "audit_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	}
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, e)
+	l.appendFileLocked(e)
+	if l.pruneLocked(time.Unix(e.Time, 0), maxEntries, maxAge) {
+		l.compactFileLocked(maxEntries)
+	}
+}
+
+// Query returns matching entries newest first, paginated.
+func (l *AuditLog) Query(f auditFilter, page, pageSize int) ([]AuditEntry, int) {
+	if l == nil {
+		return nil, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	matched := make([]AuditEntry, 0)
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if f.match(l.entries[i]) {
+			matched = append(matched, l.entries[i])
+		}
+	}
+	total := len(matched)
+	start := (page - 1) * pageSize
+	if start > total {
+		start = total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+	return matched[start:end], total
+}
+
+func (l *AuditLog) pruneLocked(now time.Time, maxEntries int, maxAge time.Duration) bool {
+	drop := 0
+	if maxAge > 0 {
+		cutoff := now.Add(-maxAge).Unix()
+		for drop < len(l.entries) && l.entries[drop].Time < cutoff {
+			drop++
+		}
+	}
+	if maxEntries > 0 && len(l.entries)-drop > maxEntries {
+		drop = len(l.entries) - maxEntries
+	}
+	if drop == 0 {
+		return false
+	}
+	l.entries = append([]AuditEntry(nil), l.entries[drop:]...)
+	return true
+}
+
+func (l *AuditLog) appendFileLocked(e AuditEntry) {
+	if l.path == "" {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		config.Logger.Warn("[audit] write failed", "path", l.path, "error", err)
+		return
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		config.Logger.Warn("[audit] write failed", "path", l.path, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		config.Logger.Warn("[audit] write failed", "path", l.path, "error", err)
+		return
+	}
+	l.fileLines++
+}
+
+// compactFileLocked rewrites the file with the retained entries once stale
+// lines outnumber a quarter of the limit, so pruning is amortized.
+func (l *AuditLog) compactFileLocked(maxEntries int) {
+	if l.path == "" {
+		return
+	}
+	slack := maxEntries / 4
+	if slack < 100 {
+		slack = 100
+	}
+	if l.fileLines-len(l.entries) < slack {
+		return
+	}
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		config.Logger.Warn("[audit] compact failed", "path", l.path, "error", err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range l.entries {
+		b, _ := json.Marshal(e)
+		_, _ = w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return
+	}
+	_ = f.Close()
+	if err := os.Rename(tmp, l.path); err != nil {
+		config.Logger.Warn("[audit] compact failed", "path", l.path, "error", err)
+		return
+	}
+	l.fileLines = len(l.entries)
+}
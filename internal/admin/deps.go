@@ -23,9 +23,20 @@ type ConfigStore interface {
 	AdminPasswordHash() string
 	AdminJWTExpireHours() int
 	AdminJWTValidAfterUnix() int64
+	AdminUsers() []config.AdminUser
+	AdminTOTPSecret() string
+	AdminAccessTokenMinutes() int
+	AdminLoginMaxFailures() int
+	AdminLoginLockoutSeconds() int
+	AuditMaxEntries() int
+	AuditRetentionDays() int
+	PassthroughMode() string
 	RuntimeAccountMaxInflight() int
 	RuntimeAccountMaxQueue(defaultSize int) int
 	RuntimeGlobalMaxInflight(defaultSize int) int
+	RuntimeMaxChoices() int
+	RuntimeBatchConcurrencyShare() int
+	ThinkingHistoryPolicy() string
 }
 
 type PoolController interface {
@@ -38,6 +49,10 @@ type StreamLeaseStatsProvider interface {
 	StreamLeaseStats() map[string]any
 }
 
+type PassthroughStatsProvider interface {
+	PassthroughStats() map[string]any
+}
+
 type DeepSeekCaller interface {
 	Login(ctx context.Context, acc config.Account) (string, error)
 	CreateSession(ctx context.Context, a *auth.RequestAuth, maxAttempts int) (string, error)
@@ -48,3 +63,4 @@ type DeepSeekCaller interface {
 var _ ConfigStore = (*config.Store)(nil)
 var _ PoolController = (*account.Pool)(nil)
 var _ DeepSeekCaller = (*deepseek.Client)(nil)
+var _ PassthroughStatsProvider = (*auth.Resolver)(nil)
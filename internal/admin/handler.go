@@ -1,41 +1,78 @@
 package admin
 
 import (
+	"sync"
+
 	"github.com/go-chi/chi/v5"
+
+	authn "ds2api/internal/auth"
 )
 
 type Handler struct {
-	Store      ConfigStore
-	Pool       PoolController
-	LeaseStats StreamLeaseStatsProvider
-	DS         DeepSeekCaller
+	Store       ConfigStore
+	Pool        PoolController
+	LeaseStats  StreamLeaseStatsProvider
+	Passthrough PassthroughStatsProvider
+	DS          DeepSeekCaller
+	Audit       *AuditLog
+	Sessions    *SessionStore
+
+	auditOnce    sync.Once
+	sessionsOnce sync.Once
+	throttleOnce sync.Once
+	throttle     *loginThrottle
+	totpMu       sync.Mutex
+	totpPending  map[string]pendingTOTP
 }
 
 func RegisterRoutes(r chi.Router, h *Handler) {
 	r.Post("/login", h.login)
+	r.Post("/refresh", h.refresh)
 	r.Get("/verify", h.verify)
 	r.Group(func(pr chi.Router) {
 		pr.Use(h.requireAdmin)
-		pr.Get("/vercel/config", h.getVercelConfig)
-		pr.Get("/config", h.getConfig)
-		pr.Post("/config", h.updateConfig)
-		pr.Get("/settings", h.getSettings)
-		pr.Put("/settings", h.updateSettings)
-		pr.Post("/settings/password", h.updateSettingsPassword)
-		pr.Post("/config/import", h.configImport)
-		pr.Get("/config/export", h.configExport)
-		pr.Post("/keys", h.addKey)
-		pr.Delete("/keys/{key}", h.deleteKey)
-		pr.Get("/accounts", h.listAccounts)
-		pr.Post("/accounts", h.addAccount)
-		pr.Delete("/accounts/{identifier}", h.deleteAccount)
-		pr.Get("/queue/status", h.queueStatus)
-		pr.Post("/accounts/test", h.testSingleAccount)
-		pr.Post("/accounts/test-all", h.testAllAccounts)
-		pr.Post("/import", h.batchImport)
-		pr.Post("/test", h.testAPI)
-		pr.Post("/vercel/sync", h.syncVercel)
-		pr.Get("/vercel/status", h.vercelStatus)
-		pr.Get("/export", h.exportConfig)
+		// Self-service routes available to every role.
+		pr.Post("/logout", h.logout)
+		pr.Get("/sessions", h.listSessions)
+		pr.Delete("/sessions/{id}", h.revokeSession)
+		pr.Post("/totp/enroll", h.enrollTOTP)
+		pr.With(h.audited).Post("/totp/confirm", h.confirmTOTP)
+		pr.With(h.audited).Post("/totp/disable", h.disableTOTP)
+		pr.Group(func(vr chi.Router) {
+			vr.Use(requireRole(authn.AdminRoleViewer))
+			vr.Get("/vercel/config", h.getVercelConfig)
+			vr.Get("/settings", h.getSettings)
+			vr.Get("/accounts", h.listAccounts)
+			vr.Get("/queue/status", h.queueStatus)
+			vr.Get("/vercel/status", h.vercelStatus)
+		})
+		pr.Group(func(opr chi.Router) {
+			opr.Use(requireRole(authn.AdminRoleOperator))
+			opr.Get("/config", h.getConfig)
+			opr.With(h.audited).Post("/config", h.updateConfig)
+			opr.With(h.audited).Post("/keys", h.addKey)
+			opr.With(h.audited).Delete("/keys/{key}", h.deleteKey)
+			opr.Get("/key-policies", h.listKeyPolicies)
+			opr.With(h.audited).Put("/key-policies", h.putKeyPolicy)
+			opr.With(h.audited).Post("/accounts", h.addAccount)
+			opr.With(h.audited).Delete("/accounts/{identifier}", h.deleteAccount)
+			opr.Post("/accounts/test", h.testSingleAccount)
+			opr.Post("/accounts/test-all", h.testAllAccounts)
+			opr.With(h.audited).Post("/import", h.batchImport)
+			opr.Post("/test", h.testAPI)
+		})
+		pr.Group(func(owr chi.Router) {
+			owr.Use(requireRole(authn.AdminRoleOwner))
+			owr.With(h.audited).Put("/settings", h.updateSettings)
+			owr.With(h.audited).Post("/settings/password", h.updateSettingsPassword)
+			owr.With(h.audited).Post("/config/import", h.configImport)
+			owr.Get("/config/export", h.configExport)
+			owr.With(h.audited).Post("/vercel/sync", h.syncVercel)
+			owr.Get("/export", h.exportConfig)
+			owr.Get("/users", h.listAdminUsers)
+			owr.With(h.audited).Post("/users", h.upsertAdminUser)
+			owr.With(h.audited).Delete("/users/{name}", h.deleteAdminUser)
+			owr.Get("/audit", h.listAudit)
+		})
 	})
 }
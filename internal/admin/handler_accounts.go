@@ -125,6 +125,9 @@ func (h *Handler) queueStatus(w http.ResponseWriter, _ *http.Request) {
 	if h.LeaseStats != nil {
 		status["stream_leases"] = h.LeaseStats.StreamLeaseStats()
 	}
+	if h.Passthrough != nil {
+		status["passthrough"] = h.Passthrough.PassthroughStats()
+	}
 	writeJSON(w, http.StatusOK, status)
 }
 
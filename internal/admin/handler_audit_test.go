@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	authn "ds2api/internal/auth"
+)
+
+func TestAuditRecordsRedactedConfigChanges(t *testing.T) {
+	h := newAdminTestHandler(t, `{
+		"keys":["k1"],
+		"accounts":[{"email":"a@test.com","password":"pw-secret","token":"tok-secret"}]
+	}`)
+	owner := authn.AdminIdentity{Subject: "admin", Role: authn.AdminRoleOwner}
+
+	if rec := adminRequestAs(t, h, owner, http.MethodPost, "/keys", map[string]any{"key": "super-secret-key"}); rec.Code != http.StatusOK {
+		t.Fatalf("add key: status=%d", rec.Code)
+	}
+	if rec := adminRequestAs(t, h, owner, http.MethodDelete, "/accounts/a@test.com", nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete account: status=%d", rec.Code)
+	}
+	// Reads are not audited.
+	_ = adminRequestAs(t, h, owner, http.MethodGet, "/accounts", nil)
+
+	rec := adminRequestAs(t, h, owner, http.MethodGet, "/audit", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list audit: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	raw := rec.Body.String()
+	for _, secret := range []string{"super-secret-key", "pw-secret", "tok-secret"} {
+		if strings.Contains(raw, secret) {
+			t.Fatalf("audit log leaked %q: %s", secret, raw)
+		}
+	}
+	var body struct {
+		Items []AuditEntry `json:"items"`
+		Total int          `json:"total"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if body.Total != 2 {
+		t.Fatalf("expected 2 entries, got %d: %s", body.Total, raw)
+	}
+	latest := body.Items[0]
+	if latest.Route != "/accounts/{identifier}" || latest.Method != http.MethodDelete || latest.Subject != "admin" || latest.Status != http.StatusOK {
+		t.Fatalf("unexpected latest entry: %#v", latest)
+	}
+	if len(latest.Changes) == 0 || !strings.HasPrefix(latest.Changes[0].Path, "accounts[a@test.com]") {
+		t.Fatalf("expected account removal diff, got %#v", latest.Changes)
+	}
+	keyEntry := body.Items[1]
+	if len(keyEntry.Changes) != 1 || keyEntry.Changes[0].After != true || keyEntry.Changes[0].Before != nil {
+		t.Fatalf("expected single key addition, got %#v", keyEntry.Changes)
+	}
+
+	rec = adminRequestAs(t, h, owner, http.MethodGet, "/audit?route=/keys", nil)
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if body.Total != 1 || body.Items[0].Route != "/keys" {
+		t.Fatalf("route filter mismatch: %s", rec.Body.String())
+	}
+}
+
+func TestAuditLogPersistsAndAppliesRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := NewAuditLog(path)
+	now := time.Now().Unix()
+	l.Append(AuditEntry{Time: now - 3*86400, Route: "/old"}, 10, 48*time.Hour)
+	l.Append(AuditEntry{Time: now, Route: "/a"}, 10, 48*time.Hour)
+	l.Append(AuditEntry{Time: now, Route: "/b"}, 10, 48*time.Hour)
+	items, total := l.Query(auditFilter{}, 1, 10)
+	if total != 2 || items[0].Route != "/b" || items[1].Route != "/a" {
+		t.Fatalf("unexpected retained entries: %#v", items)
+	}
+
+	l.Append(AuditEntry{Time: now, Route: "/c"}, 2, 48*time.Hour)
+	if _, total := l.Query(auditFilter{}, 1, 10); total != 2 {
+		t.Fatalf("expected max_entries cap of 2, got %d", total)
+	}
+
+	reloaded := NewAuditLog(path)
+	if _, total := reloaded.Query(auditFilter{Route: "/c"}, 1, 10); total != 1 {
+		t.Fatalf("expected entry persisted to disk")
+	}
+}
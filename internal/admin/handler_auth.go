@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,32 +13,225 @@ import (
 
 func (h *Handler) requireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := authn.VerifyAdminRequestWithStore(r, h.Store); err != nil {
+		// A bearer that is not a token this server signed is a guess at the
+		// admin key, so it shares the login lockout of the client address.
+		now := time.Now()
+		throttleKey := "ip:" + auditClientIP(r)
+		if wait := h.loginThrottle().lockedFor(now, throttleKey); wait > 0 && !h.bearerIsSignedToken(r) {
+			writeLoginLocked(w, wait)
+			return
+		}
+		id, err := authn.ResolveAdminRequest(r, h.Store)
+		if err != nil {
+			if err == authn.ErrInvalidAdminCredential {
+				h.failLogin(now, throttleKey)
+			}
 			writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": err.Error()})
 			return
 		}
-		next.ServeHTTP(w, r)
+		if id.SessionID != "" && !h.sessions().touch(id.SessionID, id.Subject) {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": "session revoked or expired"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(authn.WithAdminIdentity(r.Context(), id)))
 	})
 }
 
+// requireRole must run after requireAdmin.
+func requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := authn.AdminIdentityFromContext(r.Context())
+			if !ok || !id.Allows(role) {
+				writeJSON(w, http.StatusForbidden, map[string]any{"detail": "insufficient role: requires " + role})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 	var req map[string]any
 	_ = json.NewDecoder(r.Body).Decode(&req)
-	adminKey, _ := req["admin_key"].(string)
+	username, _ := req["username"].(string)
+	password, _ := req["password"].(string)
+	if adminKey, _ := req["admin_key"].(string); strings.TrimSpace(password) == "" {
+		password = adminKey
+	}
+	totpCode, _ := req["totp_code"].(string)
 	expireHours := intFrom(req["expire_hours"])
-	if !authn.VerifyAdminCredential(adminKey, h.Store) {
-		writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": "Invalid admin key"})
+
+	now := time.Now()
+	ip := auditClientIP(r)
+	// Only the client address is throttled: locking a subject would let
+	// anyone lock its owner out.
+	throttleKey := "ip:" + ip
+	if wait := h.loginThrottle().lockedFor(now, throttleKey); wait > 0 {
+		writeLoginLocked(w, wait)
 		return
 	}
-	token, err := authn.CreateJWTWithStore(expireHours, h.Store)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]any{"detail": err.Error()})
+	fail := func(detail string, extra map[string]any) {
+		h.failLogin(now, throttleKey)
+		body := map[string]any{"detail": detail}
+		for k, v := range extra {
+			body[k] = v
+		}
+		writeJSON(w, http.StatusUnauthorized, body)
+	}
+
+	id, ok := authn.AuthenticateAdminLogin(username, password, h.Store)
+	if !ok {
+		fail("Invalid admin key", nil)
 		return
 	}
+	// A missing code still counts as a failure; otherwise the distinct reply
+	// would be an unthrottled password oracle.
+	if secret := authn.AdminTOTPSecretFor(id.Subject, h.Store); secret != "" {
+		if strings.TrimSpace(totpCode) == "" {
+			fail("TOTP code required", map[string]any{"totp_required": true})
+			return
+		}
+		step, ok := authn.MatchTOTP(secret, totpCode, now)
+		if !ok || !h.loginThrottle().claimTOTP(id.Subject, step) {
+			fail("Invalid TOTP code", map[string]any{"totp_required": true})
+			return
+		}
+	}
+	h.loginThrottle().succeed(throttleKey)
+
 	if expireHours <= 0 {
 		expireHours = h.Store.AdminJWTExpireHours()
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"success": true, "token": token, "expires_in": expireHours * 3600})
+	sessionTTL := time.Duration(expireHours) * time.Hour
+	sess, refresh := h.sessions().create(id.Subject, ip, r.UserAgent(), sessionTTL)
+	id.SessionID = sess.ID
+	accessTTL := h.accessTokenTTL(sessionTTL)
+	token, err := authn.CreateAdminAccessToken(accessTTL, id, h.Store)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":            true,
+		"token":              token,
+		"expires_in":         int(accessTTL.Seconds()),
+		"refresh_token":      refresh,
+		"refresh_expires_in": int(sessionTTL.Seconds()),
+		"session_id":         sess.ID,
+		"subject":            id.Subject,
+		"role":               id.Role,
+	})
+}
+
+// refresh exchanges a refresh token for a new access token and rotates the
+// refresh token. Sessions older than the global logout cutoff are rejected.
+func (h *Handler) refresh(w http.ResponseWriter, r *http.Request) {
+	var req map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	refreshToken := fieldString(req, "refresh_token")
+	if refreshToken == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "refresh_token is required"})
+		return
+	}
+	sess, next, err := h.sessions().rotate(refreshToken)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": err.Error()})
+		return
+	}
+	if validAfter := h.Store.AdminJWTValidAfterUnix(); validAfter > 0 && sess.CreatedAt <= validAfter {
+		h.sessions().revoke(sess.ID)
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": "session revoked or expired"})
+		return
+	}
+	id, err := authn.AdminIdentityForSubject(sess.Subject, h.Store)
+	if err != nil {
+		h.sessions().revoke(sess.ID)
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": err.Error()})
+		return
+	}
+	id.SessionID = sess.ID
+	remaining := time.Until(time.Unix(sess.ExpiresAt, 0))
+	accessTTL := h.accessTokenTTL(remaining)
+	token, err := authn.CreateAdminAccessToken(accessTTL, id, h.Store)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":            true,
+		"token":              token,
+		"expires_in":         int(accessTTL.Seconds()),
+		"refresh_token":      next,
+		"refresh_expires_in": int(remaining.Seconds()),
+		"session_id":         sess.ID,
+		"subject":            id.Subject,
+		"role":               id.Role,
+	})
+}
+
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	id, _ := authn.AdminIdentityFromContext(r.Context())
+	if id.SessionID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "credential is not bound to a session"})
+		return
+	}
+	h.sessions().revoke(id.SessionID)
+	writeJSON(w, http.StatusOK, map[string]any{"success": true})
+}
+
+// failLogin counts a failed attempt against every throttle key.
+func (h *Handler) failLogin(now time.Time, keys ...string) {
+	lockout := time.Duration(h.Store.AdminLoginLockoutSeconds()) * time.Second
+	h.loginThrottle().fail(now, h.Store.AdminLoginMaxFailures(), lockout, keys...)
+}
+
+func writeLoginLocked(w http.ResponseWriter, wait time.Duration) {
+	secs := int(wait.Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeJSON(w, http.StatusTooManyRequests, map[string]any{"detail": "too many failed login attempts, try again later", "retry_after": secs})
+}
+
+// bearerIsSignedToken reports whether the bearer credential is a live token
+// this server signed; only those pass a lockout.
+func (h *Handler) bearerIsSignedToken(r *http.Request) bool {
+	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(authHeader) <= 7 || !strings.EqualFold(authHeader[:7], "bearer ") {
+		return false
+	}
+	_, err := authn.VerifyJWTWithStore(strings.TrimSpace(authHeader[7:]), h.Store)
+	return err == nil
+}
+
+// accessTokenTTL caps the access token lifetime at the session lifetime.
+// With a stateless session store the refresh token may reach an instance
+// that never saw the session, so the access token lasts the whole session.
+func (h *Handler) accessTokenTTL(sessionTTL time.Duration) time.Duration {
+	if h.sessions().stateless {
+		return sessionTTL
+	}
+	if minutes := h.Store.AdminAccessTokenMinutes(); minutes > 0 {
+		if ttl := time.Duration(minutes) * time.Minute; ttl < sessionTTL {
+			return ttl
+		}
+	}
+	return sessionTTL
+}
+
+func (h *Handler) sessions() *SessionStore {
+	h.sessionsOnce.Do(func() {
+		if h.Sessions == nil {
+			h.Sessions = NewSessionStore("")
+		}
+	})
+	return h.Sessions
+}
+
+func (h *Handler) loginThrottle() *loginThrottle {
+	h.throttleOnce.Do(func() {
+		h.throttle = newLoginThrottle()
+	})
+	return h.throttle
 }
 
 func (h *Handler) verify(w http.ResponseWriter, r *http.Request) {
@@ -52,12 +246,21 @@ func (h *Handler) verify(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": err.Error()})
 		return
 	}
+	id, err := authn.AdminIdentityFromClaims(payload, h.Store)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": err.Error()})
+		return
+	}
+	if id.SessionID != "" && !h.sessions().touch(id.SessionID, id.Subject) {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"detail": "session revoked or expired"})
+		return
+	}
 	exp, _ := payload["exp"].(float64)
 	remaining := int64(exp) - time.Now().Unix()
 	if remaining < 0 {
 		remaining = 0
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"valid": true, "expires_at": int64(exp), "remaining_seconds": remaining})
+	writeJSON(w, http.StatusOK, map[string]any{"valid": true, "expires_at": int64(exp), "remaining_seconds": remaining, "subject": id.Subject, "role": id.Role})
 }
 
 func (h *Handler) getVercelConfig(w http.ResponseWriter, _ *http.Request) {
@@ -15,8 +15,9 @@ import (
 func (h *Handler) getConfig(w http.ResponseWriter, _ *http.Request) {
 	snap := h.Store.Snapshot()
 	safe := map[string]any{
-		"keys":     snap.Keys,
-		"accounts": []map[string]any{},
+		"keys":         snap.Keys,
+		"key_policies": snap.KeyPolicies,
+		"accounts":     []map[string]any{},
 		"claude_mapping": func() map[string]string {
 			if len(snap.ClaudeMapping) > 0 {
 				return snap.ClaudeMapping
@@ -58,6 +59,7 @@ func (h *Handler) updateConfig(w http.ResponseWriter, r *http.Request) {
 	err := h.Store.Update(func(c *config.Config) error {
 		if keys, ok := toStringSlice(req["keys"]); ok {
 			c.Keys = keys
+			pruneKeyPolicies(c)
 		}
 		if accountsRaw, ok := req["accounts"].([]any); ok {
 			existing := map[string]config.Account{}
@@ -140,6 +142,7 @@ func (h *Handler) deleteKey(w http.ResponseWriter, r *http.Request) {
 			return fmt.Errorf("Key 不存在")
 		}
 		c.Keys = append(c.Keys[:idx], c.Keys[idx+1:]...)
+		pruneKeyPolicies(c)
 		return nil
 	})
 	if err != nil {
@@ -336,6 +339,18 @@ func (h *Handler) configImport(w http.ResponseWriter, r *http.Request) {
 			if strings.TrimSpace(incoming.Embeddings.Provider) != "" {
 				next.Embeddings.Provider = incoming.Embeddings.Provider
 			}
+			for k, r := range incoming.Reasoning.Efforts {
+				if next.Reasoning.Efforts == nil {
+					next.Reasoning.Efforts = map[string]config.ReasoningRule{}
+				}
+				next.Reasoning.Efforts[k] = r
+			}
+			if strings.TrimSpace(incoming.Reasoning.ThinkingHistory) != "" {
+				next.Reasoning.ThinkingHistory = incoming.Reasoning.ThinkingHistory
+			}
+			if strings.TrimSpace(incoming.Reasoning.SignatureKey) != "" {
+				next.Reasoning.SignatureKey = incoming.Reasoning.SignatureKey
+			}
 			if strings.TrimSpace(incoming.Admin.PasswordHash) != "" {
 				next.Admin.PasswordHash = incoming.Admin.PasswordHash
 			}
@@ -345,6 +360,31 @@ func (h *Handler) configImport(w http.ResponseWriter, r *http.Request) {
 			if incoming.Admin.JWTValidAfterUnix > 0 {
 				next.Admin.JWTValidAfterUnix = incoming.Admin.JWTValidAfterUnix
 			}
+			if strings.TrimSpace(incoming.Admin.TOTPSecret) != "" {
+				next.Admin.TOTPSecret = incoming.Admin.TOTPSecret
+			}
+			if incoming.Admin.AccessTokenMinutes > 0 {
+				next.Admin.AccessTokenMinutes = incoming.Admin.AccessTokenMinutes
+			}
+			if incoming.Admin.LoginMaxFailures > 0 {
+				next.Admin.LoginMaxFailures = incoming.Admin.LoginMaxFailures
+			}
+			if incoming.Admin.LoginLockoutSeconds > 0 {
+				next.Admin.LoginLockoutSeconds = incoming.Admin.LoginLockoutSeconds
+			}
+			for _, u := range incoming.Admin.Users {
+				replaced := false
+				for i := range next.Admin.Users {
+					if next.Admin.Users[i].Name == u.Name {
+						next.Admin.Users[i] = u
+						replaced = true
+						break
+					}
+				}
+				if !replaced {
+					next.Admin.Users = append(next.Admin.Users, u)
+				}
+			}
 			if incoming.Runtime.AccountMaxInflight > 0 {
 				next.Runtime.AccountMaxInflight = incoming.Runtime.AccountMaxInflight
 			}
@@ -354,6 +394,43 @@ func (h *Handler) configImport(w http.ResponseWriter, r *http.Request) {
 			if incoming.Runtime.GlobalMaxInflight > 0 {
 				next.Runtime.GlobalMaxInflight = incoming.Runtime.GlobalMaxInflight
 			}
+			if incoming.Runtime.MaxChoices > 0 {
+				next.Runtime.MaxChoices = incoming.Runtime.MaxChoices
+			}
+			if incoming.Runtime.BatchConcurrencyShare > 0 {
+				next.Runtime.BatchConcurrencyShare = incoming.Runtime.BatchConcurrencyShare
+			}
+			if incoming.Audit.MaxEntries > 0 {
+				next.Audit.MaxEntries = incoming.Audit.MaxEntries
+			}
+			if incoming.Audit.RetentionDays > 0 {
+				next.Audit.RetentionDays = incoming.Audit.RetentionDays
+			}
+			for _, p := range incoming.KeyPolicies {
+				replaced := false
+				for i := range next.KeyPolicies {
+					if next.KeyPolicies[i].Key == p.Key {
+						next.KeyPolicies[i] = p
+						replaced = true
+						break
+					}
+				}
+				if !replaced {
+					next.KeyPolicies = append(next.KeyPolicies, p)
+				}
+			}
+			if strings.TrimSpace(incoming.Passthrough.Mode) != "" {
+				next.Passthrough.Mode = incoming.Passthrough.Mode
+			}
+			if len(incoming.Passthrough.AllowedTokenHashes) > 0 {
+				next.Passthrough.AllowedTokenHashes = incoming.Passthrough.AllowedTokenHashes
+			}
+			if len(incoming.Network.TrustedProxyHeaders) > 0 {
+				next.Network.TrustedProxyHeaders = incoming.Network.TrustedProxyHeaders
+			}
+			if len(incoming.Network.TrustedProxyCIDRs) > 0 {
+				next.Network.TrustedProxyCIDRs = incoming.Network.TrustedProxyCIDRs
+			}
 		}
 
 		normalizeSettingsConfig(&next)
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ds2api/internal/config"
+)
+
+func (h *Handler) listKeyPolicies(w http.ResponseWriter, _ *http.Request) {
+	policies := h.Store.Snapshot().KeyPolicies
+	if policies == nil {
+		policies = []config.KeyPolicy{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": policies, "total": len(policies)})
+}
+
+// putKeyPolicy sets the allowlist, client certificate bindings and search
+// sources default of one managed key. Sending all three lists empty with
+// search_sources off removes the policy.
+func (h *Handler) putKeyPolicy(w http.ResponseWriter, r *http.Request) {
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "invalid json"})
+		return
+	}
+	policy := config.KeyPolicy{Key: fieldString(req, "key")}
+	policy.AllowedCIDRs, _ = toStringSlice(req["allowed_cidrs"])
+	policy.ClientCertSubjects, _ = toStringSlice(req["client_cert_subjects"])
+	policy.ClientCertFingerprints, _ = toStringSlice(req["client_cert_fingerprints"])
+	policy.SearchSources, _ = req["search_sources"].(bool)
+	policy = normalizeKeyPolicy(policy)
+	if policy.Key == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "key is required"})
+		return
+	}
+	remove := len(policy.AllowedCIDRs) == 0 && len(policy.ClientCertSubjects) == 0 && len(policy.ClientCertFingerprints) == 0 && !policy.SearchSources
+	err := h.Store.Update(func(c *config.Config) error {
+		next := make([]config.KeyPolicy, 0, len(c.KeyPolicies)+1)
+		for _, p := range c.KeyPolicies {
+			if p.Key != policy.Key {
+				next = append(next, p)
+			}
+		}
+		if !remove {
+			next = append(next, policy)
+		}
+		c.KeyPolicies = next
+		if err := validateKeyPolicies(c.KeyPolicies, c.Keys); err != nil {
+			return newRequestError(err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		if detail, ok := requestErrorDetail(err); ok {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"detail": detail})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "removed": remove, "policy": policy})
+}
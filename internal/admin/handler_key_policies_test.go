@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"net/http"
+	"testing"
+
+	authn "ds2api/internal/auth"
+)
+
+func TestPutKeyPolicyValidatesAndPrunes(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1","k2"]}`)
+	operator := authn.AdminIdentity{Subject: "admin", Role: authn.AdminRoleOperator}
+
+	rec := adminRequestAs(t, h, operator, http.MethodPut, "/key-policies", map[string]any{
+		"key":           "k1",
+		"allowed_cidrs": []string{"10.0.0.0/8", "192.0.2.1"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put policy: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := h.Store.Snapshot().KeyPolicies; len(got) != 1 || got[0].Key != "k1" || len(got[0].AllowedCIDRs) != 2 {
+		t.Fatalf("unexpected policies: %#v", got)
+	}
+
+	bad := []map[string]any{
+		{"key": "k2", "allowed_cidrs": []string{"10.0.0.0/33"}},
+		{"key": "missing", "allowed_cidrs": []string{"10.0.0.0/8"}},
+		{"key": "k2", "client_cert_fingerprints": []string{"abc"}},
+	}
+	for _, body := range bad {
+		if rec := adminRequestAs(t, h, operator, http.MethodPut, "/key-policies", body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %v, got %d", body, rec.Code)
+		}
+	}
+
+	if rec := adminRequestAs(t, h, operator, http.MethodDelete, "/keys/k1", nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete key: status=%d", rec.Code)
+	}
+	if got := h.Store.Snapshot().KeyPolicies; len(got) != 0 {
+		t.Fatalf("expected policy pruned with its key, got %#v", got)
+	}
+}
+
+func TestSettingsNetworkSection(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"]}`)
+	owner := authn.AdminIdentity{Subject: "admin", Role: authn.AdminRoleOwner}
+
+	body := map[string]any{"network": map[string]any{"trusted_proxy_headers": []string{"Forwarded-Bogus"}}}
+	if rec := adminRequestAs(t, h, owner, http.MethodPut, "/settings", body); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown header rejected, got %d", rec.Code)
+	}
+	body = map[string]any{"network": map[string]any{"trusted_proxy_headers": []string{"X-Forwarded-For"}, "trusted_proxy_cidrs": []string{"10.0.0.0/8"}}}
+	if rec := adminRequestAs(t, h, owner, http.MethodPut, "/settings", body); rec.Code != http.StatusOK {
+		t.Fatalf("update network: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	n := h.Store.Snapshot().Network
+	if len(n.TrustedProxyHeaders) != 1 || len(n.TrustedProxyCIDRs) != 1 {
+		t.Fatalf("unexpected network settings: %#v", n)
+	}
+}
+
+func TestPutKeyPolicySearchSources(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"]}`)
+	operator := authn.AdminIdentity{Subject: "admin", Role: authn.AdminRoleOperator}
+
+	rec := adminRequestAs(t, h, operator, http.MethodPut, "/key-policies", map[string]any{"key": "k1", "search_sources": true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put policy: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := h.Store.Snapshot().KeyPolicies; len(got) != 1 || !got[0].SearchSources {
+		t.Fatalf("expected search sources on for k1, got %#v", got)
+	}
+	if rec := adminRequestAs(t, h, operator, http.MethodPut, "/key-policies", map[string]any{"key": "k1"}); rec.Code != http.StatusOK {
+		t.Fatalf("clear policy: status=%d", rec.Code)
+	}
+	if len(h.Store.Snapshot().KeyPolicies) != 0 {
+		t.Fatalf("expected policy removed, got %#v", h.Store.Snapshot().KeyPolicies)
+	}
+}
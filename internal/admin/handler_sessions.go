@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	authn "ds2api/internal/auth"
+	"ds2api/internal/config"
+)
+
+// listSessions returns the caller's active sessions; owners may pass
+// all=true to see every admin's sessions.
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request) {
+	id, _ := authn.AdminIdentityFromContext(r.Context())
+	subject := id.Subject
+	if v := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("all"))); v == "true" || v == "1" {
+		if !id.Allows(authn.AdminRoleOwner) {
+			writeJSON(w, http.StatusForbidden, map[string]any{"detail": "insufficient role: requires " + authn.AdminRoleOwner})
+			return
+		}
+		subject = ""
+	}
+	sessions := h.sessions().list(subject)
+	items := make([]map[string]any, 0, len(sessions))
+	for _, s := range sessions {
+		items = append(items, s.public(id.SessionID))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "total": len(items)})
+}
+
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	id, _ := authn.AdminIdentityFromContext(r.Context())
+	sessionID := strings.TrimSpace(chi.URLParam(r, "id"))
+	sess, ok := h.sessions().get(sessionID)
+	if !ok || (sess.Subject != id.Subject && !id.Allows(authn.AdminRoleOwner)) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"detail": "session not found"})
+		return
+	}
+	if !h.sessions().revoke(sessionID) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"detail": "session not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "id": sessionID})
+}
+
+type pendingTOTP struct {
+	secret    string
+	expiresAt time.Time
+}
+
+const totpEnrollWindow = 10 * time.Minute
+
+// enrollTOTP generates a secret for the caller. It only takes effect after
+// confirmTOTP proves the authenticator produces matching codes.
+func (h *Handler) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	id, _ := authn.AdminIdentityFromContext(r.Context())
+	if authn.AdminTOTPSecretFor(id.Subject, h.Store) != "" {
+		writeJSON(w, http.StatusConflict, map[string]any{"detail": "TOTP is already enabled; disable it first"})
+		return
+	}
+	secret, err := authn.GenerateTOTPSecret()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"detail": err.Error()})
+		return
+	}
+	h.totpMu.Lock()
+	if h.totpPending == nil {
+		h.totpPending = map[string]pendingTOTP{}
+	}
+	h.totpPending[id.Subject] = pendingTOTP{secret: secret, expiresAt: time.Now().Add(totpEnrollWindow)}
+	h.totpMu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":     true,
+		"secret":      secret,
+		"otpauth_uri": authn.TOTPProvisioningURI(secret, id.Subject, "DS2API"),
+		"expires_in":  int(totpEnrollWindow.Seconds()),
+	})
+}
+
+func (h *Handler) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	id, _ := authn.AdminIdentityFromContext(r.Context())
+	code := totpCodeFromRequest(r)
+	h.totpMu.Lock()
+	pending, ok := h.totpPending[id.Subject]
+	if ok && time.Now().After(pending.expiresAt) {
+		delete(h.totpPending, id.Subject)
+		ok = false
+	}
+	h.totpMu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "no pending TOTP enrollment; call /admin/totp/enroll first"})
+		return
+	}
+	if !authn.VerifyTOTP(pending.secret, code, time.Now()) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "Invalid TOTP code"})
+		return
+	}
+	if err := h.setTOTPSecret(id.Subject, pending.secret); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"detail": err.Error()})
+		return
+	}
+	h.totpMu.Lock()
+	delete(h.totpPending, id.Subject)
+	h.totpMu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "totp_enabled": true})
+}
+
+func (h *Handler) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	id, _ := authn.AdminIdentityFromContext(r.Context())
+	secret := authn.AdminTOTPSecretFor(id.Subject, h.Store)
+	if secret == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "TOTP is not enabled"})
+		return
+	}
+	if !authn.VerifyTOTP(secret, totpCodeFromRequest(r), time.Now()) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "Invalid TOTP code"})
+		return
+	}
+	if err := h.setTOTPSecret(id.Subject, ""); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "totp_enabled": false})
+}
+
+func (h *Handler) setTOTPSecret(subject, secret string) error {
+	return h.Store.Update(func(c *config.Config) error {
+		if authn.IsReservedAdminName(subject) {
+			c.Admin.TOTPSecret = secret
+			return nil
+		}
+		for i := range c.Admin.Users {
+			if c.Admin.Users[i].Name == subject {
+				c.Admin.Users[i].TOTPSecret = secret
+				return nil
+			}
+		}
+		return newRequestError("admin user not found")
+	})
+}
+
+func totpCodeFromRequest(r *http.Request) string {
+	var req map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	return fieldString(req, "code")
+}
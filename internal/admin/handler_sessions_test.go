@@ -0,0 +1,226 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	authn "ds2api/internal/auth"
+)
+
+func adminPost(t *testing.T, h *Handler, path, bearer string, body any) (*httptest.ResponseRecorder, map[string]any) {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+	req.RemoteAddr = "203.0.113.7:5000"
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	out := map[string]any{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &out)
+	return rec, out
+}
+
+func TestLoginRequiresTOTPWhenEnrolled(t *testing.T) {
+	secret, err := authn.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newAdminTestHandler(t, `{
+		"keys":["k1"],
+		"admin":{"users":[{"name":"ops","password_hash":"`+authn.HashAdminPassword("opspw")+`","role":"operator","totp_secret":"`+secret+`"}]}
+	}`)
+
+	rec, body := adminPost(t, h, "/login", "", map[string]any{"username": "ops", "password": "opspw"})
+	if rec.Code != http.StatusUnauthorized || body["totp_required"] != true {
+		t.Fatalf("expected totp_required, got %d %v", rec.Code, body)
+	}
+	code := authn.TOTPCode(secret, time.Now())
+	rec, body = adminPost(t, h, "/login", "", map[string]any{"username": "ops", "password": "opspw", "totp_code": code})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login with totp: status=%d body=%v", rec.Code, body)
+	}
+	if body["refresh_token"] == "" || body["session_id"] == "" || body["role"] != authn.AdminRoleOperator {
+		t.Fatalf("unexpected login response: %v", body)
+	}
+}
+
+func TestRefreshRotatesAndDetectsReuse(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"],"admin":{"access_token_minutes":15}}`)
+	rec, body := adminPost(t, h, "/login", "", map[string]any{"admin_key": "admin"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status=%d body=%v", rec.Code, body)
+	}
+	if int(body["expires_in"].(float64)) != 15*60 {
+		t.Fatalf("expected access token capped at 15 minutes, got %v", body["expires_in"])
+	}
+	first, _ := body["refresh_token"].(string)
+
+	rec, body = adminPost(t, h, "/refresh", "", map[string]any{"refresh_token": first})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh: status=%d body=%v", rec.Code, body)
+	}
+	second, _ := body["refresh_token"].(string)
+	token, _ := body["token"].(string)
+	if second == "" || second == first {
+		t.Fatalf("expected rotated refresh token, got %q", second)
+	}
+
+	if rec, _ := adminPost(t, h, "/refresh", "", map[string]any{"refresh_token": first}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected reused refresh token rejected, got %d", rec.Code)
+	}
+	// Reuse revokes the whole session, including the newest tokens.
+	if rec, _ := adminPost(t, h, "/refresh", "", map[string]any{"refresh_token": second}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected session revoked after reuse, got %d", rec.Code)
+	}
+	if rec, _ := adminPost(t, h, "/logout", token, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected access token of revoked session rejected, got %d", rec.Code)
+	}
+}
+
+func TestRevokedSessionRejectsAccessToken(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"]}`)
+	_, body := adminPost(t, h, "/login", "", map[string]any{"admin_key": "admin"})
+	token, _ := body["token"].(string)
+	sessionID, _ := body["session_id"].(string)
+
+	owner := authn.AdminIdentity{Subject: "admin", Role: authn.AdminRoleOwner}
+	if rec := adminRequestAs(t, h, owner, http.MethodDelete, "/sessions/"+sessionID, nil); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec, _ := adminPost(t, h, "/logout", token, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked session rejected, got %d", rec.Code)
+	}
+}
+
+func TestStatelessSessionsSurviveColdStart(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"]}`)
+	h.Sessions = NewStatelessSessionStore()
+	_, body := adminPost(t, h, "/login", "", map[string]any{"admin_key": "admin", "expire_hours": 2})
+	token, _ := body["token"].(string)
+	if body["expires_in"] != float64(2*3600) {
+		t.Fatalf("expected the access token to last the session, got %v", body["expires_in"])
+	}
+
+	// Another instance never saw the session but accepts its token.
+	cold := &Handler{Store: h.Store, Pool: h.Pool, Sessions: NewStatelessSessionStore()}
+	if rec, _ := adminPost(t, cold, "/logout", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected a token from another instance accepted, got %d", rec.Code)
+	}
+	if rec, _ := adminPost(t, cold, "/logout", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected an unknown session to stay stateless, got %d", rec.Code)
+	}
+	if rec, _ := adminPost(t, h, "/logout", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("logout: status=%d", rec.Code)
+	}
+	if rec, _ := adminPost(t, h, "/logout", token, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the instance that revoked the session to reject it, got %d", rec.Code)
+	}
+}
+
+func TestLoginLockoutAfterRepeatedFailures(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"],"admin":{"login_max_failures":2,"login_lockout_seconds":60}}`)
+	for i := 0; i < 2; i++ {
+		if rec, _ := adminPost(t, h, "/login", "", map[string]any{"admin_key": "wrong"}); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, rec.Code)
+		}
+	}
+	rec, body := adminPost(t, h, "/login", "", map[string]any{"admin_key": "admin"})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected lockout, got %d %v", rec.Code, body)
+	}
+
+	// The lockout is per client address; the owner elsewhere still logs in.
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader([]byte(`{"admin_key":"admin"}`)))
+	req.RemoteAddr = "198.51.100.9:5000"
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected another address to log in, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTOTPEnrollConfirmAndDisable(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"]}`)
+	owner := authn.AdminIdentity{Subject: "admin", Role: authn.AdminRoleOwner}
+
+	rec := adminRequestAs(t, h, owner, http.MethodPost, "/totp/enroll", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enroll: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var enrolled map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &enrolled)
+	secret, _ := enrolled["secret"].(string)
+
+	if rec := adminRequestAs(t, h, owner, http.MethodPost, "/totp/confirm", map[string]any{"code": "000000"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected wrong code rejected, got %d", rec.Code)
+	}
+	code := authn.TOTPCode(secret, time.Now())
+	if rec := adminRequestAs(t, h, owner, http.MethodPost, "/totp/confirm", map[string]any{"code": code}); rec.Code != http.StatusOK {
+		t.Fatalf("confirm: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if h.Store.AdminTOTPSecret() != secret {
+		t.Fatal("expected TOTP secret persisted for legacy admin")
+	}
+	if rec, _ := adminPost(t, h, "/login", "", map[string]any{"admin_key": "admin"}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected login without code rejected once TOTP enabled, got %d", rec.Code)
+	}
+	if rec := adminRequestAs(t, h, owner, http.MethodPost, "/totp/disable", map[string]any{"code": code}); rec.Code != http.StatusOK {
+		t.Fatalf("disable: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if h.Store.AdminTOTPSecret() != "" {
+		t.Fatal("expected TOTP secret cleared")
+	}
+}
+
+func TestAdminKeyBearerSharesLoginLockout(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"],"admin":{"login_max_failures":2,"login_lockout_seconds":60}}`)
+	_, body := adminPost(t, h, "/login", "", map[string]any{"admin_key": "admin"})
+	token, _ := body["token"].(string)
+	if body["expires_in"] != float64(15*60) {
+		t.Fatalf("expected a 15 minute access token by default, got %v", body["expires_in"])
+	}
+	for i := 0; i < 2; i++ {
+		if rec, _ := adminPost(t, h, "/logout", "wrong-key", nil); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, rec.Code)
+		}
+	}
+	if rec, _ := adminPost(t, h, "/logout", "admin", nil); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the admin key bearer to be locked out, got %d", rec.Code)
+	}
+	if rec, _ := adminPost(t, h, "/login", "", map[string]any{"admin_key": "admin"}); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected login with the admin key to be locked out, got %d", rec.Code)
+	}
+	if rec, _ := adminPost(t, h, "/logout", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected a signed token to pass the lockout, got %d", rec.Code)
+	}
+}
+
+func TestLoginRejectsReusedTOTPCode(t *testing.T) {
+	secret, err := authn.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newAdminTestHandler(t, `{
+		"keys":["k1"],
+		"admin":{"users":[{"name":"ops","password_hash":"`+authn.HashAdminPassword("opspw")+`","role":"operator","totp_secret":"`+secret+`"}]}
+	}`)
+	login := map[string]any{"username": "ops", "password": "opspw", "totp_code": authn.TOTPCode(secret, time.Now())}
+	if rec, _ := adminPost(t, h, "/login", "", login); rec.Code != http.StatusOK {
+		t.Fatalf("first login: status=%d", rec.Code)
+	}
+	if rec, body := adminPost(t, h, "/login", "", login); rec.Code != http.StatusUnauthorized || body["totp_required"] != true {
+		t.Fatalf("expected a reused code to be rejected, got %d %v", rec.Code, body)
+	}
+}
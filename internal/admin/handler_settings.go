@@ -21,16 +21,39 @@ func (h *Handler) getSettings(w http.ResponseWriter, _ *http.Request) {
 			"has_password_hash":        strings.TrimSpace(snap.Admin.PasswordHash) != "",
 			"jwt_expire_hours":         h.Store.AdminJWTExpireHours(),
 			"jwt_valid_after_unix":     snap.Admin.JWTValidAfterUnix,
+			"totp_enabled":             strings.TrimSpace(snap.Admin.TOTPSecret) != "",
+			"access_token_minutes":     h.Store.AdminAccessTokenMinutes(),
+			"login_max_failures":       h.Store.AdminLoginMaxFailures(),
+			"login_lockout_seconds":    h.Store.AdminLoginLockoutSeconds(),
 			"default_password_warning": authn.UsingDefaultAdminKey(h.Store),
 		},
 		"runtime": map[string]any{
-			"account_max_inflight": h.Store.RuntimeAccountMaxInflight(),
-			"account_max_queue":    h.Store.RuntimeAccountMaxQueue(recommended),
-			"global_max_inflight":  h.Store.RuntimeGlobalMaxInflight(recommended),
+			"account_max_inflight":    h.Store.RuntimeAccountMaxInflight(),
+			"account_max_queue":       h.Store.RuntimeAccountMaxQueue(recommended),
+			"global_max_inflight":     h.Store.RuntimeGlobalMaxInflight(recommended),
+			"max_choices":             h.Store.RuntimeMaxChoices(),
+			"batch_concurrency_share": h.Store.RuntimeBatchConcurrencyShare(),
+		},
+		"toolcall":   snap.Toolcall,
+		"responses":  snap.Responses,
+		"embeddings": snap.Embeddings,
+		"reasoning": map[string]any{
+			"efforts":           settingsReasoningEfforts(snap),
+			"thinking_history":  h.Store.ThinkingHistoryPolicy(),
+			"has_signature_key": strings.TrimSpace(snap.Reasoning.SignatureKey) != "",
+		},
+		"audit": map[string]any{
+			"max_entries":    h.Store.AuditMaxEntries(),
+			"retention_days": h.Store.AuditRetentionDays(),
+		},
+		"passthrough": map[string]any{
+			"mode":                 h.Store.PassthroughMode(),
+			"allowed_token_hashes": snap.Passthrough.AllowedTokenHashes,
+		},
+		"network": map[string]any{
+			"trusted_proxy_headers": snap.Network.TrustedProxyHeaders,
+			"trusted_proxy_cidrs":   snap.Network.TrustedProxyCIDRs,
 		},
-		"toolcall":          snap.Toolcall,
-		"responses":         snap.Responses,
-		"embeddings":        snap.Embeddings,
 		"claude_mapping":    settingsClaudeMapping(snap),
 		"model_aliases":     snap.ModelAliases,
 		"env_backed":        h.Store.IsEnvBacked(),
@@ -45,55 +68,107 @@ func (h *Handler) updateSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	adminCfg, runtimeCfg, toolcallCfg, responsesCfg, embeddingsCfg, claudeMap, aliasMap, err := parseSettingsUpdateRequest(req)
+	upd, err := parseSettingsUpdateRequest(req)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": err.Error()})
 		return
 	}
-	if runtimeCfg != nil {
-		if err := validateMergedRuntimeSettings(h.Store.Snapshot().Runtime, runtimeCfg); err != nil {
+	if upd.Runtime != nil {
+		if err := validateMergedRuntimeSettings(h.Store.Snapshot().Runtime, upd.Runtime); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]any{"detail": err.Error()})
 			return
 		}
 	}
 
 	if err := h.Store.Update(func(c *config.Config) error {
-		if adminCfg != nil {
-			if adminCfg.JWTExpireHours > 0 {
-				c.Admin.JWTExpireHours = adminCfg.JWTExpireHours
+		if upd.Admin != nil {
+			if upd.Admin.JWTExpireHours > 0 {
+				c.Admin.JWTExpireHours = upd.Admin.JWTExpireHours
+			}
+			if upd.Admin.AccessTokenMinutes > 0 {
+				c.Admin.AccessTokenMinutes = upd.Admin.AccessTokenMinutes
+			}
+			if upd.Admin.LoginMaxFailures > 0 {
+				c.Admin.LoginMaxFailures = upd.Admin.LoginMaxFailures
+			}
+			if upd.Admin.LoginLockoutSeconds > 0 {
+				c.Admin.LoginLockoutSeconds = upd.Admin.LoginLockoutSeconds
+			}
+		}
+		if upd.Runtime != nil {
+			if upd.Runtime.AccountMaxInflight > 0 {
+				c.Runtime.AccountMaxInflight = upd.Runtime.AccountMaxInflight
 			}
+			if upd.Runtime.AccountMaxQueue > 0 {
+				c.Runtime.AccountMaxQueue = upd.Runtime.AccountMaxQueue
+			}
+			if upd.Runtime.GlobalMaxInflight > 0 {
+				c.Runtime.GlobalMaxInflight = upd.Runtime.GlobalMaxInflight
+			}
+			if upd.Runtime.MaxChoices > 0 {
+				c.Runtime.MaxChoices = upd.Runtime.MaxChoices
+			}
+			if upd.Runtime.BatchConcurrencyShare > 0 {
+				c.Runtime.BatchConcurrencyShare = upd.Runtime.BatchConcurrencyShare
+			}
+		}
+		if upd.Toolcall != nil {
+			if strings.TrimSpace(upd.Toolcall.Mode) != "" {
+				c.Toolcall.Mode = strings.TrimSpace(upd.Toolcall.Mode)
+			}
+			if strings.TrimSpace(upd.Toolcall.EarlyEmitConfidence) != "" {
+				c.Toolcall.EarlyEmitConfidence = strings.TrimSpace(upd.Toolcall.EarlyEmitConfidence)
+			}
+		}
+		if upd.Responses != nil && upd.Responses.StoreTTLSeconds > 0 {
+			c.Responses.StoreTTLSeconds = upd.Responses.StoreTTLSeconds
+		}
+		if upd.Embeddings != nil && strings.TrimSpace(upd.Embeddings.Provider) != "" {
+			c.Embeddings.Provider = strings.TrimSpace(upd.Embeddings.Provider)
 		}
-		if runtimeCfg != nil {
-			if runtimeCfg.AccountMaxInflight > 0 {
-				c.Runtime.AccountMaxInflight = runtimeCfg.AccountMaxInflight
+		if upd.Reasoning != nil {
+			if upd.Reasoning.Efforts != nil {
+				c.Reasoning.Efforts = upd.Reasoning.Efforts
 			}
-			if runtimeCfg.AccountMaxQueue > 0 {
-				c.Runtime.AccountMaxQueue = runtimeCfg.AccountMaxQueue
+			if upd.Reasoning.ThinkingHistory != "" {
+				c.Reasoning.ThinkingHistory = upd.Reasoning.ThinkingHistory
 			}
-			if runtimeCfg.GlobalMaxInflight > 0 {
-				c.Runtime.GlobalMaxInflight = runtimeCfg.GlobalMaxInflight
+			if upd.Reasoning.SignatureKey != "" {
+				c.Reasoning.SignatureKey = upd.Reasoning.SignatureKey
 			}
 		}
-		if toolcallCfg != nil {
-			if strings.TrimSpace(toolcallCfg.Mode) != "" {
-				c.Toolcall.Mode = strings.TrimSpace(toolcallCfg.Mode)
+		if upd.Audit != nil {
+			if upd.Audit.MaxEntries > 0 {
+				c.Audit.MaxEntries = upd.Audit.MaxEntries
 			}
-			if strings.TrimSpace(toolcallCfg.EarlyEmitConfidence) != "" {
-				c.Toolcall.EarlyEmitConfidence = strings.TrimSpace(toolcallCfg.EarlyEmitConfidence)
+			if upd.Audit.RetentionDays > 0 {
+				c.Audit.RetentionDays = upd.Audit.RetentionDays
 			}
 		}
-		if responsesCfg != nil && responsesCfg.StoreTTLSeconds > 0 {
-			c.Responses.StoreTTLSeconds = responsesCfg.StoreTTLSeconds
+		if upd.Passthrough != nil {
+			if upd.Passthrough.Mode != "" {
+				c.Passthrough.Mode = upd.Passthrough.Mode
+			}
+			if upd.Passthrough.AllowedTokenHashes != nil {
+				c.Passthrough.AllowedTokenHashes = upd.Passthrough.AllowedTokenHashes
+			}
 		}
-		if embeddingsCfg != nil && strings.TrimSpace(embeddingsCfg.Provider) != "" {
-			c.Embeddings.Provider = strings.TrimSpace(embeddingsCfg.Provider)
+		if upd.Network != nil {
+			// A present (possibly empty) list replaces the stored one, so an
+			// empty array restores the default.
+			if upd.Network.TrustedProxyHeaders != nil {
+				c.Network.TrustedProxyHeaders = upd.Network.TrustedProxyHeaders
+			}
+			if upd.Network.TrustedProxyCIDRs != nil {
+				c.Network.TrustedProxyCIDRs = upd.Network.TrustedProxyCIDRs
+			}
 		}
-		if claudeMap != nil {
-			c.ClaudeMapping = claudeMap
+		if upd.ClaudeMapping != nil {
+			c.ClaudeMapping = upd.ClaudeMapping
 			c.ClaudeModelMap = nil
 		}
-		if aliasMap != nil {
-			c.ModelAliases = aliasMap
+		if upd.ModelAliases != nil {
+			c.ModelAliases = upd.ModelAliases
 		}
 		return nil
 	}); err != nil {
@@ -124,6 +199,12 @@ func validateMergedRuntimeSettings(current config.RuntimeConfig, incoming *confi
 		if incoming.GlobalMaxInflight > 0 {
 			merged.GlobalMaxInflight = incoming.GlobalMaxInflight
 		}
+		if incoming.MaxChoices > 0 {
+			merged.MaxChoices = incoming.MaxChoices
+		}
+		if incoming.BatchConcurrencyShare > 0 {
+			merged.BatchConcurrencyShare = incoming.BatchConcurrencyShare
+		}
 	}
 	return validateRuntimeSettings(merged)
 }
@@ -194,27 +275,56 @@ func settingsClaudeMapping(c config.Config) map[string]string {
 	return map[string]string{"fast": "deepseek-chat", "slow": "deepseek-reasoner"}
 }
 
-func parseSettingsUpdateRequest(req map[string]any) (*config.AdminConfig, *config.RuntimeConfig, *config.ToolcallConfig, *config.ResponsesConfig, *config.EmbeddingsConfig, map[string]string, map[string]string, error) {
-	var (
-		adminCfg    *config.AdminConfig
-		runtimeCfg  *config.RuntimeConfig
-		toolcallCfg *config.ToolcallConfig
-		respCfg     *config.ResponsesConfig
-		embCfg      *config.EmbeddingsConfig
-		claudeMap   map[string]string
-		aliasMap    map[string]string
-	)
+// settingsUpdate holds the sections present in a PUT /admin/settings body.
+// A nil section means the caller did not send it.
+type settingsUpdate struct {
+	Admin         *config.AdminConfig
+	Runtime       *config.RuntimeConfig
+	Toolcall      *config.ToolcallConfig
+	Responses     *config.ResponsesConfig
+	Embeddings    *config.EmbeddingsConfig
+	Reasoning     *config.ReasoningConfig
+	Audit         *config.AuditConfig
+	Network       *config.NetworkConfig
+	Passthrough   *config.PassthroughConfig
+	ClaudeMapping map[string]string
+	ModelAliases  map[string]string
+}
+
+func parseSettingsUpdateRequest(req map[string]any) (settingsUpdate, error) {
+	var out settingsUpdate
 
 	if raw, ok := req["admin"].(map[string]any); ok {
 		cfg := &config.AdminConfig{}
 		if v, exists := raw["jwt_expire_hours"]; exists {
 			n := intFrom(v)
 			if n < 1 || n > 720 {
-				return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("admin.jwt_expire_hours must be between 1 and 720")
+				return settingsUpdate{}, fmt.Errorf("admin.jwt_expire_hours must be between 1 and 720")
 			}
 			cfg.JWTExpireHours = n
 		}
-		adminCfg = cfg
+		if v, exists := raw["access_token_minutes"]; exists {
+			n := intFrom(v)
+			if n < 1 || n > 1440 {
+				return settingsUpdate{}, fmt.Errorf("admin.access_token_minutes must be between 1 and 1440")
+			}
+			cfg.AccessTokenMinutes = n
+		}
+		if v, exists := raw["login_max_failures"]; exists {
+			n := intFrom(v)
+			if n < 1 || n > 100 {
+				return settingsUpdate{}, fmt.Errorf("admin.login_max_failures must be between 1 and 100")
+			}
+			cfg.LoginMaxFailures = n
+		}
+		if v, exists := raw["login_lockout_seconds"]; exists {
+			n := intFrom(v)
+			if n < 10 || n > 86400 {
+				return settingsUpdate{}, fmt.Errorf("admin.login_lockout_seconds must be between 10 and 86400")
+			}
+			cfg.LoginLockoutSeconds = n
+		}
+		out.Admin = cfg
 	}
 
 	if raw, ok := req["runtime"].(map[string]any); ok {
@@ -222,28 +332,42 @@ func parseSettingsUpdateRequest(req map[string]any) (*config.AdminConfig, *confi
 		if v, exists := raw["account_max_inflight"]; exists {
 			n := intFrom(v)
 			if n < 1 || n > 256 {
-				return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("runtime.account_max_inflight must be between 1 and 256")
+				return settingsUpdate{}, fmt.Errorf("runtime.account_max_inflight must be between 1 and 256")
 			}
 			cfg.AccountMaxInflight = n
 		}
 		if v, exists := raw["account_max_queue"]; exists {
 			n := intFrom(v)
 			if n < 1 || n > 200000 {
-				return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("runtime.account_max_queue must be between 1 and 200000")
+				return settingsUpdate{}, fmt.Errorf("runtime.account_max_queue must be between 1 and 200000")
 			}
 			cfg.AccountMaxQueue = n
 		}
 		if v, exists := raw["global_max_inflight"]; exists {
 			n := intFrom(v)
 			if n < 1 || n > 200000 {
-				return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("runtime.global_max_inflight must be between 1 and 200000")
+				return settingsUpdate{}, fmt.Errorf("runtime.global_max_inflight must be between 1 and 200000")
 			}
 			cfg.GlobalMaxInflight = n
 		}
+		if v, exists := raw["max_choices"]; exists {
+			n := intFrom(v)
+			if n < 1 || n > 16 {
+				return settingsUpdate{}, fmt.Errorf("runtime.max_choices must be between 1 and 16")
+			}
+			cfg.MaxChoices = n
+		}
+		if v, exists := raw["batch_concurrency_share"]; exists {
+			n := intFrom(v)
+			if n < 1 || n > 100 {
+				return settingsUpdate{}, fmt.Errorf("runtime.batch_concurrency_share must be between 1 and 100")
+			}
+			cfg.BatchConcurrencyShare = n
+		}
 		if cfg.AccountMaxInflight > 0 && cfg.GlobalMaxInflight > 0 && cfg.GlobalMaxInflight < cfg.AccountMaxInflight {
-			return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("runtime.global_max_inflight must be >= runtime.account_max_inflight")
+			return settingsUpdate{}, fmt.Errorf("runtime.global_max_inflight must be >= runtime.account_max_inflight")
 		}
-		runtimeCfg = cfg
+		out.Runtime = cfg
 	}
 
 	if raw, ok := req["toolcall"].(map[string]any); ok {
@@ -254,7 +378,7 @@ func parseSettingsUpdateRequest(req map[string]any) (*config.AdminConfig, *confi
 			case "feature_match", "off":
 				cfg.Mode = mode
 			default:
-				return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("toolcall.mode must be feature_match or off")
+				return settingsUpdate{}, fmt.Errorf("toolcall.mode must be feature_match or off")
 			}
 		}
 		if v, exists := raw["early_emit_confidence"]; exists {
@@ -263,10 +387,10 @@ func parseSettingsUpdateRequest(req map[string]any) (*config.AdminConfig, *confi
 			case "high", "low", "off":
 				cfg.EarlyEmitConfidence = level
 			default:
-				return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("toolcall.early_emit_confidence must be high, low or off")
+				return settingsUpdate{}, fmt.Errorf("toolcall.early_emit_confidence must be high, low or off")
 			}
 		}
-		toolcallCfg = cfg
+		out.Toolcall = cfg
 	}
 
 	if raw, ok := req["responses"].(map[string]any); ok {
@@ -274,11 +398,11 @@ func parseSettingsUpdateRequest(req map[string]any) (*config.AdminConfig, *confi
 		if v, exists := raw["store_ttl_seconds"]; exists {
 			n := intFrom(v)
 			if n < 30 || n > 86400 {
-				return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("responses.store_ttl_seconds must be between 30 and 86400")
+				return settingsUpdate{}, fmt.Errorf("responses.store_ttl_seconds must be between 30 and 86400")
 			}
 			cfg.StoreTTLSeconds = n
 		}
-		respCfg = cfg
+		out.Responses = cfg
 	}
 
 	if raw, ok := req["embeddings"].(map[string]any); ok {
@@ -286,36 +410,126 @@ func parseSettingsUpdateRequest(req map[string]any) (*config.AdminConfig, *confi
 		if v, exists := raw["provider"]; exists {
 			p := strings.TrimSpace(fmt.Sprintf("%v", v))
 			if p == "" {
-				return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("embeddings.provider cannot be empty")
+				return settingsUpdate{}, fmt.Errorf("embeddings.provider cannot be empty")
 			}
 			cfg.Provider = p
 		}
-		embCfg = cfg
+		out.Embeddings = cfg
+	}
+
+	if raw, ok := req["reasoning"].(map[string]any); ok {
+		cfg := &config.ReasoningConfig{}
+		if v, exists := raw["efforts"]; exists {
+			b, err := json.Marshal(v)
+			if err != nil || json.Unmarshal(b, &cfg.Efforts) != nil {
+				return settingsUpdate{}, fmt.Errorf("reasoning.efforts must map effort names to {thinking, search} rules")
+			}
+			cfg.Efforts = normalizeReasoningEfforts(cfg.Efforts)
+			if cfg.Efforts == nil {
+				// Present but empty clears the configured rules.
+				cfg.Efforts = map[string]config.ReasoningRule{}
+			}
+		}
+		cfg.ThinkingHistory = strings.ToLower(fieldString(raw, "thinking_history"))
+		cfg.SignatureKey = fieldString(raw, "signature_key")
+		if err := validateReasoningSettings(*cfg); err != nil {
+			return settingsUpdate{}, err
+		}
+		out.Reasoning = cfg
+	}
+
+	if raw, ok := req["audit"].(map[string]any); ok {
+		cfg := &config.AuditConfig{}
+		if v, exists := raw["max_entries"]; exists {
+			n := intFrom(v)
+			if n < 100 || n > 1000000 {
+				return settingsUpdate{}, fmt.Errorf("audit.max_entries must be between 100 and 1000000")
+			}
+			cfg.MaxEntries = n
+		}
+		if v, exists := raw["retention_days"]; exists {
+			n := intFrom(v)
+			if n < 1 || n > 3650 {
+				return settingsUpdate{}, fmt.Errorf("audit.retention_days must be between 1 and 3650")
+			}
+			cfg.RetentionDays = n
+		}
+		out.Audit = cfg
+	}
+
+	if raw, ok := req["passthrough"].(map[string]any); ok {
+		cfg := &config.PassthroughConfig{}
+		if v, exists := raw["mode"]; exists {
+			cfg.Mode = strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", v)))
+		}
+		if v, exists := raw["allowed_token_hashes"]; exists {
+			hashes, ok := toStringSlice(v)
+			if !ok {
+				return settingsUpdate{}, fmt.Errorf("passthrough.allowed_token_hashes must be an array")
+			}
+			cfg.AllowedTokenHashes = normalizeTokenHashes(hashes)
+		}
+		if err := validatePassthroughSettings(*cfg); err != nil {
+			return settingsUpdate{}, err
+		}
+		out.Passthrough = cfg
+	}
+
+	if raw, ok := req["network"].(map[string]any); ok {
+		cfg := &config.NetworkConfig{}
+		if v, exists := raw["trusted_proxy_headers"]; exists {
+			headers, ok := toStringSlice(v)
+			if !ok {
+				return settingsUpdate{}, fmt.Errorf("network.trusted_proxy_headers must be an array")
+			}
+			cfg.TrustedProxyHeaders = trimStringList(headers)
+		}
+		if v, exists := raw["trusted_proxy_cidrs"]; exists {
+			cidrs, ok := toStringSlice(v)
+			if !ok {
+				return settingsUpdate{}, fmt.Errorf("network.trusted_proxy_cidrs must be an array")
+			}
+			cfg.TrustedProxyCIDRs = trimStringList(cidrs)
+		}
+		if err := validateNetworkSettings(*cfg); err != nil {
+			return settingsUpdate{}, err
+		}
+		out.Network = cfg
 	}
 
 	if raw, ok := req["claude_mapping"].(map[string]any); ok {
-		claudeMap = map[string]string{}
+		out.ClaudeMapping = map[string]string{}
 		for k, v := range raw {
 			key := strings.TrimSpace(k)
 			val := strings.TrimSpace(fmt.Sprintf("%v", v))
 			if key == "" || val == "" {
 				continue
 			}
-			claudeMap[key] = val
+			out.ClaudeMapping[key] = val
 		}
 	}
 
 	if raw, ok := req["model_aliases"].(map[string]any); ok {
-		aliasMap = map[string]string{}
+		out.ModelAliases = map[string]string{}
 		for k, v := range raw {
 			key := strings.TrimSpace(k)
 			val := strings.TrimSpace(fmt.Sprintf("%v", v))
 			if key == "" || val == "" {
 				continue
 			}
-			aliasMap[key] = val
+			out.ModelAliases[key] = val
 		}
 	}
 
-	return adminCfg, runtimeCfg, toolcallCfg, respCfg, embCfg, claudeMap, aliasMap, nil
+	return out, nil
+}
+
+// settingsReasoningEfforts shows the effective effort rules: the defaults
+// with the configured rules merged over them.
+func settingsReasoningEfforts(snap config.Config) map[string]config.ReasoningRule {
+	out := config.DefaultReasoningEfforts()
+	for k, r := range snap.Reasoning.Efforts {
+		out[k] = r
+	}
+	return out
 }
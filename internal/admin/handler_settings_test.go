@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	authn "ds2api/internal/auth"
@@ -265,3 +266,95 @@ func TestConfigImportRejectsMergedRuntimeConflict(t *testing.T) {
 		t.Fatalf("runtime should remain unchanged, runtime=%+v", snap.Runtime)
 	}
 }
+
+func TestUpdateSettingsPassthroughPolicy(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"]}`)
+	hash := strings.Repeat("ab", 32)
+
+	for _, body := range []map[string]any{
+		{"passthrough": map[string]any{"mode": "sometimes"}},
+		{"passthrough": map[string]any{"allowed_token_hashes": []any{"raw-token"}}},
+	} {
+		b, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		h.updateSettings(rec, httptest.NewRequest(http.MethodPut, "/admin/settings", bytes.NewReader(b)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %v, got %d", body, rec.Code)
+		}
+	}
+
+	b, _ := json.Marshal(map[string]any{"passthrough": map[string]any{"mode": "allowlist", "allowed_token_hashes": []any{strings.ToUpper(hash)}}})
+	rec := httptest.NewRecorder()
+	h.updateSettings(rec, httptest.NewRequest(http.MethodPut, "/admin/settings", bytes.NewReader(b)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	if h.Store.PassthroughMode() != "allowlist" {
+		t.Fatalf("unexpected mode %q", h.Store.PassthroughMode())
+	}
+	if got := h.Store.Snapshot().Passthrough.AllowedTokenHashes; len(got) != 1 || got[0] != hash {
+		t.Fatalf("expected normalized hash, got %v", got)
+	}
+}
+
+func TestUpdateSettingsReasoningEfforts(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"]}`)
+	put := func(payload map[string]any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(payload)
+		rec := httptest.NewRecorder()
+		h.updateSettings(rec, httptest.NewRequest(http.MethodPut, "/admin/settings", bytes.NewReader(b)))
+		return rec
+	}
+	if rec := put(map[string]any{"reasoning": map[string]any{"efforts": map[string]any{"high": map[string]any{}}}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected empty rule rejected, got %d", rec.Code)
+	}
+	if rec := put(map[string]any{"reasoning": map[string]any{"efforts": map[string]any{"High": map[string]any{"search": true}}}}); rec.Code != http.StatusOK {
+		t.Fatalf("update reasoning: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	rule, ok := h.Store.Snapshot().Reasoning.Efforts["high"]
+	if !ok || rule.Search == nil || !*rule.Search || rule.Thinking != nil {
+		t.Fatalf("expected configured high rule, got %#v", h.Store.Snapshot().Reasoning)
+	}
+
+	rec := httptest.NewRecorder()
+	h.getSettings(rec, httptest.NewRequest(http.MethodGet, "/admin/settings", nil))
+	var body map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	reasoning, _ := body["reasoning"].(map[string]any)
+	efforts, _ := reasoning["efforts"].(map[string]any)
+	if _, ok := efforts["minimal"]; !ok || efforts["high"] == nil {
+		t.Fatalf("expected defaults merged with configured rules, got %#v", reasoning)
+	}
+}
+
+func TestUpdateSettingsThinkingSignatures(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"],"reasoning":{"efforts":{"high":{"search":true}}}}`)
+	put := func(payload map[string]any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(payload)
+		rec := httptest.NewRecorder()
+		h.updateSettings(rec, httptest.NewRequest(http.MethodPut, "/admin/settings", bytes.NewReader(b)))
+		return rec
+	}
+	if rec := put(map[string]any{"reasoning": map[string]any{"thinking_history": "sometimes"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown policy rejected, got %d", rec.Code)
+	}
+	if rec := put(map[string]any{"reasoning": map[string]any{"thinking_history": "Replay", "signature_key": "s3cret"}}); rec.Code != http.StatusOK {
+		t.Fatalf("update reasoning: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	snap := h.Store.Snapshot().Reasoning
+	if snap.ThinkingHistory != "replay" || snap.SignatureKey != "s3cret" || snap.Efforts["high"].Search == nil {
+		t.Fatalf("expected policy and key set with efforts kept, got %#v", snap)
+	}
+
+	rec := httptest.NewRecorder()
+	h.getSettings(rec, httptest.NewRequest(http.MethodGet, "/admin/settings", nil))
+	if strings.Contains(rec.Body.String(), "s3cret") {
+		t.Fatalf("settings leaked the signature key: %s", rec.Body.String())
+	}
+	var body map[string]any
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	reasoning, _ := body["reasoning"].(map[string]any)
+	if reasoning["thinking_history"] != "replay" || reasoning["has_signature_key"] != true {
+		t.Fatalf("unexpected reasoning settings: %#v", reasoning)
+	}
+}
@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	authn "ds2api/internal/auth"
+	"ds2api/internal/config"
+)
+
+func (h *Handler) listAdminUsers(w http.ResponseWriter, _ *http.Request) {
+	users := h.Store.AdminUsers()
+	items := make([]map[string]any, 0, len(users))
+	for _, u := range users {
+		items = append(items, map[string]any{
+			"name":              u.Name,
+			"role":              u.Role,
+			"has_password_hash": strings.TrimSpace(u.PasswordHash) != "",
+			"totp_enabled":      strings.TrimSpace(u.TOTPSecret) != "",
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "total": len(items)})
+}
+
+func (h *Handler) upsertAdminUser(w http.ResponseWriter, r *http.Request) {
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "invalid json"})
+		return
+	}
+	name := fieldString(req, "name")
+	password := fieldString(req, "password")
+	resetTOTP, _ := req["reset_totp"].(bool)
+	role, ok := authn.NormalizeAdminRole(fieldString(req, "role"))
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "role must be viewer, operator or owner"})
+		return
+	}
+	if password != "" && len(password) < 4 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": "password must be at least 4 characters"})
+		return
+	}
+	created := false
+	err := h.Store.Update(func(c *config.Config) error {
+		for i, u := range c.Admin.Users {
+			if u.Name != name {
+				continue
+			}
+			c.Admin.Users[i].Role = role
+			if password != "" {
+				c.Admin.Users[i].PasswordHash = authn.HashAdminPassword(password)
+			}
+			if resetTOTP {
+				c.Admin.Users[i].TOTPSecret = ""
+			}
+			return validateAdminUsers(c.Admin.Users)
+		}
+		if password == "" {
+			return newRequestError("password is required for a new user")
+		}
+		c.Admin.Users = append(c.Admin.Users, config.AdminUser{
+			Name:         name,
+			PasswordHash: authn.HashAdminPassword(password),
+			Role:         role,
+		})
+		created = true
+		return validateAdminUsers(c.Admin.Users)
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "created": created, "name": name, "role": role})
+}
+
+func (h *Handler) deleteAdminUser(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(chi.URLParam(r, "name"))
+	err := h.Store.Update(func(c *config.Config) error {
+		for i, u := range c.Admin.Users {
+			if u.Name == name {
+				c.Admin.Users = append(c.Admin.Users[:i], c.Admin.Users[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("admin user not found")
+	})
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"detail": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "total_users": len(h.Store.AdminUsers())})
+}
+
+func validateAdminUsers(users []config.AdminUser) error {
+	seen := map[string]struct{}{}
+	for _, u := range users {
+		name := strings.TrimSpace(u.Name)
+		if name == "" {
+			return fmt.Errorf("admin.users[].name cannot be empty")
+		}
+		if authn.IsReservedAdminName(name) {
+			return fmt.Errorf("admin.users[].name %q is reserved", name)
+		}
+		if _, dup := seen[name]; dup {
+			return fmt.Errorf("admin.users[].name %q is duplicated", name)
+		}
+		seen[name] = struct{}{}
+		if _, ok := authn.NormalizeAdminRole(u.Role); !ok {
+			return fmt.Errorf("admin.users[].role must be viewer, operator or owner")
+		}
+		if strings.TrimSpace(u.PasswordHash) == "" {
+			return fmt.Errorf("admin.users[].password_hash cannot be empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	authn "ds2api/internal/auth"
+)
+
+func adminRequestAs(t *testing.T, h *Handler, id authn.AdminIdentity, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := authn.CreateAdminJWT(1, id, h.Store)
+	if err != nil {
+		t.Fatalf("create jwt failed: %v", err)
+	}
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	r := chi.NewRouter()
+	RegisterRoutes(r, h)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestViewerRoleRouteRestrictions(t *testing.T) {
+	h := newAdminTestHandler(t, `{
+		"keys":["k1"],
+		"accounts":[{"email":"a@test.com","token":"t"}],
+		"admin":{"users":[{"name":"vic","password_hash":"`+authn.HashAdminPassword("vicpw")+`","role":"viewer"}]}
+	}`)
+	viewer := authn.AdminIdentity{Subject: "vic", Role: authn.AdminRoleViewer}
+
+	for _, path := range []string{"/queue/status", "/accounts", "/settings"} {
+		if rec := adminRequestAs(t, h, viewer, http.MethodGet, path, nil); rec.Code != http.StatusOK {
+			t.Fatalf("viewer GET %s: status=%d body=%s", path, rec.Code, rec.Body.String())
+		}
+	}
+	forbidden := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/config/export"},
+		{http.MethodGet, "/export"},
+		{http.MethodPut, "/settings"},
+		{http.MethodPost, "/vercel/sync"},
+		{http.MethodPost, "/keys"},
+	}
+	for _, tc := range forbidden {
+		if rec := adminRequestAs(t, h, viewer, tc.method, tc.path, map[string]any{}); rec.Code != http.StatusForbidden {
+			t.Fatalf("viewer %s %s: expected 403, got %d", tc.method, tc.path, rec.Code)
+		}
+	}
+}
+
+func TestOwnerManagesAdminUsers(t *testing.T) {
+	h := newAdminTestHandler(t, `{"keys":["k1"]}`)
+	owner := authn.AdminIdentity{Subject: "admin", Role: authn.AdminRoleOwner}
+
+	rec := adminRequestAs(t, h, owner, http.MethodPost, "/users", map[string]any{"name": "ops", "password": "opspw", "role": "operator"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create user: status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	users := h.Store.AdminUsers()
+	if len(users) != 1 || users[0].Role != authn.AdminRoleOperator || users[0].PasswordHash == "" {
+		t.Fatalf("unexpected users: %#v", users)
+	}
+	if rec := adminRequestAs(t, h, owner, http.MethodPost, "/users", map[string]any{"name": "admin", "password": "x1234", "role": "viewer"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected reserved name rejected, got %d", rec.Code)
+	}
+
+	operator := authn.AdminIdentity{Subject: "ops", Role: authn.AdminRoleOperator}
+	if rec := adminRequestAs(t, h, operator, http.MethodGet, "/users", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected operator forbidden from user admin, got %d", rec.Code)
+	}
+	if rec := adminRequestAs(t, h, operator, http.MethodPost, "/keys", map[string]any{"key": "k2"}); rec.Code != http.StatusOK {
+		t.Fatalf("expected operator can add keys, got %d body=%s", rec.Code, rec.Body.String())
+	}
+
+	if rec := adminRequestAs(t, h, owner, http.MethodDelete, "/users/ops", nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete user: status=%d", rec.Code)
+	}
+	if len(h.Store.AdminUsers()) != 0 {
+		t.Fatalf("expected user removed")
+	}
+}
@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"sync"
+	"time"
+)
+
+// loginThrottle locks out a login key (a client IP) after too many
+// consecutive failures. Failures older than the lockout window are forgotten.
+// It also remembers the last TOTP step each subject logged in with, so a
+// code cannot be replayed within its window.
+type loginThrottle struct {
+	mu        sync.Mutex
+	state     map[string]*loginFailures
+	totpSteps map[string]int64
+}
+
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{state: map[string]*loginFailures{}, totpSteps: map[string]int64{}}
+}
+
+// claimTOTP records step as used by subject, refusing steps at or before the
+// last one used.
+func (t *loginThrottle) claimTOTP(subject string, step int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.totpSteps[subject]; ok && step <= last {
+		return false
+	}
+	t.totpSteps[subject] = step
+	return true
+}
+
+// lockedFor returns how long the longest-locked key remains locked.
+func (t *loginThrottle) lockedFor(now time.Time, keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var wait time.Duration
+	for _, k := range keys {
+		st, ok := t.state[k]
+		if !ok {
+			continue
+		}
+		if d := st.lockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+func (t *loginThrottle) fail(now time.Time, maxFailures int, lockout time.Duration, keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweepLocked(now, lockout)
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		st, ok := t.state[k]
+		if !ok {
+			st = &loginFailures{}
+			t.state[k] = st
+		}
+		st.count++
+		st.lastFailure = now
+		if st.count >= maxFailures {
+			st.lockedUntil = now.Add(lockout)
+			st.count = 0
+		}
+	}
+}
+
+func (t *loginThrottle) succeed(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		delete(t.state, k)
+	}
+}
+
+func (t *loginThrottle) sweepLocked(now time.Time, window time.Duration) {
+	for k, st := range t.state {
+		if now.After(st.lockedUntil) && now.Sub(st.lastFailure) > window {
+			delete(t.state, k)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package admin
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ds2api/internal/config"
+)
+
+var (
+	errSessionNotFound    = errors.New("session not found")
+	errRefreshTokenReused = errors.New("refresh token reuse detected; session revoked")
+)
+
+// adminSession tracks one login. Access JWTs carry the session id as jti and
+// are only accepted while the session is active; the refresh token is kept
+// as a hash and rotated on every use.
+type adminSession struct {
+	ID          string `json:"id"`
+	Subject     string `json:"subject"`
+	CreatedAt   int64  `json:"created_at"`
+	LastUsedAt  int64  `json:"last_used_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+	IP          string `json:"ip,omitempty"`
+	UserAgent   string `json:"user_agent,omitempty"`
+	RefreshHash string `json:"refresh_hash"`
+	// PrevRefreshHash lets a reused, already-rotated refresh token be
+	// recognized so the whole session can be revoked.
+	PrevRefreshHash string `json:"prev_refresh_hash,omitempty"`
+	RevokedAt       int64  `json:"revoked_at,omitempty"`
+}
+
+func (s adminSession) active(now time.Time) bool {
+	return s.RevokedAt == 0 && now.Unix() < s.ExpiresAt
+}
+
+func (s adminSession) public(current string) map[string]any {
+	return map[string]any{
+		"id":           s.ID,
+		"subject":      s.Subject,
+		"created_at":   s.CreatedAt,
+		"last_used_at": s.LastUsedAt,
+		"expires_at":   s.ExpiresAt,
+		"ip":           s.IP,
+		"user_agent":   s.UserAgent,
+		"current":      s.ID == current,
+	}
+}
+
+// SessionStore keeps admin sessions in memory and mirrors them to a JSON file
+// when a path is set, so restarts do not log everyone out.
+type SessionStore struct {
+	mu       sync.Mutex
+	path     string
+	sessions map[string]*adminSession
+	// stateless is set where sessions cannot be shared or kept (serverless
+	// instances come and go): a session unknown to this instance is taken on
+	// the token's signature alone, so revocation only reaches the instance
+	// that did it.
+	stateless bool
+}
+
+// NewStatelessSessionStore returns a memory-only store for deployments
+// without persistent storage, such as Vercel.
+func NewStatelessSessionStore() *SessionStore {
+	st := NewSessionStore("")
+	st.stateless = true
+	return st
+}
+
+func NewSessionStore(path string) *SessionStore {
+	st := &SessionStore{path: strings.TrimSpace(path), sessions: map[string]*adminSession{}}
+	if st.path == "" {
+		return st
+	}
+	b, err := os.ReadFile(st.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			config.Logger.Warn("[admin_sessions] load failed", "path", st.path, "error", err)
+		}
+		return st
+	}
+	var list []*adminSession
+	if err := json.Unmarshal(b, &list); err != nil {
+		config.Logger.Warn("[admin_sessions] load failed", "path", st.path, "error", err)
+		return st
+	}
+	for _, s := range list {
+		if s != nil && s.ID != "" {
+			st.sessions[s.ID] = s
+		}
+	}
+	return st
+}
+
+// create starts a session and returns it with its plaintext refresh token.
+func (st *SessionStore) create(subject, ip, userAgent string, ttl time.Duration) (adminSession, string) {
+	now := time.Now()
+	refresh := randomToken("rt_")
+	s := &adminSession{
+		ID:          randomToken("sess_"),
+		Subject:     subject,
+		CreatedAt:   now.Unix(),
+		LastUsedAt:  now.Unix(),
+		ExpiresAt:   now.Add(ttl).Unix(),
+		IP:          ip,
+		UserAgent:   userAgent,
+		RefreshHash: hashRefreshToken(refresh),
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.sweepLocked(now)
+	st.sessions[s.ID] = s
+	st.saveLocked()
+	return *s, refresh
+}
+
+// touch reports whether the session is active for subject and records use.
+// A stateless store accepts sessions it does not know.
+func (st *SessionStore) touch(id, subject string) bool {
+	now := time.Now()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[id]
+	if !ok {
+		return st.stateless
+	}
+	if s.Subject != subject || !s.active(now) {
+		return false
+	}
+	s.LastUsedAt = now.Unix()
+	return true
+}
+
+// rotate exchanges a refresh token for a new one. Presenting the previous
+// token of a session revokes it, since that means the token leaked.
+func (st *SessionStore) rotate(refresh string) (adminSession, string, error) {
+	now := time.Now()
+	h := hashRefreshToken(refresh)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, s := range st.sessions {
+		if s.PrevRefreshHash != "" && subtle.ConstantTimeCompare([]byte(s.PrevRefreshHash), []byte(h)) == 1 {
+			if s.RevokedAt == 0 {
+				s.RevokedAt = now.Unix()
+				st.saveLocked()
+			}
+			return adminSession{}, "", errRefreshTokenReused
+		}
+		if subtle.ConstantTimeCompare([]byte(s.RefreshHash), []byte(h)) != 1 {
+			continue
+		}
+		if !s.active(now) {
+			return adminSession{}, "", errSessionNotFound
+		}
+		next := randomToken("rt_")
+		s.PrevRefreshHash = s.RefreshHash
+		s.RefreshHash = hashRefreshToken(next)
+		s.LastUsedAt = now.Unix()
+		st.saveLocked()
+		return *s, next, nil
+	}
+	return adminSession{}, "", errSessionNotFound
+}
+
+func (st *SessionStore) get(id string) (adminSession, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[id]
+	if !ok {
+		return adminSession{}, false
+	}
+	return *s, true
+}
+
+func (st *SessionStore) revoke(id string) bool {
+	now := time.Now()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[id]
+	if !ok || !s.active(now) {
+		return false
+	}
+	s.RevokedAt = now.Unix()
+	st.saveLocked()
+	return true
+}
+
+// list returns active sessions, newest first; an empty subject lists all.
+func (st *SessionStore) list(subject string) []adminSession {
+	now := time.Now()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make([]adminSession, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		if !s.active(now) {
+			continue
+		}
+		if subject != "" && s.Subject != subject {
+			continue
+		}
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out
+}
+
+func (st *SessionStore) sweepLocked(now time.Time) {
+	for id, s := range st.sessions {
+		if now.Unix() >= s.ExpiresAt {
+			delete(st.sessions, id)
+		}
+	}
+}
+
+func (st *SessionStore) saveLocked() {
+	if st.path == "" {
+		return
+	}
+	list := make([]*adminSession, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		list = append(list, s)
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(st.path), 0o755); err != nil {
+		config.Logger.Warn("[admin_sessions] save failed", "path", st.path, "error", err)
+		return
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		config.Logger.Warn("[admin_sessions] save failed", "path", st.path, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		config.Logger.Warn("[admin_sessions] save failed", "path", st.path, "error", err)
+	}
+}
+
+func randomToken(prefix string) string {
+	buf := make([]byte, 24)
+	_, _ = rand.Read(buf)
+	return prefix + hex.EncodeToString(buf)
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
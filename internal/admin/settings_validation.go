@@ -12,21 +12,68 @@ func normalizeSettingsConfig(c *config.Config) {
 		return
 	}
 	c.Admin.PasswordHash = strings.TrimSpace(c.Admin.PasswordHash)
+	c.Admin.TOTPSecret = strings.TrimSpace(c.Admin.TOTPSecret)
+	for i := range c.Admin.Users {
+		c.Admin.Users[i].Name = strings.TrimSpace(c.Admin.Users[i].Name)
+		c.Admin.Users[i].Role = strings.ToLower(strings.TrimSpace(c.Admin.Users[i].Role))
+		c.Admin.Users[i].PasswordHash = strings.TrimSpace(c.Admin.Users[i].PasswordHash)
+		c.Admin.Users[i].TOTPSecret = strings.TrimSpace(c.Admin.Users[i].TOTPSecret)
+	}
+	for i := range c.KeyPolicies {
+		c.KeyPolicies[i] = normalizeKeyPolicy(c.KeyPolicies[i])
+	}
+	c.Passthrough.Mode = strings.ToLower(strings.TrimSpace(c.Passthrough.Mode))
+	c.Passthrough.AllowedTokenHashes = normalizeTokenHashes(c.Passthrough.AllowedTokenHashes)
+	c.Network.TrustedProxyHeaders = trimStringList(c.Network.TrustedProxyHeaders)
+	c.Network.TrustedProxyCIDRs = trimStringList(c.Network.TrustedProxyCIDRs)
 	c.Toolcall.Mode = strings.ToLower(strings.TrimSpace(c.Toolcall.Mode))
 	c.Toolcall.EarlyEmitConfidence = strings.ToLower(strings.TrimSpace(c.Toolcall.EarlyEmitConfidence))
 	c.Embeddings.Provider = strings.TrimSpace(c.Embeddings.Provider)
+	c.Reasoning.Efforts = normalizeReasoningEfforts(c.Reasoning.Efforts)
+	c.Reasoning.ThinkingHistory = strings.ToLower(strings.TrimSpace(c.Reasoning.ThinkingHistory))
+	c.Reasoning.SignatureKey = strings.TrimSpace(c.Reasoning.SignatureKey)
 }
 
 func validateSettingsConfig(c config.Config) error {
 	if c.Admin.JWTExpireHours != 0 && (c.Admin.JWTExpireHours < 1 || c.Admin.JWTExpireHours > 720) {
 		return fmt.Errorf("admin.jwt_expire_hours must be between 1 and 720")
 	}
+	if c.Admin.AccessTokenMinutes != 0 && (c.Admin.AccessTokenMinutes < 1 || c.Admin.AccessTokenMinutes > 1440) {
+		return fmt.Errorf("admin.access_token_minutes must be between 1 and 1440")
+	}
+	if c.Admin.LoginMaxFailures != 0 && (c.Admin.LoginMaxFailures < 1 || c.Admin.LoginMaxFailures > 100) {
+		return fmt.Errorf("admin.login_max_failures must be between 1 and 100")
+	}
+	if c.Admin.LoginLockoutSeconds != 0 && (c.Admin.LoginLockoutSeconds < 10 || c.Admin.LoginLockoutSeconds > 86400) {
+		return fmt.Errorf("admin.login_lockout_seconds must be between 10 and 86400")
+	}
+	if err := validateAdminUsers(c.Admin.Users); err != nil {
+		return err
+	}
 	if err := validateRuntimeSettings(c.Runtime); err != nil {
 		return err
 	}
+	if err := validateKeyPolicies(c.KeyPolicies, c.Keys); err != nil {
+		return err
+	}
+	if err := validateNetworkSettings(c.Network); err != nil {
+		return err
+	}
+	if err := validatePassthroughSettings(c.Passthrough); err != nil {
+		return err
+	}
+	if err := validateReasoningSettings(c.Reasoning); err != nil {
+		return err
+	}
 	if c.Responses.StoreTTLSeconds != 0 && (c.Responses.StoreTTLSeconds < 30 || c.Responses.StoreTTLSeconds > 86400) {
 		return fmt.Errorf("responses.store_ttl_seconds must be between 30 and 86400")
 	}
+	if c.Audit.MaxEntries != 0 && (c.Audit.MaxEntries < 100 || c.Audit.MaxEntries > 1000000) {
+		return fmt.Errorf("audit.max_entries must be between 100 and 1000000")
+	}
+	if c.Audit.RetentionDays != 0 && (c.Audit.RetentionDays < 1 || c.Audit.RetentionDays > 3650) {
+		return fmt.Errorf("audit.retention_days must be between 1 and 3650")
+	}
 	if mode := strings.TrimSpace(c.Toolcall.Mode); mode != "" {
 		switch mode {
 		case "feature_match", "off":
@@ -57,8 +104,166 @@ func validateRuntimeSettings(runtime config.RuntimeConfig) error {
 	if runtime.GlobalMaxInflight != 0 && (runtime.GlobalMaxInflight < 1 || runtime.GlobalMaxInflight > 200000) {
 		return fmt.Errorf("runtime.global_max_inflight must be between 1 and 200000")
 	}
+	if runtime.MaxChoices != 0 && (runtime.MaxChoices < 1 || runtime.MaxChoices > 16) {
+		return fmt.Errorf("runtime.max_choices must be between 1 and 16")
+	}
+	if runtime.BatchConcurrencyShare != 0 && (runtime.BatchConcurrencyShare < 1 || runtime.BatchConcurrencyShare > 100) {
+		return fmt.Errorf("runtime.batch_concurrency_share must be between 1 and 100")
+	}
 	if runtime.AccountMaxInflight > 0 && runtime.GlobalMaxInflight > 0 && runtime.GlobalMaxInflight < runtime.AccountMaxInflight {
 		return fmt.Errorf("runtime.global_max_inflight must be >= runtime.account_max_inflight")
 	}
 	return nil
 }
+
+func normalizeKeyPolicy(p config.KeyPolicy) config.KeyPolicy {
+	p.Key = strings.TrimSpace(p.Key)
+	p.AllowedCIDRs = trimStringList(p.AllowedCIDRs)
+	p.ClientCertSubjects = trimStringList(p.ClientCertSubjects)
+	p.ClientCertFingerprints = trimStringList(p.ClientCertFingerprints)
+	for i, fp := range p.ClientCertFingerprints {
+		p.ClientCertFingerprints[i] = config.NormalizeCertFingerprint(fp)
+	}
+	return p
+}
+
+func validateKeyPolicies(policies []config.KeyPolicy, keys []string) error {
+	known := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		known[k] = true
+	}
+	seenKeys := map[string]bool{}
+	seenCerts := map[string]bool{}
+	for _, p := range policies {
+		if p.Key == "" {
+			return fmt.Errorf("key_policies.key cannot be empty")
+		}
+		if !known[p.Key] {
+			return fmt.Errorf("key_policies references a key that is not in keys")
+		}
+		if seenKeys[p.Key] {
+			return fmt.Errorf("key_policies contains duplicate entries for one key")
+		}
+		seenKeys[p.Key] = true
+		if _, err := config.ParseCIDRs(p.AllowedCIDRs); err != nil {
+			return fmt.Errorf("key_policies.allowed_cidrs: %v", err)
+		}
+		for _, fp := range p.ClientCertFingerprints {
+			if !isSHA256Hex(fp) {
+				return fmt.Errorf("key_policies.client_cert_fingerprints must be SHA-256 hex digests")
+			}
+			if seenCerts["fp:"+fp] {
+				return fmt.Errorf("client certificate fingerprint %s is bound to more than one key", fp)
+			}
+			seenCerts["fp:"+fp] = true
+		}
+		for _, subject := range p.ClientCertSubjects {
+			if seenCerts["subject:"+subject] {
+				return fmt.Errorf("client certificate subject %q is bound to more than one key", subject)
+			}
+			seenCerts["subject:"+subject] = true
+		}
+	}
+	return nil
+}
+
+func validateNetworkSettings(n config.NetworkConfig) error {
+	for _, h := range n.TrustedProxyHeaders {
+		switch strings.ToLower(h) {
+		case "true-client-ip", "x-real-ip", "x-forwarded-for", "none":
+		default:
+			return fmt.Errorf("network.trusted_proxy_headers entries must be True-Client-IP, X-Real-IP, X-Forwarded-For or none")
+		}
+	}
+	if _, err := config.ParseCIDRs(n.TrustedProxyCIDRs); err != nil {
+		return fmt.Errorf("network.trusted_proxy_cidrs: %v", err)
+	}
+	return nil
+}
+
+func validatePassthroughSettings(p config.PassthroughConfig) error {
+	switch p.Mode {
+	case "", config.PassthroughAllow, config.PassthroughDeny, config.PassthroughAllowlist:
+	default:
+		return fmt.Errorf("passthrough.mode must be allow, deny or allowlist")
+	}
+	for _, h := range p.AllowedTokenHashes {
+		if !isSHA256Hex(h) {
+			return fmt.Errorf("passthrough.allowed_token_hashes must be SHA-256 hex digests of the raw tokens")
+		}
+	}
+	return nil
+}
+
+// normalizeReasoningEfforts lower-cases effort names, the form requests are
+// matched in.
+func normalizeReasoningEfforts(in map[string]config.ReasoningRule) map[string]config.ReasoningRule {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]config.ReasoningRule, len(in))
+	for k, r := range in {
+		out[strings.ToLower(strings.TrimSpace(k))] = r
+	}
+	return out
+}
+
+func validateReasoningSettings(r config.ReasoningConfig) error {
+	for effort, rule := range r.Efforts {
+		if effort == "" {
+			return fmt.Errorf("reasoning.efforts names cannot be empty")
+		}
+		if rule.Thinking == nil && rule.Search == nil {
+			return fmt.Errorf("reasoning.efforts.%s must set thinking or search", effort)
+		}
+	}
+	switch r.ThinkingHistory {
+	case "", config.ThinkingHistoryDrop, config.ThinkingHistoryReplay, config.ThinkingHistoryIgnore:
+	default:
+		return fmt.Errorf("reasoning.thinking_history must be drop, replay or ignore")
+	}
+	return nil
+}
+
+func normalizeTokenHashes(in []string) []string {
+	out := trimStringList(in)
+	for i, h := range out {
+		out[i] = strings.ToLower(h)
+	}
+	return out
+}
+
+func isSHA256Hex(v string) bool {
+	return len(v) == 64 && strings.Trim(v, "0123456789abcdef") == ""
+}
+
+// pruneKeyPolicies drops policies whose key was removed from keys.
+func pruneKeyPolicies(c *config.Config) {
+	if len(c.KeyPolicies) == 0 {
+		return
+	}
+	known := make(map[string]bool, len(c.Keys))
+	for _, k := range c.Keys {
+		known[k] = true
+	}
+	kept := c.KeyPolicies[:0]
+	for _, p := range c.KeyPolicies {
+		if known[p.Key] {
+			kept = append(kept, p)
+		}
+	}
+	c.KeyPolicies = kept
+}
+
+func trimStringList(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	out := make([]string, 0, len(in))
+	for _, v := range in {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/subtle"
@@ -15,14 +16,79 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"ds2api/internal/config"
 )
 
 var warnOnce sync.Once
 
+// ErrInvalidAdminCredential is returned for a bearer that is neither the
+// admin key nor a token this server signed: a guessed credential, which
+// callers throttle like a failed login.
+var ErrInvalidAdminCredential = errors.New("invalid credentials")
+
+var (
+	errJWTFormat    = errors.New("invalid token format")
+	errJWTSignature = errors.New("invalid signature")
+)
+
 type AdminConfigReader interface {
 	AdminPasswordHash() string
 	AdminJWTExpireHours() int
 	AdminJWTValidAfterUnix() int64
+	AdminUsers() []config.AdminUser
+	AdminTOTPSecret() string
+}
+
+const (
+	AdminRoleViewer   = "viewer"
+	AdminRoleOperator = "operator"
+	AdminRoleOwner    = "owner"
+
+	// legacyAdminSubject identifies the shared DS2API_ADMIN_KEY /
+	// admin.password_hash credential, which always acts as owner.
+	legacyAdminSubject = "admin"
+)
+
+var adminRoleRank = map[string]int{
+	AdminRoleViewer:   1,
+	AdminRoleOperator: 2,
+	AdminRoleOwner:    3,
+}
+
+// AdminIdentity is the authenticated admin principal behind a request.
+// SessionID is the JWT id; it is empty for the raw admin key and for tokens
+// issued before sessions were tracked.
+type AdminIdentity struct {
+	Subject   string
+	Role      string
+	SessionID string
+}
+
+// Allows reports whether the identity's role is at least the required role.
+func (id AdminIdentity) Allows(required string) bool {
+	have, ok := adminRoleRank[id.Role]
+	if !ok {
+		return false
+	}
+	return have >= adminRoleRank[required]
+}
+
+func NormalizeAdminRole(role string) (string, bool) {
+	role = strings.ToLower(strings.TrimSpace(role))
+	_, ok := adminRoleRank[role]
+	return role, ok
+}
+
+const adminCtxKey ctxKey = "admin_identity"
+
+func WithAdminIdentity(ctx context.Context, id AdminIdentity) context.Context {
+	return context.WithValue(ctx, adminCtxKey, id)
+}
+
+func AdminIdentityFromContext(ctx context.Context) (AdminIdentity, bool) {
+	id, ok := ctx.Value(adminCtxKey).(AdminIdentity)
+	return id, ok
 }
 
 func AdminKey() string {
@@ -75,9 +141,20 @@ func CreateJWT(expireHours int) (string, error) {
 }
 
 func CreateJWTWithStore(expireHours int, store AdminConfigReader) (string, error) {
+	return CreateAdminJWT(expireHours, AdminIdentity{Subject: legacyAdminSubject, Role: AdminRoleOwner}, store)
+}
+
+// CreateAdminJWT mints a token carrying the identity's subject and role.
+func CreateAdminJWT(expireHours int, id AdminIdentity, store AdminConfigReader) (string, error) {
 	if expireHours <= 0 {
 		expireHours = jwtExpireHours(store)
 	}
+	return CreateAdminAccessToken(time.Duration(expireHours)*time.Hour, id, store)
+}
+
+// CreateAdminAccessToken mints a token valid for ttl. When the identity has a
+// session id it is embedded as the jti claim.
+func CreateAdminAccessToken(ttl time.Duration, id AdminIdentity, store AdminConfigReader) (string, error) {
 	issuedAt := time.Now().Unix()
 	// If sessions were invalidated in this same second, move iat forward by
 	// one second so newly minted tokens remain valid with strict cutoff checks.
@@ -86,9 +163,12 @@ func CreateJWTWithStore(expireHours int, store AdminConfigReader) (string, error
 			issuedAt = validAfter + 1
 		}
 	}
-	expireAt := time.Unix(issuedAt, 0).Add(time.Duration(expireHours) * time.Hour).Unix()
+	expireAt := time.Unix(issuedAt, 0).Add(ttl).Unix()
 	header := map[string]any{"alg": "HS256", "typ": "JWT"}
-	payload := map[string]any{"iat": issuedAt, "exp": expireAt, "role": "admin"}
+	payload := map[string]any{"iat": issuedAt, "exp": expireAt, "sub": id.Subject, "role": id.Role}
+	if id.SessionID != "" {
+		payload["jti"] = id.SessionID
+	}
 	h, _ := json.Marshal(header)
 	p, _ := json.Marshal(payload)
 	headerB64 := rawB64Encode(h)
@@ -105,16 +185,16 @@ func VerifyJWT(token string) (map[string]any, error) {
 func VerifyJWTWithStore(token string, store AdminConfigReader) (map[string]any, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
-		return nil, errors.New("invalid token format")
+		return nil, errJWTFormat
 	}
 	msg := parts[0] + "." + parts[1]
 	expected := signHS256(msg, store)
 	actual, err := rawB64Decode(parts[2])
 	if err != nil {
-		return nil, errors.New("invalid signature")
+		return nil, errJWTSignature
 	}
 	if !hmac.Equal(expected, actual) {
-		return nil, errors.New("invalid signature")
+		return nil, errJWTSignature
 	}
 	payloadBytes, err := rawB64Decode(parts[1])
 	if err != nil {
@@ -145,21 +225,125 @@ func VerifyAdminRequest(r *http.Request) error {
 }
 
 func VerifyAdminRequestWithStore(r *http.Request, store AdminConfigReader) error {
+	_, err := ResolveAdminRequest(r, store)
+	return err
+}
+
+// ResolveAdminRequest authenticates the bearer credential of an admin request
+// and returns the identity it acts as. The shared admin key acts as owner;
+// JWTs take their role from the current user list so demotions and removals
+// apply to tokens that were already issued.
+func ResolveAdminRequest(r *http.Request, store AdminConfigReader) (AdminIdentity, error) {
 	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
 	if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
-		return errors.New("authentication required")
+		return AdminIdentity{}, errors.New("authentication required")
 	}
 	token := strings.TrimSpace(authHeader[7:])
 	if token == "" {
-		return errors.New("authentication required")
+		return AdminIdentity{}, errors.New("authentication required")
+	}
+	// Once the shared credential has TOTP enabled, presenting it directly as a
+	// bearer would bypass the second factor, so only JWTs are accepted.
+	if AdminTOTPSecretFor(legacyAdminSubject, store) == "" && VerifyAdminCredential(token, store) {
+		return AdminIdentity{Subject: legacyAdminSubject, Role: AdminRoleOwner}, nil
 	}
-	if VerifyAdminCredential(token, store) {
-		return nil
+	payload, err := VerifyJWTWithStore(token, store)
+	if errors.Is(err, errJWTFormat) || errors.Is(err, errJWTSignature) {
+		return AdminIdentity{}, ErrInvalidAdminCredential
 	}
-	if _, err := VerifyJWTWithStore(token, store); err == nil {
-		return nil
+	if err != nil {
+		return AdminIdentity{}, errors.New("invalid credentials")
 	}
-	return errors.New("invalid credentials")
+	return AdminIdentityFromClaims(payload, store)
+}
+
+// AdminIdentityFromClaims maps verified JWT claims to an identity. Tokens
+// minted before named users existed carry role "admin" and no subject.
+func AdminIdentityFromClaims(payload map[string]any, store AdminConfigReader) (AdminIdentity, error) {
+	sub, _ := payload["sub"].(string)
+	id, err := AdminIdentityForSubject(sub, store)
+	if err != nil {
+		return AdminIdentity{}, err
+	}
+	id.SessionID, _ = payload["jti"].(string)
+	return id, nil
+}
+
+// AdminIdentityForSubject resolves the current role of subject.
+func AdminIdentityForSubject(subject string, store AdminConfigReader) (AdminIdentity, error) {
+	subject = strings.TrimSpace(subject)
+	if subject == "" || subject == legacyAdminSubject {
+		return AdminIdentity{Subject: legacyAdminSubject, Role: AdminRoleOwner}, nil
+	}
+	user, ok := findAdminUser(store, subject)
+	if !ok {
+		return AdminIdentity{}, errors.New("invalid credentials")
+	}
+	role, ok := NormalizeAdminRole(user.Role)
+	if !ok {
+		return AdminIdentity{}, errors.New("invalid credentials")
+	}
+	return AdminIdentity{Subject: user.Name, Role: role}, nil
+}
+
+// AdminTOTPSecretFor returns the enrolled TOTP secret of subject, or "" when
+// the subject has no second factor.
+func AdminTOTPSecretFor(subject string, store AdminConfigReader) string {
+	if store == nil {
+		return ""
+	}
+	if IsReservedAdminName(subject) {
+		return strings.TrimSpace(store.AdminTOTPSecret())
+	}
+	user, ok := findAdminUser(store, strings.TrimSpace(subject))
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(user.TOTPSecret)
+}
+
+// AuthenticateAdminLogin checks login credentials. An empty username (or the
+// reserved "admin") falls back to the shared admin key; otherwise the named
+// user's hash is checked.
+func AuthenticateAdminLogin(username, password string, store AdminConfigReader) (AdminIdentity, bool) {
+	username = strings.TrimSpace(username)
+	if username == "" || IsReservedAdminName(username) {
+		if VerifyAdminCredential(password, store) {
+			return AdminIdentity{Subject: legacyAdminSubject, Role: AdminRoleOwner}, true
+		}
+		return AdminIdentity{}, false
+	}
+	user, ok := findAdminUser(store, username)
+	if !ok {
+		return AdminIdentity{}, false
+	}
+	password = strings.TrimSpace(password)
+	if password == "" || !verifyAdminPasswordHash(password, user.PasswordHash) {
+		return AdminIdentity{}, false
+	}
+	role, ok := NormalizeAdminRole(user.Role)
+	if !ok {
+		return AdminIdentity{}, false
+	}
+	return AdminIdentity{Subject: user.Name, Role: role}, true
+}
+
+func findAdminUser(store AdminConfigReader, name string) (config.AdminUser, bool) {
+	if store == nil {
+		return config.AdminUser{}, false
+	}
+	for _, u := range store.AdminUsers() {
+		if strings.TrimSpace(u.Name) == name {
+			return u, true
+		}
+	}
+	return config.AdminUser{}, false
+}
+
+// IsReservedAdminName reports whether name collides with the subject used by
+// the shared admin key; such names cannot be assigned to named users.
+func IsReservedAdminName(name string) bool {
+	return strings.EqualFold(strings.TrimSpace(name), legacyAdminSubject)
 }
 
 func VerifyAdminCredential(candidate string, store AdminConfigReader) bool {
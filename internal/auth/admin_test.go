@@ -16,11 +16,56 @@ func TestJWTCreateVerify(t *testing.T) {
 	if err != nil {
 		t.Fatalf("verify jwt failed: %v", err)
 	}
-	if payload["role"] != "admin" {
+	if payload["role"] != AdminRoleOwner || payload["sub"] != "admin" {
 		t.Fatalf("unexpected payload: %#v", payload)
 	}
 }
 
+func TestNamedAdminUserLoginCarriesRole(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{"admin":{"users":[{"name":"alice","password_hash":"`+HashAdminPassword("alicepw")+`","role":"viewer"}]}}`)
+	store := config.LoadStore()
+	if _, ok := AuthenticateAdminLogin("alice", "wrong", store); ok {
+		t.Fatal("expected wrong password rejected")
+	}
+	id, ok := AuthenticateAdminLogin("alice", "alicepw", store)
+	if !ok || id.Subject != "alice" || id.Role != AdminRoleViewer {
+		t.Fatalf("unexpected identity: %#v ok=%v", id, ok)
+	}
+	token, err := CreateAdminJWT(1, id, store)
+	if err != nil {
+		t.Fatalf("create jwt failed: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/admin/accounts", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	got, err := ResolveAdminRequest(req, store)
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if got.Allows(AdminRoleOperator) || !got.Allows(AdminRoleViewer) {
+		t.Fatalf("unexpected role permissions: %#v", got)
+	}
+
+	// Role changes apply to already-issued tokens; removal revokes them.
+	if err := store.Update(func(c *config.Config) error {
+		c.Admin.Users[0].Role = AdminRoleOwner
+		return nil
+	}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if got, _ := ResolveAdminRequest(req, store); got.Role != AdminRoleOwner {
+		t.Fatalf("expected promoted role, got %#v", got)
+	}
+	if err := store.Update(func(c *config.Config) error {
+		c.Admin.Users = nil
+		return nil
+	}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if _, err := ResolveAdminRequest(req, store); err == nil {
+		t.Fatal("expected token of removed user rejected")
+	}
+}
+
 func TestVerifyAdminRequest(t *testing.T) {
 	token, _ := CreateJWT(1)
 	req, _ := http.NewRequest(http.MethodGet, "/admin/config", nil)
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrCredentialGone means the credential background work was queued with
+// can no longer be recovered: its managed key was removed, or the key it was
+// sealed with changed.
+var ErrCredentialGone = errors.New("unauthorized: the credential this work was submitted with is no longer available")
+
+const (
+	credentialRefKey    = "key:"
+	credentialRefSealed = "sealed:"
+)
+
+// credentialRef returns a reference to key that is safe to write to disk. A
+// managed key is referenced by its SHA-256 digest and looked up again in the
+// config; any other token is sealed with Store.CredentialKey.
+func (r *Resolver) credentialRef(key string) string {
+	if key == "" || r == nil || r.Store == nil {
+		return ""
+	}
+	if r.Store.HasAPIKey(key) {
+		sum := sha256.Sum256([]byte(key))
+		return credentialRefKey + hex.EncodeToString(sum[:])
+	}
+	gcm, err := credentialCipher(r.Store.CredentialKey())
+	if err != nil {
+		return ""
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return ""
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(key), nil)
+	return credentialRefSealed + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// resolveCredentialRef turns a reference made by credentialRef back into the
+// credential.
+func (r *Resolver) resolveCredentialRef(ref string) (string, error) {
+	if digest, ok := strings.CutPrefix(ref, credentialRefKey); ok {
+		want, err := hex.DecodeString(digest)
+		if err != nil {
+			return "", ErrCredentialGone
+		}
+		for _, key := range r.Store.Keys() {
+			sum := sha256.Sum256([]byte(key))
+			if subtle.ConstantTimeCompare(sum[:], want) == 1 {
+				return key, nil
+			}
+		}
+		return "", ErrCredentialGone
+	}
+	if encoded, ok := strings.CutPrefix(ref, credentialRefSealed); ok {
+		sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", ErrCredentialGone
+		}
+		gcm, err := credentialCipher(r.Store.CredentialKey())
+		if err != nil || len(sealed) < gcm.NonceSize() {
+			return "", ErrCredentialGone
+		}
+		plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err != nil {
+			return "", ErrCredentialGone
+		}
+		return string(plain), nil
+	}
+	return "", ErrCredentialGone
+}
+
+func credentialCipher(key []byte) (cipher.AEAD, error) {
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"ds2api/internal/config"
+)
+
+var (
+	ErrPassthroughDenied     = errors.New("unauthorized: this deployment only accepts managed API keys; raw DeepSeek tokens are not forwarded")
+	ErrPassthroughNotAllowed = errors.New("unauthorized: token is not a managed API key and is not on the passthrough allowlist")
+)
+
+const maxTrackedPassthroughTokens = 1000
+
+type passthroughTokenStat struct {
+	Requests int64
+	Denied   int64
+	LastSeen int64
+}
+
+// passthroughStats counts raw-token requests. Tokens are tracked only by
+// their SHA-256 digest, which is also what the allowlist stores.
+type passthroughStats struct {
+	mu      sync.Mutex
+	allowed int64
+	denied  int64
+	tokens  map[string]*passthroughTokenStat
+}
+
+func (s *passthroughStats) record(hash string, allowed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if allowed {
+		s.allowed++
+	} else {
+		s.denied++
+	}
+	if s.tokens == nil {
+		s.tokens = map[string]*passthroughTokenStat{}
+	}
+	st, ok := s.tokens[hash]
+	if !ok {
+		if len(s.tokens) >= maxTrackedPassthroughTokens {
+			s.evictOldestLocked()
+		}
+		st = &passthroughTokenStat{}
+		s.tokens[hash] = st
+	}
+	if allowed {
+		st.Requests++
+	} else {
+		st.Denied++
+	}
+	st.LastSeen = time.Now().Unix()
+}
+
+func (s *passthroughStats) evictOldestLocked() {
+	oldest, oldestAt := "", int64(0)
+	for h, st := range s.tokens {
+		if oldest == "" || st.LastSeen < oldestAt {
+			oldest, oldestAt = h, st.LastSeen
+		}
+	}
+	delete(s.tokens, oldest)
+}
+
+// checkPassthrough applies the passthrough policy to a bearer token that is
+// not a managed key, recording the outcome either way.
+func (r *Resolver) checkPassthrough(token string) error {
+	hash := config.PassthroughTokenHash(token)
+	var err error
+	switch r.Store.PassthroughMode() {
+	case config.PassthroughDeny:
+		err = ErrPassthroughDenied
+	case config.PassthroughAllowlist:
+		if !r.Store.PassthroughTokenAllowed(hash) {
+			err = ErrPassthroughNotAllowed
+		}
+	}
+	r.passthrough.record(hash, err == nil)
+	return err
+}
+
+// PassthroughStats reports raw-token usage for the admin queue status page.
+func (r *Resolver) PassthroughStats() map[string]any {
+	s := &r.passthrough
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := make([]map[string]any, 0, len(s.tokens))
+	for h, st := range s.tokens {
+		tokens = append(tokens, map[string]any{
+			"token_sha256": h,
+			"requests":     st.Requests,
+			"denied":       st.Denied,
+			"last_seen":    st.LastSeen,
+		})
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i]["last_seen"].(int64) > tokens[j]["last_seen"].(int64)
+	})
+	if len(tokens) > 20 {
+		tokens = tokens[:20]
+	}
+	return map[string]any{
+		"mode":            r.Store.PassthroughMode(),
+		"allowed_total":   s.allowed,
+		"denied_total":    s.denied,
+		"distinct_tokens": len(s.tokens),
+		"recent_tokens":   tokens,
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"ds2api/internal/config"
+)
+
+func newPassthroughResolver(t *testing.T, passthrough string) *Resolver {
+	t.Helper()
+	return newPolicyResolver(t, `"passthrough":`+passthrough)
+}
+
+func bearerRequest(token string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestPassthroughDefaultAllowsWithIsolatedTenant(t *testing.T) {
+	r := newPassthroughResolver(t, `{}`)
+	a, err := r.Determine(bearerRequest("raw-ds-token"))
+	if err != nil {
+		t.Fatalf("determine failed: %v", err)
+	}
+	if !a.Passthrough || a.DeepSeekToken != "raw-ds-token" {
+		t.Fatalf("expected passthrough auth, got %#v", a)
+	}
+	if !strings.HasPrefix(a.CallerID, "passthrough:") || a.CallerID == callerTokenID("raw-ds-token") {
+		t.Fatalf("expected passthrough tenant namespace, got %q", a.CallerID)
+	}
+	caller, err := r.DetermineCaller(bearerRequest("raw-ds-token"))
+	if err != nil || caller.CallerID != a.CallerID {
+		t.Fatalf("expected DetermineCaller to agree on tenant, got %q err=%v", caller.CallerID, err)
+	}
+}
+
+func TestPassthroughDenyRejectsRawTokens(t *testing.T) {
+	r := newPassthroughResolver(t, `{"mode":"deny"}`)
+	if _, err := r.Determine(bearerRequest("raw-ds-token")); err != ErrPassthroughDenied {
+		t.Fatalf("expected ErrPassthroughDenied, got %v", err)
+	}
+	if _, err := r.DetermineCaller(bearerRequest("raw-ds-token")); err != ErrPassthroughDenied {
+		t.Fatalf("expected DetermineCaller denied too, got %v", err)
+	}
+	a, err := r.Determine(bearerRequest("open-key"))
+	if err != nil {
+		t.Fatalf("managed key should still work: %v", err)
+	}
+	r.Release(a)
+
+	stats := r.PassthroughStats()
+	if stats["mode"] != config.PassthroughDeny || stats["denied_total"].(int64) != 2 || stats["allowed_total"].(int64) != 0 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+}
+
+func TestPassthroughAllowlistByHash(t *testing.T) {
+	r := newPassthroughResolver(t, `{"mode":"allowlist","allowed_token_hashes":["`+config.PassthroughTokenHash("good-token")+`"]}`)
+	if _, err := r.Determine(bearerRequest("good-token")); err != nil {
+		t.Fatalf("allowlisted token rejected: %v", err)
+	}
+	if _, err := r.Determine(bearerRequest("other-token")); err != ErrPassthroughNotAllowed {
+		t.Fatalf("expected ErrPassthroughNotAllowed, got %v", err)
+	}
+	stats := r.PassthroughStats()
+	if stats["distinct_tokens"].(int) != 2 || stats["allowed_total"].(int64) != 1 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync/atomic"
 
 	"ds2api/internal/account"
 	"ds2api/internal/config"
@@ -19,16 +22,27 @@ const authCtxKey ctxKey = "auth_context"
 var (
 	ErrUnauthorized = errors.New("unauthorized: missing auth token")
 	ErrNoAccount    = errors.New("no accounts configured or all accounts are busy")
+	// ErrClientNotAllowed means the key is valid but its allowlist does not
+	// cover the client address; callers should answer 403.
+	ErrClientNotAllowed = errors.New("forbidden: client address is not allowed for this API key")
 )
 
 type RequestAuth struct {
 	UseConfigToken bool
-	DeepSeekToken  string
-	CallerID       string
-	AccountID      string
-	Account        config.Account
-	TriedAccounts  map[string]bool
-	resolver       *Resolver
+	// Passthrough marks a raw DeepSeek token forwarded as-is; its CallerID
+	// lives in a separate namespace from managed keys.
+	Passthrough bool
+	// SearchSources is the caller key's default for the search progress
+	// and source extensions of chat completions.
+	SearchSources bool
+	DeepSeekToken string
+	CallerID      string
+	AccountID     string
+	Account       config.Account
+	TriedAccounts map[string]bool
+	resolver      *Resolver
+	// idle marks a lease from AcquireIdle that holds a batch slot.
+	idle bool
 }
 
 type LoginFunc func(ctx context.Context, acc config.Account) (string, error)
@@ -37,6 +51,10 @@ type Resolver struct {
 	Store *config.Store
 	Pool  *account.Pool
 	Login LoginFunc
+
+	passthrough passthroughStats
+	// idleInflight counts the leases from AcquireIdle not yet released.
+	idleInflight atomic.Int64
 }
 
 func NewResolver(store *config.Store, pool *account.Pool, login LoginFunc) *Resolver {
@@ -44,21 +62,22 @@ func NewResolver(store *config.Store, pool *account.Pool, login LoginFunc) *Reso
 }
 
 func (r *Resolver) Determine(req *http.Request) (*RequestAuth, error) {
-	callerKey := extractCallerToken(req)
-	if callerKey == "" {
-		return nil, ErrUnauthorized
+	callerKey, err := r.callerKey(req)
+	if err != nil {
+		return nil, err
 	}
-	callerID := callerTokenID(callerKey)
 	ctx := req.Context()
 	if !r.Store.HasAPIKey(callerKey) {
 		return &RequestAuth{
 			UseConfigToken: false,
+			Passthrough:    true,
 			DeepSeekToken:  callerKey,
-			CallerID:       callerID,
+			CallerID:       passthroughCallerID(callerKey),
 			resolver:       r,
 			TriedAccounts:  map[string]bool{},
 		}, nil
 	}
+	callerID := callerTokenID(callerKey)
 	target := strings.TrimSpace(req.Header.Get("X-Ds2-Target-Account"))
 	acc, ok := r.Pool.AcquireWait(ctx, target, nil)
 	if !ok {
@@ -67,6 +86,7 @@ func (r *Resolver) Determine(req *http.Request) (*RequestAuth, error) {
 	a := &RequestAuth{
 		UseConfigToken: true,
 		CallerID:       callerID,
+		SearchSources:  r.Store.KeySearchSources(callerKey),
 		AccountID:      acc.Identifier(),
 		Account:        acc,
 		TriedAccounts:  map[string]bool{},
@@ -86,23 +106,77 @@ func (r *Resolver) Determine(req *http.Request) (*RequestAuth, error) {
 // DetermineCaller resolves caller identity without acquiring any pooled account.
 // Use this for local-cache lookup routes that only need tenant isolation.
 func (r *Resolver) DetermineCaller(req *http.Request) (*RequestAuth, error) {
-	callerKey := extractCallerToken(req)
-	if callerKey == "" {
-		return nil, ErrUnauthorized
+	callerKey, err := r.callerKey(req)
+	if err != nil {
+		return nil, err
 	}
-	callerID := callerTokenID(callerKey)
 	a := &RequestAuth{
 		UseConfigToken: false,
-		CallerID:       callerID,
+		CallerID:       callerTokenID(callerKey),
 		resolver:       r,
 		TriedAccounts:  map[string]bool{},
 	}
 	if r == nil || r.Store == nil || !r.Store.HasAPIKey(callerKey) {
+		a.Passthrough = true
 		a.DeepSeekToken = callerKey
+		a.CallerID = passthroughCallerID(callerKey)
 	}
 	return a, nil
 }
 
+// callerKey returns the bearer credential (subject to the passthrough policy
+// when it is not a managed key), falling back to the managed key
+// bound to a verified client certificate, and enforces the key's address
+// allowlist against the (proxy-resolved) remote address.
+func (r *Resolver) callerKey(req *http.Request) (string, error) {
+	callerKey := extractCallerToken(req)
+	if r == nil || r.Store == nil {
+		if callerKey == "" {
+			return "", ErrUnauthorized
+		}
+		return callerKey, nil
+	}
+	if callerKey == "" {
+		callerKey = clientCertKey(req, r.Store)
+	}
+	if callerKey == "" {
+		return "", ErrUnauthorized
+	}
+	if !r.Store.HasAPIKey(callerKey) {
+		if err := r.checkPassthrough(callerKey); err != nil {
+			return "", err
+		}
+		return callerKey, nil
+	}
+	if !r.Store.KeyAllowsAddr(callerKey, remoteAddr(req)) {
+		return "", ErrClientNotAllowed
+	}
+	return callerKey, nil
+}
+
+func clientCertKey(req *http.Request, store *config.Store) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	key, ok := store.KeyForClientCert(req.TLS.VerifiedChains[0][0])
+	if !ok {
+		return ""
+	}
+	return key
+}
+
+func remoteAddr(req *http.Request) netip.Addr {
+	host := strings.TrimSpace(req.RemoteAddr)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
 func WithAuth(ctx context.Context, a *RequestAuth) context.Context {
 	return context.WithValue(ctx, authCtxKey, a)
 }
@@ -172,8 +246,147 @@ func (r *Resolver) SwitchAccount(ctx context.Context, a *RequestAuth) bool {
 	return true
 }
 
+// AcquireExtra leases an additional upstream identity for the same caller so
+// one request can fan out to several parallel completions. A different pooled
+// account is preferred; when none is free a second slot on any account is
+// taken without waiting. Passthrough callers get a copy of their own token.
+// Every returned auth must be handed back via Release.
+func (r *Resolver) AcquireExtra(ctx context.Context, primary *RequestAuth) (*RequestAuth, bool) {
+	if primary == nil {
+		return nil, false
+	}
+	if !primary.UseConfigToken {
+		return &RequestAuth{
+			Passthrough:   primary.Passthrough,
+			DeepSeekToken: primary.DeepSeekToken,
+			CallerID:      primary.CallerID,
+			TriedAccounts: map[string]bool{},
+			resolver:      r,
+		}, true
+	}
+	acc, ok := r.Pool.Acquire("", map[string]bool{primary.AccountID: true})
+	if !ok {
+		acc, ok = r.Pool.Acquire("", nil)
+	}
+	if !ok {
+		return nil, false
+	}
+	a := &RequestAuth{
+		UseConfigToken: true,
+		CallerID:       primary.CallerID,
+		AccountID:      acc.Identifier(),
+		Account:        acc,
+		TriedAccounts:  map[string]bool{},
+		resolver:       r,
+	}
+	if acc.Token == "" {
+		if err := r.loginAndPersist(ctx, a); err != nil {
+			r.Pool.Release(a.AccountID)
+			return nil, false
+		}
+	} else {
+		a.DeepSeekToken = acc.Token
+	}
+	return a, true
+}
+
+// DetermineCallerRef is DetermineCaller that also returns a reference to
+// the credential the caller authenticated with. The reference holds no
+// plaintext secret, so work resumed later on the caller's behalf can persist
+// it and hand it to AcquireIdle.
+func (r *Resolver) DetermineCallerRef(req *http.Request) (*RequestAuth, string, error) {
+	a, err := r.DetermineCaller(req)
+	if err != nil {
+		return nil, "", err
+	}
+	key := extractCallerToken(req)
+	if key == "" && r != nil && r.Store != nil {
+		key = clientCertKey(req, r.Store)
+	}
+	return a, r.credentialRef(key), nil
+}
+
+// AcquireIdle leases an upstream identity for low-priority background work
+// on behalf of the caller whose credential ref references. A managed key
+// gets a pooled account only via Pool.AcquireIdle; ok is false when none is
+// free and the work should retry later, as it is when
+// RuntimeBatchMaxInflight leases are already out. A credential that can no
+// longer be recovered or is no longer accepted yields an error. Address
+// allowlists are not checked again since there is no client request.
+func (r *Resolver) AcquireIdle(ctx context.Context, ref string) (*RequestAuth, bool, error) {
+	key, err := r.resolveCredentialRef(ref)
+	if err != nil {
+		return nil, false, err
+	}
+	if !r.Store.HasAPIKey(key) {
+		if err := r.checkPassthrough(key); err != nil {
+			return nil, false, err
+		}
+		if !r.reserveIdle() {
+			return nil, false, nil
+		}
+		return &RequestAuth{
+			Passthrough:   true,
+			DeepSeekToken: key,
+			CallerID:      passthroughCallerID(key),
+			resolver:      r,
+			TriedAccounts: map[string]bool{},
+			idle:          true,
+		}, true, nil
+	}
+	if !r.reserveIdle() {
+		return nil, false, nil
+	}
+	acc, ok := r.Pool.AcquireIdle(nil)
+	if !ok {
+		r.idleInflight.Add(-1)
+		return nil, false, nil
+	}
+	a := &RequestAuth{
+		UseConfigToken: true,
+		CallerID:       callerTokenID(key),
+		SearchSources:  r.Store.KeySearchSources(key),
+		AccountID:      acc.Identifier(),
+		Account:        acc,
+		TriedAccounts:  map[string]bool{},
+		resolver:       r,
+		idle:           true,
+	}
+	if acc.Token == "" {
+		if err := r.loginAndPersist(ctx, a); err != nil {
+			r.Release(a)
+			return nil, false, nil
+		}
+	} else {
+		a.DeepSeekToken = acc.Token
+	}
+	return a, true, nil
+}
+
+// reserveIdle takes one of the RuntimeBatchMaxInflight slots idle leases
+// share, reporting false when all are taken.
+func (r *Resolver) reserveIdle() bool {
+	limit := int64(r.Store.RuntimeBatchMaxInflight())
+	for {
+		n := r.idleInflight.Load()
+		if n >= limit {
+			return false
+		}
+		if r.idleInflight.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
 func (r *Resolver) Release(a *RequestAuth) {
-	if a == nil || !a.UseConfigToken || a.AccountID == "" {
+	if a == nil {
+		return
+	}
+	if a.idle {
+		a.idle = false
+		r.idleInflight.Add(-1)
+	}
+	if !a.UseConfigToken || a.AccountID == "" {
 		return
 	}
 	r.Pool.Release(a.AccountID)
@@ -198,3 +411,14 @@ func callerTokenID(token string) string {
 	sum := sha256.Sum256([]byte(token))
 	return "caller:" + hex.EncodeToString(sum[:8])
 }
+
+// passthroughCallerID keeps raw-token tenants apart from managed-key tenants
+// even though both are derived from the bearer value.
+func passthroughCallerID(token string) string {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "passthrough:" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"ds2api/internal/account"
+	"ds2api/internal/config"
+)
+
+// newPolicyResolver loads two managed keys plus the given extra top-level
+// config fields.
+func newPolicyResolver(t *testing.T, fields string) *Resolver {
+	t.Helper()
+	t.Setenv("DS2API_CONFIG_JSON", `{
+		"keys":["ci-key","open-key"],
+		"accounts":[{"email":"acc@example.com","token":"account-token"}],
+		`+fields+`
+	}`)
+	store := config.LoadStore()
+	return NewResolver(store, account.NewPool(store), func(_ context.Context, _ config.Account) (string, error) {
+		return "fresh-token", nil
+	})
+}
+
+func TestDetermineEnforcesKeyCIDRAllowlist(t *testing.T) {
+	r := newPolicyResolver(t, `"key_policies":[{"key":"ci-key","allowed_cidrs":["10.20.0.0/16","192.0.2.7"]}]`)
+	cases := []struct {
+		key    string
+		remote string
+		err    error
+	}{
+		{"ci-key", "10.20.3.4:5123", nil},
+		{"ci-key", "192.0.2.7", nil},
+		{"ci-key", "10.21.0.1:5123", ErrClientNotAllowed},
+		{"ci-key", "not-an-ip", ErrClientNotAllowed},
+		{"open-key", "198.51.100.1:80", nil},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.key)
+		req.RemoteAddr = tc.remote
+		a, err := r.Determine(req)
+		if err != tc.err {
+			t.Fatalf("%s from %s: err=%v want %v", tc.key, tc.remote, err, tc.err)
+		}
+		r.Release(a)
+	}
+}
+
+func TestDetermineCallerEnforcesKeyCIDRAllowlist(t *testing.T) {
+	r := newPolicyResolver(t, `"key_policies":[{"key":"ci-key","allowed_cidrs":["10.0.0.0/8"]}]`)
+	req, _ := http.NewRequest(http.MethodGet, "/v1/responses/resp_1", nil)
+	req.Header.Set("Authorization", "Bearer ci-key")
+	req.RemoteAddr = "203.0.113.9:443"
+	if _, err := r.DetermineCaller(req); err != ErrClientNotAllowed {
+		t.Fatalf("expected ErrClientNotAllowed, got %v", err)
+	}
+}
+
+func TestDetermineMapsVerifiedClientCertToKey(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("runner-cert-der"), Subject: pkix.Name{CommonName: "ci-runner", Organization: []string{"Acme"}}}
+	sum := sha256.Sum256(cert.Raw)
+	other := &x509.Certificate{Raw: []byte("other-cert-der"), Subject: pkix.Name{CommonName: "laptop"}}
+
+	for name, policy := range map[string]string{
+		"subject":     `"key_policies":[{"key":"ci-key","client_cert_subjects":["ci-runner"]}]`,
+		"fingerprint": `"key_policies":[{"key":"ci-key","client_cert_fingerprints":["` + hex.EncodeToString(sum[:]) + `"]}]`,
+	} {
+		r := newPolicyResolver(t, policy)
+		req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		a, err := r.Determine(req)
+		if err != nil {
+			t.Fatalf("%s: determine failed: %v", name, err)
+		}
+		if !a.UseConfigToken || a.CallerID != callerTokenID("ci-key") {
+			t.Fatalf("%s: expected managed ci-key identity, got %#v", name, a)
+		}
+		r.Release(a)
+
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{other}}}
+		if _, err := r.Determine(req); err != ErrUnauthorized {
+			t.Fatalf("%s: expected unmapped cert unauthorized, got %v", name, err)
+		}
+	}
+}
+
+func TestDetermineCarriesKeySearchSources(t *testing.T) {
+	r := newPolicyResolver(t, `"key_policies":[{"key":"ci-key","search_sources":true}]`)
+	for key, want := range map[string]bool{"ci-key": true, "open-key": false} {
+		req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		a, err := r.Determine(req)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if a.SearchSources != want {
+			t.Fatalf("%s: SearchSources=%v want %v", key, a.SearchSources, want)
+		}
+		r.Release(a)
+	}
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"net/http"
+	"strings"
 	"testing"
 
 	"ds2api/internal/account"
@@ -126,3 +127,111 @@ func TestDetermineCallerMissingToken(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestAcquireExtraPrefersOtherAccountAndRespectsLimits(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{
+		"keys":["managed-key"],
+		"accounts":[
+			{"email":"a@example.com","password":"pwd","token":"token-a"},
+			{"email":"b@example.com","password":"pwd","token":"token-b"}
+		]
+	}`)
+	t.Setenv("DS2API_ACCOUNT_MAX_INFLIGHT", "1")
+	store := config.LoadStore()
+	r := NewResolver(store, account.NewPool(store), func(_ context.Context, _ config.Account) (string, error) {
+		return "fresh-token", nil
+	})
+	req, _ := http.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer managed-key")
+	primary, err := r.Determine(req)
+	if err != nil {
+		t.Fatalf("determine failed: %v", err)
+	}
+	defer r.Release(primary)
+
+	extra, ok := r.AcquireExtra(context.Background(), primary)
+	if !ok {
+		t.Fatal("expected a second account to be acquired")
+	}
+	if extra.AccountID == primary.AccountID || extra.CallerID != primary.CallerID {
+		t.Fatalf("unexpected extra auth: account=%q caller=%q", extra.AccountID, extra.CallerID)
+	}
+	if _, ok := r.AcquireExtra(context.Background(), primary); ok {
+		t.Fatal("expected no further slots with every account busy")
+	}
+	r.Release(extra)
+	if again, ok := r.AcquireExtra(context.Background(), primary); !ok {
+		t.Fatal("expected released slot to be reusable")
+	} else {
+		r.Release(again)
+	}
+}
+
+func TestAcquireIdleMatchesDetermineCaller(t *testing.T) {
+	r := newTestResolver(t)
+	req, _ := http.NewRequest(http.MethodPost, "/anthropic/v1/messages/batches", nil)
+	req.Header.Set("x-api-key", "managed-key")
+	caller, key, err := r.DetermineCallerRef(req)
+	if err != nil {
+		t.Fatalf("determine caller failed: %v", err)
+	}
+	a, ok, err := r.AcquireIdle(context.Background(), key)
+	if err != nil || !ok {
+		t.Fatalf("expected idle acquire, got ok=%v err=%v", ok, err)
+	}
+	defer r.Release(a)
+	if !a.UseConfigToken || a.CallerID != caller.CallerID || a.DeepSeekToken != "account-token" {
+		t.Fatalf("unexpected auth: %#v", a)
+	}
+
+	t.Setenv("DS2API_CONFIG_JSON", `{"keys":["managed-key"],"passthrough":{"mode":"deny"}}`)
+	r.Store = config.LoadStore()
+	if _, _, err := r.AcquireIdle(context.Background(), r.credentialRef("raw-token")); err == nil {
+		t.Fatal("expected a denied passthrough token to be rejected")
+	}
+}
+
+func TestCredentialRefHoldsNoSecret(t *testing.T) {
+	r := newTestResolver(t)
+	for _, key := range []string{"managed-key", "raw-token"} {
+		ref := r.credentialRef(key)
+		if ref == "" || strings.Contains(ref, key) {
+			t.Fatalf("expected an opaque reference for %q, got %q", key, ref)
+		}
+		if got, err := r.resolveCredentialRef(ref); err != nil || got != key {
+			t.Fatalf("expected %q back, got %q err=%v", key, got, err)
+		}
+	}
+
+	ref := r.credentialRef("managed-key")
+	t.Setenv("DS2API_CONFIG_JSON", `{"keys":["other-key"]}`)
+	r.Store = config.LoadStore()
+	if _, _, err := r.AcquireIdle(context.Background(), ref); err != ErrCredentialGone {
+		t.Fatalf("expected a removed managed key to be gone, got %v", err)
+	}
+}
+
+func TestAcquireIdleKeepsToBatchShare(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{"runtime":{"global_max_inflight":4,"batch_concurrency_share":50}}`)
+	store := config.LoadStore()
+	r := NewResolver(store, account.NewPool(store), nil)
+	var leases []*RequestAuth
+	for i := 0; i < 2; i++ {
+		a, ok, err := r.AcquireIdle(context.Background(), r.credentialRef("raw-token"))
+		if err != nil || !ok {
+			t.Fatalf("lease %d: expected idle acquire, got ok=%v err=%v", i, ok, err)
+		}
+		leases = append(leases, a)
+	}
+	if _, ok, err := r.AcquireIdle(context.Background(), r.credentialRef("raw-token")); ok || err != nil {
+		t.Fatalf("expected the batch share to be exhausted, got ok=%v err=%v", ok, err)
+	}
+	r.Release(leases[0])
+	r.Release(leases[0])
+	a, ok, _ := r.AcquireIdle(context.Background(), r.credentialRef("raw-token"))
+	if !ok {
+		t.Fatal("expected a released slot to be reusable")
+	}
+	r.Release(a)
+	r.Release(leases[1])
+}
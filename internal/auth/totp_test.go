@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B SHA1 vectors, truncated to six digits.
+func TestTOTPCodeMatchesRFCVectors(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+	}
+	for unix, want := range cases {
+		if got := TOTPCode(secret, time.Unix(unix, 0)); got != want {
+			t.Fatalf("TOTPCode at %d = %q, want %q", unix, got, want)
+		}
+	}
+}
+
+func TestVerifyTOTPAllowsOneStepSkew(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	if !VerifyTOTP(secret, TOTPCode(secret, now.Add(-30*time.Second)), now) {
+		t.Fatal("expected previous step accepted")
+	}
+	if VerifyTOTP(secret, TOTPCode(secret, now.Add(-90*time.Second)), now) {
+		t.Fatal("expected code three steps old rejected")
+	}
+	if VerifyTOTP(secret, "", now) {
+		t.Fatal("expected empty code rejected")
+	}
+}
@@ -0,0 +1,123 @@
+// Package batch runs the message batches of the Claude and OpenAI adapters.
+// It queues their requests, saves them so unfinished batches resume after a
+// restart, and runs each request on an idle account lease. The adapters
+// parse the requests, run them and shape results and batch objects.
+package batch
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound       = errors.New("batch not found")
+	ErrNotEnded       = errors.New("batch has not ended")
+	ErrNotCancellable = errors.New("batch cannot be cancelled")
+)
+
+// State is where a batch is in its lifecycle.
+type State string
+
+const (
+	// Validating batches wait for Spec.Validate to supply their requests.
+	Validating State = "validating"
+	// Running batches hand their requests to the worker.
+	Running State = "running"
+	// Stopping batches were cancelled or expired: the requests not yet
+	// started were skipped and the running ones are finishing.
+	Stopping State = "stopping"
+	// Finalizing batches have a result for every request and wait for
+	// Spec.Finalize.
+	Finalizing State = "finalizing"
+	// Ended batches are final.
+	Ended State = "ended"
+)
+
+// Request is one request of a batch.
+type Request struct {
+	CustomID string         `json:"custom_id"`
+	Body     map[string]any `json:"body"`
+}
+
+// Result is the result line of one request and the sink it is appended to.
+type Result struct {
+	Sink string
+	Line map[string]any
+}
+
+// Batch is the state the store keeps for every batch. Adapters embed it in
+// their batch record, which is saved as <id>.json; the requests are saved as
+// <id>.requests.json until every one has a result, and the result lines of
+// each sink are appended to <id>.<sink>.jsonl as they arrive.
+type Batch struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	// CredentialRef references the caller credential the batch runs with
+	// (see auth.Resolver.DetermineCallerRef); it lets the worker pick the
+	// batch up again after a restart without the credential being stored.
+	CredentialRef string     `json:"credential_ref"`
+	State         State      `json:"state"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	CancelledAt   *time.Time `json:"cancelled_at,omitempty"`
+	ExpiredAt     *time.Time `json:"expired_at,omitempty"`
+	// FinishedAt is when the last request got its result.
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+
+	requests []Request
+	done     map[string]bool
+	next     int
+	inflight int
+	results  map[string][]byte
+}
+
+// Core returns b; embedding Batch makes an adapter record a Record.
+func (b *Batch) Core() *Batch { return b }
+
+// Record is an adapter's batch record, a pointer to a struct embedding Batch.
+type Record interface {
+	Core() *Batch
+}
+
+// Spec is what an adapter plugs into a Store.
+type Spec[R Record] struct {
+	// Name tags the log lines of the store, e.g. "claude_batches".
+	Name string
+	// IDPrefix starts the id of every batch, e.g. "msgbatch_".
+	IDPrefix string
+	// Sinks names the result files of a batch.
+	Sinks []string
+	// Window is how long a batch may run before its unprocessed requests
+	// expire.
+	Window time.Duration
+	// Retention is how long an ended batch is kept.
+	Retention time.Duration
+
+	// New returns an empty record to load a saved batch into.
+	New func() R
+	// Object renders a batch as the API returns it.
+	Object func(rec R) map[string]any
+	// Reset sets the counts of rec to total requests without results.
+	Reset func(rec R, total int)
+	// Count adds a result to the counts of rec, both as results arrive and
+	// when the results of a resumed batch are read back.
+	Count func(rec R, res Result)
+	// Skipped is the result of a request that never ran because its batch
+	// was cancelled or expired.
+	Skipped func(customID string, cancelled bool) Result
+
+	// Validate, if set, supplies the requests of a batch created without
+	// them. It runs on the worker without the store lock. When fail is not
+	// nil the batch ends instead, after fail records why on rec.
+	Validate func(rec R) (requests []Request, fail func(R))
+	// Finalize, if set, hands the results of a batch on once every request
+	// has one. It runs on the worker without the store lock; open reads a
+	// sink and fails with fs.ErrNotExist when the sink is empty. The
+	// returned function is applied to rec before the batch ends and its
+	// result lines are dropped. Without Finalize a batch ends at once and
+	// keeps its results, which Results serves, until retention.
+	Finalize func(rec R, open func(sink string) (io.ReadCloser, error)) func(R)
+}
@@ -0,0 +1,551 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"ds2api/internal/config"
+)
+
+// Store holds batches. With a directory they survive restarts and
+// unfinished ones are resumed; without one, result lines are kept in memory.
+type Store[R Record] struct {
+	spec    Spec[R]
+	mu      sync.Mutex
+	dir     string
+	batches map[string]R
+	wake    chan struct{}
+}
+
+// NewStore loads the batches saved in dir ("" keeps them in memory only).
+func NewStore[R Record](dir string, spec Spec[R]) *Store[R] {
+	st := &Store[R]{spec: spec, dir: strings.TrimSpace(dir), batches: map[string]R{}, wake: make(chan struct{}, 1)}
+	if st.dir == "" {
+		return st
+	}
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			config.Logger.Warn("["+spec.Name+"] load failed", "dir", st.dir, "error", err)
+		}
+		return st
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".requests.json") {
+			continue
+		}
+		if rec, err := st.load(strings.TrimSuffix(name, ".json")); err != nil {
+			config.Logger.Warn("["+spec.Name+"] load failed", "batch", name, "error", err)
+		} else {
+			st.batches[rec.Core().ID] = rec
+		}
+	}
+	return st
+}
+
+// load reads a saved batch. A running batch gets its requests back and its
+// counts rebuilt from its result lines, so requests that were running when
+// the process stopped run again.
+func (st *Store[R]) load(id string) (R, error) {
+	rec := st.spec.New()
+	raw, err := os.ReadFile(st.path(id, ".json"))
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(raw, rec); err != nil {
+		return rec, err
+	}
+	b := rec.Core()
+	if b.ID != id {
+		return rec, errors.New("batch id does not match its file name")
+	}
+	if b.State != Running && b.State != Stopping {
+		return rec, nil
+	}
+	raw, err = os.ReadFile(st.path(id, ".requests.json"))
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(raw, &b.requests); err != nil {
+		return rec, err
+	}
+	b.done = map[string]bool{}
+	st.spec.Reset(rec, len(b.requests))
+	for _, sink := range st.spec.Sinks {
+		f, err := os.Open(st.sinkPath(id, sink))
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+		for sc.Scan() {
+			var line map[string]any
+			if json.Unmarshal(sc.Bytes(), &line) != nil {
+				continue
+			}
+			customID, _ := line["custom_id"].(string)
+			if b.done[customID] {
+				continue
+			}
+			b.done[customID] = true
+			st.spec.Count(rec, Result{Sink: sink, Line: line})
+		}
+		_ = f.Close()
+	}
+	if b.State == Stopping {
+		st.skipPendingLocked(rec)
+	}
+	st.endIfDoneLocked(rec, time.Now())
+	return rec, nil
+}
+
+func (st *Store[R]) path(id, suffix string) string {
+	return filepath.Join(st.dir, id+suffix)
+}
+
+func (st *Store[R]) sinkPath(id, sink string) string {
+	return st.path(id, "."+sink+".jsonl")
+}
+
+// Create stores rec as a new batch of owner, run with the credential behind
+// ref, wakes the worker and returns the batch object. A batch created
+// without requests waits for Spec.Validate.
+func (st *Store[R]) Create(rec R, owner, ref string, requests []Request, now time.Time) map[string]any {
+	b := rec.Core()
+	b.ID = st.spec.IDPrefix + strings.ReplaceAll(uuid.NewString(), "-", "")
+	b.Owner, b.CredentialRef = owner, ref
+	b.State = Validating
+	b.CreatedAt, b.ExpiresAt = now, now.Add(st.spec.Window)
+	st.mu.Lock()
+	st.batches[b.ID] = rec
+	if requests != nil {
+		st.startLocked(rec, requests, now)
+	} else {
+		st.saveLocked(rec)
+	}
+	obj := st.spec.Object(rec)
+	st.mu.Unlock()
+	st.notify()
+	return obj
+}
+
+func (st *Store[R]) notify() {
+	select {
+	case st.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Get returns the batch object if owner owns id.
+func (st *Store[R]) Get(owner, id string) (map[string]any, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	rec, ok := st.owned(owner, id)
+	if !ok {
+		return nil, false
+	}
+	return st.spec.Object(rec), true
+}
+
+func (st *Store[R]) owned(owner, id string) (R, bool) {
+	rec, ok := st.batches[id]
+	if !ok || rec.Core().Owner != owner {
+		var zero R
+		return zero, false
+	}
+	return rec, true
+}
+
+// List returns owner's batch objects newest first.
+func (st *Store[R]) List(owner string) []map[string]any {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	owned := make([]*Batch, 0)
+	for _, rec := range st.batches {
+		if b := rec.Core(); b.Owner == owner {
+			owned = append(owned, b)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		if owned[i].CreatedAt.Equal(owned[j].CreatedAt) {
+			return owned[i].ID > owned[j].ID
+		}
+		return owned[i].CreatedAt.After(owned[j].CreatedAt)
+	})
+	out := make([]map[string]any, 0, len(owned))
+	for _, b := range owned {
+		out = append(out, st.spec.Object(st.batches[b.ID]))
+	}
+	return out
+}
+
+// Cancel stops a batch that is validating or running: requests not yet
+// started get skipped results and the batch ends once the running ones
+// finish. A batch already stopping is returned unchanged; one that has
+// finished is returned with ErrNotCancellable.
+func (st *Store[R]) Cancel(owner, id string, now time.Time) (map[string]any, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	rec, ok := st.owned(owner, id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	b := rec.Core()
+	switch b.State {
+	case Validating:
+		b.CancelledAt = &now
+		st.endLocked(rec, now)
+	case Running:
+		b.State = Stopping
+		b.CancelledAt = &now
+		st.skipPendingLocked(rec)
+		if !st.endIfDoneLocked(rec, now) {
+			st.saveLocked(rec)
+		}
+	case Stopping:
+	default:
+		if b.State != Finalizing || b.CancelledAt == nil {
+			return st.spec.Object(rec), ErrNotCancellable
+		}
+	}
+	return st.spec.Object(rec), nil
+}
+
+// Results returns the result lines of sink of an ended batch as JSONL.
+func (st *Store[R]) Results(owner, id, sink string) (io.ReadCloser, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	rec, ok := st.owned(owner, id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if rec.Core().State != Ended {
+		return nil, ErrNotEnded
+	}
+	rc, err := st.openLocked(rec.Core(), sink)
+	if errors.Is(err, fs.ErrNotExist) {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	return rc, err
+}
+
+// openLocked reads the result lines of sink, failing with fs.ErrNotExist
+// when there are none.
+func (st *Store[R]) openLocked(b *Batch, sink string) (io.ReadCloser, error) {
+	if st.dir != "" {
+		return os.Open(st.sinkPath(b.ID, sink))
+	}
+	if len(b.results[sink]) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(b.results[sink])), nil
+}
+
+// validating returns a batch waiting for its requests.
+func (st *Store[R]) validating() (R, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, rec := range st.batches {
+		if rec.Core().State == Validating {
+			return rec, true
+		}
+	}
+	var zero R
+	return zero, false
+}
+
+// start moves a validating batch into progress with requests, or ends it
+// after fail when its input did not validate.
+func (st *Store[R]) start(id string, requests []Request, fail func(R), now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	rec, ok := st.batches[id]
+	if !ok || rec.Core().State != Validating {
+		return
+	}
+	if fail != nil {
+		fail(rec)
+		st.endLocked(rec, now)
+		return
+	}
+	st.startLocked(rec, requests, now)
+}
+
+func (st *Store[R]) startLocked(rec R, requests []Request, now time.Time) {
+	b := rec.Core()
+	if st.dir != "" {
+		if raw, err := json.Marshal(requests); err == nil {
+			st.writeFile(st.path(b.ID, ".requests.json"), raw)
+		}
+	}
+	b.State = Running
+	b.StartedAt = &now
+	b.requests = requests
+	b.done = map[string]bool{}
+	st.spec.Reset(rec, len(requests))
+	if !st.endIfDoneLocked(rec, now) {
+		st.saveLocked(rec)
+	}
+}
+
+// job is one request the worker has taken from a batch.
+type job[R Record] struct {
+	rec     R
+	ref     string
+	request Request
+}
+
+// next takes the next request of the oldest running batch.
+func (st *Store[R]) next() (job[R], bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var oldest *Batch
+	for _, rec := range st.batches {
+		b := rec.Core()
+		if b.State != Running || !advance(b) {
+			continue
+		}
+		if oldest == nil || b.CreatedAt.Before(oldest.CreatedAt) || (b.CreatedAt.Equal(oldest.CreatedAt) && b.ID < oldest.ID) {
+			oldest = b
+		}
+	}
+	if oldest == nil {
+		return job[R]{}, false
+	}
+	req := oldest.requests[oldest.next]
+	oldest.next++
+	oldest.inflight++
+	return job[R]{rec: st.batches[oldest.ID], ref: oldest.CredentialRef, request: req}, true
+}
+
+// advance moves b.next past finished requests and reports whether a request
+// is left to start.
+func advance(b *Batch) bool {
+	for b.next < len(b.requests) && b.done[b.requests[b.next].CustomID] {
+		b.next++
+	}
+	return b.next < len(b.requests)
+}
+
+// wanted reports whether a taken job should still run. When it should not,
+// the job is recorded as skipped.
+func (st *Store[R]) wanted(j job[R], now time.Time) bool {
+	st.mu.Lock()
+	b := j.rec.Core()
+	if b.State == Running && now.Before(b.ExpiresAt) {
+		st.mu.Unlock()
+		return true
+	}
+	cancelled := b.CancelledAt != nil
+	st.mu.Unlock()
+	st.record(j, st.spec.Skipped(j.request.CustomID, cancelled), now)
+	return false
+}
+
+// record stores the result of a job and finishes its batch after the last
+// one.
+func (st *Store[R]) record(j job[R], res Result, now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	b := j.rec.Core()
+	if cur, ok := st.batches[b.ID]; !ok || cur.Core() != b {
+		return
+	}
+	b.inflight--
+	if b.done[j.request.CustomID] {
+		return
+	}
+	st.appendResultLocked(j.rec, j.request.CustomID, res)
+	if !st.endIfDoneLocked(j.rec, now) {
+		st.saveLocked(j.rec)
+	}
+}
+
+// sweep expires the pending requests of batches past their window and
+// forgets ended batches past retention.
+func (st *Store[R]) sweep(now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for id, rec := range st.batches {
+		b := rec.Core()
+		switch b.State {
+		case Ended:
+			if now.Sub(b.CreatedAt) > st.spec.Retention {
+				delete(st.batches, id)
+				if st.dir != "" {
+					_ = os.Remove(st.path(id, ".json"))
+					for _, sink := range st.spec.Sinks {
+						_ = os.Remove(st.sinkPath(id, sink))
+					}
+				}
+			}
+		case Validating, Running:
+			if now.Before(b.ExpiresAt) {
+				continue
+			}
+			b.ExpiredAt = &now
+			if b.State == Validating {
+				st.endLocked(rec, now)
+				continue
+			}
+			b.State = Stopping
+			st.skipPendingLocked(rec)
+			if !st.endIfDoneLocked(rec, now) {
+				st.saveLocked(rec)
+			}
+		}
+	}
+}
+
+// skipPendingLocked gives every request that has not started yet its
+// skipped result.
+func (st *Store[R]) skipPendingLocked(rec R) {
+	b := rec.Core()
+	cancelled := b.CancelledAt != nil
+	for ; b.next < len(b.requests); b.next++ {
+		if id := b.requests[b.next].CustomID; !b.done[id] {
+			st.appendResultLocked(rec, id, st.spec.Skipped(id, cancelled))
+		}
+	}
+}
+
+func (st *Store[R]) appendResultLocked(rec R, customID string, res Result) {
+	raw, err := json.Marshal(res.Line)
+	if err != nil {
+		return
+	}
+	raw = append(raw, '\n')
+	b := rec.Core()
+	b.done[customID] = true
+	st.spec.Count(rec, res)
+	if st.dir == "" {
+		if b.results == nil {
+			b.results = map[string][]byte{}
+		}
+		b.results[res.Sink] = append(b.results[res.Sink], raw...)
+		return
+	}
+	path := st.sinkPath(b.ID, res.Sink)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		config.Logger.Warn("["+st.spec.Name+"] write failed", "path", path, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(raw); err != nil {
+		config.Logger.Warn("["+st.spec.Name+"] write failed", "path", path, "error", err)
+	}
+}
+
+// endIfDoneLocked finishes rec once every request has a result and none is
+// running: it ends at once, or waits for Spec.Finalize when there is one.
+func (st *Store[R]) endIfDoneLocked(rec R, now time.Time) bool {
+	b := rec.Core()
+	if (b.State != Running && b.State != Stopping) || b.inflight > 0 || len(b.done) < len(b.requests) {
+		return false
+	}
+	b.FinishedAt = &now
+	if st.spec.Finalize == nil {
+		st.endLocked(rec, now)
+		return true
+	}
+	b.State = Finalizing
+	b.requests, b.done = nil, nil
+	st.saveLocked(rec)
+	if st.dir != "" {
+		_ = os.Remove(st.path(b.ID, ".requests.json"))
+	}
+	st.notify()
+	return true
+}
+
+// endLocked ends rec, dropping the requests it no longer needs.
+func (st *Store[R]) endLocked(rec R, now time.Time) {
+	b := rec.Core()
+	b.State = Ended
+	b.EndedAt = &now
+	b.requests, b.done = nil, nil
+	st.saveLocked(rec)
+	if st.dir != "" {
+		_ = os.Remove(st.path(b.ID, ".requests.json"))
+	}
+}
+
+// finalizing returns a batch waiting for Spec.Finalize.
+func (st *Store[R]) finalizing() (R, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, rec := range st.batches {
+		if rec.Core().State == Finalizing {
+			return rec, true
+		}
+	}
+	var zero R
+	return zero, false
+}
+
+// finalize runs Spec.Finalize on rec and ends it, dropping its result lines.
+func (st *Store[R]) finalize(rec R, now time.Time) {
+	open := func(sink string) (io.ReadCloser, error) {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		return st.openLocked(rec.Core(), sink)
+	}
+	apply := st.spec.Finalize(rec, open)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	b := rec.Core()
+	if b.State != Finalizing {
+		return
+	}
+	if apply != nil {
+		apply(rec)
+	}
+	b.results = nil
+	st.endLocked(rec, now)
+	if st.dir != "" {
+		for _, sink := range st.spec.Sinks {
+			_ = os.Remove(st.sinkPath(b.ID, sink))
+		}
+	}
+}
+
+func (st *Store[R]) saveLocked(rec R) {
+	if st.dir == "" {
+		return
+	}
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	st.writeFile(st.path(rec.Core().ID, ".json"), raw)
+}
+
+func (st *Store[R]) writeFile(path string, raw []byte) {
+	if err := os.MkdirAll(st.dir, 0o700); err != nil {
+		config.Logger.Warn("["+st.spec.Name+"] save failed", "path", path, "error", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		config.Logger.Warn("["+st.spec.Name+"] save failed", "path", path, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		config.Logger.Warn("["+st.spec.Name+"] save failed", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,160 @@
+package batch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBatch counts results per sink.
+type testBatch struct {
+	Batch
+	Total  int            `json:"total"`
+	Counts map[string]int `json:"counts"`
+}
+
+func testSpec() Spec[*testBatch] {
+	return Spec[*testBatch]{
+		Name:      "test_batches",
+		IDPrefix:  "tb_",
+		Sinks:     []string{"ok", "failed"},
+		Window:    time.Hour,
+		Retention: 2 * time.Hour,
+		New:       func() *testBatch { return &testBatch{} },
+		Object: func(b *testBatch) map[string]any {
+			counts := map[string]int{}
+			for k, v := range b.Counts {
+				counts[k] = v
+			}
+			return map[string]any{"id": b.ID, "state": b.State, "total": b.Total, "counts": counts}
+		},
+		Reset: func(b *testBatch, total int) { b.Total, b.Counts = total, map[string]int{} },
+		Count: func(b *testBatch, res Result) { b.Counts[res.Sink]++ },
+		Skipped: func(customID string, cancelled bool) Result {
+			reason := "expired"
+			if cancelled {
+				reason = "cancelled"
+			}
+			return Result{Sink: "failed", Line: map[string]any{"custom_id": customID, "reason": reason}}
+		},
+	}
+}
+
+func testRequests(ids ...string) []Request {
+	out := make([]Request, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, Request{CustomID: id, Body: map[string]any{"n": id}})
+	}
+	return out
+}
+
+func TestStoreResumesAndCancels(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	st := NewStore(dir, testSpec())
+	id := st.Create(&testBatch{}, "alice", "key", testRequests("a", "b", "c"), now)["id"].(string)
+	first, _ := st.next()
+	st.record(first, Result{Sink: "ok", Line: map[string]any{"custom_id": "a"}}, now)
+	if _, ok := st.next(); !ok {
+		t.Fatal("expected a second job")
+	}
+
+	// A restart drops the running job "b"; it must run again.
+	st = NewStore(dir, testSpec())
+	obj, ok := st.Get("alice", id)
+	if counts := obj["counts"].(map[string]int); !ok || obj["state"] != Running || obj["total"] != 3 || counts["ok"] != 1 {
+		t.Fatalf("unexpected resumed batch %#v", obj)
+	}
+	if _, ok := st.Get("bob", id); ok {
+		t.Fatal("expected another owner not to see the batch")
+	}
+	j, ok := st.next()
+	if !ok || j.request.CustomID != "b" || j.ref != "key" || j.request.Body["n"] != "b" {
+		t.Fatalf("expected to resume with b, got %#v ok=%v", j, ok)
+	}
+	if _, err := st.Results("alice", id, "ok"); err != ErrNotEnded {
+		t.Fatalf("expected the results of a running batch to be refused, got %v", err)
+	}
+
+	obj, err := st.Cancel("alice", id, now)
+	if counts := obj["counts"].(map[string]int); err != nil || obj["state"] != Stopping || counts["failed"] != 1 {
+		t.Fatalf("unexpected stopping batch %#v err=%v", obj, err)
+	}
+	if st.wanted(j, now) {
+		t.Fatal("expected a job of a cancelled batch to be dropped")
+	}
+	obj, _ = st.Get("alice", id)
+	if counts := obj["counts"].(map[string]int); obj["state"] != Ended || counts["ok"] != 1 || counts["failed"] != 2 {
+		t.Fatalf("unexpected ended batch %#v", obj)
+	}
+	if _, err := st.Cancel("alice", id, now); err != ErrNotCancellable {
+		t.Fatalf("expected an ended batch not to be cancellable, got %v", err)
+	}
+
+	rc, err := NewStore(dir, testSpec()).Results("alice", id, "failed")
+	if err != nil {
+		t.Fatalf("expected the ended batch to survive a restart: %v", err)
+	}
+	defer rc.Close()
+	var lines bytes.Buffer
+	_, _ = lines.ReadFrom(rc)
+	if strings.Count(lines.String(), `"reason":"cancelled"`) != 2 {
+		t.Fatalf("expected b and c to be cancelled, got %s", lines.String())
+	}
+}
+
+func TestStoreSweepExpiresAndForgets(t *testing.T) {
+	st := NewStore("", testSpec())
+	now := time.Now()
+	id := st.Create(&testBatch{}, "alice", "key", testRequests("a", "b"), now)["id"].(string)
+	running, _ := st.next()
+
+	st.sweep(now.Add(90 * time.Minute))
+	obj, _ := st.Get("alice", id)
+	if counts := obj["counts"].(map[string]int); obj["state"] != Stopping || counts["failed"] != 1 {
+		t.Fatalf("expected b to expire while a runs, got %#v", obj)
+	}
+	st.record(running, Result{Sink: "ok", Line: map[string]any{"custom_id": "a"}}, now.Add(91*time.Minute))
+	if obj, _ := st.Get("alice", id); obj["state"] != Ended {
+		t.Fatalf("expected the batch to end after its last request, got %#v", obj)
+	}
+	rc, _ := st.Results("alice", id, "failed")
+	var lines bytes.Buffer
+	_, _ = lines.ReadFrom(rc)
+	if !strings.Contains(lines.String(), `"reason":"expired"`) {
+		t.Fatalf("expected b to expire, got %s", lines.String())
+	}
+
+	st.sweep(now.Add(3 * time.Hour))
+	if _, ok := st.Get("alice", id); ok {
+		t.Fatal("expected the batch to be forgotten after retention")
+	}
+}
+
+func TestStoreValidatesBeforeRunning(t *testing.T) {
+	spec := testSpec()
+	spec.Validate = func(b *testBatch) ([]Request, func(*testBatch)) {
+		if b.Owner == "mallory" {
+			return nil, func(b *testBatch) { b.Total = -1 }
+		}
+		return testRequests("a"), nil
+	}
+	st := NewStore("", spec)
+	now := time.Now()
+	good := st.Create(&testBatch{}, "alice", "key", nil, now)["id"].(string)
+	bad := st.Create(&testBatch{}, "mallory", "key", nil, now)["id"].(string)
+	if obj, _ := st.Get("alice", good); obj["state"] != Validating {
+		t.Fatalf("expected a batch without requests to validate first, got %#v", obj)
+	}
+	for rec, ok := st.validating(); ok; rec, ok = st.validating() {
+		requests, fail := spec.Validate(rec)
+		st.start(rec.ID, requests, fail, now)
+	}
+	if obj, _ := st.Get("alice", good); obj["state"] != Running || obj["total"] != 1 {
+		t.Fatalf("expected the valid batch to run, got %#v", obj)
+	}
+	if obj, _ := st.Get("mallory", bad); obj["state"] != Ended || obj["total"] != -1 {
+		t.Fatalf("expected the invalid batch to fail, got %#v", obj)
+	}
+}
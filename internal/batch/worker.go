@@ -0,0 +1,108 @@
+package batch
+
+import (
+	"context"
+	"time"
+
+	"ds2api/internal/auth"
+)
+
+// pollInterval is how often the worker retries a busy pool and looks for
+// expired batches.
+var pollInterval = time.Second
+
+// Leaser leases accounts for batch requests; auth.Resolver implements it.
+type Leaser interface {
+	AcquireIdle(ctx context.Context, ref string) (*auth.RequestAuth, bool, error)
+	Release(a *auth.RequestAuth)
+}
+
+// Worker is how the requests of a Store run.
+type Worker[R Record] struct {
+	Auth Leaser
+	// Run runs req of rec on the leased auth a and returns its result.
+	Run func(ctx context.Context, a *auth.RequestAuth, rec R, req Request) Result
+	// Failed is the result of a request whose credential could not be
+	// leased with.
+	Failed func(req Request, err error) Result
+}
+
+// Run is the batch worker. It validates new batches, finalizes finished
+// ones and runs requests oldest batch first, each on a lease from
+// AcquireIdle, which keeps batch work within its share of the in-flight
+// limit and off accounts interactive requests are waiting for. It returns
+// once ctx ends.
+func (st *Store[R]) Run(ctx context.Context, w Worker[R]) {
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	st.sweep(time.Now())
+	for {
+		j, a, ok := st.nextJob(ctx, w, poll.C)
+		if !ok {
+			return
+		}
+		go func() {
+			res := w.Run(ctx, a, j.rec, j.request)
+			w.Auth.Release(a)
+			// The freed slot may let a waiting job start.
+			st.notify()
+			if ctx.Err() != nil {
+				// Shutting down: the request runs again after a restart.
+				return
+			}
+			st.record(j, res, time.Now())
+		}()
+	}
+}
+
+// nextJob does the pending validation and finalization, then waits for a
+// request to run and an account to run it on. It returns false once ctx
+// ends.
+func (st *Store[R]) nextJob(ctx context.Context, w Worker[R], poll <-chan time.Time) (job[R], *auth.RequestAuth, bool) {
+	for {
+		if st.spec.Validate != nil {
+			for rec, ok := st.validating(); ok; rec, ok = st.validating() {
+				requests, fail := st.spec.Validate(rec)
+				st.start(rec.Core().ID, requests, fail, time.Now())
+			}
+		}
+		if st.spec.Finalize != nil {
+			for rec, ok := st.finalizing(); ok; rec, ok = st.finalizing() {
+				st.finalize(rec, time.Now())
+			}
+		}
+		j, ok := st.next()
+		for ok && st.wanted(j, time.Now()) {
+			a, acquired, err := w.Auth.AcquireIdle(ctx, j.ref)
+			if err != nil {
+				st.record(j, w.Failed(j.request, err), time.Now())
+				break
+			}
+			if acquired {
+				return j, a, true
+			}
+			if !st.wait(ctx, poll) {
+				return job[R]{}, nil, false
+			}
+		}
+		if ok {
+			continue
+		}
+		if !st.wait(ctx, poll) {
+			return job[R]{}, nil, false
+		}
+	}
+}
+
+// wait blocks until the store changes or the poll ticks, sweeping on a
+// tick. It returns false once ctx ends.
+func (st *Store[R]) wait(ctx context.Context, poll <-chan time.Time) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-st.wake:
+	case <-poll:
+		st.sweep(time.Now())
+	}
+	return true
+}
@@ -30,7 +30,7 @@ func ConvertClaudeToDeepSeek(claudeReq map[string]any, mappingProvider ClaudeMap
 	}
 
 	convertedMessages := make([]any, 0, len(messages)+1)
-	if system, ok := claudeReq["system"].(string); ok && system != "" {
+	if system := SystemText(claudeReq["system"]); system != "" {
 		convertedMessages = append(convertedMessages, map[string]any{"role": "system", "content": system})
 	}
 	convertedMessages = append(convertedMessages, messages...)
@@ -46,3 +46,27 @@ func ConvertClaudeToDeepSeek(claudeReq map[string]any, mappingProvider ClaudeMap
 	}
 	return out
 }
+
+// SystemText reads a Claude system prompt, either a string or an array of
+// text blocks (as Claude Code sends it); blocks are joined by blank lines and
+// their cache_control markers ignored.
+func SystemText(raw any) string {
+	switch x := raw.(type) {
+	case string:
+		return x
+	case []any:
+		parts := make([]string, 0, len(x))
+		for _, item := range x {
+			block, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if text, _ := block["text"].(string); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n\n")
+	default:
+		return ""
+	}
+}
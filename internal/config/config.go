@@ -70,6 +70,11 @@ type Config struct {
 	Toolcall         ToolcallConfig    `json:"toolcall,omitempty"`
 	Responses        ResponsesConfig   `json:"responses,omitempty"`
 	Embeddings       EmbeddingsConfig  `json:"embeddings,omitempty"`
+	Reasoning        ReasoningConfig   `json:"reasoning,omitempty"`
+	Audit            AuditConfig       `json:"audit,omitempty"`
+	KeyPolicies      []KeyPolicy       `json:"key_policies,omitempty"`
+	Passthrough      PassthroughConfig `json:"passthrough,omitempty"`
+	Network          NetworkConfig     `json:"network,omitempty"`
 	VercelSyncHash   string            `json:"_vercel_sync_hash,omitempty"`
 	VercelSyncTime   int64             `json:"_vercel_sync_time,omitempty"`
 	AdditionalFields map[string]any    `json:"-"`
@@ -77,18 +82,42 @@ type Config struct {
 
 type CompatConfig struct {
 	WideInputStrictOutput *bool `json:"wide_input_strict_output,omitempty"`
+	ResponseFormatRepair  *bool `json:"response_format_repair,omitempty"`
 }
 
 type AdminConfig struct {
-	PasswordHash      string `json:"password_hash,omitempty"`
-	JWTExpireHours    int    `json:"jwt_expire_hours,omitempty"`
-	JWTValidAfterUnix int64  `json:"jwt_valid_after_unix,omitempty"`
+	PasswordHash        string      `json:"password_hash,omitempty"`
+	JWTExpireHours      int         `json:"jwt_expire_hours,omitempty"`
+	JWTValidAfterUnix   int64       `json:"jwt_valid_after_unix,omitempty"`
+	Users               []AdminUser `json:"users,omitempty"`
+	TOTPSecret          string      `json:"totp_secret,omitempty"`
+	AccessTokenMinutes  int         `json:"access_token_minutes,omitempty"`
+	LoginMaxFailures    int         `json:"login_max_failures,omitempty"`
+	LoginLockoutSeconds int         `json:"login_lockout_seconds,omitempty"`
+}
+
+func (a AdminConfig) isZero() bool {
+	return strings.TrimSpace(a.PasswordHash) == "" && a.JWTExpireHours <= 0 && a.JWTValidAfterUnix <= 0 &&
+		len(a.Users) == 0 && strings.TrimSpace(a.TOTPSecret) == "" && a.AccessTokenMinutes <= 0 &&
+		a.LoginMaxFailures <= 0 && a.LoginLockoutSeconds <= 0
+}
+
+// AdminUser is a named admin login. Role is one of viewer, operator or owner.
+type AdminUser struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+	TOTPSecret   string `json:"totp_secret,omitempty"`
 }
 
 type RuntimeConfig struct {
 	AccountMaxInflight int `json:"account_max_inflight,omitempty"`
 	AccountMaxQueue    int `json:"account_max_queue,omitempty"`
 	GlobalMaxInflight  int `json:"global_max_inflight,omitempty"`
+	MaxChoices         int `json:"max_choices,omitempty"`
+	// BatchConcurrencyShare is the percentage of the global in-flight
+	// limit batch workers may occupy.
+	BatchConcurrencyShare int `json:"batch_concurrency_share,omitempty"`
 }
 
 type ToolcallConfig struct {
@@ -104,6 +133,26 @@ type EmbeddingsConfig struct {
 	Provider string `json:"provider,omitempty"`
 }
 
+type AuditConfig struct {
+	MaxEntries    int `json:"max_entries,omitempty"`
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+// PassthroughConfig governs bearer tokens that are not managed keys and would
+// be forwarded upstream as raw DeepSeek user tokens. Mode is allow (default),
+// deny, or allowlist; in allowlist mode only tokens whose SHA-256 hex digest
+// appears in AllowedTokenHashes are forwarded.
+type PassthroughConfig struct {
+	Mode               string   `json:"mode,omitempty"`
+	AllowedTokenHashes []string `json:"allowed_token_hashes,omitempty"`
+}
+
+const (
+	PassthroughAllow     = "allow"
+	PassthroughDeny      = "deny"
+	PassthroughAllowlist = "allowlist"
+)
+
 func (c Config) MarshalJSON() ([]byte, error) {
 	m := map[string]any{}
 	for k, v := range c.AdditionalFields {
@@ -124,13 +173,13 @@ func (c Config) MarshalJSON() ([]byte, error) {
 	if len(c.ModelAliases) > 0 {
 		m["model_aliases"] = c.ModelAliases
 	}
-	if strings.TrimSpace(c.Admin.PasswordHash) != "" || c.Admin.JWTExpireHours > 0 || c.Admin.JWTValidAfterUnix > 0 {
+	if !c.Admin.isZero() {
 		m["admin"] = c.Admin
 	}
-	if c.Runtime.AccountMaxInflight > 0 || c.Runtime.AccountMaxQueue > 0 || c.Runtime.GlobalMaxInflight > 0 {
+	if c.Runtime.AccountMaxInflight > 0 || c.Runtime.AccountMaxQueue > 0 || c.Runtime.GlobalMaxInflight > 0 || c.Runtime.MaxChoices > 0 || c.Runtime.BatchConcurrencyShare > 0 {
 		m["runtime"] = c.Runtime
 	}
-	if c.Compat.WideInputStrictOutput != nil {
+	if c.Compat.WideInputStrictOutput != nil || c.Compat.ResponseFormatRepair != nil {
 		m["compat"] = c.Compat
 	}
 	if strings.TrimSpace(c.Toolcall.Mode) != "" || strings.TrimSpace(c.Toolcall.EarlyEmitConfidence) != "" {
@@ -142,6 +191,21 @@ func (c Config) MarshalJSON() ([]byte, error) {
 	if strings.TrimSpace(c.Embeddings.Provider) != "" {
 		m["embeddings"] = c.Embeddings
 	}
+	if !c.Reasoning.isZero() {
+		m["reasoning"] = c.Reasoning
+	}
+	if c.Audit.MaxEntries > 0 || c.Audit.RetentionDays > 0 {
+		m["audit"] = c.Audit
+	}
+	if len(c.KeyPolicies) > 0 {
+		m["key_policies"] = c.KeyPolicies
+	}
+	if strings.TrimSpace(c.Passthrough.Mode) != "" || len(c.Passthrough.AllowedTokenHashes) > 0 {
+		m["passthrough"] = c.Passthrough
+	}
+	if len(c.Network.TrustedProxyHeaders) > 0 || len(c.Network.TrustedProxyCIDRs) > 0 {
+		m["network"] = c.Network
+	}
 	if c.VercelSyncHash != "" {
 		m["_vercel_sync_hash"] = c.VercelSyncHash
 	}
@@ -203,6 +267,26 @@ func (c *Config) UnmarshalJSON(b []byte) error {
 			if err := json.Unmarshal(v, &c.Embeddings); err != nil {
 				return fmt.Errorf("invalid field %q: %w", k, err)
 			}
+		case "reasoning":
+			if err := json.Unmarshal(v, &c.Reasoning); err != nil {
+				return fmt.Errorf("invalid field %q: %w", k, err)
+			}
+		case "audit":
+			if err := json.Unmarshal(v, &c.Audit); err != nil {
+				return fmt.Errorf("invalid field %q: %w", k, err)
+			}
+		case "key_policies":
+			if err := json.Unmarshal(v, &c.KeyPolicies); err != nil {
+				return fmt.Errorf("invalid field %q: %w", k, err)
+			}
+		case "passthrough":
+			if err := json.Unmarshal(v, &c.Passthrough); err != nil {
+				return fmt.Errorf("invalid field %q: %w", k, err)
+			}
+		case "network":
+			if err := json.Unmarshal(v, &c.Network); err != nil {
+				return fmt.Errorf("invalid field %q: %w", k, err)
+			}
 		case "_vercel_sync_hash":
 			if err := json.Unmarshal(v, &c.VercelSyncHash); err != nil {
 				return fmt.Errorf("invalid field %q: %w", k, err)
@@ -232,14 +316,24 @@ func (c Config) Clone() Config {
 		Runtime:        c.Runtime,
 		Compat: CompatConfig{
 			WideInputStrictOutput: cloneBoolPtr(c.Compat.WideInputStrictOutput),
+			ResponseFormatRepair:  cloneBoolPtr(c.Compat.ResponseFormatRepair),
 		},
 		Toolcall:         c.Toolcall,
 		Responses:        c.Responses,
 		Embeddings:       c.Embeddings,
+		Reasoning:        c.Reasoning.clone(),
+		Audit:            c.Audit,
+		KeyPolicies:      cloneKeyPolicies(c.KeyPolicies),
+		Passthrough:      c.Passthrough,
+		Network:          c.Network,
 		VercelSyncHash:   c.VercelSyncHash,
 		VercelSyncTime:   c.VercelSyncTime,
 		AdditionalFields: map[string]any{},
 	}
+	clone.Admin.Users = slices.Clone(c.Admin.Users)
+	clone.Passthrough.AllowedTokenHashes = slices.Clone(c.Passthrough.AllowedTokenHashes)
+	clone.Network.TrustedProxyHeaders = slices.Clone(c.Network.TrustedProxyHeaders)
+	clone.Network.TrustedProxyCIDRs = slices.Clone(c.Network.TrustedProxyCIDRs)
 	for k, v := range c.AdditionalFields {
 		clone.AdditionalFields[k] = v
 	}
@@ -272,6 +366,8 @@ type Store struct {
 	fromEnv bool
 	keyMap  map[string]struct{} // O(1) API key lookup index
 	accMap  map[string]int      // O(1) account lookup: identifier -> slice index
+	network networkIndex        // parsed key policies and trusted proxies
+	ptHash  map[string]struct{} // passthrough allowlist (token SHA-256 hex)
 }
 
 func BaseDir() string {
@@ -305,6 +401,30 @@ func WASMPath() string {
 	return ResolvePath("DS2API_WASM_PATH", "sha3_wasm_bg.7b9ca65ddd.wasm")
 }
 
+func TokenizerPath() string {
+	return ResolvePath("DS2API_TOKENIZER_PATH", "tokenizer.json")
+}
+
+func AdminSessionsPath() string {
+	return ResolvePath("DS2API_ADMIN_SESSIONS_PATH", "data/admin_sessions.json")
+}
+
+func AuditLogPath() string {
+	return ResolvePath("DS2API_AUDIT_LOG_PATH", "data/admin_audit.jsonl")
+}
+
+func ClaudeBatchesDir() string {
+	return ResolvePath("DS2API_CLAUDE_BATCHES_DIR", "data/claude_batches")
+}
+
+func OpenAIFilesDir() string {
+	return ResolvePath("DS2API_OPENAI_FILES_DIR", "data/openai_files")
+}
+
+func OpenAIBatchesDir() string {
+	return ResolvePath("DS2API_OPENAI_BATCHES_DIR", "data/openai_batches")
+}
+
 func StaticAdminDir() string {
 	return ResolvePath("DS2API_STATIC_ADMIN_DIR", "static/admin")
 }
@@ -335,6 +455,11 @@ func (s *Store) rebuildIndexes() {
 			s.accMap[id] = i
 		}
 	}
+	s.network = buildNetworkIndex(s.cfg)
+	s.ptHash = make(map[string]struct{}, len(s.cfg.Passthrough.AllowedTokenHashes))
+	for _, h := range s.cfg.Passthrough.AllowedTokenHashes {
+		s.ptHash[strings.ToLower(strings.TrimSpace(h))] = struct{}{}
+	}
 }
 
 func loadConfig() (Config, bool, error) {
@@ -618,6 +743,22 @@ func (s *Store) CompatWideInputStrictOutput() bool {
 	return *s.cfg.Compat.WideInputStrictOutput
 }
 
+// CompatResponseFormatRepair reports whether output that fails a requested
+// response_format is sent back to the model once for correction.
+func (s *Store) CompatResponseFormatRepair() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cfg.Compat.ResponseFormatRepair != nil {
+		return *s.cfg.Compat.ResponseFormatRepair
+	}
+	if raw := strings.TrimSpace(os.Getenv("DS2API_RESPONSE_FORMAT_REPAIR")); raw != "" {
+		if v, err := strconv.ParseBool(raw); err == nil {
+			return v
+		}
+	}
+	return true
+}
+
 func (s *Store) ToolcallMode() string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -653,6 +794,56 @@ func (s *Store) EmbeddingsProvider() string {
 	return strings.TrimSpace(s.cfg.Embeddings.Provider)
 }
 
+func (s *Store) AuditMaxEntries() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cfg.Audit.MaxEntries > 0 {
+		return s.cfg.Audit.MaxEntries
+	}
+	return 10000
+}
+
+func (s *Store) AuditRetentionDays() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cfg.Audit.RetentionDays > 0 {
+		return s.cfg.Audit.RetentionDays
+	}
+	return 90
+}
+
+// PassthroughMode returns allow, deny or allowlist. DS2API_PASSTHROUGH_MODE
+// applies when the config leaves it unset.
+func (s *Store) PassthroughMode() string {
+	s.mu.RLock()
+	mode := strings.ToLower(strings.TrimSpace(s.cfg.Passthrough.Mode))
+	s.mu.RUnlock()
+	if mode == "" {
+		mode = strings.ToLower(strings.TrimSpace(os.Getenv("DS2API_PASSTHROUGH_MODE")))
+	}
+	switch mode {
+	case PassthroughDeny, PassthroughAllowlist:
+		return mode
+	default:
+		return PassthroughAllow
+	}
+}
+
+// PassthroughTokenAllowed reports whether a raw token's SHA-256 hex digest is
+// on the passthrough allowlist.
+func (s *Store) PassthroughTokenAllowed(tokenHash string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.ptHash[tokenHash]
+	return ok
+}
+
+// PassthroughTokenHash is the digest used by passthrough.allowed_token_hashes.
+func PassthroughTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
+
 func (s *Store) AdminPasswordHash() string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -673,6 +864,49 @@ func (s *Store) AdminJWTExpireHours() int {
 	return 24
 }
 
+// AdminUsers returns the configured named admin users.
+func (s *Store) AdminUsers() []AdminUser {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.cfg.Admin.Users)
+}
+
+// AdminTOTPSecret returns the TOTP secret of the shared admin credential.
+func (s *Store) AdminTOTPSecret() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return strings.TrimSpace(s.cfg.Admin.TOTPSecret)
+}
+
+// AdminAccessTokenMinutes is the lifetime of admin access tokens, 15 minutes
+// by default; clients renew them with the refresh token of their session.
+func (s *Store) AdminAccessTokenMinutes() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cfg.Admin.AccessTokenMinutes > 0 {
+		return s.cfg.Admin.AccessTokenMinutes
+	}
+	return 15
+}
+
+func (s *Store) AdminLoginMaxFailures() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cfg.Admin.LoginMaxFailures > 0 {
+		return s.cfg.Admin.LoginMaxFailures
+	}
+	return 5
+}
+
+func (s *Store) AdminLoginLockoutSeconds() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cfg.Admin.LoginLockoutSeconds > 0 {
+		return s.cfg.Admin.LoginLockoutSeconds
+	}
+	return 900
+}
+
 func (s *Store) AdminJWTValidAfterUnix() int64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -720,6 +954,54 @@ func (s *Store) RuntimeAccountMaxQueue(defaultSize int) int {
 	return defaultSize
 }
 
+// RuntimeMaxChoices is the largest `n` a chat completion may request, and
+// the most prompts × `n` a legacy completion may. Each choice is served by
+// its own upstream completion.
+func (s *Store) RuntimeMaxChoices() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cfg.Runtime.MaxChoices > 0 {
+		return s.cfg.Runtime.MaxChoices
+	}
+	if raw := strings.TrimSpace(os.Getenv("DS2API_MAX_CHOICES")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 4
+}
+
+// RuntimeBatchConcurrencyShare is the percentage (1-100) of the global
+// in-flight limit that batch workers may occupy.
+func (s *Store) RuntimeBatchConcurrencyShare() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if n := s.cfg.Runtime.BatchConcurrencyShare; n > 0 && n <= 100 {
+		return n
+	}
+	if raw := strings.TrimSpace(os.Getenv("DS2API_BATCH_CONCURRENCY_SHARE")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 && n <= 100 {
+			return n
+		}
+	}
+	return 50
+}
+
+// RuntimeBatchMaxInflight is how many batch requests may run at once: the
+// batch share of the global in-flight limit, and at least one.
+func (s *Store) RuntimeBatchMaxInflight() int {
+	perAccount := s.RuntimeAccountMaxInflight()
+	recommended := perAccount * len(s.Accounts())
+	if recommended <= 0 {
+		recommended = perAccount
+	}
+	n := s.RuntimeGlobalMaxInflight(recommended) * s.RuntimeBatchConcurrencyShare() / 100
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
 func (s *Store) RuntimeGlobalMaxInflight(defaultSize int) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
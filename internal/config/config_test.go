@@ -121,3 +121,24 @@ func TestLoadConfigOnVercelWithoutConfigFileFallsBackToMemory(t *testing.T) {
 		t.Fatalf("expected empty bootstrap config, got keys=%d accounts=%d", len(cfg.Keys), len(cfg.Accounts))
 	}
 }
+
+func TestThinkingSignatureKeyIsDerivedFromAdminSecret(t *testing.T) {
+	t.Setenv("DS2API_THINKING_SIGNATURE_KEY", "")
+	t.Setenv("DS2API_JWT_SECRET", "")
+	t.Setenv("DS2API_ADMIN_KEY", "admin-secret")
+	t.Setenv("DS2API_CONFIG_JSON", `{}`)
+	first := LoadStore().ThinkingSignatureKey()
+	second := LoadStore().ThinkingSignatureKey()
+	if len(first) != 32 || string(first) != string(second) || string(first) == "admin-secret" {
+		t.Fatalf("expected a stable derived key, got %x and %x", first, second)
+	}
+
+	t.Setenv("DS2API_JWT_SECRET", "jwt-secret")
+	if string(LoadStore().ThinkingSignatureKey()) == string(first) {
+		t.Fatal("expected DS2API_JWT_SECRET to take precedence over the admin key")
+	}
+	t.Setenv("DS2API_CONFIG_JSON", `{"reasoning":{"signature_key":"explicit"}}`)
+	if got := string(LoadStore().ThinkingSignatureKey()); got != "explicit" {
+		t.Fatalf("expected the configured key, got %q", got)
+	}
+}
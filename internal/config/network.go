@@ -0,0 +1,212 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// KeyPolicy restricts where a managed API key may be used from and binds
+// client certificates to it. A request presenting a verified certificate
+// that matches ClientCertSubjects or ClientCertFingerprints authenticates as
+// Key without sending the key itself. SearchSources turns on the search
+// progress and source extensions of chat completions for the key.
+type KeyPolicy struct {
+	Key                    string   `json:"key"`
+	AllowedCIDRs           []string `json:"allowed_cidrs,omitempty"`
+	ClientCertSubjects     []string `json:"client_cert_subjects,omitempty"`
+	ClientCertFingerprints []string `json:"client_cert_fingerprints,omitempty"`
+	SearchSources          bool     `json:"search_sources,omitempty"`
+}
+
+// NetworkConfig controls which proxy headers may override the peer address.
+// Headers are only honoured from peers inside TrustedProxyCIDRs, so none are
+// trusted until it is set. TrustedProxyHeaders defaults to True-Client-IP,
+// X-Real-IP and X-Forwarded-For; ["none"] disables them.
+type NetworkConfig struct {
+	TrustedProxyHeaders []string `json:"trusted_proxy_headers,omitempty"`
+	TrustedProxyCIDRs   []string `json:"trusted_proxy_cidrs,omitempty"`
+}
+
+var defaultTrustedProxyHeaders = []string{"True-Client-IP", "X-Real-IP", "X-Forwarded-For"}
+
+type networkIndex struct {
+	allowedCIDRs   map[string][]netip.Prefix
+	certSubjects   map[string]string
+	certPrints     map[string]string
+	searchSources  map[string]bool
+	proxyHeaders   []string
+	trustedProxies []netip.Prefix
+}
+
+func cloneKeyPolicies(in []KeyPolicy) []KeyPolicy {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]KeyPolicy, len(in))
+	for i, p := range in {
+		out[i] = KeyPolicy{
+			Key:                    p.Key,
+			AllowedCIDRs:           slices.Clone(p.AllowedCIDRs),
+			ClientCertSubjects:     slices.Clone(p.ClientCertSubjects),
+			ClientCertFingerprints: slices.Clone(p.ClientCertFingerprints),
+			SearchSources:          p.SearchSources,
+		}
+	}
+	return out
+}
+
+// ParseCIDRs parses CIDR ranges; bare addresses are treated as single-host
+// ranges.
+func ParseCIDRs(values []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(values))
+	for _, raw := range values {
+		v := strings.TrimSpace(raw)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", raw)
+			}
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", raw)
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
+// NormalizeCertFingerprint lower-cases a SHA-256 fingerprint and strips the
+// colons many tools print between bytes.
+func NormalizeCertFingerprint(v string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(v), ":", ""))
+}
+
+func buildNetworkIndex(c Config) networkIndex {
+	idx := networkIndex{
+		allowedCIDRs:  map[string][]netip.Prefix{},
+		certSubjects:  map[string]string{},
+		certPrints:    map[string]string{},
+		searchSources: map[string]bool{},
+		proxyHeaders:  defaultTrustedProxyHeaders,
+	}
+	for _, p := range c.KeyPolicies {
+		key := strings.TrimSpace(p.Key)
+		if key == "" {
+			continue
+		}
+		if p.SearchSources {
+			idx.searchSources[key] = true
+		}
+		if prefixes, err := ParseCIDRs(p.AllowedCIDRs); err == nil && len(prefixes) > 0 {
+			idx.allowedCIDRs[key] = prefixes
+		} else if err != nil {
+			// Fail closed: a policy that cannot be parsed admits nobody.
+			Logger.Warn("[config] invalid key policy CIDR", "error", err)
+			idx.allowedCIDRs[key] = []netip.Prefix{}
+		}
+		for _, subject := range p.ClientCertSubjects {
+			if v := strings.TrimSpace(subject); v != "" {
+				idx.certSubjects[v] = key
+			}
+		}
+		for _, fp := range p.ClientCertFingerprints {
+			if v := NormalizeCertFingerprint(fp); v != "" {
+				idx.certPrints[v] = key
+			}
+		}
+	}
+	if len(c.Network.TrustedProxyHeaders) > 0 {
+		idx.proxyHeaders = nil
+		for _, h := range c.Network.TrustedProxyHeaders {
+			h = strings.TrimSpace(h)
+			if h == "" || strings.EqualFold(h, "none") {
+				continue
+			}
+			idx.proxyHeaders = append(idx.proxyHeaders, h)
+		}
+	}
+	if prefixes, err := ParseCIDRs(c.Network.TrustedProxyCIDRs); err == nil {
+		idx.trustedProxies = prefixes
+	} else {
+		Logger.Warn("[config] invalid trusted proxy CIDR", "error", err)
+		idx.proxyHeaders = nil
+	}
+	return idx
+}
+
+// KeyAllowsAddr reports whether a managed key may be used from addr. Keys
+// without an allowlist are usable from anywhere.
+func (s *Store) KeyAllowsAddr(key string, addr netip.Addr) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	prefixes, ok := s.network.allowedCIDRs[key]
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeySearchSources reports whether a managed key receives search progress
+// and sources on chat completions by default.
+func (s *Store) KeySearchSources(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.network.searchSources[key]
+}
+
+// KeyForClientCert maps a verified client certificate to the managed key it
+// is bound to, by SHA-256 fingerprint first and then by subject (full DN or
+// common name).
+func (s *Store) KeyForClientCert(cert *x509.Certificate) (string, bool) {
+	if cert == nil {
+		return "", false
+	}
+	sum := sha256.Sum256(cert.Raw)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, ok := s.network.certPrints[hex.EncodeToString(sum[:])]; ok {
+		return key, true
+	}
+	if key, ok := s.network.certSubjects[cert.Subject.String()]; ok {
+		return key, true
+	}
+	if cn := strings.TrimSpace(cert.Subject.CommonName); cn != "" {
+		if key, ok := s.network.certSubjects[cn]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// TrustedProxyHeaders returns the headers that may carry the client address,
+// in precedence order.
+func (s *Store) TrustedProxyHeaders() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.network.proxyHeaders)
+}
+
+// TrustedProxies returns the peer ranges allowed to set proxy headers. Empty
+// means no peer.
+func (s *Store) TrustedProxies() []netip.Prefix {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.network.trustedProxies)
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ReasoningConfig maps the reasoning controls clients send (OpenAI
+// reasoning_effort, Responses reasoning.effort, Claude thinking) to the
+// upstream thinking and search toggles. Efforts are merged over
+// DefaultReasoningEfforts.
+//
+// SignatureKey signs the Claude thinking blocks returned to clients and
+// ThinkingHistory decides what happens to those blocks when a client sends
+// them back: drop (the default) discards them, replay verifies them and
+// keeps their text in the prompt, and ignore discards them unverified.
+type ReasoningConfig struct {
+	Efforts         map[string]ReasoningRule `json:"efforts,omitempty"`
+	SignatureKey    string                   `json:"signature_key,omitempty"`
+	ThinkingHistory string                   `json:"thinking_history,omitempty"`
+}
+
+const (
+	ThinkingHistoryDrop   = "drop"
+	ThinkingHistoryReplay = "replay"
+	ThinkingHistoryIgnore = "ignore"
+)
+
+func (r ReasoningConfig) isZero() bool {
+	return len(r.Efforts) == 0 && strings.TrimSpace(r.SignatureKey) == "" && strings.TrimSpace(r.ThinkingHistory) == ""
+}
+
+func (r ReasoningConfig) clone() ReasoningConfig {
+	r.Efforts = cloneReasoningEfforts(r.Efforts)
+	return r
+}
+
+// ReasoningRule is what one effort level selects. A nil toggle keeps the
+// value the model implies.
+type ReasoningRule struct {
+	Thinking *bool `json:"thinking,omitempty"`
+	Search   *bool `json:"search,omitempty"`
+}
+
+// Apply overrides the model's thinking and search toggles.
+func (r ReasoningRule) Apply(thinking, search bool) (bool, bool) {
+	if r.Thinking != nil {
+		thinking = *r.Thinking
+	}
+	if r.Search != nil {
+		search = *r.Search
+	}
+	return thinking, search
+}
+
+func (r ReasoningRule) isZero() bool {
+	return r.Thinking == nil && r.Search == nil
+}
+
+// DefaultReasoningEfforts turns thinking off for none and minimal and on for
+// every higher effort; search follows the model.
+func DefaultReasoningEfforts() map[string]ReasoningRule {
+	off, on := false, true
+	return map[string]ReasoningRule{
+		"none":    {Thinking: &off},
+		"minimal": {Thinking: &off},
+		"low":     {Thinking: &on},
+		"medium":  {Thinking: &on},
+		"high":    {Thinking: &on},
+		"xhigh":   {Thinking: &on},
+	}
+}
+
+// ClaudeThinkingEffort maps a Claude thinking budget to the effort whose rule
+// applies: under 4096 tokens is low, under 16384 medium, anything more high.
+func ClaudeThinkingEffort(budgetTokens int) string {
+	switch {
+	case budgetTokens < 4096:
+		return "low"
+	case budgetTokens < 16384:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+func cloneReasoningEfforts(in map[string]ReasoningRule) map[string]ReasoningRule {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]ReasoningRule, len(in))
+	for k, r := range in {
+		out[k] = ReasoningRule{Thinking: cloneBoolPtr(r.Thinking), Search: cloneBoolPtr(r.Search)}
+	}
+	return out
+}
+
+// ReasoningEffortRule returns the rule of an effort level, configured rules
+// taking precedence over the defaults. ok is false for unknown efforts.
+func (s *Store) ReasoningEffortRule(effort string) (ReasoningRule, bool) {
+	effort = strings.ToLower(strings.TrimSpace(effort))
+	s.mu.RLock()
+	rule, ok := s.cfg.Reasoning.Efforts[effort]
+	s.mu.RUnlock()
+	if ok && !rule.isZero() {
+		return ReasoningRule{Thinking: cloneBoolPtr(rule.Thinking), Search: cloneBoolPtr(rule.Search)}, true
+	}
+	rule, ok = DefaultReasoningEfforts()[effort]
+	return rule, ok
+}
+
+// ReasoningRuleReader resolves effort levels to rules.
+type ReasoningRuleReader interface {
+	ReasoningEffortRule(effort string) (ReasoningRule, bool)
+}
+
+// LookupReasoningEffort resolves an effort through store, or through the
+// defaults when store is nil.
+func LookupReasoningEffort(store ReasoningRuleReader, effort string) (ReasoningRule, bool) {
+	if store != nil {
+		return store.ReasoningEffortRule(effort)
+	}
+	rule, ok := DefaultReasoningEfforts()[strings.ToLower(strings.TrimSpace(effort))]
+	return rule, ok
+}
+
+var (
+	processSignatureKeyOnce sync.Once
+	processSignatureKey     []byte
+)
+
+// ThinkingSignatureKey returns the key thinking signatures are made with:
+// reasoning.signature_key, then DS2API_THINKING_SIGNATURE_KEY, then a key
+// derived from the admin secret (DS2API_JWT_SECRET, admin.password_hash or
+// DS2API_ADMIN_KEY) so signatures survive restarts and are shared between
+// instances. Only without any of those is a random per-process key used.
+func (s *Store) ThinkingSignatureKey() []byte {
+	s.mu.RLock()
+	key := strings.TrimSpace(s.cfg.Reasoning.SignatureKey)
+	secret := strings.TrimSpace(s.cfg.Admin.PasswordHash)
+	s.mu.RUnlock()
+	if key == "" {
+		key = strings.TrimSpace(os.Getenv("DS2API_THINKING_SIGNATURE_KEY"))
+	}
+	if key != "" {
+		return []byte(key)
+	}
+	if derived := deriveAdminKey(secret, "ds2api thinking signature"); derived != nil {
+		return derived
+	}
+	processSignatureKeyOnce.Do(func() {
+		processSignatureKey = make([]byte, 32)
+		_, _ = rand.Read(processSignatureKey)
+		Logger.Warn("[config] reasoning.signature_key is not set; thinking signatures will not survive a restart")
+	})
+	return processSignatureKey
+}
+
+var (
+	processCredentialKeyOnce sync.Once
+	processCredentialKey     []byte
+)
+
+// CredentialKey returns the key caller credentials kept for background work
+// are sealed with, derived from the admin secret like ThinkingSignatureKey.
+// Without an admin secret a random per-process key is used, so sealed
+// credentials do not survive a restart.
+func (s *Store) CredentialKey() []byte {
+	s.mu.RLock()
+	secret := strings.TrimSpace(s.cfg.Admin.PasswordHash)
+	s.mu.RUnlock()
+	if derived := deriveAdminKey(secret, "ds2api credential seal"); derived != nil {
+		return derived
+	}
+	processCredentialKeyOnce.Do(func() {
+		processCredentialKey = make([]byte, 32)
+		_, _ = rand.Read(processCredentialKey)
+		Logger.Warn("[config] no admin secret is set; queued batch credentials will not survive a restart")
+	})
+	return processCredentialKey
+}
+
+// deriveAdminKey derives a key for info from the admin secret:
+// DS2API_JWT_SECRET, then passwordHash, then DS2API_ADMIN_KEY. It returns nil
+// when none is set.
+func deriveAdminKey(passwordHash, info string) []byte {
+	secret := passwordHash
+	if v := strings.TrimSpace(os.Getenv("DS2API_JWT_SECRET")); v != "" {
+		secret = v
+	}
+	if secret == "" {
+		secret = strings.TrimSpace(os.Getenv("DS2API_ADMIN_KEY"))
+	}
+	if secret == "" {
+		return nil
+	}
+	derived, err := hkdf.Key(sha256.New, []byte(secret), nil, info, 32)
+	if err != nil {
+		return nil
+	}
+	return derived
+}
+
+// ThinkingHistoryPolicy returns drop, replay or ignore.
+func (s *Store) ThinkingHistoryPolicy() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch policy := strings.ToLower(strings.TrimSpace(s.cfg.Reasoning.ThinkingHistory)); policy {
+	case ThinkingHistoryReplay, ThinkingHistoryIgnore:
+		return policy
+	default:
+		return ThinkingHistoryDrop
+	}
+}
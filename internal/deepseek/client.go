@@ -24,6 +24,19 @@ import (
 // intFrom is a package-internal alias for the shared util version.
 var intFrom = util.IntFrom
 
+// CallError is returned when a session, PoW or completion call gives up.
+// Status is the HTTP status of the last reply, 0 when none arrived, and
+// TokenInvalid whether DeepSeek rejected the account token.
+type CallError struct {
+	Op           string
+	Status       int
+	TokenInvalid bool
+}
+
+func (e *CallError) Error() string {
+	return e.Op + " failed"
+}
+
 type Client struct {
 	Store      *config.Store
 	Auth       *auth.Resolver
@@ -93,11 +106,13 @@ func (c *Client) CreateSession(ctx context.Context, a *auth.RequestAuth, maxAtte
 	}
 	attempts := 0
 	refreshed := false
+	callErr := &CallError{Op: "create session"}
 	for attempts < maxAttempts {
 		headers := c.authHeaders(a.DeepSeekToken)
 		resp, status, err := c.postJSONWithStatus(ctx, c.regular, DeepSeekCreateSessionURL, headers, map[string]any{"agent": "chat"})
 		if err != nil {
 			config.Logger.Warn("[create_session] request error", "error", err, "account", a.AccountID)
+			callErr.Status, callErr.TokenInvalid = 0, false
 			attempts++
 			continue
 		}
@@ -112,8 +127,9 @@ func (c *Client) CreateSession(ctx context.Context, a *auth.RequestAuth, maxAtte
 		}
 		msg, _ := resp["msg"].(string)
 		config.Logger.Warn("[create_session] failed", "status", status, "code", code, "msg", msg, "use_config_token", a.UseConfigToken, "account", a.AccountID)
+		callErr.Status, callErr.TokenInvalid = status, isTokenInvalid(status, code, msg)
 		if a.UseConfigToken {
-			if isTokenInvalid(status, code, msg) && !refreshed {
+			if callErr.TokenInvalid && !refreshed {
 				if c.Auth.RefreshToken(ctx, a) {
 					refreshed = true
 					continue
@@ -127,7 +143,7 @@ func (c *Client) CreateSession(ctx context.Context, a *auth.RequestAuth, maxAtte
 		}
 		attempts++
 	}
-	return "", errors.New("create session failed")
+	return "", callErr
 }
 
 func (c *Client) GetPow(ctx context.Context, a *auth.RequestAuth, maxAttempts int) (string, error) {
@@ -135,11 +151,13 @@ func (c *Client) GetPow(ctx context.Context, a *auth.RequestAuth, maxAttempts in
 		maxAttempts = c.maxRetries
 	}
 	attempts := 0
+	callErr := &CallError{Op: "get pow"}
 	for attempts < maxAttempts {
 		headers := c.authHeaders(a.DeepSeekToken)
 		resp, status, err := c.postJSONWithStatus(ctx, c.regular, DeepSeekCreatePowURL, headers, map[string]any{"target_path": "/api/v0/chat/completion"})
 		if err != nil {
 			config.Logger.Warn("[get_pow] request error", "error", err, "account", a.AccountID)
+			callErr.Status, callErr.TokenInvalid = 0, false
 			attempts++
 			continue
 		}
@@ -157,8 +175,9 @@ func (c *Client) GetPow(ctx context.Context, a *auth.RequestAuth, maxAttempts in
 		}
 		msg, _ := resp["msg"].(string)
 		config.Logger.Warn("[get_pow] failed", "status", status, "code", code, "msg", msg, "use_config_token", a.UseConfigToken, "account", a.AccountID)
+		callErr.Status, callErr.TokenInvalid = status, isTokenInvalid(status, code, msg)
 		if a.UseConfigToken {
-			if isTokenInvalid(status, code, msg) {
+			if callErr.TokenInvalid {
 				if c.Auth.RefreshToken(ctx, a) {
 					continue
 				}
@@ -170,7 +189,7 @@ func (c *Client) GetPow(ctx context.Context, a *auth.RequestAuth, maxAttempts in
 		}
 		attempts++
 	}
-	return "", errors.New("get pow failed")
+	return "", callErr
 }
 
 func (c *Client) CallCompletion(ctx context.Context, a *auth.RequestAuth, payload map[string]any, powResp string, maxAttempts int) (*http.Response, error) {
@@ -180,9 +199,11 @@ func (c *Client) CallCompletion(ctx context.Context, a *auth.RequestAuth, payloa
 	headers := c.authHeaders(a.DeepSeekToken)
 	headers["x-ds-pow-response"] = powResp
 	attempts := 0
+	callErr := &CallError{Op: "completion"}
 	for attempts < maxAttempts {
 		resp, err := c.streamPost(ctx, DeepSeekCompletionURL, headers, payload)
 		if err != nil {
+			callErr.Status = 0
 			attempts++
 			time.Sleep(time.Second)
 			continue
@@ -190,11 +211,12 @@ func (c *Client) CallCompletion(ctx context.Context, a *auth.RequestAuth, payloa
 		if resp.StatusCode == http.StatusOK {
 			return resp, nil
 		}
+		callErr.Status = resp.StatusCode
 		_ = resp.Body.Close()
 		attempts++
 		time.Sleep(time.Second)
 	}
-	return nil, errors.New("completion failed")
+	return nil, callErr
 }
 
 func (c *Client) postJSON(ctx context.Context, doer trans.Doer, url string, headers map[string]string, payload any) (map[string]any, error) {
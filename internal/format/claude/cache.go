@@ -0,0 +1,19 @@
+package claude
+
+// CacheUsage is the prompt-caching share of a message's input: tokens
+// written to the cache by this request and tokens read from it. Both are
+// estimates from the local prompt cache, not upstream cache hits.
+type CacheUsage struct {
+	CreationInputTokens int
+	ReadInputTokens     int
+}
+
+// Apply reports the cache split in usage. Cached tokens are taken out of
+// input_tokens, which then only counts the uncached rest of the prompt.
+func (c CacheUsage) Apply(usage map[string]any) {
+	if in, ok := usage["input_tokens"].(int); ok {
+		usage["input_tokens"] = max(in-c.CreationInputTokens-c.ReadInputTokens, 0)
+	}
+	usage["cache_creation_input_tokens"] = c.CreationInputTokens
+	usage["cache_read_input_tokens"] = c.ReadInputTokens
+}
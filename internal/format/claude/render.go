@@ -1,25 +1,42 @@
 package claude
 
 import (
-	"fmt"
-	"time"
+	"strings"
+
+	"github.com/google/uuid"
 
 	"ds2api/internal/util"
 )
 
-func BuildMessageResponse(messageID, model string, normalizedMessages []any, finalThinking, finalText string, toolNames []string) map[string]any {
-	detected := util.ParseToolCalls(finalText, toolNames)
+// BuildMessageResponse renders a message; input_tokens counts finalPrompt,
+// the prompt actually sent upstream.
+func BuildMessageResponse(messageID, model, finalPrompt, finalThinking, finalText string, toolNames []string) map[string]any {
+	return BuildMessageResponseWithToolCalls(messageID, model, finalPrompt, finalThinking, finalText, util.ParseToolCalls(finalText, toolNames))
+}
+
+// BuildMessageResponseWithToolCalls renders a message whose tool calls were
+// already resolved by the caller.
+func BuildMessageResponseWithToolCalls(messageID, model, finalPrompt, finalThinking, finalText string, detected []util.ParsedToolCall) map[string]any {
+	return BuildMessageResponseWithSearch(messageID, model, finalPrompt, finalThinking, "", finalText, detected, nil)
+}
+
+// BuildMessageResponseWithSearch also renders the searches upstream ran as
+// web_search blocks and resolves the citation markers in the text. sources
+// is nil unless search was enabled; thinkingSignature signs finalThinking.
+func BuildMessageResponseWithSearch(messageID, model, finalPrompt, finalThinking, thinkingSignature, finalText string, detected []util.ParsedToolCall, sources *util.SearchSources) map[string]any {
 	content := make([]map[string]any, 0, 4)
 	if finalThinking != "" {
-		content = append(content, map[string]any{"type": "thinking", "thinking": finalThinking})
+		content = append(content, BuildThinkingBlock(finalThinking, thinkingSignature))
 	}
+	searches := sources.Searches()
+	content = append(content, BuildWebSearchBlocks(searches)...)
 	stopReason := "end_turn"
 	if len(detected) > 0 {
 		stopReason = "tool_use"
-		for i, tc := range detected {
+		for _, tc := range detected {
 			content = append(content, map[string]any{
 				"type":  "tool_use",
-				"id":    fmt.Sprintf("toolu_%d_%d", time.Now().Unix(), i),
+				"id":    NewToolUseID(),
 				"name":  tc.Name,
 				"input": tc.Input,
 			})
@@ -28,7 +45,15 @@ func BuildMessageResponse(messageID, model string, normalizedMessages []any, fin
 		if finalText == "" {
 			finalText = "抱歉，没有生成有效的响应内容。"
 		}
-		content = append(content, map[string]any{"type": "text", "text": finalText})
+		if sources != nil {
+			content = append(content, BuildCitedTextBlocks(finalText, sources)...)
+		} else {
+			content = append(content, map[string]any{"type": "text", "text": finalText})
+		}
+	}
+	usage := BuildUsage(finalPrompt, finalThinking, finalText, util.UpstreamUsage{})
+	if len(searches) > 0 {
+		usage["server_tool_use"] = BuildServerToolUsage(len(searches))
 	}
 	return map[string]any{
 		"id":            messageID,
@@ -38,9 +63,26 @@ func BuildMessageResponse(messageID, model string, normalizedMessages []any, fin
 		"content":       content,
 		"stop_reason":   stopReason,
 		"stop_sequence": nil,
-		"usage": map[string]any{
-			"input_tokens":  util.EstimateTokens(fmt.Sprintf("%v", normalizedMessages)),
-			"output_tokens": util.EstimateTokens(finalThinking) + util.EstimateTokens(finalText),
-		},
+		"usage":         usage,
+	}
+}
+
+// NewToolUseID returns a unique id for a tool_use block. Clients echo it as
+// the tool_use_id of the matching tool_result.
+func NewToolUseID() string {
+	return "toolu_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+// BuildUsage renders message usage, preferring the output token count
+// upstream reported over a local count.
+func BuildUsage(finalPrompt, finalThinking, finalText string, upstream util.UpstreamUsage) map[string]any {
+	_, outputTokens := upstream.Output(finalThinking, finalText)
+	usage := map[string]any{
+		"input_tokens":  upstream.Input(finalPrompt),
+		"output_tokens": outputTokens,
+	}
+	if secs, ok := upstream.ReasoningSeconds(); ok {
+		usage["reasoning_seconds"] = secs
 	}
+	return usage
 }
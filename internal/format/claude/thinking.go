@@ -0,0 +1,41 @@
+package claude
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// thinkingSignatureContext separates thinking signatures from any other
+// HMAC made with the same key.
+const thinkingSignatureContext = "ds2api.thinking.v1\x00"
+
+// SignThinking returns the signature of a thinking block: an HMAC-SHA256 of
+// its text under key, base64-encoded. Clients send it back unchanged with the
+// block, which lets the server tell its own thinking from edited or foreign
+// blocks.
+func SignThinking(key []byte, thinking string) string {
+	return base64.StdEncoding.EncodeToString(thinkingMAC(key, thinking))
+}
+
+// VerifyThinking reports whether signature was made by SignThinking for
+// thinking under key.
+func VerifyThinking(key []byte, thinking, signature string) bool {
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, thinkingMAC(key, thinking))
+}
+
+func thinkingMAC(key []byte, thinking string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(thinkingSignatureContext))
+	_, _ = h.Write([]byte(thinking))
+	return h.Sum(nil)
+}
+
+// BuildThinkingBlock renders a complete thinking block.
+func BuildThinkingBlock(thinking, signature string) map[string]any {
+	return map[string]any{"type": "thinking", "thinking": thinking, "signature": signature}
+}
@@ -0,0 +1,119 @@
+package claude
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"ds2api/internal/util"
+)
+
+const citedTextLimit = 150
+
+// NewServerToolUseID returns an id for a server_tool_use block.
+func NewServerToolUseID(i int) string {
+	return fmt.Sprintf("srvtoolu_%d_%d", time.Now().UnixNano(), i)
+}
+
+// BuildServerToolUseBlock renders the web_search call behind one upstream
+// search.
+func BuildServerToolUseBlock(id, query string) map[string]any {
+	return map[string]any{
+		"type":  "server_tool_use",
+		"id":    id,
+		"name":  "web_search",
+		"input": map[string]any{"query": query},
+	}
+}
+
+// BuildWebSearchToolResultBlock renders the pages one search found.
+func BuildWebSearchToolResultBlock(toolUseID string, results []util.SearchResult) map[string]any {
+	content := make([]any, 0, len(results))
+	for _, r := range results {
+		item := map[string]any{
+			"type":              "web_search_result",
+			"url":               r.URL,
+			"title":             r.Title,
+			"encrypted_content": base64.StdEncoding.EncodeToString([]byte(r.URL)),
+			"page_age":          nil,
+		}
+		if r.PublishedAt > 0 {
+			item["page_age"] = time.Unix(r.PublishedAt, 0).UTC().Format("January 2, 2006")
+		}
+		content = append(content, item)
+	}
+	return map[string]any{
+		"type":        "web_search_tool_result",
+		"tool_use_id": toolUseID,
+		"content":     content,
+	}
+}
+
+// BuildWebSearchCitation renders citation n of a text block; cited_text is
+// the page's snippet.
+func BuildWebSearchCitation(n int, r util.SearchResult) map[string]any {
+	cited := []rune(strings.TrimSpace(r.Snippet))
+	if len(cited) > citedTextLimit {
+		cited = cited[:citedTextLimit]
+	}
+	return map[string]any{
+		"type":            "web_search_result_location",
+		"url":             r.URL,
+		"title":           r.Title,
+		"encrypted_index": base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", n, r.URL))),
+		"cited_text":      string(cited),
+	}
+}
+
+// BuildWebSearchBlocks renders a server_tool_use and web_search_tool_result
+// pair per search.
+func BuildWebSearchBlocks(searches []util.WebSearch) []map[string]any {
+	blocks := make([]map[string]any, 0, 2*len(searches))
+	for i, ws := range searches {
+		id := NewServerToolUseID(i)
+		blocks = append(blocks, BuildServerToolUseBlock(id, ws.Query), BuildWebSearchToolResultBlock(id, ws.Results))
+	}
+	return blocks
+}
+
+// BuildCitedTextBlocks splits text at its [citation:N] markers into text
+// blocks; the citations a marker resolves to go on the text before it.
+// Markers no search result answers are dropped.
+func BuildCitedTextBlocks(text string, sources *util.SearchSources) []map[string]any {
+	blocks := make([]map[string]any, 0, 2)
+	var cur strings.Builder
+	var citations []any
+	flush := func() {
+		if cur.Len() == 0 && len(citations) == 0 {
+			return
+		}
+		block := map[string]any{"type": "text", "text": cur.String()}
+		if len(citations) > 0 {
+			block["citations"] = citations
+		}
+		blocks = append(blocks, block)
+		cur.Reset()
+		citations = nil
+	}
+	for _, seg := range util.SplitCitations(text) {
+		if seg.Cite > 0 {
+			if r, ok := sources.Lookup(seg.Cite); ok {
+				citations = append(citations, BuildWebSearchCitation(seg.Cite, r))
+			}
+			continue
+		}
+		if len(citations) > 0 {
+			flush()
+		}
+		cur.WriteString(seg.Text)
+	}
+	flush()
+	return blocks
+}
+
+// BuildServerToolUsage renders the server_tool_use usage of a message that
+// ran searches.
+func BuildServerToolUsage(searches int) map[string]any {
+	return map[string]any{"web_search_requests": searches}
+}
@@ -0,0 +1,37 @@
+package openai
+
+import "ds2api/internal/util"
+
+// BuildChatSearchResults renders results for the search_results extension.
+// offset is the position of the first result among all of the completion's
+// results; the index of a result upstream assigned no cite index follows
+// from it, matching how [citation:N] markers resolve.
+func BuildChatSearchResults(results []util.SearchResult, offset int) []any {
+	out := make([]any, 0, len(results))
+	for i, r := range results {
+		index := r.CiteIndex
+		if index == 0 {
+			index = offset + i + 1
+		}
+		item := map[string]any{
+			"index":   index,
+			"url":     r.URL,
+			"title":   r.Title,
+			"snippet": r.Snippet,
+		}
+		if r.SiteName != "" {
+			item["site_name"] = r.SiteName
+		}
+		if r.PublishedAt > 0 {
+			item["published_at"] = r.PublishedAt
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// BuildChatSources renders the sources extension of a non-stream completion:
+// every result its searches returned.
+func BuildChatSources(sources *util.SearchSources) []any {
+	return BuildChatSearchResults(sources.Results(), 0)
+}
@@ -4,14 +4,84 @@ import (
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
-
 	"ds2api/internal/util"
 )
 
 func BuildChatCompletion(completionID, model, finalPrompt, finalThinking, finalText string, toolNames []string) map[string]any {
-	detected := util.ParseToolCalls(finalText, toolNames)
-	finishReason := "stop"
+	return BuildChatCompletionWithToolCalls(completionID, model, finalPrompt, finalThinking, finalText, util.ParseToolCalls(finalText, toolNames), "")
+}
+
+// BuildChatCompletionWithToolCalls renders a completion whose tool calls were
+// already resolved by the caller. An empty finishReason means "stop"; tool
+// calls always finish with "tool_calls".
+func BuildChatCompletionWithToolCalls(completionID, model, finalPrompt, finalThinking, finalText string, detected []util.ParsedToolCall, finishReason string) map[string]any {
+	choice := buildChatChoice(0, finalThinking, finalText, detected, finishReason)
+	return map[string]any{
+		"id":      completionID,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]any{choice},
+		"usage":   BuildChatUsage(finalPrompt, finalThinking, finalText),
+	}
+}
+
+// ChatChoiceOutput is the collected result of one choice of an n>1 chat
+// completion. A non-empty Error marks a choice whose upstream call failed.
+// ToolCalls are the calls already resolved from Text; FinishReason overrides
+// the default "stop" when the output was cut short. Usage is the accounting
+// upstream reported for the delivered output. Sources, when set, are the
+// search results rendered by the sources extension.
+type ChatChoiceOutput struct {
+	Thinking     string
+	Text         string
+	ToolCalls    []util.ParsedToolCall
+	FinishReason string
+	Error        string
+	Usage        util.UpstreamUsage
+	Sources      *util.SearchSources
+}
+
+// BuildChatCompletionChoices renders a multi-choice completion. Choice i comes
+// from outputs[i]; failed choices keep their index with finish_reason "error".
+// Search sources go on the completion when there is one choice and on each
+// choice otherwise.
+func BuildChatCompletionChoices(completionID, model, finalPrompt string, outputs []ChatChoiceOutput) map[string]any {
+	choices := make([]map[string]any, 0, len(outputs))
+	for i, out := range outputs {
+		if out.Error != "" {
+			choices = append(choices, map[string]any{
+				"index":         i,
+				"message":       map[string]any{"role": "assistant", "content": nil},
+				"finish_reason": "error",
+				"error":         map[string]any{"message": out.Error},
+			})
+			continue
+		}
+		choice := buildChatChoice(i, out.Thinking, out.Text, out.ToolCalls, out.FinishReason)
+		if out.Sources != nil && len(outputs) > 1 {
+			choice["sources"] = BuildChatSources(out.Sources)
+		}
+		choices = append(choices, choice)
+	}
+	obj := map[string]any{
+		"id":      completionID,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": choices,
+		"usage":   BuildChatChoicesUsage(finalPrompt, outputs),
+	}
+	if len(outputs) == 1 && outputs[0].Sources != nil {
+		obj["sources"] = BuildChatSources(outputs[0].Sources)
+	}
+	return obj
+}
+
+func buildChatChoice(index int, finalThinking, finalText string, detected []util.ParsedToolCall, finishReason string) map[string]any {
+	if finishReason == "" {
+		finishReason = "stop"
+	}
 	messageObj := map[string]any{"role": "assistant", "content": finalText}
 	if strings.TrimSpace(finalThinking) != "" {
 		messageObj["reasoning_content"] = finalThinking
@@ -21,68 +91,35 @@ func BuildChatCompletion(completionID, model, finalPrompt, finalThinking, finalT
 		messageObj["tool_calls"] = util.FormatOpenAIToolCalls(detected)
 		messageObj["content"] = nil
 	}
-	promptTokens := util.EstimateTokens(finalPrompt)
-	reasoningTokens := util.EstimateTokens(finalThinking)
-	completionTokens := util.EstimateTokens(finalText)
-
-	return map[string]any{
-		"id":      completionID,
-		"object":  "chat.completion",
-		"created": time.Now().Unix(),
-		"model":   model,
-		"choices": []map[string]any{{"index": 0, "message": messageObj, "finish_reason": finishReason}},
-		"usage": map[string]any{
-			"prompt_tokens":     promptTokens,
-			"completion_tokens": reasoningTokens + completionTokens,
-			"total_tokens":      promptTokens + reasoningTokens + completionTokens,
-			"completion_tokens_details": map[string]any{
-				"reasoning_tokens": reasoningTokens,
-			},
-		},
-	}
+	return map[string]any{"index": index, "message": messageObj, "finish_reason": finishReason}
 }
 
 func BuildResponseObject(responseID, model, finalPrompt, finalThinking, finalText string, toolNames []string) map[string]any {
-	detected := util.ParseToolCalls(finalText, toolNames)
-	exposedOutputText := finalText
-	output := make([]any, 0, 2)
+	return BuildResponseObjectWithToolCalls(responseID, model, finalPrompt, finalThinking, finalText, util.ParseToolCalls(finalText, toolNames))
+}
+
+// BuildResponseObjectWithToolCalls renders a response whose tool calls were
+// already resolved by the caller: a reasoning item when there was thinking,
+// then either function_call items or one assistant message.
+func BuildResponseObjectWithToolCalls(responseID, model, finalPrompt, finalThinking, finalText string, detected []util.ParsedToolCall) map[string]any {
+	output := make([]any, 0, len(detected)+2)
+	if finalThinking != "" {
+		output = append(output, BuildResponseReasoningItem(NewResponseItemID("rs"), finalThinking))
+	}
 	if len(detected) > 0 {
-		exposedOutputText = ""
-		toolCalls := make([]any, 0, len(detected))
 		for _, tc := range detected {
-			toolCalls = append(toolCalls, map[string]any{
-				"type":      "tool_call",
-				"name":      tc.Name,
-				"arguments": tc.Input,
-			})
+			output = append(output, BuildResponseFunctionCallItem(NewResponseItemID("fc"), NewResponseItemID("call"), tc.Name, ToolCallArguments(tc.Input), "completed"))
 		}
-		output = append(output, map[string]any{
-			"type":       "tool_calls",
-			"tool_calls": toolCalls,
-		})
 	} else {
-		content := []any{
-			map[string]any{
-				"type": "output_text",
-				"text": finalText,
-			},
-		}
-		if finalThinking != "" {
-			content = append([]any{map[string]any{
-				"type": "reasoning",
-				"text": finalThinking,
-			}}, content...)
-		}
-		output = append(output, map[string]any{
-			"type":    "message",
-			"id":      "msg_" + strings.ReplaceAll(uuid.NewString(), "-", ""),
-			"role":    "assistant",
-			"content": content,
-		})
-	}
-	promptTokens := util.EstimateTokens(finalPrompt)
-	reasoningTokens := util.EstimateTokens(finalThinking)
-	completionTokens := util.EstimateTokens(finalText)
+		output = append(output, BuildResponseMessageItem(NewResponseItemID("msg"), finalText, "completed", nil))
+	}
+	return BuildResponseObjectWithOutput(responseID, model, finalPrompt, finalThinking, finalText, output)
+}
+
+// BuildResponseObjectWithOutput renders a completed response around output
+// items assembled by the caller. output_text joins the text of its
+// messages.
+func BuildResponseObjectWithOutput(responseID, model, finalPrompt, finalThinking, finalText string, output []any) map[string]any {
 	return map[string]any{
 		"id":          responseID,
 		"type":        "response",
@@ -91,12 +128,39 @@ func BuildResponseObject(responseID, model, finalPrompt, finalThinking, finalTex
 		"status":      "completed",
 		"model":       model,
 		"output":      output,
-		"output_text": exposedOutputText,
-		"usage": map[string]any{
-			"input_tokens":  promptTokens,
-			"output_tokens": reasoningTokens + completionTokens,
-			"total_tokens":  promptTokens + reasoningTokens + completionTokens,
-		},
+		"output_text": responseOutputText(output),
+		"usage":       BuildResponsesUsage(finalPrompt, finalThinking, finalText, util.UpstreamUsage{}),
+	}
+}
+
+// BuildResponsePendingObject renders a response that has not finished yet,
+// with status "queued" or "in_progress".
+func BuildResponsePendingObject(responseID, model, status string) map[string]any {
+	return map[string]any{
+		"id":         responseID,
+		"type":       "response",
+		"object":     "response",
+		"created_at": time.Now().Unix(),
+		"status":     status,
+		"model":      model,
+		"output":     []any{},
+	}
+}
+
+// BuildResponsesUsage renders Responses usage, preferring the output token
+// count upstream reported over a local count.
+func BuildResponsesUsage(finalPrompt, finalThinking, finalText string, upstream util.UpstreamUsage) map[string]any {
+	promptTokens := upstream.Input(finalPrompt)
+	reasoningTokens, outputTokens := upstream.Output(finalThinking, finalText)
+	details := map[string]any{"reasoning_tokens": reasoningTokens}
+	if secs, ok := upstream.ReasoningSeconds(); ok {
+		details["reasoning_seconds"] = secs
+	}
+	return map[string]any{
+		"input_tokens":          promptTokens,
+		"output_tokens":         outputTokens,
+		"total_tokens":          promptTokens + outputTokens,
+		"output_tokens_details": details,
 	}
 }
 
@@ -130,64 +194,133 @@ func BuildChatStreamChunk(completionID string, created int64, model string, choi
 }
 
 func BuildChatUsage(finalPrompt, finalThinking, finalText string) map[string]any {
-	promptTokens := util.EstimateTokens(finalPrompt)
-	reasoningTokens := util.EstimateTokens(finalThinking)
-	completionTokens := util.EstimateTokens(finalText)
+	return BuildChatUsageWithUpstream(finalPrompt, finalThinking, finalText, util.UpstreamUsage{})
+}
+
+// BuildChatUsageWithUpstream prefers the completion token count upstream
+// reported over a local count, and exposes the reasoning time when known.
+func BuildChatUsageWithUpstream(finalPrompt, finalThinking, finalText string, upstream util.UpstreamUsage) map[string]any {
+	promptTokens := upstream.Input(finalPrompt)
+	reasoningTokens, completionTokens := upstream.Output(finalThinking, finalText)
+	details := map[string]any{"reasoning_tokens": reasoningTokens}
+	if secs, ok := upstream.ReasoningSeconds(); ok {
+		details["reasoning_seconds"] = secs
+	}
+	return map[string]any{
+		"prompt_tokens":             promptTokens,
+		"completion_tokens":         completionTokens,
+		"total_tokens":              promptTokens + completionTokens,
+		"completion_tokens_details": details,
+	}
+}
+
+// BuildChatChoicesUsage counts the shared prompt once, plus any retries, and
+// sums completion tokens over every choice that produced output, upstream
+// counts first.
+func BuildChatChoicesUsage(finalPrompt string, outputs []ChatChoiceOutput) map[string]any {
+	promptTokens := util.CountTokens(finalPrompt)
+	reasoningTokens := 0
+	completionTokens := 0
+	for _, out := range outputs {
+		if out.Error != "" {
+			continue
+		}
+		reasoning, completion := out.Usage.Output(out.Thinking, out.Text)
+		promptTokens += out.Usage.RetryInputTokens
+		reasoningTokens += reasoning
+		completionTokens += completion
+	}
 	return map[string]any{
 		"prompt_tokens":     promptTokens,
-		"completion_tokens": reasoningTokens + completionTokens,
-		"total_tokens":      promptTokens + reasoningTokens + completionTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      promptTokens + completionTokens,
 		"completion_tokens_details": map[string]any{
 			"reasoning_tokens": reasoningTokens,
 		},
 	}
 }
 
-func BuildResponsesCreatedPayload(responseID, model string) map[string]any {
-	return map[string]any{
-		"type":   "response.created",
-		"id":     responseID,
-		"object": "response",
-		"model":  model,
-		"status": "in_progress",
-	}
+func BuildResponsesCompletedPayload(response map[string]any) map[string]any {
+	return BuildResponsesLifecyclePayload("response.completed", response)
 }
 
-func BuildResponsesTextDeltaPayload(responseID, delta string) map[string]any {
-	return map[string]any{
-		"type":  "response.output_text.delta",
-		"id":    responseID,
-		"delta": delta,
-	}
+// TextChoiceOutput is the collected result of one legacy completion choice.
+// Echo is the prompt repeated ahead of Text when echo was requested; it is
+// not counted as completion tokens.
+type TextChoiceOutput struct {
+	Echo         string
+	Text         string
+	FinishReason string
+	Error        string
+	Usage        util.UpstreamUsage
 }
 
-func BuildResponsesReasoningDeltaPayload(responseID, delta string) map[string]any {
-	return map[string]any{
-		"type":  "response.reasoning.delta",
-		"id":    responseID,
-		"delta": delta,
+// BuildTextCompletion renders a legacy text_completion object. Choice i
+// comes from outputs[i]; failed choices keep their index with finish_reason
+// "error".
+func BuildTextCompletion(completionID, model string, finalPrompts []string, outputs []TextChoiceOutput) map[string]any {
+	choices := make([]map[string]any, 0, len(outputs))
+	for i, out := range outputs {
+		if out.Error != "" {
+			choice := BuildTextCompletionChoice(i, "", "error")
+			choice["error"] = map[string]any{"message": out.Error}
+			choices = append(choices, choice)
+			continue
+		}
+		finishReason := out.FinishReason
+		if finishReason == "" {
+			finishReason = "stop"
+		}
+		choices = append(choices, BuildTextCompletionChoice(i, out.Echo+out.Text, finishReason))
 	}
+	return BuildTextCompletionChunk(completionID, time.Now().Unix(), model, choices, BuildTextCompletionUsage(finalPrompts, outputs))
 }
 
-func BuildResponsesToolCallDeltaPayload(responseID string, toolCalls []map[string]any) map[string]any {
+// BuildTextCompletionChoice renders one choice; finishReason is nil while a
+// stream is still running.
+func BuildTextCompletionChoice(index int, text string, finishReason any) map[string]any {
 	return map[string]any{
-		"type":       "response.output_tool_call.delta",
-		"id":         responseID,
-		"tool_calls": toolCalls,
+		"text":          text,
+		"index":         index,
+		"logprobs":      nil,
+		"finish_reason": finishReason,
 	}
 }
 
-func BuildResponsesToolCallDonePayload(responseID string, toolCalls []map[string]any) map[string]any {
-	return map[string]any{
-		"type":       "response.output_tool_call.done",
-		"id":         responseID,
-		"tool_calls": toolCalls,
+// BuildTextCompletionChunk renders a text_completion object; streamed chunks
+// share the shape of the final object.
+func BuildTextCompletionChunk(completionID string, created int64, model string, choices []map[string]any, usage map[string]any) map[string]any {
+	out := map[string]any{
+		"id":      completionID,
+		"object":  "text_completion",
+		"created": created,
+		"model":   model,
+		"choices": choices,
+	}
+	if len(usage) > 0 {
+		out["usage"] = usage
 	}
+	return out
 }
 
-func BuildResponsesCompletedPayload(response map[string]any) map[string]any {
+// BuildTextCompletionUsage counts every prompt once and sums completion
+// tokens over the choices that produced output, upstream counts first.
+func BuildTextCompletionUsage(finalPrompts []string, outputs []TextChoiceOutput) map[string]any {
+	promptTokens := 0
+	for _, p := range finalPrompts {
+		promptTokens += util.CountTokens(p)
+	}
+	completionTokens := 0
+	for _, out := range outputs {
+		if out.Error != "" {
+			continue
+		}
+		_, tokens := out.Usage.Output("", out.Text)
+		completionTokens += tokens
+	}
 	return map[string]any{
-		"type":     "response.completed",
-		"response": response,
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      promptTokens + completionTokens,
 	}
 }
@@ -0,0 +1,244 @@
+package openai
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"ds2api/internal/util"
+)
+
+// NewResponseItemID returns a fresh id for a Responses output item, e.g.
+// "msg_…", "rs_…", "fc_…", or a "call_…" id for a function call.
+func NewResponseItemID(prefix string) string {
+	return prefix + "_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+// ToolCallArguments renders parsed tool input as the JSON string Responses
+// function_call items carry.
+func ToolCallArguments(input map[string]any) string {
+	if input == nil {
+		return "{}"
+	}
+	b, err := json.Marshal(input)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// BuildResponseReasoningItem renders a reasoning item whose summary is the
+// model's thinking; an empty summary renders as an empty list.
+func BuildResponseReasoningItem(id, summary string) map[string]any {
+	parts := []any{}
+	if summary != "" {
+		parts = append(parts, BuildResponseSummaryTextPart(summary))
+	}
+	return map[string]any{
+		"id":      id,
+		"type":    "reasoning",
+		"summary": parts,
+	}
+}
+
+func BuildResponseSummaryTextPart(text string) map[string]any {
+	return map[string]any{"type": "summary_text", "text": text}
+}
+
+// BuildResponseMessageItem renders an assistant message item. An in_progress
+// message with no text yet has no content parts.
+func BuildResponseMessageItem(id, text, status string, annotations []any) map[string]any {
+	content := []any{}
+	if text != "" || status != "in_progress" {
+		content = append(content, BuildResponseOutputTextPart(text, annotations))
+	}
+	return map[string]any{
+		"id":      id,
+		"type":    "message",
+		"status":  status,
+		"role":    "assistant",
+		"content": content,
+	}
+}
+
+func BuildResponseOutputTextPart(text string, annotations []any) map[string]any {
+	if annotations == nil {
+		annotations = []any{}
+	}
+	return map[string]any{"type": "output_text", "text": text, "annotations": annotations}
+}
+
+// BuildResponseURLCitation renders a url_citation annotation over the
+// characters [start, end) of an output_text part.
+func BuildResponseURLCitation(start, end int, url, title string) map[string]any {
+	return map[string]any{
+		"type":        "url_citation",
+		"start_index": start,
+		"end_index":   end,
+		"url":         url,
+		"title":       title,
+	}
+}
+
+// BuildResponseWebSearchCallItem renders a web_search_call item for one
+// upstream search; a completed call lists the pages it found as sources.
+func BuildResponseWebSearchCallItem(id, status, query string, results []util.SearchResult) map[string]any {
+	action := map[string]any{"type": "search", "query": query}
+	if status == "completed" {
+		sources := make([]any, 0, len(results))
+		for _, r := range results {
+			sources = append(sources, map[string]any{"type": "url", "url": r.URL})
+		}
+		action["sources"] = sources
+	}
+	return map[string]any{
+		"id":     id,
+		"type":   "web_search_call",
+		"status": status,
+		"action": action,
+	}
+}
+
+func BuildResponseFunctionCallItem(id, callID, name, arguments, status string) map[string]any {
+	return map[string]any{
+		"id":        id,
+		"type":      "function_call",
+		"status":    status,
+		"call_id":   callID,
+		"name":      name,
+		"arguments": arguments,
+	}
+}
+
+// responseOutputText joins the output_text parts of the message items.
+func responseOutputText(output []any) string {
+	var b strings.Builder
+	for _, raw := range output {
+		item, _ := raw.(map[string]any)
+		if item["type"] != "message" {
+			continue
+		}
+		parts, _ := item["content"].([]any)
+		for _, p := range parts {
+			if part, ok := p.(map[string]any); ok && part["type"] == "output_text" {
+				text, _ := part["text"].(string)
+				b.WriteString(text)
+			}
+		}
+	}
+	return b.String()
+}
+
+// BuildResponsesLifecyclePayload renders the events carrying the whole
+// response: response.created, response.in_progress, response.completed,
+// response.failed and response.cancelled.
+func BuildResponsesLifecyclePayload(eventType string, response map[string]any) map[string]any {
+	return map[string]any{"type": eventType, "response": response}
+}
+
+// BuildResponsesOutputItemPayload renders response.output_item.added and
+// response.output_item.done.
+func BuildResponsesOutputItemPayload(eventType string, outputIndex int, item map[string]any) map[string]any {
+	return map[string]any{"type": eventType, "output_index": outputIndex, "item": item}
+}
+
+// BuildResponsesContentPartPayload renders response.content_part.added and
+// response.content_part.done.
+func BuildResponsesContentPartPayload(eventType, itemID string, outputIndex, contentIndex int, part map[string]any) map[string]any {
+	return map[string]any{
+		"type":          eventType,
+		"item_id":       itemID,
+		"output_index":  outputIndex,
+		"content_index": contentIndex,
+		"part":          part,
+	}
+}
+
+func BuildResponsesTextDeltaPayload(itemID string, outputIndex, contentIndex int, delta string) map[string]any {
+	return map[string]any{
+		"type":          "response.output_text.delta",
+		"item_id":       itemID,
+		"output_index":  outputIndex,
+		"content_index": contentIndex,
+		"delta":         delta,
+	}
+}
+
+func BuildResponsesAnnotationAddedPayload(itemID string, outputIndex, contentIndex, annotationIndex int, annotation map[string]any) map[string]any {
+	return map[string]any{
+		"type":             "response.output_text.annotation.added",
+		"item_id":          itemID,
+		"output_index":     outputIndex,
+		"content_index":    contentIndex,
+		"annotation_index": annotationIndex,
+		"annotation":       annotation,
+	}
+}
+
+// BuildResponsesWebSearchCallPayload renders the progress events of a
+// web_search_call item: response.web_search_call.in_progress, .searching
+// and .completed.
+func BuildResponsesWebSearchCallPayload(eventType, itemID string, outputIndex int) map[string]any {
+	return map[string]any{"type": eventType, "item_id": itemID, "output_index": outputIndex}
+}
+
+func BuildResponsesTextDonePayload(itemID string, outputIndex, contentIndex int, text string) map[string]any {
+	return map[string]any{
+		"type":          "response.output_text.done",
+		"item_id":       itemID,
+		"output_index":  outputIndex,
+		"content_index": contentIndex,
+		"text":          text,
+	}
+}
+
+// BuildResponsesSummaryPartPayload renders
+// response.reasoning_summary_part.added and .done.
+func BuildResponsesSummaryPartPayload(eventType, itemID string, outputIndex, summaryIndex int, part map[string]any) map[string]any {
+	return map[string]any{
+		"type":          eventType,
+		"item_id":       itemID,
+		"output_index":  outputIndex,
+		"summary_index": summaryIndex,
+		"part":          part,
+	}
+}
+
+func BuildResponsesSummaryTextDeltaPayload(itemID string, outputIndex, summaryIndex int, delta string) map[string]any {
+	return map[string]any{
+		"type":          "response.reasoning_summary_text.delta",
+		"item_id":       itemID,
+		"output_index":  outputIndex,
+		"summary_index": summaryIndex,
+		"delta":         delta,
+	}
+}
+
+func BuildResponsesSummaryTextDonePayload(itemID string, outputIndex, summaryIndex int, text string) map[string]any {
+	return map[string]any{
+		"type":          "response.reasoning_summary_text.done",
+		"item_id":       itemID,
+		"output_index":  outputIndex,
+		"summary_index": summaryIndex,
+		"text":          text,
+	}
+}
+
+func BuildResponsesArgumentsDeltaPayload(itemID string, outputIndex int, delta string) map[string]any {
+	return map[string]any{
+		"type":         "response.function_call_arguments.delta",
+		"item_id":      itemID,
+		"output_index": outputIndex,
+		"delta":        delta,
+	}
+}
+
+func BuildResponsesArgumentsDonePayload(itemID string, outputIndex int, arguments string) map[string]any {
+	return map[string]any{
+		"type":         "response.function_call_arguments.done",
+		"item_id":      itemID,
+		"output_index": outputIndex,
+		"arguments":    arguments,
+	}
+}
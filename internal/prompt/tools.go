@@ -0,0 +1,18 @@
+package prompt
+
+import "fmt"
+
+// FormatToolCall renders one earlier tool call for the prompt. Every adapter
+// replays tool history in this shape so ids pair calls with results.
+func FormatToolCall(id, name, arguments string) string {
+	return fmt.Sprintf("Tool call:\n- tool_call_id: %s\n- function.name: %s\n- function.arguments: %s", id, name, arguments)
+}
+
+// FormatToolResult renders the result of tool call id for the prompt; a
+// failed call is marked with is_error.
+func FormatToolResult(id, name, content string, isError bool) string {
+	if isError {
+		return fmt.Sprintf("Tool result:\n- tool_call_id: %s\n- name: %s\n- is_error: true\n- content: %s", id, name, content)
+	}
+	return fmt.Sprintf("Tool result:\n- tool_call_id: %s\n- name: %s\n- content: %s", id, name, content)
+}
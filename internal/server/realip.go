@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"ds2api/internal/config"
+)
+
+// realIP replaces chi's middleware.RealIP: it rewrites RemoteAddr from proxy
+// headers, but only from the headers listed in network.trusted_proxy_headers
+// and only for peers inside network.trusted_proxy_cidrs. X-Forwarded-Proto
+// is dropped from other peers, so handlers may trust what is left of it.
+//
+// With no trusted proxies configured, the first request that carries
+// X-Forwarded-For or X-Real-IP logs a warning: such a deployment is likely
+// behind a proxy whose clients all appear under the proxy's address.
+func realIP(store *config.Store) func(http.Handler) http.Handler {
+	var warnOnce sync.Once
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			trusted := store.TrustedProxies()
+			if len(trusted) == 0 {
+				if name := forwardingHeader(r); name != "" {
+					warnOnce.Do(func() {
+						config.Logger.Warn("[network] ignoring proxy headers: network.trusted_proxy_cidrs is empty; set it when running behind a reverse proxy or CDN", "header", name, "peer", r.RemoteAddr)
+					})
+				}
+			}
+			if !prefixesContain(trusted, parseAddr(r.RemoteAddr)) {
+				r.Header.Del("X-Forwarded-Proto")
+			}
+			if ip := clientIPFromHeaders(r, store.TrustedProxyHeaders(), trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardingHeader returns the first of X-Forwarded-For and X-Real-IP that
+// r carries.
+func forwardingHeader(r *http.Request) string {
+	for _, name := range []string{"X-Forwarded-For", "X-Real-IP"} {
+		if strings.TrimSpace(r.Header.Get(name)) != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func clientIPFromHeaders(r *http.Request, headers []string, trusted []netip.Prefix) string {
+	if len(headers) == 0 || !prefixesContain(trusted, parseAddr(r.RemoteAddr)) {
+		return ""
+	}
+	for _, name := range headers {
+		raw := strings.TrimSpace(r.Header.Get(name))
+		if raw == "" {
+			continue
+		}
+		if !strings.EqualFold(name, "X-Forwarded-For") {
+			if addr := parseAddr(raw); addr.IsValid() {
+				return addr.String()
+			}
+			continue
+		}
+		if ip := forwardedForClient(raw, trusted); ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// forwardedForClient picks the client from an X-Forwarded-For chain: it
+// walks from the right and skips hops that are themselves trusted proxies,
+// so a client cannot spoof its address by prepending entries.
+func forwardedForClient(raw string, trusted []netip.Prefix) string {
+	parts := strings.Split(raw, ",")
+	for i := len(parts) - 1; i >= 0; i-- {
+		addr := parseAddr(parts[i])
+		if !addr.IsValid() {
+			return ""
+		}
+		if i == 0 || !prefixesContain(trusted, addr) {
+			return addr.String()
+		}
+	}
+	return ""
+}
+
+func parseAddr(raw string) netip.Addr {
+	host := strings.TrimSpace(raw)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
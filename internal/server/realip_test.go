@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"ds2api/internal/config"
+)
+
+func TestClientIPFromHeaders(t *testing.T) {
+	all := []string{"True-Client-IP", "X-Real-IP", "X-Forwarded-For"}
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		trusted []string
+		proxies []netip.Prefix
+		want    string
+	}{
+		{"no trusted proxies ignores headers", "10.0.0.1:1", map[string]string{"X-Forwarded-For": "203.0.113.5", "X-Real-IP": "203.0.113.5"}, all, nil, ""},
+		{"header disabled", "10.0.0.1:1", map[string]string{"X-Real-IP": "203.0.113.5"}, []string{"X-Forwarded-For"}, proxies, ""},
+		{"untrusted peer ignored", "198.51.100.1:1", map[string]string{"X-Real-IP": "203.0.113.5"}, all, proxies, ""},
+		{"trusted peer honoured", "10.0.0.1:1", map[string]string{"X-Real-IP": "203.0.113.5"}, all, proxies, "203.0.113.5"},
+		{"spoofed prefix skipped", "10.0.0.1:1", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.5, 10.0.0.9"}, all, proxies, "203.0.113.5"},
+		{"right-most untrusted hop", "10.0.0.1:1", map[string]string{"X-Forwarded-For": "203.0.113.5, 198.51.100.7"}, all, proxies, "198.51.100.7"},
+		{"no headers configured", "10.0.0.1:1", map[string]string{"X-Real-IP": "203.0.113.5"}, nil, proxies, ""},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		if got := clientIPFromHeaders(req, tc.trusted, tc.proxies); got != tc.want {
+			t.Fatalf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRealIPKeepsForwardedProtoFromTrustedProxiesOnly(t *testing.T) {
+	t.Setenv("DS2API_CONFIG_JSON", `{"network":{"trusted_proxy_cidrs":["10.0.0.0/8"]}}`)
+	var seen *http.Request
+	h := realIP(config.LoadStore())(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { seen = r }))
+	for remote, want := range map[string]string{"10.0.0.1:1": "https", "198.51.100.1:1": ""} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got := seen.Header.Get("X-Forwarded-Proto"); got != want {
+			t.Fatalf("peer %s: got X-Forwarded-Proto %q want %q", remote, got, want)
+		}
+	}
+}
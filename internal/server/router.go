@@ -17,6 +17,7 @@ import (
 	"ds2api/internal/auth"
 	"ds2api/internal/config"
 	"ds2api/internal/deepseek"
+	"ds2api/internal/tokenizer"
 	"ds2api/internal/webui"
 )
 
@@ -41,15 +42,40 @@ func NewApp() *App {
 	} else {
 		config.Logger.Info("[WASM] module preloaded", "path", config.WASMPath())
 	}
+	if err := tokenizer.Init(config.TokenizerPath()); err != nil {
+		config.Logger.Warn("[tokenizer] vocabulary unavailable; token counts are estimated", "error", err)
+	} else {
+		config.Logger.Info("[tokenizer] loaded", "tokenizer", tokenizer.Name)
+	}
 
 	openaiHandler := &openai.Handler{Store: store, Auth: resolver, DS: dsClient}
 	claudeHandler := &claude.Handler{Store: store, Auth: resolver, DS: dsClient}
-	adminHandler := &admin.Handler{Store: store, Pool: pool, LeaseStats: openaiHandler, DS: dsClient}
+	auditPath, batchesDir := "", ""
+	filesDir, openaiBatchesDir := "", ""
+	sessions := admin.NewStatelessSessionStore()
+	if !config.IsVercel() {
+		auditPath = config.AuditLogPath()
+		sessions = admin.NewSessionStore(config.AdminSessionsPath())
+		batchesDir = config.ClaudeBatchesDir()
+		filesDir = config.OpenAIFilesDir()
+		openaiBatchesDir = config.OpenAIBatchesDir()
+	}
+	claudeHandler.StartBatches(context.Background(), batchesDir)
+	openaiHandler.StartBatches(context.Background(), filesDir, openaiBatchesDir)
+	adminHandler := &admin.Handler{
+		Store:       store,
+		Pool:        pool,
+		LeaseStats:  openaiHandler,
+		Passthrough: resolver,
+		DS:          dsClient,
+		Audit:       admin.NewAuditLog(auditPath),
+		Sessions:    sessions,
+	}
 	webuiHandler := webui.NewHandler()
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP(store))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(cors)
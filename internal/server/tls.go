@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"ds2api/internal/config"
+)
+
+// TLSConfigFromEnv builds the listener TLS config. It returns nil when
+// DS2API_TLS_CERT_FILE/DS2API_TLS_KEY_FILE are unset. Setting
+// DS2API_TLS_CLIENT_CA_FILE enables mTLS: certificates signed by that CA are
+// verified when offered and can stand in for an API key via
+// key_policies[].client_cert_subjects / client_cert_fingerprints. Clients
+// without a certificate can still use bearer keys.
+func TLSConfigFromEnv() (*tls.Config, error) {
+	certFile := strings.TrimSpace(os.Getenv("DS2API_TLS_CERT_FILE"))
+	keyFile := strings.TrimSpace(os.Getenv("DS2API_TLS_KEY_FILE"))
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("DS2API_TLS_CERT_FILE and DS2API_TLS_KEY_FILE must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(config.ResolvePath("DS2API_TLS_CERT_FILE", ""), config.ResolvePath("DS2API_TLS_KEY_FILE", ""))
+	if err != nil {
+		return nil, fmt.Errorf("load TLS key pair: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if strings.TrimSpace(os.Getenv("DS2API_TLS_CLIENT_CA_FILE")) != "" {
+		pem, err := os.ReadFile(config.ResolvePath("DS2API_TLS_CLIENT_CA_FILE", ""))
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA file contains no certificates")
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"ds2api/internal/deepseek"
+	"ds2api/internal/util"
 )
 
 // CollectResult holds the aggregated text and thinking content from a
@@ -12,6 +13,15 @@ import (
 type CollectResult struct {
 	Text     string
 	Thinking string
+	// Usage is the accounting upstream reported; zero fields are missing.
+	Usage util.UpstreamUsage
+	// Search holds the web searches upstream ran.
+	Search util.SearchSources
+	// ContentFilter reports that upstream's content filter ended the stream.
+	ContentFilter bool
+	// ErrorMessage is the upstream error or read failure that cut the stream
+	// short, if any.
+	ErrorMessage string
 }
 
 // CollectStream fully consumes a DeepSeek SSE response and separates
@@ -21,32 +31,52 @@ type CollectResult struct {
 //
 // The caller is responsible for closing resp.Body unless closeBody is true.
 func CollectStream(resp *http.Response, thinkingEnabled bool, closeBody bool) CollectResult {
+	return CollectStreamLimited(resp, thinkingEnabled, closeBody, nil)
+}
+
+// CollectStreamLimited is CollectStream with client output limits applied:
+// once limiter reports a stop sequence or an exhausted token budget the scan
+// ends, so closing the body aborts the rest of the upstream generation.
+func CollectStreamLimited(resp *http.Response, thinkingEnabled bool, closeBody bool, limiter *util.OutputLimiter) CollectResult {
 	if closeBody {
 		defer resp.Body.Close()
 	}
 	text := strings.Builder{}
 	thinking := strings.Builder{}
+	var usage util.UpstreamUsage
+	var search util.SearchSources
+	filtered, errMessage := false, ""
 	currentType := "text"
 	if thinkingEnabled {
 		currentType = "thinking"
 	}
-	_ = deepseek.ScanSSELines(resp, func(line []byte) bool {
+	err := deepseek.ScanSSELines(resp, func(line []byte) bool {
 		result := ParseDeepSeekContentLine(line, thinkingEnabled, currentType)
 		currentType = result.NextType
 		if !result.Parsed {
 			return true
 		}
+		usage.Merge(result.Usage)
+		search.Merge(result.Search)
 		if result.Stop {
+			filtered = result.ContentFilter
+			if !filtered {
+				errMessage = result.ErrorMessage
+			}
 			return false
 		}
 		for _, p := range result.Parts {
 			if p.Type == "thinking" {
-				thinking.WriteString(p.Text)
+				thinking.WriteString(limiter.Thinking(p.Text))
 			} else {
-				text.WriteString(p.Text)
+				text.WriteString(limiter.Text(p.Text))
 			}
 		}
-		return true
+		return !limiter.Done()
 	})
-	return CollectResult{Text: text.String(), Thinking: thinking.String()}
+	if err != nil && errMessage == "" {
+		errMessage = err.Error()
+	}
+	text.WriteString(limiter.Flush())
+	return CollectResult{Text: text.String(), Thinking: thinking.String(), Usage: usage, Search: search, ContentFilter: filtered, ErrorMessage: errMessage}
 }
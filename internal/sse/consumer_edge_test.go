@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+
+	"ds2api/internal/util"
 )
 
 // ─── CollectStream edge cases ────────────────────────────────────────
@@ -138,3 +140,34 @@ func TestCollectStreamStatusFinished(t *testing.T) {
 		t.Fatalf("expected 'Hello', got %q", result.Text)
 	}
 }
+
+func TestCollectStreamLimitedStopsAtStopSequence(t *testing.T) {
+	resp := makeHTTPResponse(
+		"data: {\"p\":\"response/content\",\"v\":\"one\\ntwo\"}\n" +
+			"data: {\"p\":\"response/content\",\"v\":\"\\nthree\"}\n" +
+			"data: [DONE]\n",
+	)
+	limiter := util.NewOutputLimiter(util.OutputLimits{Stop: []string{"\nthree"}})
+	result := CollectStreamLimited(resp, false, false, limiter)
+	if result.Text != "one\ntwo" || limiter.Reason() != util.OutputLimitStopSequence {
+		t.Fatalf("unexpected text=%q reason=%q", result.Text, limiter.Reason())
+	}
+}
+
+func TestCollectStreamReportsHowItStopped(t *testing.T) {
+	filtered := CollectStream(makeHTTPResponse(
+		"data: {\"p\":\"response/content\",\"v\":\"Hi\"}\n"+
+			"data: {\"code\":\"content_filter\"}\n",
+	), false, false)
+	if !filtered.ContentFilter || filtered.ErrorMessage != "" || filtered.Text != "Hi" {
+		t.Fatalf("expected a content filter stop, got %#v", filtered)
+	}
+	failed := CollectStream(makeHTTPResponse("data: {\"error\":{\"message\":\"boom\"}}\n"), false, false)
+	if failed.ContentFilter || !strings.Contains(failed.ErrorMessage, "boom") {
+		t.Fatalf("expected an upstream error, got %#v", failed)
+	}
+	done := CollectStream(makeHTTPResponse("data: {\"p\":\"response/content\",\"v\":\"Hi\"}\ndata: [DONE]\n"), false, false)
+	if done.ContentFilter || done.ErrorMessage != "" {
+		t.Fatalf("expected a clean stop, got %#v", done)
+	}
+}
@@ -1,6 +1,10 @@
 package sse
 
-import "fmt"
+import (
+	"fmt"
+
+	"ds2api/internal/util"
+)
 
 // LineResult is the normalized parse result for one DeepSeek SSE line.
 type LineResult struct {
@@ -10,6 +14,11 @@ type LineResult struct {
 	ErrorMessage  string
 	Parts         []ContentPart
 	NextType      string
+	// Usage is upstream accounting carried on this line, if any. It may
+	// arrive on the same line that stops the stream.
+	Usage util.UpstreamUsage
+	// Search is the web search activity reported on this line, if any.
+	Search util.SearchUpdate
 }
 
 // ParseDeepSeekContentLine centralizes one-line DeepSeek SSE parsing for both
@@ -45,5 +54,7 @@ func ParseDeepSeekContentLine(raw []byte, thinkingEnabled bool, currentType stri
 		Stop:     finished,
 		Parts:    parts,
 		NextType: nextType,
+		Usage:    parseUpstreamUsage(chunk),
+		Search:   parseSearchUpdate(chunk),
 	}
 }
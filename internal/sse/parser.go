@@ -47,7 +47,7 @@ func ParseSSEChunkForContent(chunk map[string]any, thinkingEnabled bool, current
 		return nil, false, currentFragmentType
 	}
 	path, _ := chunk["p"].(string)
-	if shouldSkipPath(path) {
+	if shouldSkipPath(path) || isSearchPath(path) {
 		return nil, false, currentFragmentType
 	}
 	if path == "response/status" {
@@ -229,7 +229,7 @@ func extractContentRecursive(items []any, defaultType string) ([]ContentPart, bo
 				return nil, true
 			}
 		}
-		if shouldSkipPath(itemPath) {
+		if shouldSkipPath(itemPath) || isSearchPath(itemPath) {
 			continue
 		}
 		if content, ok := m["content"].(string); ok && content != "" {
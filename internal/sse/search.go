@@ -0,0 +1,135 @@
+package sse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"ds2api/internal/util"
+)
+
+// searchCitePathPattern matches the SET that assigns a cite index to one
+// search result, e.g. "response/search_results/3/cite_index".
+var searchCitePathPattern = regexp.MustCompile(`results/(\d+)/cite_index$`)
+
+// parseSearchUpdate reads the web search activity on a line: the queries
+// of a SEARCH fragment, result batches and cite index assignments. Results
+// arrive on "response/search_results", on a SEARCH fragment's "results",
+// inside a BATCH on "response", or in the initial response snapshot.
+func parseSearchUpdate(chunk map[string]any) util.SearchUpdate {
+	var u util.SearchUpdate
+	v, ok := chunk["v"]
+	if !ok {
+		return u
+	}
+	path, _ := chunk["p"].(string)
+	collectSearchValue(&u, path, v)
+	return u
+}
+
+func collectSearchValue(u *util.SearchUpdate, path string, v any) {
+	if m := searchCitePathPattern.FindStringSubmatch(path); m != nil {
+		pos, _ := strconv.Atoi(m[1])
+		if n, ok := v.(float64); ok && n > 0 {
+			u.Cites = append(u.Cites, util.SearchCite{Result: pos, Index: int(n)})
+		}
+		return
+	}
+	switch {
+	case strings.HasSuffix(path, "search_results") || strings.HasSuffix(path, "/results"):
+		appendSearchResults(u, v)
+		return
+	case strings.HasSuffix(path, "/queries"):
+		appendSearchQueries(u, v)
+		return
+	}
+	switch val := v.(type) {
+	case []any:
+		for _, item := range val {
+			m, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if strings.EqualFold(asString(m["type"]), "SEARCH") {
+				collectSearchFragment(u, m)
+				continue
+			}
+			itemPath, _ := m["p"].(string)
+			if itemV, ok := m["v"]; ok && itemPath != "" {
+				collectSearchValue(u, joinUsagePath(path, itemPath), itemV)
+			}
+		}
+	case map[string]any:
+		resp := val
+		if wrapped, ok := val["response"].(map[string]any); ok {
+			resp = wrapped
+		}
+		if results, ok := resp["search_results"]; ok {
+			appendSearchResults(u, results)
+		}
+		if frags, ok := resp["fragments"].([]any); ok {
+			for _, frag := range frags {
+				if m, ok := frag.(map[string]any); ok && strings.EqualFold(asString(m["type"]), "SEARCH") {
+					collectSearchFragment(u, m)
+				}
+			}
+		}
+	}
+}
+
+func collectSearchFragment(u *util.SearchUpdate, frag map[string]any) {
+	appendSearchQueries(u, frag["queries"])
+	appendSearchResults(u, frag["results"])
+}
+
+func appendSearchQueries(u *util.SearchUpdate, v any) {
+	items, _ := v.([]any)
+	for _, item := range items {
+		switch q := item.(type) {
+		case string:
+			u.Queries = append(u.Queries, q)
+		case map[string]any:
+			if s := asString(q["query"]); s != "" {
+				u.Queries = append(u.Queries, s)
+			}
+		}
+	}
+}
+
+func appendSearchResults(u *util.SearchUpdate, v any) {
+	items, _ := v.([]any)
+	batch := make([]util.SearchResult, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok || asString(m["url"]) == "" {
+			continue
+		}
+		r := util.SearchResult{
+			URL:      asString(m["url"]),
+			Title:    asString(m["title"]),
+			Snippet:  asString(m["snippet"]),
+			SiteName: asString(m["site_name"]),
+		}
+		if n, ok := m["published_at"].(float64); ok {
+			r.PublishedAt = int64(n)
+		}
+		if n, ok := m["cite_index"].(float64); ok && n > 0 {
+			r.CiteIndex = int(n)
+		}
+		batch = append(batch, r)
+	}
+	if len(batch) > 0 {
+		u.Results = append(u.Results, batch...)
+	}
+}
+
+// isSearchPath reports paths that carry search data rather than content.
+func isSearchPath(path string) bool {
+	return strings.Contains(path, "search_results") || strings.HasSuffix(path, "/results") ||
+		strings.HasSuffix(path, "/queries") || strings.HasSuffix(path, "/cite_index")
+}
+
+func asString(v any) string {
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,50 @@
+package sse
+
+import "testing"
+
+func TestParseDeepSeekContentLineSearchResults(t *testing.T) {
+	line := `data: {"p":"response/search_results","v":[{"url":"https://a.example","title":"A","snippet":"alpha","cite_index":null},{"url":"https://b.example","title":"B","cite_index":2}]}`
+	res := ParseDeepSeekContentLine([]byte(line), false, "text")
+	if len(res.Parts) != 0 {
+		t.Fatalf("expected no content parts, got %#v", res.Parts)
+	}
+	if len(res.Search.Results) != 2 || res.Search.Results[0].URL != "https://a.example" || res.Search.Results[1].CiteIndex != 2 {
+		t.Fatalf("unexpected search results: %#v", res.Search)
+	}
+}
+
+func TestParseDeepSeekContentLineSearchFragment(t *testing.T) {
+	line := `data: {"p":"response/fragments","o":"APPEND","v":[{"type":"SEARCH","content":"","queries":[{"query":"go release"}],"results":[]}]}`
+	res := ParseDeepSeekContentLine([]byte(line), false, "text")
+	if len(res.Parts) != 0 || len(res.Search.Queries) != 1 || res.Search.Queries[0] != "go release" {
+		t.Fatalf("unexpected parse: %#v", res)
+	}
+
+	res = ParseDeepSeekContentLine([]byte(`data: {"p":"response/fragments/-1/results","o":"SET","v":[{"url":"https://go.dev","title":"Go"}]}`), false, "text")
+	if len(res.Parts) != 0 || len(res.Search.Results) != 1 {
+		t.Fatalf("unexpected parse: %#v", res)
+	}
+
+	res = ParseDeepSeekContentLine([]byte(`data: {"p":"response/fragments/-1/results/0/cite_index","v":1}`), false, "text")
+	if len(res.Parts) != 0 || len(res.Search.Cites) != 1 || res.Search.Cites[0].Index != 1 {
+		t.Fatalf("unexpected parse: %#v", res)
+	}
+}
+
+func TestCollectStreamGathersSearchSources(t *testing.T) {
+	resp := makeHTTPResponse(
+		"data: {\"p\":\"response/search_status\",\"v\":\"SEARCHING\"}\n" +
+			"data: {\"p\":\"response\",\"o\":\"BATCH\",\"v\":[{\"p\":\"search_results\",\"v\":[{\"url\":\"https://a.example\",\"title\":\"A\"}]}]}\n" +
+			"data: {\"p\":\"response/content\",\"v\":\"Answer\"}\n" +
+			"data: {\"v\":\"[citation:1]\"}\n" +
+			"data: [DONE]\n",
+	)
+	result := CollectStream(resp, false, false)
+	if result.Text != "Answer[citation:1]" {
+		t.Fatalf("unexpected text %q", result.Text)
+	}
+	got, ok := result.Search.Lookup(1)
+	if !ok || got.URL != "https://a.example" {
+		t.Fatalf("expected citation 1 to resolve, got %#v", got)
+	}
+}
@@ -0,0 +1,95 @@
+package sse
+
+import (
+	"strings"
+
+	"ds2api/internal/util"
+)
+
+// parseUpstreamUsage reads the accounting DeepSeek sends on paths the
+// content parser skips: token usage, thinking time and quasi status. They
+// arrive as a SET on their own path, inside a BATCH on "response", or as
+// fields of the initial response snapshot.
+func parseUpstreamUsage(chunk map[string]any) util.UpstreamUsage {
+	var u util.UpstreamUsage
+	v, ok := chunk["v"]
+	if !ok {
+		return u
+	}
+	path, _ := chunk["p"].(string)
+	collectUsageValue(&u, path, v)
+	return u
+}
+
+func collectUsageValue(u *util.UpstreamUsage, path string, v any) {
+	switch {
+	case strings.HasSuffix(path, "token_usage"):
+		u.Merge(util.UpstreamUsage{OutputTokens: usageTokens(v)})
+		return
+	case strings.HasSuffix(path, "elapsed_secs"):
+		if secs, ok := v.(float64); ok {
+			u.Merge(util.UpstreamUsage{ThinkingSeconds: secs})
+		}
+		return
+	case strings.HasSuffix(path, "quasi_status"):
+		if s, ok := v.(string); ok {
+			u.Merge(util.UpstreamUsage{QuasiStatus: s})
+		}
+		return
+	}
+	switch val := v.(type) {
+	case []any:
+		for _, item := range val {
+			m, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			itemPath, _ := m["p"].(string)
+			if itemV, ok := m["v"]; ok && itemPath != "" {
+				collectUsageValue(u, joinUsagePath(path, itemPath), itemV)
+			}
+		}
+	case map[string]any:
+		resp := val
+		if wrapped, ok := val["response"].(map[string]any); ok {
+			resp = wrapped
+		}
+		for key, fieldV := range resp {
+			if strings.HasSuffix(key, "token_usage") || strings.HasSuffix(key, "elapsed_secs") || key == "quasi_status" {
+				collectUsageValue(u, key, fieldV)
+			}
+		}
+		if frags, ok := resp["fragments"].([]any); ok {
+			for _, frag := range frags {
+				if m, ok := frag.(map[string]any); ok {
+					if secs, ok := m["elapsed_secs"]; ok {
+						collectUsageValue(u, "elapsed_secs", secs)
+					}
+				}
+			}
+		}
+	}
+}
+
+func joinUsagePath(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "/" + child
+}
+
+// usageTokens reads a token count given as a number or as an object with a
+// completion or output count.
+func usageTokens(v any) int {
+	switch val := v.(type) {
+	case float64:
+		return int(val)
+	case map[string]any:
+		for _, key := range []string{"completion_tokens", "output_tokens"} {
+			if n, ok := val[key].(float64); ok {
+				return int(n)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package sse
+
+import "testing"
+
+func TestParseDeepSeekContentLineUsageSet(t *testing.T) {
+	res := ParseDeepSeekContentLine([]byte(`data: {"p":"response/thinking_elapsed_secs","o":"SET","v":3.25}`), true, "thinking")
+	if len(res.Parts) != 0 {
+		t.Fatalf("expected no content parts, got %#v", res.Parts)
+	}
+	if res.Usage.ThinkingSeconds != 3.25 {
+		t.Fatalf("expected thinking seconds, got %#v", res.Usage)
+	}
+}
+
+func TestParseDeepSeekContentLineUsageBatch(t *testing.T) {
+	line := `data: {"p":"response","o":"BATCH","v":[{"p":"accumulated_token_usage","v":63},{"p":"quasi_status","v":"FINISHED"}]}`
+	res := ParseDeepSeekContentLine([]byte(line), false, "text")
+	if len(res.Parts) != 0 {
+		t.Fatalf("expected no content parts, got %#v", res.Parts)
+	}
+	if res.Usage.OutputTokens != 63 || res.Usage.QuasiStatus != "FINISHED" {
+		t.Fatalf("unexpected usage: %#v", res.Usage)
+	}
+}
+
+func TestParseDeepSeekContentLineUsageSnapshot(t *testing.T) {
+	line := `data: {"v":{"response":{"accumulated_token_usage":0,"thinking_elapsed_secs":null,"fragments":[{"type":"THINK","content":"x","elapsed_secs":1.5}]}}}`
+	res := ParseDeepSeekContentLine([]byte(line), true, "thinking")
+	if res.Usage.OutputTokens != 0 || res.Usage.ThinkingSeconds != 1.5 {
+		t.Fatalf("unexpected usage: %#v", res.Usage)
+	}
+}
+
+func TestParseDeepSeekContentLineTokenUsageObject(t *testing.T) {
+	res := ParseDeepSeekContentLine([]byte(`data: {"p":"response/token_usage","v":{"prompt_tokens":9,"completion_tokens":21}}`), false, "text")
+	if res.Usage.OutputTokens != 21 {
+		t.Fatalf("unexpected usage: %#v", res.Usage)
+	}
+}
+
+func TestCollectStreamKeepsUsageFromStopLine(t *testing.T) {
+	resp := makeHTTPResponse(
+		"data: {\"p\":\"response/content\",\"v\":\"Hello\"}\n" +
+			"data: {\"p\":\"response/accumulated_token_usage\",\"v\":12}\n" +
+			"data: {\"p\":\"response\",\"o\":\"BATCH\",\"v\":[{\"p\":\"accumulated_token_usage\",\"v\":40},{\"p\":\"status\",\"v\":\"FINISHED\"}]}\n" +
+			"data: {\"p\":\"response/content\",\"v\":\" ignored\"}\n",
+	)
+	result := CollectStream(resp, false, false)
+	if result.Text != "Hello" {
+		t.Fatalf("unexpected text %q", result.Text)
+	}
+	if result.Usage.OutputTokens != 40 {
+		t.Fatalf("expected usage from the finishing line, got %#v", result.Usage)
+	}
+}
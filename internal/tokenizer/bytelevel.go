@@ -0,0 +1,23 @@
+package tokenizer
+
+// byteToUnicode is the GPT-2 byte-level alphabet: printable Latin-1 bytes map
+// to themselves and the rest are shifted above U+0100, so every byte has a
+// visible, whitespace-free symbol in the vocabulary.
+var (
+	byteToUnicode [256]string
+	unicodeToByte = map[rune]byte{}
+)
+
+func init() {
+	n := 0
+	for b := 0; b < 256; b++ {
+		r := rune(b)
+		printable := (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF)
+		if !printable {
+			r = rune(256 + n)
+			n++
+		}
+		byteToUnicode[b] = string(r)
+		unicodeToByte[r] = byte(b)
+	}
+}
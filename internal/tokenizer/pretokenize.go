@@ -0,0 +1,142 @@
+package tokenizer
+
+import "unicode"
+
+// pretokenize splits text the way the DeepSeek-V3 tokenizer.json
+// pre-tokenizer does before byte-level BPE. It is a sequence of three
+// isolated splits:
+//
+//  1. \p{N}{1,3}
+//  2. [一-龥\x{3040}-ゟ゠-ヿ]+
+//  3. [!"#$%&'()*+,\-./:;<=>?@\[\\\]^_`{|}~][A-Za-z]+|[^\r\n\p{L}\p{P}\p{S}]?[\p{L}\p{M}]+|
+//     ?[\p{P}\p{S}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// Each split keeps both the matches and the text between them. The third
+// pattern needs a lookahead, which Go's regexp lacks, so all three are
+// matched by hand with the same leftmost-first alternation semantics.
+func pretokenize(text string) []string {
+	pieces := isolate([]string{text}, matchDigits)
+	pieces = isolate(pieces, matchCJK)
+	return isolate(pieces, matchWord)
+}
+
+// isolate applies one isolated split to every piece: match reports the
+// length in runes of the match starting at i, or 0 for none.
+func isolate(pieces []string, match func(rs []rune, i int) int) []string {
+	out := make([]string, 0, len(pieces))
+	for _, piece := range pieces {
+		rs := []rune(piece)
+		gap := 0
+		for i := 0; i < len(rs); {
+			n := match(rs, i)
+			if n == 0 {
+				i++
+				continue
+			}
+			if gap < i {
+				out = append(out, string(rs[gap:i]))
+			}
+			out = append(out, string(rs[i:i+n]))
+			i += n
+			gap = i
+		}
+		if gap < len(rs) {
+			out = append(out, string(rs[gap:]))
+		}
+	}
+	return out
+}
+
+func matchDigits(rs []rune, i int) int {
+	n := 0
+	for i+n < len(rs) && n < 3 && unicode.IsNumber(rs[i+n]) {
+		n++
+	}
+	return n
+}
+
+func isCJK(r rune) bool {
+	return (r >= 0x4E00 && r <= 0x9FA5) || (r >= 0x3040 && r <= 0x309F) || (r >= 0x30A0 && r <= 0x30FF)
+}
+
+func matchCJK(rs []rune, i int) int {
+	n := 0
+	for i+n < len(rs) && isCJK(rs[i+n]) {
+		n++
+	}
+	return n
+}
+
+func isASCIIPunct(r rune) bool {
+	return r < 128 && r > ' ' && r != 0x7F && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func isASCIILetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isLetterOrMark(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r)
+}
+
+func isPunctOrSymbol(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+func runWhile(rs []rune, i int, pred func(rune) bool) int {
+	n := 0
+	for i+n < len(rs) && pred(rs[i+n]) {
+		n++
+	}
+	return n
+}
+
+func matchWord(rs []rune, i int) int {
+	// [ASCII punct][A-Za-z]+
+	if isASCIIPunct(rs[i]) && i+1 < len(rs) && isASCIILetter(rs[i+1]) {
+		return 1 + runWhile(rs, i+1, isASCIILetter)
+	}
+	// [^\r\n\p{L}\p{P}\p{S}]?[\p{L}\p{M}]+
+	r := rs[i]
+	if !isNewline(r) && !unicode.IsLetter(r) && !isPunctOrSymbol(r) {
+		if n := runWhile(rs, i+1, isLetterOrMark); n > 0 {
+			return 1 + n
+		}
+	}
+	if n := runWhile(rs, i, isLetterOrMark); n > 0 {
+		return n
+	}
+	// ' '?[\p{P}\p{S}]+[\r\n]*
+	start := i
+	if r == ' ' && i+1 < len(rs) && isPunctOrSymbol(rs[i+1]) {
+		start++
+	}
+	if n := runWhile(rs, start, isPunctOrSymbol); n > 0 {
+		end := start + n
+		end += runWhile(rs, end, isNewline)
+		return end - i
+	}
+	ws := runWhile(rs, i, unicode.IsSpace)
+	if ws == 0 {
+		return 0
+	}
+	// \s*[\r\n]+ ends after the last newline of the whitespace run.
+	for k := ws - 1; k >= 0; k-- {
+		if isNewline(rs[i+k]) {
+			return k + 1
+		}
+	}
+	// \s+(?!\S) leaves the last space to lead the next word.
+	if i+ws == len(rs) {
+		return ws
+	}
+	if ws > 1 {
+		return ws - 1
+	}
+	// \s+
+	return ws
+}
@@ -0,0 +1,332 @@
+// Package tokenizer is a pure-Go byte-level BPE tokenizer that reads the
+// Hugging Face tokenizer.json of DeepSeek-V3, used for exact token
+// accounting instead of character-count estimates.
+package tokenizer
+
+import (
+	"compress/gzip"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Name identifies the vocabulary this package is built for.
+const Name = "deepseek-v3"
+
+const embeddedAsset = "assets/tokenizer.json.gz"
+
+//go:embed assets
+var assets embed.FS
+
+type mergePair struct {
+	left, right string
+}
+
+type addedToken struct {
+	content string
+	id      int
+}
+
+// Tokenizer encodes text into DeepSeek-V3 token ids. It is safe for
+// concurrent use.
+type Tokenizer struct {
+	vocab   map[string]int
+	tokens  map[int]string
+	ranks   map[mergePair]int
+	added   map[rune][]addedToken
+	special map[int]bool
+
+	cacheMu sync.Mutex
+	cache   map[string][]int
+}
+
+// maxCacheEntries bounds the per-word cache; it is simply reset when full.
+const maxCacheEntries = 100000
+
+type tokenizerFile struct {
+	AddedTokens []struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+		Special bool   `json:"special"`
+	} `json:"added_tokens"`
+	Model struct {
+		Type   string          `json:"type"`
+		Vocab  map[string]int  `json:"vocab"`
+		Merges json.RawMessage `json:"merges"`
+	} `json:"model"`
+}
+
+// Parse reads a Hugging Face tokenizer.json with a byte-level BPE model.
+func Parse(r io.Reader) (*Tokenizer, error) {
+	var f tokenizerFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("decode tokenizer.json: %w", err)
+	}
+	if f.Model.Type != "" && f.Model.Type != "BPE" {
+		return nil, fmt.Errorf("unsupported tokenizer model %q", f.Model.Type)
+	}
+	if len(f.Model.Vocab) == 0 {
+		return nil, errors.New("tokenizer.json has an empty vocabulary")
+	}
+	merges, err := parseMerges(f.Model.Merges)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tokenizer{
+		vocab:   f.Model.Vocab,
+		tokens:  make(map[int]string, len(f.Model.Vocab)+len(f.AddedTokens)),
+		ranks:   make(map[mergePair]int, len(merges)),
+		added:   map[rune][]addedToken{},
+		special: map[int]bool{},
+		cache:   map[string][]int{},
+	}
+	for tok, id := range f.Model.Vocab {
+		t.tokens[id] = tok
+	}
+	for i, m := range merges {
+		if _, ok := t.ranks[m]; !ok {
+			t.ranks[m] = i
+		}
+	}
+	for _, at := range f.AddedTokens {
+		if at.Content == "" {
+			continue
+		}
+		first, _ := utf8.DecodeRuneInString(at.Content)
+		t.added[first] = append(t.added[first], addedToken{content: at.Content, id: at.ID})
+		t.tokens[at.ID] = at.Content
+		if at.Special {
+			t.special[at.ID] = true
+		}
+	}
+	for first := range t.added {
+		list := t.added[first]
+		sort.SliceStable(list, func(i, j int) bool { return len(list[i].content) > len(list[j].content) })
+	}
+	return t, nil
+}
+
+// parseMerges accepts both the legacy "a b" string form and the newer
+// ["a", "b"] pair form of model.merges.
+func parseMerges(raw json.RawMessage) ([]mergePair, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var asStrings []string
+	if err := json.Unmarshal(raw, &asStrings); err == nil {
+		out := make([]mergePair, 0, len(asStrings))
+		for _, m := range asStrings {
+			left, right, ok := strings.Cut(m, " ")
+			if !ok {
+				return nil, fmt.Errorf("malformed merge %q", m)
+			}
+			out = append(out, mergePair{left, right})
+		}
+		return out, nil
+	}
+	var asPairs [][2]string
+	if err := json.Unmarshal(raw, &asPairs); err != nil {
+		return nil, fmt.Errorf("decode merges: %w", err)
+	}
+	out := make([]mergePair, 0, len(asPairs))
+	for _, m := range asPairs {
+		out = append(out, mergePair{m[0], m[1]})
+	}
+	return out, nil
+}
+
+// Encode returns the token ids of text. Added tokens such as the chat
+// template markers are matched verbatim; no BOS token is prepended.
+func (t *Tokenizer) Encode(text string) []int {
+	ids := make([]int, 0, len(text)/3+1)
+	start := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if tok, ok := t.matchAdded(text[i:], r); ok {
+			ids = t.encodeOrdinary(ids, text[start:i])
+			ids = append(ids, tok.id)
+			i += len(tok.content)
+			start = i
+			continue
+		}
+		i += size
+	}
+	return t.encodeOrdinary(ids, text[start:])
+}
+
+// Count returns the number of tokens in text.
+func (t *Tokenizer) Count(text string) int {
+	return len(t.Encode(text))
+}
+
+// Decode turns token ids back into text; unknown ids are skipped.
+func (t *Tokenizer) Decode(ids []int) string {
+	var out []byte
+	for _, id := range ids {
+		tok, ok := t.tokens[id]
+		if !ok {
+			continue
+		}
+		if _, isVocab := t.vocab[tok]; !isVocab || t.special[id] {
+			out = append(out, tok...)
+			continue
+		}
+		for _, r := range tok {
+			if b, ok := unicodeToByte[r]; ok {
+				out = append(out, b)
+			}
+		}
+	}
+	return string(out)
+}
+
+// Token returns the vocabulary entry of id as stored in tokenizer.json.
+func (t *Tokenizer) Token(id int) (string, bool) {
+	tok, ok := t.tokens[id]
+	return tok, ok
+}
+
+func (t *Tokenizer) matchAdded(s string, first rune) (addedToken, bool) {
+	for _, tok := range t.added[first] {
+		if strings.HasPrefix(s, tok.content) {
+			return tok, true
+		}
+	}
+	return addedToken{}, false
+}
+
+func (t *Tokenizer) encodeOrdinary(ids []int, text string) []int {
+	if text == "" {
+		return ids
+	}
+	for _, piece := range pretokenize(text) {
+		ids = append(ids, t.encodePiece(piece)...)
+	}
+	return ids
+}
+
+func (t *Tokenizer) encodePiece(piece string) []int {
+	t.cacheMu.Lock()
+	cached, ok := t.cache[piece]
+	t.cacheMu.Unlock()
+	if ok {
+		return cached
+	}
+
+	symbols := make([]string, 0, len(piece))
+	for i := 0; i < len(piece); i++ {
+		symbols = append(symbols, byteToUnicode[piece[i]])
+	}
+	for len(symbols) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i+1 < len(symbols); i++ {
+			if rank, ok := t.ranks[mergePair{symbols[i], symbols[i+1]}]; ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		pair := mergePair{symbols[best], symbols[best+1]}
+		merged := make([]string, 0, len(symbols)-1)
+		for i := 0; i < len(symbols); i++ {
+			if i+1 < len(symbols) && symbols[i] == pair.left && symbols[i+1] == pair.right {
+				merged = append(merged, pair.left+pair.right)
+				i++
+				continue
+			}
+			merged = append(merged, symbols[i])
+		}
+		symbols = merged
+	}
+
+	ids := make([]int, 0, len(symbols))
+	for _, sym := range symbols {
+		if id, ok := t.vocab[sym]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		// A merged symbol missing from the vocabulary falls back to bytes.
+		for _, r := range sym {
+			if id, ok := t.vocab[string(r)]; ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	t.cacheMu.Lock()
+	if len(t.cache) >= maxCacheEntries {
+		t.cache = map[string][]int{}
+	}
+	t.cache[piece] = ids
+	t.cacheMu.Unlock()
+	return ids
+}
+
+var (
+	defaultMu  sync.RWMutex
+	defaultTok *Tokenizer
+)
+
+// Load reads the tokenizer from path, or from the embedded asset when the
+// file does not exist. Files ending in .gz are decompressed.
+func Load(path string) (*Tokenizer, error) {
+	var r io.ReadCloser
+	name := path
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		r = f
+	case errors.Is(err, os.ErrNotExist):
+		embedded, embErr := assets.Open(embeddedAsset)
+		if embErr != nil {
+			return nil, fmt.Errorf("no tokenizer at %s and no embedded vocabulary", path)
+		}
+		r, name = embedded, embeddedAsset
+	default:
+		return nil, err
+	}
+	defer r.Close()
+	var src io.Reader = r
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		src = gz
+	}
+	return Parse(src)
+}
+
+// Init loads the tokenizer from path (falling back to the embedded asset)
+// and installs it as the default.
+func Init(path string) error {
+	t, err := Load(path)
+	if err != nil {
+		return err
+	}
+	SetDefault(t)
+	return nil
+}
+
+// SetDefault installs t as the tokenizer returned by Default.
+func SetDefault(t *Tokenizer) {
+	defaultMu.Lock()
+	defaultTok = t
+	defaultMu.Unlock()
+}
+
+// Default returns the installed tokenizer, or nil when none was loaded.
+func Default() *Tokenizer {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultTok
+}
@@ -0,0 +1,147 @@
+package tokenizer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testTokenizerJSON builds a tiny byte-level BPE: the 256 byte symbols plus a
+// few merges, one added chat marker and one special token.
+func testTokenizerJSON(t *testing.T, pairMerges bool) []byte {
+	t.Helper()
+	vocab := map[string]int{}
+	for b := 0; b < 256; b++ {
+		vocab[byteToUnicode[b]] = b
+	}
+	merges := [][2]string{{"h", "e"}, {"l", "l"}, {"he", "ll"}, {"hell", "o"}, {"Ġ", "w"}, {"Ġw", "o"}}
+	for i, m := range merges {
+		vocab[m[0]+m[1]] = 256 + i
+	}
+	var mergesField any = merges
+	if !pairMerges {
+		legacy := make([]string, 0, len(merges))
+		for _, m := range merges {
+			legacy = append(legacy, m[0]+" "+m[1])
+		}
+		mergesField = legacy
+	}
+	b, err := json.Marshal(map[string]any{
+		"added_tokens": []any{
+			map[string]any{"id": 1000, "content": "<｜User｜>", "special": false},
+			map[string]any{"id": 1001, "content": "<｜end▁of▁sentence｜>", "special": true},
+		},
+		"model": map[string]any{"type": "BPE", "vocab": vocab, "merges": mergesField},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPretokenizeMatchesDeepSeekSplits(t *testing.T) {
+	cases := map[string][]string{
+		"Hello world":  {"Hello", " world"},
+		"12345":        {"123", "45"},
+		"你好world":      {"你好", "world"},
+		"a  b":         {"a", " ", " b"},
+		"x\n\ny":       {"x", "\n\n", "y"},
+		"(foo) bar":    {"(foo", ")", " bar"},
+		"don't stop!!": {"don", "'t", " stop", "!!"},
+		"tail   ":      {"tail", "   "},
+	}
+	for in, want := range cases {
+		if got := pretokenize(in); !reflect.DeepEqual(got, want) {
+			t.Fatalf("pretokenize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEncodeAppliesMergesAndAddedTokens(t *testing.T) {
+	for _, pairs := range []bool{false, true} {
+		tok, err := Parse(bytes.NewReader(testTokenizerJSON(t, pairs)))
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		ids := tok.Encode("hello world<｜User｜>hi")
+		want := []int{tok.vocab["hello"], tok.vocab["Ġwo"], 'r', 'l', 'd', 1000, 'h', 'i'}
+		if !reflect.DeepEqual(ids, want) {
+			t.Fatalf("Encode = %v, want %v", ids, want)
+		}
+		if got := tok.Decode(ids); got != "hello world<｜User｜>hi" {
+			t.Fatalf("Decode round trip = %q", got)
+		}
+	}
+}
+
+func TestEncodeNonASCIIUsesByteSymbols(t *testing.T) {
+	tok, err := Parse(bytes.NewReader(testTokenizerJSON(t, false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := tok.Encode("好")
+	if len(ids) != len("好") || tok.Decode(ids) != "好" {
+		t.Fatalf("expected one token per UTF-8 byte, got %v", ids)
+	}
+	if tok.Count("") != 0 {
+		t.Fatal("empty text should have no tokens")
+	}
+}
+
+func TestLoadReadsGzipFile(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write(testTokenizerJSON(t, false))
+	_ = gz.Close()
+	path := filepath.Join(t.TempDir(), "tokenizer.json.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := Load(path)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if tok.Count("hello") != 1 {
+		t.Fatalf("expected merged token, got %v", tok.Encode("hello"))
+	}
+}
+
+func TestLoadMissingFileWithoutEmbeddedAsset(t *testing.T) {
+	if _, err := assets.Open(embeddedAsset); err == nil {
+		t.Skip("vocabulary is embedded")
+	}
+	_, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err == nil || !strings.Contains(err.Error(), "no embedded vocabulary") {
+		t.Fatalf("expected missing vocabulary error, got %v", err)
+	}
+}
+
+func TestEmbeddedVocabularyEncodesKnownIDs(t *testing.T) {
+	if _, err := assets.Open(embeddedAsset); err != nil {
+		t.Fatal("assets/tokenizer.json.gz is not embedded; see assets/README.md")
+	}
+	tok, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("load embedded vocabulary: %v", err)
+	}
+	if len(tok.vocab) < 128000 {
+		t.Fatalf("expected the DeepSeek-V3 vocabulary, got %d entries", len(tok.vocab))
+	}
+	const text = "<｜begin▁of▁sentence｜><｜User｜>Hello, world!<｜Assistant｜>你好<｜end▁of▁sentence｜>"
+	ids := tok.Encode(text)
+	if len(ids) < 5 || ids[0] != 0 || ids[1] != 128803 || ids[len(ids)-1] != 1 {
+		t.Fatalf("unexpected ids for chat markers: %v", ids)
+	}
+	if !reflect.DeepEqual(ids, tok.Encode(text)) || tok.Decode(ids) != text {
+		t.Fatalf("round trip failed: %v -> %q", ids, tok.Decode(ids))
+	}
+	if !slices.Contains(ids, 128804) {
+		t.Fatalf("expected <｜Assistant｜> as id 128804, got %v", ids)
+	}
+}
@@ -0,0 +1,340 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateJSONSchema checks a decoded JSON value against the subset of JSON
+// Schema that structured output clients use in practice: type, enum, const,
+// object properties/required/additionalProperties, array items and bounds,
+// string length/pattern, numeric bounds, allOf/anyOf/oneOf/not, and local
+// $ref pointers into $defs or definitions.
+func ValidateJSONSchema(value any, schema map[string]any) error {
+	v := schemaValidator{root: schema}
+	return v.validate(value, schema, "$", 0)
+}
+
+type schemaValidator struct {
+	root map[string]any
+}
+
+const maxSchemaDepth = 64
+
+func (v schemaValidator) validate(value any, schema map[string]any, path string, depth int) error {
+	if len(schema) == 0 {
+		return nil
+	}
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("%s: schema nesting too deep", path)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := v.resolveRef(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return v.validate(value, target, path, depth+1)
+	}
+	if err := checkSchemaType(value, schema["type"], path); err != nil {
+		return err
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		matched := false
+		for _, candidate := range enum {
+			if jsonEqual(value, candidate) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: value is not one of the allowed enum values", path)
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(value, c) {
+		return fmt.Errorf("%s: value does not match const", path)
+	}
+
+	switch x := value.(type) {
+	case map[string]any:
+		if err := v.validateObject(x, schema, path, depth); err != nil {
+			return err
+		}
+	case []any:
+		if err := v.validateArray(x, schema, path, depth); err != nil {
+			return err
+		}
+	case string:
+		if err := validateString(x, schema, path); err != nil {
+			return err
+		}
+	case float64:
+		if err := validateNumber(x, schema, path); err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		if err := v.validate(value, sub, path, depth+1); err != nil {
+			return err
+		}
+	}
+	if subs := schemaList(schema["anyOf"]); len(subs) > 0 {
+		var firstErr error
+		for _, sub := range subs {
+			err := v.validate(value, sub, path, depth+1)
+			if err == nil {
+				firstErr = nil
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return fmt.Errorf("%s: value does not match any allowed schema (%v)", path, firstErr)
+		}
+	}
+	if subs := schemaList(schema["oneOf"]); len(subs) > 0 {
+		matches := 0
+		for _, sub := range subs {
+			if v.validate(value, sub, path, depth+1) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: value must match exactly one schema, matched %d", path, matches)
+		}
+	}
+	if not, ok := schema["not"].(map[string]any); ok {
+		if v.validate(value, not, path, depth+1) == nil {
+			return fmt.Errorf("%s: value matches a disallowed schema", path)
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) validateObject(obj map[string]any, schema map[string]any, path string, depth int) error {
+	for _, name := range toStringList(schema["required"]) {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+	props, _ := schema["properties"].(map[string]any)
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		childPath := path + "." + k
+		if propSchema, ok := props[k].(map[string]any); ok {
+			if err := v.validate(obj[k], propSchema, childPath, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, declared := props[k]; declared {
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return fmt.Errorf("%s: unexpected property %q", path, k)
+			}
+		case map[string]any:
+			if err := v.validate(obj[k], extra, childPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if n, ok := schemaInt(schema["minProperties"]); ok && len(obj) < n {
+		return fmt.Errorf("%s: expected at least %d properties", path, n)
+	}
+	if n, ok := schemaInt(schema["maxProperties"]); ok && len(obj) > n {
+		return fmt.Errorf("%s: expected at most %d properties", path, n)
+	}
+	return nil
+}
+
+func (v schemaValidator) validateArray(arr []any, schema map[string]any, path string, depth int) error {
+	if n, ok := schemaInt(schema["minItems"]); ok && len(arr) < n {
+		return fmt.Errorf("%s: expected at least %d items", path, n)
+	}
+	if n, ok := schemaInt(schema["maxItems"]); ok && len(arr) > n {
+		return fmt.Errorf("%s: expected at most %d items", path, n)
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		for i, item := range arr {
+			if err := v.validate(item, items, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					return fmt.Errorf("%s: items must be unique", path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateString(s string, schema map[string]any, path string) error {
+	length := utf8.RuneCountInString(s)
+	if n, ok := schemaInt(schema["minLength"]); ok && length < n {
+		return fmt.Errorf("%s: string shorter than %d", path, n)
+	}
+	if n, ok := schemaInt(schema["maxLength"]); ok && length > n {
+		return fmt.Errorf("%s: string longer than %d", path, n)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err == nil && !re.MatchString(s) {
+			return fmt.Errorf("%s: string does not match pattern %q", path, pattern)
+		}
+	}
+	return nil
+}
+
+func validateNumber(n float64, schema map[string]any, path string) error {
+	if min, ok := schema["minimum"].(float64); ok && n < min {
+		return fmt.Errorf("%s: %v is less than minimum %v", path, n, min)
+	}
+	if max, ok := schema["maximum"].(float64); ok && n > max {
+		return fmt.Errorf("%s: %v is greater than maximum %v", path, n, max)
+	}
+	if min, ok := schema["exclusiveMinimum"].(float64); ok && n <= min {
+		return fmt.Errorf("%s: %v must be greater than %v", path, n, min)
+	}
+	if max, ok := schema["exclusiveMaximum"].(float64); ok && n >= max {
+		return fmt.Errorf("%s: %v must be less than %v", path, n, max)
+	}
+	if step, ok := schema["multipleOf"].(float64); ok && step > 0 {
+		if q := n / step; math.Abs(q-math.Round(q)) > 1e-9 {
+			return fmt.Errorf("%s: %v is not a multiple of %v", path, n, step)
+		}
+	}
+	return nil
+}
+
+func checkSchemaType(value any, rawType any, path string) error {
+	var types []string
+	switch t := rawType.(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		types = toStringList(t)
+	default:
+		return nil
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	actual := jsonTypeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), actual)
+}
+
+func jsonTypeOf(value any) string {
+	switch x := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if x == math.Trunc(x) && !math.IsInf(x, 0) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func (v schemaValidator) resolveRef(ref string) (map[string]any, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	var cur any = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		cur, ok = m[part]
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	target, ok := cur.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return target, nil
+}
+
+func schemaList(v any) []map[string]any {
+	arr, ok := v.([]any)
+	if !ok {
+		return nil
+	}
+	out := make([]map[string]any, 0, len(arr))
+	for _, item := range arr {
+		if m, ok := item.(map[string]any); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func schemaInt(v any) (int, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return 0, false
+	}
+	return int(f), true
+}
+
+func toStringList(v any) []string {
+	arr, ok := v.([]any)
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(arr))
+	for _, item := range arr {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func jsonEqual(a, b any) bool {
+	if fa, ok := a.(float64); ok {
+		fb, ok := b.(float64)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
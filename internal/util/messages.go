@@ -4,6 +4,7 @@ import (
 	"ds2api/internal/claudeconv"
 	"ds2api/internal/config"
 	"ds2api/internal/prompt"
+	"ds2api/internal/tokenizer"
 )
 
 const ClaudeDefaultModel = "claude-sonnet-4-5"
@@ -25,27 +26,48 @@ func ConvertClaudeToDeepSeek(claudeReq map[string]any, store *config.Store) map[
 	return claudeconv.ConvertClaudeToDeepSeek(claudeReq, store, ClaudeDefaultModel)
 }
 
-// EstimateTokens provides a rough token count approximation.
-// For ASCII text (English, code, etc.) we use ~4 chars per token.
-// For non-ASCII text (Chinese, Japanese, Korean, etc.) we use ~1.3 chars per token,
-// which better reflects typical BPE tokenizer behavior for CJK scripts.
+// CountTokens counts text with the DeepSeek-V3 tokenizer when its vocabulary
+// is loaded, and falls back to EstimateTokens otherwise.
+func CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	if tok := tokenizer.Default(); tok != nil {
+		return tok.Count(text)
+	}
+	return EstimateTokens(text)
+}
+
+// EstimateTokens provides a rough token count approximation (see
+// tokenEstimate); non-empty text counts as at least one token.
 func EstimateTokens(text string) int {
 	if text == "" {
 		return 0
 	}
-	asciiChars := 0
-	nonASCIIChars := 0
+	var est tokenEstimate
 	for _, r := range text {
-		if r < 128 {
-			asciiChars++
-		} else {
-			nonASCIIChars++
-		}
+		est.add(r)
 	}
-	// ASCII: ~4 chars per token; non-ASCII (CJK): ~1.3 chars per token
-	n := asciiChars/4 + (nonASCIIChars*10+7)/13
-	if n < 1 {
-		return 1
+	return max(est.tokens(), 1)
+}
+
+// tokenEstimate tallies runes for EstimateTokens. ASCII text (English, code,
+// etc.) runs at ~4 chars per token; non-ASCII text (Chinese, Japanese,
+// Korean, etc.) at ~1.3 chars per token, which better reflects typical BPE
+// tokenizer behavior for CJK scripts.
+type tokenEstimate struct {
+	ascii int
+	other int
+}
+
+func (e *tokenEstimate) add(r rune) {
+	if r < 128 {
+		e.ascii++
+	} else {
+		e.other++
 	}
-	return n
+}
+
+func (e tokenEstimate) tokens() int {
+	return e.ascii/4 + (e.other*10+7)/13
 }
@@ -0,0 +1,259 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"ds2api/internal/tokenizer"
+)
+
+// OutputLimits are client generation limits that the DeepSeek web API
+// ignores, so they are enforced locally on the output as it arrives.
+type OutputLimits struct {
+	Stop      []string
+	MaxTokens int
+}
+
+func (l OutputLimits) Active() bool {
+	return len(l.Stop) > 0 || l.MaxTokens > 0
+}
+
+// ParseStopSequences reads a stop parameter given as one string or a list of
+// strings; empty entries are dropped.
+func ParseStopSequences(raw any, field string) ([]string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []string{v}, nil
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("'%s' must contain only strings.", field)
+			}
+			if s != "" {
+				out = append(out, s)
+			}
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("'%s' must be a string or an array of strings.", field)
+	}
+}
+
+// ParseMaxTokens reads a token limit; absent or null means unlimited.
+func ParseMaxTokens(raw any, field string) (int, error) {
+	if raw == nil {
+		return 0, nil
+	}
+	f, ok := raw.(float64)
+	if !ok {
+		if i, isInt := raw.(int); isInt {
+			f, ok = float64(i), true
+		}
+	}
+	if !ok || f != float64(int(f)) || f < 1 {
+		return 0, fmt.Errorf("'%s' must be a positive integer.", field)
+	}
+	return int(f), nil
+}
+
+const (
+	OutputLimitStopSequence = "stop_sequence"
+	OutputLimitMaxTokens    = "max_tokens"
+)
+
+// OutputLimiter cuts streamed output at the first stop sequence or once the
+// token count of thinking plus text would exceed the budget. Tokens are
+// counted with the DeepSeek tokenizer when it is loaded, otherwise as
+// EstimateTokens does. A nil limiter passes everything through.
+type OutputLimiter struct {
+	limits   OutputLimits
+	used     int
+	estimate tokenEstimate
+	held     string
+	reason   string
+	matched  string
+	// thinkingTail and textTail are the ends of the admitted output a token
+	// may still span, so a chunk is counted together with them rather than
+	// on its own.
+	thinkingTail string
+	textTail     string
+}
+
+// tokenCarryBytes bounds the tail kept for text without whitespace.
+const tokenCarryBytes = 64
+
+// NewOutputLimiter returns nil when limits impose nothing.
+func NewOutputLimiter(limits OutputLimits) *OutputLimiter {
+	if !limits.Active() {
+		return nil
+	}
+	return &OutputLimiter{limits: limits}
+}
+
+// Done reports whether a limit was hit; nothing more is admitted after that.
+func (l *OutputLimiter) Done() bool {
+	return l != nil && l.reason != ""
+}
+
+// Reason is OutputLimitStopSequence, OutputLimitMaxTokens or "".
+func (l *OutputLimiter) Reason() string {
+	if l == nil {
+		return ""
+	}
+	return l.reason
+}
+
+// StopSequence is the stop sequence that ended the output, if any.
+func (l *OutputLimiter) StopSequence() string {
+	if l == nil {
+		return ""
+	}
+	return l.matched
+}
+
+// Thinking admits a reasoning chunk against the token budget. Stop
+// sequences only apply to visible text.
+func (l *OutputLimiter) Thinking(chunk string) string {
+	if l == nil {
+		return chunk
+	}
+	if l.Done() {
+		return ""
+	}
+	return l.spend(chunk, &l.thinkingTail)
+}
+
+// Text admits a visible text chunk. A tail that could be the start of a stop
+// sequence is held back until the next chunk or Flush settles it.
+func (l *OutputLimiter) Text(chunk string) string {
+	if l == nil {
+		return chunk
+	}
+	if l.Done() {
+		return ""
+	}
+	buf := l.held + chunk
+	l.held = ""
+	if idx, seq := firstStopSequence(buf, l.limits.Stop); idx >= 0 {
+		out := l.spend(buf[:idx], &l.textTail)
+		if l.reason == "" {
+			l.reason = OutputLimitStopSequence
+			l.matched = seq
+		}
+		return out
+	}
+	keep := stopSequencePrefixLen(buf, l.limits.Stop)
+	l.held = buf[len(buf)-keep:]
+	return l.spend(buf[:len(buf)-keep], &l.textTail)
+}
+
+// Flush releases text held back as a possible stop sequence prefix once the
+// output has ended.
+func (l *OutputLimiter) Flush() string {
+	if l == nil || l.Done() {
+		return ""
+	}
+	held := l.held
+	l.held = ""
+	return l.spend(held, &l.textTail)
+}
+
+func (l *OutputLimiter) spend(text string, tail *string) string {
+	if l.limits.MaxTokens <= 0 || text == "" {
+		return text
+	}
+	if tok := tokenizer.Default(); tok != nil {
+		return l.spendTokens(tok, text, tail)
+	}
+	for i, r := range text {
+		est := l.estimate
+		est.add(r)
+		if est.tokens() > l.limits.MaxTokens {
+			l.reason = OutputLimitMaxTokens
+			return text[:i]
+		}
+		l.estimate = est
+	}
+	return text
+}
+
+// spendTokens admits text against the budget using real token counts,
+// keeping the longest rune-aligned prefix that still fits. text is counted
+// after tail, the admitted output it follows, so tokens that span chunks are
+// not counted twice.
+func (l *OutputLimiter) spendTokens(tok *tokenizer.Tokenizer, text string, tail *string) string {
+	base := tok.Count(*tail)
+	if n := tok.Count(*tail+text) - base; l.used+n <= l.limits.MaxTokens {
+		l.used += n
+		*tail = tokenCarry(*tail + text)
+		return text
+	}
+	cuts := make([]int, 0, len(text)+1)
+	for i := range text {
+		cuts = append(cuts, i)
+	}
+	lo, hi := 0, len(cuts)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if l.used+tok.Count(*tail+text[:cuts[mid]])-base <= l.limits.MaxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	kept := text[:cuts[lo]]
+	l.used += tok.Count(*tail+kept) - base
+	l.reason = OutputLimitMaxTokens
+	return kept
+}
+
+// tokenCarry returns the end of text a later chunk may still merge into a
+// token: everything from the last whitespace, which words split at, cut to
+// at most tokenCarryBytes.
+func tokenCarry(text string) string {
+	if i := strings.LastIndexAny(text, " \t\r\n"); i >= 0 {
+		text = text[i:]
+	}
+	if len(text) > tokenCarryBytes {
+		cut := len(text) - tokenCarryBytes
+		for cut < len(text) && !utf8.RuneStart(text[cut]) {
+			cut++
+		}
+		text = text[cut:]
+	}
+	return text
+}
+
+func firstStopSequence(text string, stops []string) (int, string) {
+	best, match := -1, ""
+	for _, seq := range stops {
+		if seq == "" {
+			continue
+		}
+		if idx := strings.Index(text, seq); idx >= 0 && (best < 0 || idx < best) {
+			best, match = idx, seq
+		}
+	}
+	return best, match
+}
+
+func stopSequencePrefixLen(text string, stops []string) int {
+	longest := 0
+	for _, seq := range stops {
+		for k := len(seq) - 1; k > longest; k-- {
+			if k <= len(text) && strings.HasSuffix(text, seq[:k]) {
+				longest = k
+				break
+			}
+		}
+	}
+	return longest
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"ds2api/internal/tokenizer"
+)
+
+func TestOutputLimiterStopSequenceAcrossChunks(t *testing.T) {
+	l := NewOutputLimiter(OutputLimits{Stop: []string{"END", "\n\n"}})
+	got := l.Text("hello E")
+	got += l.Text("NDING")
+	if got != "hello " || l.Reason() != OutputLimitStopSequence || l.StopSequence() != "END" {
+		t.Fatalf("got %q reason=%q seq=%q", got, l.Reason(), l.StopSequence())
+	}
+	if rest := l.Text("more"); rest != "" {
+		t.Fatalf("expected nothing after stop, got %q", rest)
+	}
+}
+
+func TestOutputLimiterReleasesFalseStopPrefix(t *testing.T) {
+	l := NewOutputLimiter(OutputLimits{Stop: []string{"END"}})
+	if got := l.Text("the E"); got != "the " {
+		t.Fatalf("expected possible stop prefix to be held, got %q", got)
+	}
+	if got := l.Text("ast"); got != "East" || l.Done() {
+		t.Fatalf("expected held prefix to be released, got %q done=%v", got, l.Done())
+	}
+}
+
+func TestOutputLimiterFlushReleasesHeldTail(t *testing.T) {
+	l := NewOutputLimiter(OutputLimits{Stop: []string{"STOP"}})
+	got := l.Text("go ST") + l.Flush()
+	if got != "go ST" || l.Done() {
+		t.Fatalf("got %q done=%v", got, l.Done())
+	}
+}
+
+func TestOutputLimiterMaxTokens(t *testing.T) {
+	l := NewOutputLimiter(OutputLimits{MaxTokens: 2})
+	if got := l.Thinking("abcd"); got != "abcd" {
+		t.Fatalf("unexpected thinking %q", got)
+	}
+	got := l.Text("efghijkl")
+	if got != "efghijk" || l.Reason() != OutputLimitMaxTokens {
+		t.Fatalf("got %q reason=%q", got, l.Reason())
+	}
+	if EstimateTokens("abcd"+got) != 2 {
+		t.Fatalf("kept output should fit the budget")
+	}
+}
+
+func TestOutputLimiterCountsTokensAcrossChunks(t *testing.T) {
+	tok, err := tokenizer.Parse(strings.NewReader(`{"model":{"type":"BPE","vocab":{"a":0,"b":1,"ab":2},"merges":["a b"]}}`))
+	if err != nil {
+		t.Fatalf("parse tokenizer: %v", err)
+	}
+	tokenizer.SetDefault(tok)
+	t.Cleanup(func() { tokenizer.SetDefault(nil) })
+
+	// "ababab" is three tokens, though each chunk alone is one.
+	l := NewOutputLimiter(OutputLimits{MaxTokens: 3})
+	var got string
+	for _, chunk := range []string{"a", "b", "a", "b", "a", "b"} {
+		got += l.Text(chunk)
+	}
+	if got != "ababab" || l.Done() {
+		t.Fatalf("expected tokens spanning chunks counted once, got %q reason=%q", got, l.Reason())
+	}
+	if got := l.Text("ab"); got != "" || l.Reason() != OutputLimitMaxTokens {
+		t.Fatalf("expected the budget spent, got %q reason=%q", got, l.Reason())
+	}
+}
+
+func TestOutputLimiterNilPassesThrough(t *testing.T) {
+	var l *OutputLimiter = NewOutputLimiter(OutputLimits{})
+	if l != nil {
+		t.Fatal("expected nil limiter without limits")
+	}
+	if l.Text("a") != "a" || l.Thinking("b") != "b" || l.Flush() != "" || l.Done() || l.Reason() != "" {
+		t.Fatal("nil limiter should pass everything through")
+	}
+}
+
+func TestParseOutputLimitFields(t *testing.T) {
+	if got, err := ParseStopSequences("x", "stop"); err != nil || len(got) != 1 {
+		t.Fatalf("string stop: %v %v", got, err)
+	}
+	if got, err := ParseStopSequences([]any{"a", "", "b"}, "stop"); err != nil || len(got) != 2 {
+		t.Fatalf("array stop: %v %v", got, err)
+	}
+	if _, err := ParseStopSequences([]any{1.0}, "stop"); err == nil {
+		t.Fatal("expected non-string stop entry to be rejected")
+	}
+	if n, err := ParseMaxTokens(16.0, "max_tokens"); err != nil || n != 16 {
+		t.Fatalf("max_tokens: %d %v", n, err)
+	}
+	for _, bad := range []any{0.0, 1.5, "10"} {
+		if _, err := ParseMaxTokens(bad, "max_tokens"); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
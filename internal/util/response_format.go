@@ -0,0 +1,102 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// ResponseFormat is a structured output constraint requested by the client
+// (OpenAI `response_format` or Responses `text.format`).
+type ResponseFormat struct {
+	Type   string
+	Name   string
+	Schema map[string]any
+	Strict bool
+}
+
+// Instruction is the prompt text that asks the model for conforming output.
+func (f *ResponseFormat) Instruction() string {
+	if f == nil {
+		return ""
+	}
+	if f.Type == ResponseFormatJSONObject {
+		return "Respond with a single valid JSON object only. Do not wrap it in markdown code fences and do not add any text before or after it."
+	}
+	schema, _ := json.Marshal(f.Schema)
+	name := strings.TrimSpace(f.Name)
+	if name == "" {
+		name = "response"
+	}
+	return fmt.Sprintf("Respond with a single JSON value only. It must validate against the JSON Schema named %q below. Do not wrap it in markdown code fences and do not add any text before or after it.\n\nJSON Schema:\n%s", name, string(schema))
+}
+
+// Check extracts the JSON payload from model output and validates it against
+// the format. On success it returns the extracted JSON text.
+func (f *ResponseFormat) Check(text string) (string, error) {
+	raw, value, ok := ExtractJSONValue(text)
+	if !ok {
+		return "", errors.New("output is not valid JSON")
+	}
+	if f == nil {
+		return raw, nil
+	}
+	if f.Type == ResponseFormatJSONObject {
+		if _, isObj := value.(map[string]any); !isObj {
+			return "", errors.New("output is not a JSON object")
+		}
+		return raw, nil
+	}
+	if err := ValidateJSONSchema(value, f.Schema); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// ExtractJSONValue finds the JSON document in model output. The whole text
+// (optionally inside one markdown fence) is tried first, then the first
+// decodable object or array embedded in surrounding prose.
+func ExtractJSONValue(text string) (string, any, bool) {
+	trimmed := strings.TrimSpace(text)
+	if m := fencedBlockPattern.FindStringSubmatch(trimmed); m != nil && strings.TrimSpace(m[0]) == trimmed {
+		trimmed = strings.TrimSpace(stripFence(trimmed))
+	}
+	if trimmed == "" {
+		return "", nil, false
+	}
+	var v any
+	if err := json.Unmarshal([]byte(trimmed), &v); err == nil {
+		return trimmed, v, true
+	}
+	for i := 0; i < len(trimmed); i++ {
+		if trimmed[i] != '{' && trimmed[i] != '[' {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(trimmed[i:]))
+		var candidate any
+		if err := dec.Decode(&candidate); err != nil {
+			continue
+		}
+		raw := trimmed[i : i+int(dec.InputOffset())]
+		var out any
+		if err := json.Unmarshal([]byte(raw), &out); err != nil {
+			continue
+		}
+		return raw, out, true
+	}
+	return "", nil, false
+}
+
+func stripFence(text string) string {
+	body := strings.TrimPrefix(text, "```")
+	if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+		body = body[nl+1:]
+	}
+	return strings.TrimSuffix(strings.TrimSpace(body), "```")
+}
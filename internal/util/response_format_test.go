@@ -0,0 +1,104 @@
+package util
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func mustSchema(t *testing.T, raw string) map[string]any {
+	t.Helper()
+	var out map[string]any
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		t.Fatalf("bad schema: %v", err)
+	}
+	return out
+}
+
+func TestExtractJSONValueHandlesFencesAndProse(t *testing.T) {
+	cases := map[string]string{
+		`{"a":1}`:                              `{"a":1}`,
+		"```json\n{\"a\":1}\n```":              `{"a":1}`,
+		"Here you go: {\"a\":1} hope it helps": `{"a":1}`,
+		"[note] result: [1,2]":                 `[1,2]`,
+	}
+	for in, want := range cases {
+		raw, _, ok := ExtractJSONValue(in)
+		if !ok || raw != want {
+			t.Fatalf("ExtractJSONValue(%q) = %q, %v; want %q", in, raw, ok, want)
+		}
+	}
+	if _, _, ok := ExtractJSONValue("no json here"); ok {
+		t.Fatal("expected no JSON to be found")
+	}
+}
+
+func TestResponseFormatCheckJSONObject(t *testing.T) {
+	f := &ResponseFormat{Type: ResponseFormatJSONObject}
+	if out, err := f.Check("```\n{\"ok\":true}\n```"); err != nil || out != `{"ok":true}` {
+		t.Fatalf("unexpected result: %q %v", out, err)
+	}
+	if _, err := f.Check(`[1,2]`); err == nil {
+		t.Fatal("expected array to be rejected for json_object")
+	}
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := mustSchema(t, `{
+		"type":"object",
+		"properties":{
+			"name":{"type":"string","minLength":1},
+			"age":{"type":"integer","minimum":0},
+			"tags":{"type":"array","items":{"type":"string"},"maxItems":2},
+			"kind":{"enum":["a","b"]},
+			"pet":{"$ref":"#/$defs/pet"}
+		},
+		"required":["name","age"],
+		"additionalProperties":false,
+		"$defs":{"pet":{"type":"object","required":["species"]}}
+	}`)
+	valid := `{"name":"x","age":3,"tags":["t"],"kind":"a","pet":{"species":"cat"}}`
+	var v any
+	_ = json.Unmarshal([]byte(valid), &v)
+	if err := ValidateJSONSchema(v, schema); err != nil {
+		t.Fatalf("expected valid document, got %v", err)
+	}
+
+	invalid := map[string]string{
+		`{"age":3}`:                                 "missing required property",
+		`{"name":"x","age":1.5}`:                    "expected integer",
+		`{"name":"x","age":-1}`:                     "less than minimum",
+		`{"name":"x","age":1,"extra":true}`:         "unexpected property",
+		`{"name":"x","age":1,"tags":["a","b","c"]}`: "at most 2 items",
+		`{"name":"x","age":1,"tags":[1]}`:           "$.tags[0]",
+		`{"name":"x","age":1,"kind":"z"}`:           "enum",
+		`{"name":"x","age":1,"pet":{}}`:             "species",
+		`{"name":"","age":1}`:                       "shorter than",
+	}
+	for doc, want := range invalid {
+		var value any
+		_ = json.Unmarshal([]byte(doc), &value)
+		err := ValidateJSONSchema(value, schema)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("ValidateJSONSchema(%s) = %v; want error containing %q", doc, err, want)
+		}
+	}
+}
+
+func TestValidateJSONSchemaCombinators(t *testing.T) {
+	schema := mustSchema(t, `{"anyOf":[{"type":"string"},{"type":"number"}],"not":{"const":"forbidden"}}`)
+	for _, ok := range []any{"hi", float64(2)} {
+		if err := ValidateJSONSchema(ok, schema); err != nil {
+			t.Fatalf("expected %v to pass: %v", ok, err)
+		}
+	}
+	for _, bad := range []any{true, "forbidden"} {
+		if err := ValidateJSONSchema(bad, schema); err == nil {
+			t.Fatalf("expected %v to fail", bad)
+		}
+	}
+	oneOf := mustSchema(t, `{"oneOf":[{"type":"integer"},{"type":"number"}]}`)
+	if err := ValidateJSONSchema(float64(1), oneOf); err == nil {
+		t.Fatal("expected integer to match both oneOf branches and fail")
+	}
+}
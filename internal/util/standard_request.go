@@ -7,10 +7,20 @@ type StandardRequest struct {
 	ResponseModel  string
 	Messages       []any
 	FinalPrompt    string
-	ToolNames      []string
-	Stream         bool
-	Thinking       bool
-	Search         bool
+	// RawPrompt marks a FinalPrompt taken verbatim from the client, with no
+	// chat template applied (legacy completions).
+	RawPrompt  bool
+	ToolNames  []string
+	ToolPolicy ToolPolicy
+	Stream     bool
+	Thinking   bool
+	Search     bool
+	// SearchSources asks for the search progress and source extensions of
+	// chat completions; it only takes effect with Search.
+	SearchSources  bool
+	N              int
+	ResponseFormat *ResponseFormat
+	Limits         OutputLimits
 	PassThrough    map[string]any
 }
 
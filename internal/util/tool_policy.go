@@ -0,0 +1,110 @@
+package util
+
+import "fmt"
+
+const (
+	ToolChoiceAuto     = "auto"
+	ToolChoiceNone     = "none"
+	ToolChoiceRequired = "required"
+	ToolChoiceFunction = "function"
+)
+
+// ToolPolicy is the normalized form of tool_choice plus parallel tool call
+// control shared by the OpenAI and Claude surfaces. The zero value means
+// "auto" with parallel calls allowed.
+type ToolPolicy struct {
+	Choice string
+	// Function is the forced tool name when Choice is ToolChoiceFunction.
+	Function string
+	// Single limits a reply to at most one tool call.
+	Single bool
+}
+
+func (p ToolPolicy) mode() string {
+	if p.Choice == "" {
+		return ToolChoiceAuto
+	}
+	return p.Choice
+}
+
+func (p ToolPolicy) IsDefault() bool {
+	return p.mode() == ToolChoiceAuto && !p.Single
+}
+
+// Disabled reports whether tools must not be offered or called at all.
+func (p ToolPolicy) Disabled() bool {
+	return p.mode() == ToolChoiceNone
+}
+
+// RequiresCall reports whether a reply without a tool call is non-compliant.
+func (p ToolPolicy) RequiresCall() bool {
+	m := p.mode()
+	return m == ToolChoiceRequired || m == ToolChoiceFunction
+}
+
+// Offers reports whether the named tool should be listed in the prompt.
+func (p ToolPolicy) Offers(name string) bool {
+	switch p.mode() {
+	case ToolChoiceNone:
+		return false
+	case ToolChoiceFunction:
+		return name == p.Function
+	default:
+		return true
+	}
+}
+
+// Filter drops parsed calls the policy does not admit. When a call is
+// required only declared tools count, so the undeclared-name fallback of
+// ParseToolCalls cannot satisfy it.
+func (p ToolPolicy) Filter(calls []ParsedToolCall, declared []string) []ParsedToolCall {
+	if len(calls) == 0 || p.Disabled() {
+		return nil
+	}
+	if p.RequiresCall() {
+		allowed := map[string]struct{}{}
+		for _, name := range declared {
+			if p.Offers(name) {
+				allowed[name] = struct{}{}
+			}
+		}
+		kept := make([]ParsedToolCall, 0, len(calls))
+		for _, tc := range calls {
+			if _, ok := allowed[tc.Name]; ok {
+				kept = append(kept, tc)
+			}
+		}
+		calls = kept
+	}
+	if p.Single && len(calls) > 1 {
+		calls = calls[:1]
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+	return calls
+}
+
+// PromptRules returns the extra tool prompt instructions for this policy.
+func (p ToolPolicy) PromptRules() []string {
+	var rules []string
+	switch p.mode() {
+	case ToolChoiceRequired:
+		rules = append(rules, "You MUST call at least one of the tools above in this reply; a plain text answer is not allowed.")
+	case ToolChoiceFunction:
+		rules = append(rules, fmt.Sprintf("You MUST call the tool %q in this reply; a plain text answer is not allowed.", p.Function))
+	}
+	if p.Single {
+		rules = append(rules, "Call at most ONE tool per reply.")
+	}
+	return rules
+}
+
+// RetryPrompt is sent back to the model when a required tool call is missing.
+func (p ToolPolicy) RetryPrompt() string {
+	target := "one of the available tools"
+	if p.mode() == ToolChoiceFunction {
+		target = fmt.Sprintf("the tool %q", p.Function)
+	}
+	return fmt.Sprintf("Your previous reply did not call a tool. You must call %s now. Output ONLY the tool_calls JSON.", target)
+}
@@ -0,0 +1,24 @@
+package util
+
+import "testing"
+
+func TestToolPolicyFilter(t *testing.T) {
+	calls := []ParsedToolCall{{Name: "a"}, {Name: "b"}, {Name: "ghost"}}
+	declared := []string{"a", "b"}
+
+	if got := (ToolPolicy{}).Filter(calls, declared); len(got) != 3 {
+		t.Fatalf("auto should keep every call, got %#v", got)
+	}
+	if got := (ToolPolicy{Choice: ToolChoiceNone}).Filter(calls, declared); got != nil {
+		t.Fatalf("none should drop every call, got %#v", got)
+	}
+	if got := (ToolPolicy{Choice: ToolChoiceRequired}).Filter(calls, declared); len(got) != 2 {
+		t.Fatalf("required should keep declared calls only, got %#v", got)
+	}
+	if got := (ToolPolicy{Choice: ToolChoiceFunction, Function: "b"}).Filter(calls, declared); len(got) != 1 || got[0].Name != "b" {
+		t.Fatalf("function should keep the forced call only, got %#v", got)
+	}
+	if got := (ToolPolicy{Single: true}).Filter(calls, declared); len(got) != 1 || got[0].Name != "a" {
+		t.Fatalf("single should keep the first call, got %#v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package util
+
+import "math"
+
+// UpstreamUsage is the accounting DeepSeek reports alongside content in its
+// SSE stream. Zero fields were not reported.
+type UpstreamUsage struct {
+	// OutputTokens is the upstream token count of the generated message,
+	// reasoning included.
+	OutputTokens int
+	// ThinkingSeconds is how long the model spent reasoning.
+	ThinkingSeconds float64
+	// QuasiStatus is the last quasi_status seen, e.g. "FINISHED".
+	QuasiStatus string
+	// RetryInputTokens and RetryOutputTokens are what follow-up calls made
+	// to correct the message (a tool_choice retry, a response_format
+	// repair) cost on top of it: their prompts and the output the message
+	// does not contain.
+	RetryInputTokens  int
+	RetryOutputTokens int
+}
+
+func (u UpstreamUsage) IsZero() bool {
+	return u.OutputTokens == 0 && u.ThinkingSeconds == 0 && u.QuasiStatus == ""
+}
+
+// Merge folds a later report into u. Token usage is cumulative upstream, so
+// the largest count wins; timing and status take the latest value.
+func (u *UpstreamUsage) Merge(o UpstreamUsage) {
+	if o.OutputTokens > u.OutputTokens {
+		u.OutputTokens = o.OutputTokens
+	}
+	if o.ThinkingSeconds > 0 {
+		u.ThinkingSeconds = o.ThinkingSeconds
+	}
+	if o.QuasiStatus != "" {
+		u.QuasiStatus = o.QuasiStatus
+	}
+}
+
+// Delivered returns u for output that reached the client as generated. When
+// the output was cut locally or rewritten, the upstream token count describes
+// text the client never received, so only the timing is kept.
+func (u UpstreamUsage) Delivered(altered bool) UpstreamUsage {
+	if altered {
+		u.OutputTokens = 0
+	}
+	return u
+}
+
+// AddRetry counts a follow-up call that sent prompt. droppedThinking and
+// droppedText are the output left out of the message: the retry's own when
+// it was discarded, otherwise what it replaced.
+func (u *UpstreamUsage) AddRetry(prompt, droppedThinking, droppedText string) {
+	u.RetryInputTokens += CountTokens(prompt)
+	u.RetryOutputTokens += CountTokens(droppedThinking) + CountTokens(droppedText)
+}
+
+// Input returns the input token count of a message sent with finalPrompt,
+// retries included.
+func (u UpstreamUsage) Input(finalPrompt string) int {
+	return CountTokens(finalPrompt) + u.RetryInputTokens
+}
+
+// Output returns the reasoning and total output token counts of a message,
+// preferring the upstream total and counting locally when it is missing;
+// retries are added to the total. Reasoning is always counted locally since
+// upstream does not split it out.
+func (u UpstreamUsage) Output(finalThinking, finalText string) (reasoningTokens, outputTokens int) {
+	reasoningTokens = CountTokens(finalThinking)
+	if u.OutputTokens <= 0 {
+		return reasoningTokens, reasoningTokens + CountTokens(finalText) + u.RetryOutputTokens
+	}
+	if reasoningTokens > u.OutputTokens {
+		reasoningTokens = u.OutputTokens
+	}
+	return reasoningTokens, u.OutputTokens + u.RetryOutputTokens
+}
+
+// ReasoningSeconds is ThinkingSeconds rounded to milliseconds, for usage
+// objects; ok is false when upstream reported no reasoning time.
+func (u UpstreamUsage) ReasoningSeconds() (float64, bool) {
+	if u.ThinkingSeconds <= 0 {
+		return 0, false
+	}
+	return math.Round(u.ThinkingSeconds*1000) / 1000, true
+}
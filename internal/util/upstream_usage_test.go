@@ -0,0 +1,55 @@
+package util
+
+import "testing"
+
+func TestUpstreamUsageOutputPrefersUpstream(t *testing.T) {
+	u := UpstreamUsage{OutputTokens: 30}
+	reasoning, output := u.Output("think", "answer")
+	if output != 30 || reasoning != CountTokens("think") {
+		t.Fatalf("unexpected counts reasoning=%d output=%d", reasoning, output)
+	}
+
+	reasoning, output = UpstreamUsage{}.Output("think", "answer")
+	if output != CountTokens("think")+CountTokens("answer") || reasoning != CountTokens("think") {
+		t.Fatalf("expected local counts, got reasoning=%d output=%d", reasoning, output)
+	}
+
+	reasoning, output = UpstreamUsage{OutputTokens: 1}.Output("a much longer reasoning trace", "")
+	if reasoning != 1 || output != 1 {
+		t.Fatalf("expected reasoning capped at the upstream total, got reasoning=%d output=%d", reasoning, output)
+	}
+}
+
+func TestUpstreamUsageCountsRetries(t *testing.T) {
+	var u UpstreamUsage
+	u.AddRetry("retry prompt", "", "dropped reply")
+	if got := u.Input("prompt"); got != CountTokens("prompt")+CountTokens("retry prompt") {
+		t.Fatalf("expected the retry prompt in the input count, got %d", got)
+	}
+	if _, output := u.Output("", "answer"); output != CountTokens("answer")+CountTokens("dropped reply") {
+		t.Fatalf("expected the dropped reply in the output count, got %d", output)
+	}
+	u.OutputTokens = 30
+	if _, output := u.Output("", "answer"); output != 30+CountTokens("dropped reply") {
+		t.Fatalf("expected retries on top of the upstream count, got %d", output)
+	}
+}
+
+func TestUpstreamUsageMergeAndDelivered(t *testing.T) {
+	var u UpstreamUsage
+	u.Merge(UpstreamUsage{OutputTokens: 40, ThinkingSeconds: 1.2})
+	u.Merge(UpstreamUsage{OutputTokens: 12, QuasiStatus: "FINISHED"})
+	if u.OutputTokens != 40 || u.ThinkingSeconds != 1.2 || u.QuasiStatus != "FINISHED" {
+		t.Fatalf("unexpected merge result: %#v", u)
+	}
+	cut := u.Delivered(true)
+	if cut.OutputTokens != 0 || cut.ThinkingSeconds != 1.2 {
+		t.Fatalf("expected only timing kept for altered output: %#v", cut)
+	}
+	if secs, ok := u.ReasoningSeconds(); !ok || secs != 1.2 {
+		t.Fatalf("unexpected reasoning seconds %v %v", secs, ok)
+	}
+	if _, ok := (UpstreamUsage{}).ReasoningSeconds(); ok {
+		t.Fatal("expected no reasoning seconds when unreported")
+	}
+}
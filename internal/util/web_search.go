@@ -0,0 +1,206 @@
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SearchResult is one web page an upstream search returned. CiteIndex is the
+// number [citation:N] markers in the answer use for it; zero means upstream
+// did not assign one.
+type SearchResult struct {
+	URL         string
+	Title       string
+	Snippet     string
+	SiteName    string
+	PublishedAt int64
+	CiteIndex   int
+}
+
+// SearchCite assigns a cite index to the result at position Result of the
+// latest batch.
+type SearchCite struct {
+	Result int
+	Index  int
+}
+
+// SearchUpdate is the search activity DeepSeek reports on one SSE line.
+// Results, when present, are one new batch: the results of one search.
+type SearchUpdate struct {
+	Queries []string
+	Results []SearchResult
+	Cites   []SearchCite
+}
+
+func (u SearchUpdate) IsZero() bool {
+	return len(u.Queries) == 0 && len(u.Results) == 0 && len(u.Cites) == 0
+}
+
+// WebSearch is one search of a completion: its query and results.
+type WebSearch struct {
+	Query   string
+	Results []SearchResult
+}
+
+// SearchSources collects the searches of one completion so citation
+// markers in the answer can be resolved to their pages.
+type SearchSources struct {
+	queries []string
+	// results holds every result in the order received; batches are where
+	// each search's results start.
+	results []SearchResult
+	batches []searchBatch
+}
+
+type searchBatch struct {
+	query string
+	start int
+}
+
+// Merge folds one line's update in.
+func (s *SearchSources) Merge(u SearchUpdate) {
+	for _, q := range u.Queries {
+		if q = strings.TrimSpace(q); q != "" {
+			s.queries = append(s.queries, q)
+		}
+	}
+	if len(u.Results) > 0 {
+		query := ""
+		if n := len(s.batches); n < len(s.queries) {
+			query = s.queries[n]
+		} else if len(s.queries) > 0 {
+			query = s.queries[len(s.queries)-1]
+		}
+		s.batches = append(s.batches, searchBatch{query: query, start: len(s.results)})
+		s.results = append(s.results, u.Results...)
+	}
+	if len(s.batches) == 0 {
+		return
+	}
+	latest := s.batches[len(s.batches)-1].start
+	for _, c := range u.Cites {
+		if i := latest + c.Result; c.Result >= 0 && i < len(s.results) {
+			s.results[i].CiteIndex = c.Index
+		}
+	}
+}
+
+// Searched reports whether upstream ran a search.
+func (s *SearchSources) Searched() bool {
+	return s != nil && len(s.batches) > 0
+}
+
+// Searches returns the searches in the order they ran.
+func (s *SearchSources) Searches() []WebSearch {
+	if s == nil {
+		return nil
+	}
+	out := make([]WebSearch, len(s.batches))
+	for i, b := range s.batches {
+		end := len(s.results)
+		if i+1 < len(s.batches) {
+			end = s.batches[i+1].start
+		}
+		out[i] = WebSearch{Query: b.query, Results: s.results[b.start:end]}
+	}
+	return out
+}
+
+// Results returns every result received, in order.
+func (s *SearchSources) Results() []SearchResult {
+	if s == nil {
+		return nil
+	}
+	return s.results
+}
+
+// Lookup resolves citation number n: the result upstream gave that cite
+// index, or else the n-th result received.
+func (s *SearchSources) Lookup(n int) (SearchResult, bool) {
+	if s == nil || n <= 0 {
+		return SearchResult{}, false
+	}
+	for _, r := range s.results {
+		if r.CiteIndex == n {
+			return r, true
+		}
+	}
+	if n <= len(s.results) && s.results[n-1].CiteIndex == 0 {
+		return s.results[n-1], true
+	}
+	return SearchResult{}, false
+}
+
+// CitationSegment is a piece of answer text: either plain Text or, when
+// Cite is positive, the citation marker [citation:Cite].
+type CitationSegment struct {
+	Text string
+	Cite int
+}
+
+var citationMarkerPattern = regexp.MustCompile(`\[citation:\s*(\d+(?:\s*,\s*\d+)*)\s*\]`)
+
+// SplitCitations splits text around its [citation:N] markers; a marker
+// listing several numbers yields one segment per number.
+func SplitCitations(text string) []CitationSegment {
+	var out []CitationSegment
+	last := 0
+	for _, m := range citationMarkerPattern.FindAllStringSubmatchIndex(text, -1) {
+		if m[0] > last {
+			out = append(out, CitationSegment{Text: text[last:m[0]]})
+		}
+		for _, raw := range strings.Split(text[m[2]:m[3]], ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(raw)); err == nil && n > 0 {
+				out = append(out, CitationSegment{Cite: n})
+			}
+		}
+		last = m[1]
+	}
+	if last < len(text) {
+		out = append(out, CitationSegment{Text: text[last:]})
+	}
+	return out
+}
+
+// CitationScanner splits streamed text around citation markers. A chunk
+// ending in what may be the start of a marker is held back until the next
+// chunk or Flush decides it.
+type CitationScanner struct {
+	pending string
+}
+
+func (c *CitationScanner) Feed(text string) []CitationSegment {
+	text = c.pending + text
+	c.pending = ""
+	if i := strings.LastIndexByte(text, '['); i >= 0 && isCitationPrefix(text[i:]) {
+		c.pending = text[i:]
+		text = text[:i]
+	}
+	return SplitCitations(text)
+}
+
+// Flush returns the text still held back.
+func (c *CitationScanner) Flush() []CitationSegment {
+	text := c.pending
+	c.pending = ""
+	return SplitCitations(text)
+}
+
+const citationMarkerOpen = "[citation:"
+
+// isCitationPrefix reports whether s could grow into a citation marker.
+func isCitationPrefix(s string) bool {
+	if len(s) <= len(citationMarkerOpen) {
+		return strings.HasPrefix(citationMarkerOpen, s)
+	}
+	if !strings.HasPrefix(s, citationMarkerOpen) || len(s) > 32 {
+		return false
+	}
+	for _, r := range s[len(citationMarkerOpen):] {
+		if (r < '0' || r > '9') && r != ',' && r != ' ' {
+			return false
+		}
+	}
+	return true
+}
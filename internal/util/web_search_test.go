@@ -0,0 +1,47 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCitations(t *testing.T) {
+	got := SplitCitations("Go 1.22[citation:1] is out[citation:2, 3].")
+	want := []CitationSegment{{Text: "Go 1.22"}, {Cite: 1}, {Text: " is out"}, {Cite: 2}, {Cite: 3}, {Text: "."}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected segments: %#v", got)
+	}
+}
+
+func TestCitationScannerHoldsPartialMarker(t *testing.T) {
+	var c CitationScanner
+	var got []CitationSegment
+	for _, chunk := range []string{"See [cit", "ation:1", "2] and [x]", " end [ci"} {
+		got = append(got, c.Feed(chunk)...)
+	}
+	got = append(got, c.Flush()...)
+	want := []CitationSegment{{Text: "See "}, {Cite: 12}, {Text: " and [x]"}, {Text: " end "}, {Text: "[ci"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected segments: %#v", got)
+	}
+}
+
+func TestSearchSourcesLookup(t *testing.T) {
+	var s SearchSources
+	s.Merge(SearchUpdate{Queries: []string{"q1"}})
+	s.Merge(SearchUpdate{Results: []SearchResult{{URL: "https://a"}, {URL: "https://b"}}})
+	s.Merge(SearchUpdate{Cites: []SearchCite{{Result: 1, Index: 1}}})
+	if r, ok := s.Lookup(1); !ok || r.URL != "https://b" {
+		t.Fatalf("expected the assigned cite index to win, got %#v", r)
+	}
+	if _, ok := s.Lookup(2); ok {
+		t.Fatal("expected no fallback to a result cited under another number")
+	}
+	s.Merge(SearchUpdate{Results: []SearchResult{{URL: "https://c"}}})
+	if r, ok := s.Lookup(3); !ok || r.URL != "https://c" {
+		t.Fatalf("expected position fallback for citation 3, got %#v", r)
+	}
+	if searches := s.Searches(); len(searches) != 2 || searches[0].Query != "q1" || searches[0].Results[1].CiteIndex != 1 {
+		t.Fatalf("unexpected searches: %#v", searches)
+	}
+}
@@ -29,8 +29,9 @@ import Login from './components/Login'
 import LandingPage from './components/LandingPage'
 import LanguageToggle from './components/LanguageToggle'
 import { useI18n } from './i18n'
+import { clearSession, loadSession, refreshSession } from './adminSession'
 
-function Dashboard({ token, onLogout, config, fetchConfig, showMessage, message, onForceLogout }) {
+function Dashboard({ token, onLogout, onTokenRefresh, config, fetchConfig, showMessage, message, onForceLogout }) {
     const { t } = useI18n()
     const [activeTab, setActiveTab] = useState('accounts')
     const [sidebarOpen, setSidebarOpen] = useState(false)
@@ -45,12 +46,20 @@ function Dashboard({ token, onLogout, config, fetchConfig, showMessage, message,
     ]
 
     const authFetch = async (url, options = {}) => {
-        const headers = {
-            ...options.headers,
-            'Authorization': `Bearer ${token}`
-        }
-        const res = await fetch(url, { ...options, headers })
+        const send = (bearer) => fetch(url, {
+            ...options,
+            headers: { ...options.headers, 'Authorization': `Bearer ${bearer}` }
+        })
+        let res = await send(token)
 
+        // Access tokens are short-lived: renew once and retry.
+        if (res.status === 401) {
+            const renewed = await refreshSession()
+            if (renewed) {
+                onTokenRefresh(renewed)
+                res = await send(renewed)
+            }
+        }
         if (res.status === 401) {
             onLogout()
             throw new Error(t('auth.expired'))
@@ -229,10 +238,12 @@ export default function App() {
         }
 
         const checkAuth = async () => {
-            const storedToken = localStorage.getItem('ds2api_token') || sessionStorage.getItem('ds2api_token')
-            const expiresAt = parseInt(localStorage.getItem('ds2api_token_expires') || sessionStorage.getItem('ds2api_token_expires') || '0')
+            let { token: storedToken, expiresAt } = loadSession()
+            if (!storedToken || expiresAt <= Date.now()) {
+                storedToken = await refreshSession()
+            }
 
-            if (storedToken && expiresAt > Date.now()) {
+            if (storedToken) {
                 try {
                     const res = await fetch('/admin/verify', {
                         headers: { 'Authorization': `Bearer ${storedToken}` }
@@ -287,10 +298,7 @@ export default function App() {
 
     const handleLogout = () => {
         setToken(null)
-        localStorage.removeItem('ds2api_token')
-        localStorage.removeItem('ds2api_token_expires')
-        sessionStorage.removeItem('ds2api_token')
-        sessionStorage.removeItem('ds2api_token_expires')
+        clearSession()
     }
 
     // Wait for auth checks on admin routes.
@@ -315,6 +323,7 @@ export default function App() {
                     <Dashboard
                         token={token}
                         onLogout={handleLogout}
+                        onTokenRefresh={setToken}
                         config={config}
                         fetchConfig={fetchConfig}
                         showMessage={showMessage}
@@ -0,0 +1,59 @@
+// Admin credentials live in localStorage ("remember me") or sessionStorage.
+// Access tokens are short-lived; the session's refresh token renews them
+// through /admin/refresh.
+const KEYS = ['ds2api_token', 'ds2api_token_expires', 'ds2api_refresh_token', 'ds2api_refresh_expires']
+
+function activeStorage() {
+    return localStorage.getItem('ds2api_token') ? localStorage : sessionStorage
+}
+
+export function saveSession(data, storage = activeStorage()) {
+    storage.setItem('ds2api_token', data.token)
+    storage.setItem('ds2api_token_expires', Date.now() + data.expires_in * 1000)
+    if (data.refresh_token) {
+        storage.setItem('ds2api_refresh_token', data.refresh_token)
+        storage.setItem('ds2api_refresh_expires', Date.now() + data.refresh_expires_in * 1000)
+    }
+}
+
+export function loadSession() {
+    const storage = activeStorage()
+    return {
+        token: storage.getItem('ds2api_token'),
+        expiresAt: parseInt(storage.getItem('ds2api_token_expires') || '0'),
+        refreshToken: storage.getItem('ds2api_refresh_token'),
+        refreshExpiresAt: parseInt(storage.getItem('ds2api_refresh_expires') || '0'),
+    }
+}
+
+export function clearSession() {
+    for (const storage of [localStorage, sessionStorage]) {
+        KEYS.forEach(key => storage.removeItem(key))
+    }
+}
+
+let pendingRefresh = null
+
+// refreshSession trades the stored refresh token for a new access token and
+// resolves to it, or to null when the session is gone. Refresh tokens rotate,
+// so concurrent callers share one request.
+export function refreshSession() {
+    if (!pendingRefresh) {
+        pendingRefresh = (async () => {
+            const { refreshToken, refreshExpiresAt } = loadSession()
+            if (!refreshToken || refreshExpiresAt <= Date.now()) return null
+            const res = await fetch('/admin/refresh', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ refresh_token: refreshToken }),
+            })
+            if (!res.ok) return null
+            const data = await res.json()
+            saveSession(data)
+            return data.token
+        })().catch(() => null).finally(() => {
+            pendingRefresh = null
+        })
+    }
+    return pendingRefresh
+}